@@ -24,6 +24,11 @@ func NewGraphqlHTTPHandler(
 		engine:     engine,
 		wsUpgrader: upgrader,
 		log:        logger,
+		uploads: graphql.UploadConfiguration{
+			MaxFileSizeBytes: graphql.DefaultUploadMaxFileSizeBytes,
+			MaxFiles:         graphql.DefaultUploadMaxFiles,
+			MaxMemoryBytes:   graphql.DefaultUploadMaxMemoryBytes,
+		},
 	}
 }
 
@@ -32,6 +37,7 @@ type GraphQLHTTPRequestHandler struct {
 	wsUpgrader *ws.HTTPUpgrader
 	engine     *graphql.ExecutionEngineV2
 	schema     *graphql.Schema
+	uploads    graphql.UploadConfiguration
 }
 
 func (g *GraphQLHTTPRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
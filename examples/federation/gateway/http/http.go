@@ -19,7 +19,19 @@ func (g *GraphQLHTTPRequestHandler) handleHTTP(w http.ResponseWriter, r *http.Re
 	var err error
 
 	var gqlRequest graphql.Request
-	if err = graphql.UnmarshalHttpRequest(r, &gqlRequest); err != nil {
+	// the uploads are removed on every path, including failed unmarshalling
+	defer func() {
+		if err := gqlRequest.RemoveUploads(); err != nil {
+			g.log.Error("RemoveUploads", log.Error(err))
+		}
+	}()
+
+	if graphql.IsMultipartHttpRequest(r) {
+		err = graphql.UnmarshalMultipartHttpRequest(r, &gqlRequest, g.uploads)
+	} else {
+		err = graphql.UnmarshalHttpRequest(r, &gqlRequest)
+	}
+	if err != nil {
 		g.log.Error("UnmarshalHttpRequest", log.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -203,6 +203,10 @@ func (d *Document) TypeValueNeedsQuotes(ref int, definition *Document) bool {
 			return true
 		default:
 			node, _ := definition.Index.FirstNodeByNameStr(typeName)
+			if node.Kind == NodeKindScalarTypeDefinition {
+				// values of Upload variables are placeholders of the files of GraphQL multipart requests
+				return typeName == "Upload"
+			}
 			return node.Kind == NodeKindEnumTypeDefinition
 		}
 	default:
@@ -85,4 +85,18 @@ func TestDocument_TypeValueNeedsQuotes(t *testing.T) {
 	})
 	enumNeedsQuotes := doc.TypeValueNeedsQuotes(8, definition)
 	assert.Equal(t, true, enumNeedsQuotes)
+
+	uploadRef := doc.Input.AppendInputString("Upload")
+	doc.Types = append(doc.Types, Type{
+		TypeKind: TypeKindNamed,
+		Name:     uploadRef,
+	})
+	uploadNeedsQuotes := doc.TypeValueNeedsQuotes(9, definition)
+	assert.Equal(t, false, uploadNeedsQuotes)
+
+	definition.Index.AddNodeStr("Upload", Node{
+		Kind: NodeKindScalarTypeDefinition,
+	})
+	uploadScalarNeedsQuotes := doc.TypeValueNeedsQuotes(9, definition)
+	assert.Equal(t, true, uploadScalarNeedsQuotes)
 }
@@ -150,6 +150,11 @@ directive @deprecated(
     """
     reason: String = "No longer supported"
 ) on FIELD_DEFINITION | ENUM_VALUE
+"Turns a query into a live query, it gets resolved again when its data changes or the polling interval elapses."
+directive @live(
+    "Resolves the query again after this interval, if set."
+    pollingIntervalMillis: Int
+) on QUERY
 
 """
 A Directive provides a way to describe alternate runtime execution and type validation behavior in a GraphQL document.
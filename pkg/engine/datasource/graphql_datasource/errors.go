@@ -0,0 +1,119 @@
+package graphql_datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+)
+
+// ErrorsConfiguration defines how errors of the upstream are passed to the client
+// Paths of upstream errors are rewritten to the client response, locations refer to the upstream operation and are removed.
+type ErrorsConfiguration struct {
+	// PassThroughExtensions passes the extensions of upstream errors, e.g. error codes, to the client
+	PassThroughExtensions bool
+	// AllowedExtensions limits the passed extensions to the keys if not empty, e.g. "code"
+	AllowedExtensions []string
+	// DeniedExtensions removes the extensions of the keys, e.g. "exception" which may contain stack traces
+	DeniedExtensions []string
+}
+
+// extensions returns the extensions passed to the client or nil if none are left
+func (c ErrorsConfiguration) extensions(extensions []byte) []byte {
+	if !c.PassThroughExtensions || len(extensions) == 0 {
+		return nil
+	}
+	if len(c.AllowedExtensions) == 0 && len(c.DeniedExtensions) == 0 {
+		return extensions
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteByte('{')
+	_ = jsonparser.ObjectEach(extensions, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		if !c.allowsExtension(string(key)) {
+			return nil
+		}
+		if out.Len() != 1 {
+			out.WriteByte(',')
+		}
+		out.WriteByte('"')
+		out.Write(key)
+		out.WriteString(`":`)
+		if dataType == jsonparser.String {
+			out.WriteByte('"')
+			out.Write(value)
+			out.WriteByte('"')
+		} else {
+			out.Write(value)
+		}
+		return nil
+	})
+	if out.Len() == 1 {
+		return nil
+	}
+	out.WriteByte('}')
+	return out.Bytes()
+}
+
+func (c ErrorsConfiguration) allowsExtension(key string) bool {
+	for i := range c.DeniedExtensions {
+		if c.DeniedExtensions[i] == key {
+			return false
+		}
+	}
+	if len(c.AllowedExtensions) == 0 {
+		return true
+	}
+	for i := range c.AllowedExtensions {
+		if c.AllowedExtensions[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// responseErrorPath rewrites the path of an upstream error onto the client response
+// The upstream path starts at the fetch path, the object the fetch loads data for.
+// Entity fetches load the entity of a single object, so the _entities field and the entity index map onto the fetch path,
+// which contains the list indices of the entity.
+func responseErrorPath(fetchPath []string, upstreamPath []byte, extractEntities bool) []byte {
+	if len(upstreamPath) == 0 {
+		return nil
+	}
+
+	path := make([]interface{}, 0, len(fetchPath)+4)
+	for i := range fetchPath {
+		if index, err := strconv.Atoi(fetchPath[i]); err == nil {
+			path = append(path, index)
+			continue
+		}
+		path = append(path, fetchPath[i])
+	}
+
+	i := 0
+	_, err := jsonparser.ArrayEach(upstreamPath, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		defer func() { i++ }()
+		if extractEntities && i < 2 {
+			// skip _entities and the entity index
+			return
+		}
+		if dataType == jsonparser.Number {
+			index, err := strconv.Atoi(string(value))
+			if err == nil {
+				path = append(path, index)
+			}
+			return
+		}
+		path = append(path, string(value))
+	})
+	if err != nil {
+		return nil
+	}
+
+	out, err := json.Marshal(path)
+	if err != nil {
+		return nil
+	}
+	return out
+}
@@ -3,10 +3,13 @@ package graphql_datasource
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
 
 	"github.com/buger/jsonparser"
 	"github.com/tidwall/sjson"
@@ -18,6 +21,8 @@ import (
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/graphql-websocket-subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/sse_subscription"
 	"github.com/jensneuse/graphql-go-tools/pkg/federation"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 	"github.com/jensneuse/graphql-go-tools/pkg/operationreport"
@@ -28,6 +33,13 @@ const (
 	UniqueIdentifier = "graphql"
 )
 
+const (
+	persistedQueryNotFound         = "PersistedQueryNotFound"
+	persistedQueryNotFoundCode     = "PERSISTED_QUERY_NOT_FOUND"
+	persistedQueryNotSupported     = "PersistedQueryNotSupported"
+	persistedQueryNotSupportedCode = "PERSISTED_QUERY_NOT_SUPPORTED"
+)
+
 type Planner struct {
 	visitor                    *plan.Visitor
 	config                     Configuration
@@ -44,6 +56,8 @@ type Planner struct {
 	rootTypeName               string // rootTypeName - holds name of top level type
 	rootFieldName              string // rootFieldName - holds name of root type field
 	rootFieldRef               int    // rootFieldRef - holds ref of root type field
+	headerForwarding           *resolve.HeaderForwarding
+	namespace                  string // namespace - holds the response key of the namespace root field
 }
 
 func (p *Planner) DownstreamResponseFieldAlias(downstreamFieldRef int) (alias string, exists bool) {
@@ -85,6 +99,17 @@ type Configuration struct {
 	Fetch        FetchConfiguration
 	Subscription SubscriptionConfiguration
 	Federation   FederationConfiguration
+	// HeaderForwarding forwards client request headers with fetches and the connection_init of websocket subscriptions
+	// It defaults to the DefaultHeaderForwarding of the plan.Configuration.
+	HeaderForwarding *resolve.HeaderForwardingRules
+	// Transport configures the http client of fetches if the Factory has no Client
+	// Clients are cached by the ClientCache of the plan.Configuration and shared by data sources with equal transports.
+	Transport *httpclient.TransportConfiguration
+	// Errors configures how upstream errors are passed to the client
+	Errors ErrorsConfiguration
+	// SchemaMapping reverses the SchemaTransforms of the upstream schema in upstream operations and responses
+	// Use the mapping returned by TransformSchema.
+	SchemaMapping *SchemaMapping
 }
 
 func ConfigJson(config Configuration) json.RawMessage {
@@ -99,12 +124,24 @@ type FederationConfiguration struct {
 
 type SubscriptionConfiguration struct {
 	URL string
+	// Protocol is the websocket subprotocol used with the upstream, e.g. graphql_websocket_subscription.ProtocolGraphQLTransportWS
+	// If empty the protocol is negotiated with the upstream.
+	Protocol string
+	// ConnectionInitPayload is sent with the connection_init message
+	// It may contain request header templates, e.g. {"Authorization":"{{ .request.headers.Authorization }}"}
+	ConnectionInitPayload json.RawMessage
+	// UseSSE subscribes with a POST request to the URL expecting Server-Sent Events instead of using websockets
+	// The Protocol and ConnectionInitPayload are ignored.
+	UseSSE bool
 }
 
 type FetchConfiguration struct {
 	URL    string
 	Method string
 	Header http.Header
+	// AutomaticPersistedQueries sends the sha256 hash of the upstream query as persistedQuery extension instead of the query
+	// The query is sent along with the hash if the upstream responds with PersistedQueryNotFound or PersistedQueryNotSupported.
+	AutomaticPersistedQueries bool
 }
 
 func (c *Configuration) ApplyDefaults() {
@@ -130,9 +167,43 @@ func (p *Planner) Register(visitor *plan.Visitor, config json.RawMessage, isNest
 	p.config.ApplyDefaults()
 	p.isNested = isNested
 
+	headerForwarding := p.config.HeaderForwarding
+	if headerForwarding == nil {
+		headerForwarding = visitor.Config.DefaultHeaderForwarding
+	}
+	p.headerForwarding = nil
+	if headerForwarding != nil {
+		p.headerForwarding, err = resolve.NewHeaderForwarding(*headerForwarding)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.client == nil && p.config.Transport != nil {
+		clientCache := visitor.Config.ClientCache
+		if clientCache == nil {
+			clientCache = httpclient.DefaultClientCache
+		}
+		client, err := clientCache.NetHttpClient(*p.config.Transport)
+		if err != nil {
+			return err
+		}
+		p.client = client
+	}
+
 	return nil
 }
 
+func (p *Planner) configureHeaderForwarding(input []byte) []byte {
+	if p.headerForwarding == nil {
+		return input
+	}
+	variable, _ := p.variables.AddVariable(&resolve.HeaderForwardingVariable{
+		Forwarding: p.headerForwarding,
+	}, false)
+	return httpclient.SetInputForwardedHeader(input, []byte(variable))
+}
+
 func (p *Planner) ConfigureFetch() plan.FetchConfiguration {
 
 	var input []byte
@@ -140,21 +211,34 @@ func (p *Planner) ConfigureFetch() plan.FetchConfiguration {
 		input, _ = sjson.SetRawBytes(input, "extract_entities", []byte("true"))
 	}
 	input = httpclient.SetInputBodyWithPath(input, p.upstreamVariables, "variables")
-	input = httpclient.SetInputBodyWithPath(input, p.printOperation(), "query")
+
+	source := &Source{
+		client:    p.client,
+		errors:    p.config.Errors,
+		typeNames: p.config.SchemaMapping.typeNames(),
+		namespace: p.namespace,
+	}
+	query := p.printOperation()
+	if p.config.Fetch.AutomaticPersistedQueries && len(query) != 0 {
+		// the hash is computed once per plan, the query is only sent if the upstream doesn't know the hash
+		input = httpclient.SetInputBodyWithPath(input, persistedQueryExtensions(query), "extensions")
+		source.persistedQuery = string(query)
+	} else {
+		input = httpclient.SetInputBodyWithPath(input, query, "query")
+	}
 
 	header, err := json.Marshal(p.config.Fetch.Header)
 	if err == nil && len(header) != 0 && !bytes.Equal(header, literal.NULL) {
 		input = httpclient.SetInputHeader(input, header)
 	}
+	input = p.configureHeaderForwarding(input)
 
 	input = httpclient.SetInputURL(input, []byte(p.config.Fetch.URL))
 	input = httpclient.SetInputMethod(input, []byte(p.config.Fetch.Method))
 
 	return plan.FetchConfiguration{
-		Input: string(input),
-		DataSource: &Source{
-			client: p.client,
-		},
+		Input:                string(input),
+		DataSource:           source,
 		Variables:            p.variables,
 		DisallowSingleFlight: p.disallowSingleFlight,
 	}
@@ -170,6 +254,23 @@ func (p *Planner) ConfigureSubscription() plan.SubscriptionConfiguration {
 	if err == nil && len(header) != 0 && !bytes.Equal(header, literal.NULL) {
 		input = httpclient.SetInputHeader(input, header)
 	}
+	input = p.configureHeaderForwarding(input)
+
+	if p.config.Subscription.UseSSE {
+		input = httpclient.SetInputMethod(input, []byte("POST"))
+		return plan.SubscriptionConfiguration{
+			Input:                 string(input),
+			SubscriptionManagerID: string(sse_subscription.ServerSentEvents),
+			Variables:             p.variables,
+		}
+	}
+
+	if p.config.Subscription.Protocol != "" {
+		input = graphql_websocket_subscription.SetInputProtocol(input, p.config.Subscription.Protocol)
+	}
+	if len(p.config.Subscription.ConnectionInitPayload) != 0 && !bytes.Equal(p.config.Subscription.ConnectionInitPayload, literal.NULL) {
+		input = graphql_websocket_subscription.SetInputConnectionInitPayload(input, p.config.Subscription.ConnectionInitPayload)
+	}
 
 	return plan.SubscriptionConfiguration{
 		Input:                 string(input),
@@ -283,6 +384,19 @@ func (p *Planner) EnterField(ref int) {
 
 	p.lastFieldEnclosingTypeName = p.visitor.Walker.EnclosingTypeDefinition.NameString(p.visitor.Definition)
 
+	if p.config.SchemaMapping.isNamespace(p.lastFieldEnclosingTypeName, fieldName) {
+		responseKey := p.visitor.Operation.FieldAliasOrNameString(ref)
+		if p.namespace != "" {
+			// the selections of all aliases would be merged into one upstream operation, where they might collide
+			p.visitor.Walker.StopWithExternalErr(operationreport.ExternalError{
+				Message: fmt.Sprintf("namespace field %s can only be selected once per operation, got %s and %s", fieldName, p.namespace, responseKey),
+			})
+			return
+		}
+		// the upstream responds with the fields of the namespace, the response gets wrapped into its response key
+		p.namespace = responseKey
+	}
+
 	p.handleFederation(ref)
 	p.addField(ref)
 
@@ -320,6 +434,7 @@ func (p *Planner) EnterDocument(operation, definition *ast.Document) {
 	p.disallowSingleFlight = false
 	p.hasFederationRoot = false
 	p.extractEntities = false
+	p.namespace = ""
 
 	// reset information about root type
 	p.rootTypeName = ""
@@ -356,9 +471,10 @@ func (p *Planner) addRepresentationsVariable() {
 		p.visitor.Walker.StopWithInternalErr(fmt.Errorf("GraphQL Planner: failed parsing Federation SDL"))
 		return
 	}
+	typeName := p.config.SchemaMapping.upstreamTypeName(p.lastFieldEnclosingTypeName)
 	directive := -1
 	for i := range doc.ObjectTypeExtensions {
-		if p.lastFieldEnclosingTypeName == doc.ObjectTypeExtensionNameString(i) {
+		if typeName == doc.ObjectTypeExtensionNameString(i) {
 			for _, j := range doc.ObjectTypeExtensions[i].Directives.Refs {
 				if doc.DirectiveNameString(j) == "key" {
 					directive = j
@@ -369,7 +485,7 @@ func (p *Planner) addRepresentationsVariable() {
 		}
 	}
 	for i := range doc.ObjectTypeDefinitions {
-		if p.lastFieldEnclosingTypeName == doc.ObjectTypeDefinitionNameString(i) {
+		if typeName == doc.ObjectTypeDefinitionNameString(i) {
 			for _, j := range doc.ObjectTypeDefinitions[i].Directives.Refs {
 				if doc.DirectiveNameString(j) == "key" {
 					directive = j
@@ -391,7 +507,7 @@ func (p *Planner) addRepresentationsVariable() {
 	}
 	fieldsStr := doc.StringValueContentString(value.Ref)
 	fields := strings.Split(fieldsStr, " ")
-	representationsJson, _ := sjson.SetRawBytes(nil, "__typename", []byte("\""+p.lastFieldEnclosingTypeName+"\""))
+	representationsJson, _ := sjson.SetRawBytes(nil, "__typename", []byte("\""+typeName+"\""))
 	for i := range fields {
 		variable, exists := p.variables.AddVariable(&resolve.ObjectVariable{
 			Path: []string{fields[i]},
@@ -690,6 +806,13 @@ func (p *Planner) printOperation() []byte {
 		return nil
 	}
 
+	// rename types and fields of the transformed schema to the names of the upstream
+	p.config.SchemaMapping.reverseOperation(operation, definition, report)
+	if report.HasErrors() {
+		p.stopWithError(normalizationFailedErrMsg)
+		return nil
+	}
+
 	buf.Reset()
 
 	// print upstream operation
@@ -860,6 +983,10 @@ func (p *Planner) addField(ref int) {
 }
 
 type Factory struct {
+	// Client sends the requests of the data source
+	// Use a httpclient.ResilientClient per data source for timeouts, retries, circuit breaking and concurrency limits
+	// and a httpclient.OAuth2Client for upstreams which require OAuth2 access tokens.
+	// Without Client the Transport of the Configuration is used.
 	Client httpclient.Client
 }
 
@@ -876,8 +1003,8 @@ var (
 	}
 	errorPaths = [][]string{
 		{"message"},
-		{"locations"},
 		{"path"},
+		{"extensions"},
 	}
 	entitiesPath     = []string{"_entities", "[0]"}
 	uniqueIdentifier = []byte(UniqueIdentifier)
@@ -885,13 +1012,90 @@ var (
 
 type Source struct {
 	client httpclient.Client
+	errors ErrorsConfiguration
+	// typeNames maps the upstream type names of __typename fields to the names of the transformed schema
+	typeNames map[string]string
+	// namespace is the response key the upstream data gets wrapped into
+	namespace string
+	// persistedQuery is the query of the persisted query hash sent instead of the query
+	persistedQuery string
+	// persistedQueriesNotSupported is set to 1 once the upstream responded with PersistedQueryNotSupported
+	persistedQueriesNotSupported int32
+}
+
+// persistedQueryExtensions returns the automatic persisted query extensions with the sha256 hash of the query
+func persistedQueryExtensions(query []byte) []byte {
+	hash := sha256.Sum256(query)
+	return []byte(fmt.Sprintf(`{"persistedQuery":{"version":1,"sha256Hash":"%s"}}`, hex.EncodeToString(hash[:])))
+}
+
+// persistedQueryErrorCode returns the code of a PersistedQueryNotFound or PersistedQueryNotSupported error of the response
+func persistedQueryErrorCode(responseData []byte) (code string) {
+	_, _ = jsonparser.ArrayEach(responseData, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if code != "" {
+			return
+		}
+		message, _ := jsonparser.GetString(value, "message")
+		extensionsCode, _ := jsonparser.GetString(value, "extensions", "code")
+		switch {
+		case message == persistedQueryNotFound || extensionsCode == persistedQueryNotFoundCode:
+			code = persistedQueryNotFoundCode
+		case message == persistedQueryNotSupported || extensionsCode == persistedQueryNotSupportedCode:
+			code = persistedQueryNotSupportedCode
+		}
+	}, "errors")
+	return code
+}
+
+// withPersistedQuery adds the query to the input of a persisted query
+func (s *Source) withPersistedQuery(input []byte) []byte {
+	out, err := sjson.SetBytes(input, httpclient.BODY+".query", s.persistedQuery)
+	if err != nil {
+		return input
+	}
+	return out
 }
 
 func (s *Source) Load(ctx context.Context, input []byte, bufPair *resolve.BufPair) (err error) {
 	buf := pool.BytesBuffer.Get()
 	defer pool.BytesBuffer.Put(buf)
 
+	if fetchResponse := resolve.FetchResponseFromContext(ctx); fetchResponse != nil {
+		var response *httpclient.ResponseContext
+		ctx, response = httpclient.InjectResponseContext(ctx)
+		defer func() {
+			fetchResponse.StatusCode, fetchResponse.Header = response.StatusCode, response.Header
+		}()
+	}
+
+	if files := httpclient.FilesFromContext(ctx); len(files) != 0 {
+		// uploaded files referenced by the variables are sent as GraphQL multipart request
+		input, err = httpclient.SetInputFiles(input, files)
+		if err != nil {
+			return err
+		}
+	}
+
+	queryOmitted := s.persistedQuery != "" && atomic.LoadInt32(&s.persistedQueriesNotSupported) == 0
+	if s.persistedQuery != "" && !queryOmitted {
+		input = s.withPersistedQuery(input)
+	}
+
 	err = s.client.Do(ctx, input, buf)
+	if err == nil && queryOmitted {
+		if code := persistedQueryErrorCode(buf.Bytes()); code != "" {
+			if code == persistedQueryNotSupportedCode {
+				atomic.StoreInt32(&s.persistedQueriesNotSupported, 1)
+			}
+			// the upstream registers the query sent along with the hash
+			buf.Reset()
+			err = s.client.Do(ctx, s.withPersistedQuery(input), buf)
+		}
+	}
+	if message, ok := httpclient.RequestErrorMessage(err); ok && resolve.ErrorPolicyFromContext(ctx) == nil {
+		bufPair.WriteErr(message, nil, nil)
+		return nil
+	}
 	if err != nil {
 		return
 	}
@@ -904,37 +1108,62 @@ func (s *Source) Load(ctx context.Context, input []byte, bufPair *resolve.BufPai
 	jsonparser.EachKey(responseData, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 		switch i {
 		case 0:
+			fetchPath := resolve.FetchPathFromContext(ctx)
+			if s.namespace != "" {
+				fetchPath = append(fetchPath[:len(fetchPath):len(fetchPath)], s.namespace)
+			}
 			_, _ = jsonparser.ArrayEach(bytes, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 				var (
-					message, locations, path []byte
+					message, path, extensions []byte
 				)
 				jsonparser.EachKey(value, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 					switch i {
 					case 0:
 						message = bytes
 					case 1:
-						locations = bytes
-					case 2:
 						path = bytes
+					case 2:
+						extensions = bytes
 					}
 				}, errorPaths...)
 				if message != nil {
-					bufPair.WriteErr(message, locations, path)
+					// locations refer to the upstream operation and are meaningless to the client
+					bufPair.WriteErrWithExtensions(message, nil, responseErrorPath(fetchPath, path, extractEntities), s.errors.extensions(extensions))
 				}
 			})
 		case 1:
 			if extractEntities {
-				data, _, _, _ := jsonparser.Get(bytes, entitiesPath...)
-				bufPair.Data.WriteBytes(data)
+				data, dataType, _, _ := jsonparser.Get(bytes, entitiesPath...)
+				s.writeData(bufPair, data, dataType)
 				return
 			}
-			bufPair.Data.WriteBytes(bytes)
+			s.writeData(bufPair, bytes, valueType)
 		}
 	}, responsePaths...)
 
 	return
 }
 
+// writeData writes the upstream data with the __typename fields renamed and wrapped into the namespace
+func (s *Source) writeData(bufPair *resolve.BufPair, data []byte, dataType jsonparser.ValueType) {
+	if s.typeNames != nil {
+		out := &bytes.Buffer{}
+		renameResponseTypeNames(out, data, dataType, s.typeNames)
+		data = out.Bytes()
+	}
+	if s.namespace == "" {
+		bufPair.Data.WriteBytes(data)
+		return
+	}
+	bufPair.Data.WriteBytes(literal.LBRACE)
+	bufPair.Data.WriteBytes(literal.QUOTE)
+	bufPair.Data.WriteString(s.namespace)
+	bufPair.Data.WriteBytes(literal.QUOTE)
+	bufPair.Data.WriteBytes(literal.COLON)
+	bufPair.Data.WriteBytes(data)
+	bufPair.Data.WriteBytes(literal.RBRACE)
+}
+
 func (s *Source) UniqueIdentifier() []byte {
 	return uniqueIdentifier
 }
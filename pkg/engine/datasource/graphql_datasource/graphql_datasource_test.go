@@ -1,12 +1,21 @@
 package graphql_datasource
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
 	. "github.com/jensneuse/graphql-go-tools/pkg/engine/datasourcetesting"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/graphql-websocket-subscription"
 )
 
 func TestGraphQLDataSource(t *testing.T) {
@@ -282,6 +291,99 @@ func TestGraphQLDataSource(t *testing.T) {
 		},
 	))
 
+	t.Run("simple mutation with automatic persisted queries", RunTest(`
+		type Mutation {
+			addFriend(name: String!):Friend!
+		}
+		type Friend {
+			id: ID!
+			name: String!
+		}
+	`,
+		`mutation AddFriend($name: String!){ addFriend(name: $name){ id name } }`,
+		"AddFriend",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fetch: &resolve.SingleFetch{
+						BufferId: 0,
+						Input:    `{"method":"POST","url":"https://service.one","body":{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"affca026fdf00ce2f1f4fa6a7b600b5b05122d2fce6570c4f1ad2afe075236f7"}},"variables":{"name":"$$0$$"}}}`,
+						DataSource: &Source{
+							persistedQuery: "mutation($name: String!){addFriend(name: $name){id name}}",
+						},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"name"},
+							},
+						),
+						DisallowSingleFlight: true,
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("addFriend"),
+							Value: &resolve.Object{
+								Fields: []*resolve.Field{
+									{
+										Name: []byte("id"),
+										Value: &resolve.String{
+											Path: []string{"id"},
+										},
+									},
+									{
+										Name: []byte("name"),
+										Value: &resolve.String{
+											Path: []string{"name"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Mutation",
+							FieldNames: []string{"addFriend"},
+						},
+					},
+					ChildNodes: []plan.TypeField{
+						{
+							TypeName:   "Friend",
+							FieldNames: []string{"id", "name"},
+						},
+					},
+					Custom: ConfigJson(Configuration{
+						Fetch: FetchConfiguration{
+							URL:                       "https://service.one",
+							AutomaticPersistedQueries: true,
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:              "Mutation",
+					FieldName:             "addFriend",
+					DisableDefaultMapping: true,
+					Arguments: []plan.ArgumentConfiguration{
+						{
+							Name:       "name",
+							SourceType: plan.FieldArgumentSource,
+						},
+					},
+				},
+			},
+		},
+	))
+
 	t.Run("nested resolvers of same upstream", RunTest(`
 		type Query {
 			foo(bar: String):Baz
@@ -1451,6 +1553,100 @@ func TestGraphQLDataSource(t *testing.T) {
 		},
 	}))
 
+	t.Run("subscription with server-sent events", RunTest(testDefinition, `
+		subscription RemainingJedis {
+			remainingJedis
+		}
+	`, "RemainingJedis", &plan.SubscriptionResponsePlan{
+		Response: resolve.GraphQLSubscription{
+			Trigger: resolve.GraphQLSubscriptionTrigger{
+				ManagerID: []byte("sse_stream"),
+				Input:     `{"method":"POST","url":"https://swapi.com/graphql","body":{"query":"subscription{remainingJedis}"}}`,
+			},
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fields: []*resolve.Field{
+						{
+							Name: []byte("remainingJedis"),
+							Value: &resolve.Integer{
+								Path:     []string{"remainingJedis"},
+								Nullable: false,
+							},
+						},
+					},
+				},
+			},
+		},
+	}, plan.Configuration{
+		DataSources: []plan.DataSourceConfiguration{
+			{
+				RootNodes: []plan.TypeField{
+					{
+						TypeName:   "Subscription",
+						FieldNames: []string{"remainingJedis"},
+					},
+				},
+				Custom: ConfigJson(Configuration{
+					Subscription: SubscriptionConfiguration{
+						URL:    "https://swapi.com/graphql",
+						UseSSE: true,
+					},
+				}),
+				Factory: &Factory{},
+			},
+		},
+	}))
+
+	t.Run("subscription with protocol and connection init payload", RunTest(testDefinition, `
+		subscription RemainingJedis {
+			remainingJedis
+		}
+	`, "RemainingJedis", &plan.SubscriptionResponsePlan{
+		Response: resolve.GraphQLSubscription{
+			Trigger: resolve.GraphQLSubscriptionTrigger{
+				ManagerID: []byte("graphql_websocket_subscription"),
+				Input:     `{"connection_init_payload":{"Authorization":"$$0$$"},"protocol":"graphql-transport-ws","url":"wss://swapi.com/graphql","body":{"query":"subscription{remainingJedis}"}}`,
+				Variables: resolve.NewVariables(
+					&resolve.HeaderVariable{
+						Path: []string{"Authorization"},
+					},
+				),
+			},
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fields: []*resolve.Field{
+						{
+							Name: []byte("remainingJedis"),
+							Value: &resolve.Integer{
+								Path:     []string{"remainingJedis"},
+								Nullable: false,
+							},
+						},
+					},
+				},
+			},
+		},
+	}, plan.Configuration{
+		DataSources: []plan.DataSourceConfiguration{
+			{
+				RootNodes: []plan.TypeField{
+					{
+						TypeName:   "Subscription",
+						FieldNames: []string{"remainingJedis"},
+					},
+				},
+				Custom: ConfigJson(Configuration{
+					Subscription: SubscriptionConfiguration{
+						URL:                   "wss://swapi.com/graphql",
+						Protocol:              graphql_websocket_subscription.ProtocolGraphQLTransportWS,
+						ConnectionInitPayload: []byte(`{"Authorization":"{{ .request.headers.Authorization }}"}`),
+					},
+				}),
+				Factory: &Factory{},
+			},
+		},
+	}))
+
 	t.Run("subscription with variables", RunTest(`
 		type Subscription {
 			foo(bar: String): Int!
@@ -2796,3 +2992,113 @@ type Api {
 
 union DeleteDeploymentResponse = Success | Error
 `
+
+func TestSource_AutomaticPersistedQueries(t *testing.T) {
+	query := "{hello}"
+	extensions := string(persistedQueryExtensions([]byte(query)))
+
+	// the upstream knows the hash once the query was sent along with it
+	newServer := func(errorResponse string, bodies *[]string) *httptest.Server {
+		registered := false
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			*bodies = append(*bodies, string(body))
+			switch {
+			case bytes.Contains(body, []byte(`"query"`)):
+				registered = errorResponse != `{"errors":[{"message":"PersistedQueryNotSupported"}]}`
+			case !registered:
+				_, _ = w.Write([]byte(errorResponse))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"hello":"world"}}`))
+		}))
+	}
+
+	load := func(t *testing.T, source *Source, url string) string {
+		input := httpclient.SetInputURL(nil, []byte(url))
+		input = httpclient.SetInputMethod(input, []byte("POST"))
+		input = httpclient.SetInputBodyWithPath(input, []byte(extensions), "extensions")
+		bufPair := resolve.NewBufPair()
+		require.NoError(t, source.Load(context.Background(), input, bufPair))
+		assert.Equal(t, "", bufPair.Errors.String())
+		return bufPair.Data.String()
+	}
+
+	t.Run("sends the query once if the hash is not found", func(t *testing.T) {
+		var bodies []string
+		server := newServer(`{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`, &bodies)
+		defer server.Close()
+
+		source := &Source{client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), persistedQuery: query}
+		assert.Equal(t, `{"hello":"world"}`, load(t, source, server.URL))
+		assert.Equal(t, `{"hello":"world"}`, load(t, source, server.URL))
+		assert.Equal(t, []string{
+			`{"extensions":` + extensions + `}`,
+			`{"query":"{hello}","extensions":` + extensions + `}`,
+			`{"extensions":` + extensions + `}`,
+		}, bodies)
+	})
+
+	t.Run("always sends the query if persisted queries are not supported", func(t *testing.T) {
+		var bodies []string
+		server := newServer(`{"errors":[{"message":"PersistedQueryNotSupported"}]}`, &bodies)
+		defer server.Close()
+
+		source := &Source{client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), persistedQuery: query}
+		assert.Equal(t, `{"hello":"world"}`, load(t, source, server.URL))
+		assert.Equal(t, `{"hello":"world"}`, load(t, source, server.URL))
+		assert.Equal(t, []string{
+			`{"extensions":` + extensions + `}`,
+			`{"query":"{hello}","extensions":` + extensions + `}`,
+			`{"query":"{hello}","extensions":` + extensions + `}`,
+		}, bodies)
+	})
+}
+
+func TestSource_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errors":[{"message":"denied","locations":[{"line":1,"column":2}],"path":["me","name"],"extensions":{"code":"FORBIDDEN","exception":{"stacktrace":["at resolver"]}}},{"message":"invalid"}],"data":{"me":null}}`))
+	}))
+	defer server.Close()
+
+	load := func(t *testing.T, errors ErrorsConfiguration) string {
+		source := &Source{client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), errors: errors}
+		input := httpclient.SetInputURL(nil, []byte(server.URL))
+		bufPair := resolve.NewBufPair()
+		require.NoError(t, source.Load(context.Background(), input, bufPair))
+		assert.Equal(t, `{"me":null}`, bufPair.Data.String())
+		return bufPair.Errors.String()
+	}
+
+	t.Run("removes locations and extensions", func(t *testing.T) {
+		assert.Equal(t, `{"message":"denied","path":["me","name"]},{"message":"invalid"}`, load(t, ErrorsConfiguration{}))
+	})
+
+	t.Run("passes through extensions", func(t *testing.T) {
+		assert.Equal(t,
+			`{"message":"denied","path":["me","name"],"extensions":{"code":"FORBIDDEN","exception":{"stacktrace":["at resolver"]}}},{"message":"invalid"}`,
+			load(t, ErrorsConfiguration{PassThroughExtensions: true}),
+		)
+		assert.Equal(t,
+			`{"message":"denied","path":["me","name"],"extensions":{"code":"FORBIDDEN"}},{"message":"invalid"}`,
+			load(t, ErrorsConfiguration{PassThroughExtensions: true, DeniedExtensions: []string{"exception"}}),
+		)
+		assert.Equal(t,
+			`{"message":"denied","path":["me","name"],"extensions":{"code":"FORBIDDEN"}},{"message":"invalid"}`,
+			load(t, ErrorsConfiguration{PassThroughExtensions: true, AllowedExtensions: []string{"code"}}),
+		)
+		assert.Equal(t,
+			`{"message":"denied","path":["me","name"]},{"message":"invalid"}`,
+			load(t, ErrorsConfiguration{PassThroughExtensions: true, AllowedExtensions: []string{"code"}, DeniedExtensions: []string{"code"}}),
+		)
+	})
+
+	t.Run("rewrites paths onto the client response", func(t *testing.T) {
+		fetchPath := []string{"me", "reviews", "1", "product"}
+		assert.Equal(t, `["me","reviews",1,"product","name"]`, string(responseErrorPath(fetchPath, []byte(`["_entities",0,"name"]`), true)))
+		assert.Equal(t, `["me","reviews",1,"product"]`, string(responseErrorPath(fetchPath, []byte(`["_entities",0]`), true)))
+		assert.Equal(t, `["me","reviews",1,"product","variants",2,"price"]`, string(responseErrorPath(fetchPath, []byte(`["variants",2,"price"]`), false)))
+		assert.Nil(t, responseErrorPath(fetchPath, nil, false))
+	})
+}
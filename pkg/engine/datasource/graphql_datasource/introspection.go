@@ -0,0 +1,225 @@
+package graphql_datasource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/astprinter"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
+	"github.com/jensneuse/graphql-go-tools/pkg/introspection"
+	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
+)
+
+const DefaultIntrospectionRefreshInterval = time.Minute
+
+// IntrospectionQuery is sent to upstreams to introspect their schema
+const IntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) { name description args { ...InputValue } type { ...TypeRef } isDeprecated deprecationReason }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type {
+  kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+// IntrospectedConfiguration is the configuration of a GraphQL upstream derived from its introspection
+type IntrospectedConfiguration struct {
+	// SDL is the schema of the upstream
+	SDL string
+	// DataSource has root nodes for all fields of the root operation types and child nodes for all fields of other object and interface types
+	DataSource plan.DataSourceConfiguration
+	// Fields configures the arguments of all fields with arguments
+	Fields plan.FieldConfigurations
+}
+
+// ConfigurationFromIntrospection derives the configuration of the upstream from an introspection result
+// The introspection result is the data of the introspection response, e.g. {"__schema":{...}}, or the whole response, e.g. saved to a file.
+func ConfigurationFromIntrospection(introspectionJSON []byte, config Configuration, factory *Factory) (*IntrospectedConfiguration, error) {
+	if data, dataType, _, err := jsonparser.Get(introspectionJSON, "data"); err == nil && dataType == jsonparser.Object {
+		introspectionJSON = data
+	}
+
+	var data introspection.Data
+	if err := json.Unmarshal(introspectionJSON, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection json: %v", err)
+	}
+	if data.Schema.QueryType == nil {
+		return nil, errors.New("introspection json has no query type")
+	}
+
+	converter := introspection.JsonConverter{}
+	document, err := converter.GraphQLDocument(bytes.NewReader(introspectionJSON))
+	if err != nil {
+		return nil, err
+	}
+	sdl, err := astprinter.PrintStringIndent(document, nil, "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if factory == nil {
+		factory = &Factory{}
+	}
+	introspected := &IntrospectedConfiguration{
+		SDL: sdl,
+		DataSource: plan.DataSourceConfiguration{
+			Factory: factory,
+			Custom:  ConfigJson(config),
+		},
+	}
+
+	query, mutation, subscription := data.Schema.TypeNames()
+	for _, fullType := range data.Schema.Types {
+		if strings.HasPrefix(fullType.Name, "__") || len(fullType.Fields) == 0 {
+			continue
+		}
+
+		typeField := plan.TypeField{
+			TypeName:   fullType.Name,
+			FieldNames: make([]string, 0, len(fullType.Fields)),
+		}
+		for _, field := range fullType.Fields {
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			typeField.FieldNames = append(typeField.FieldNames, field.Name)
+			if len(field.Args) == 0 {
+				continue
+			}
+			fieldConfiguration := plan.FieldConfiguration{
+				TypeName:  fullType.Name,
+				FieldName: field.Name,
+				Arguments: make(plan.ArgumentsConfigurations, 0, len(field.Args)),
+			}
+			for _, arg := range field.Args {
+				fieldConfiguration.Arguments = append(fieldConfiguration.Arguments, plan.ArgumentConfiguration{
+					Name:       arg.Name,
+					SourceType: plan.FieldArgumentSource,
+				})
+			}
+			introspected.Fields = append(introspected.Fields, fieldConfiguration)
+		}
+
+		switch fullType.Name {
+		case query, mutation, subscription:
+			introspected.DataSource.RootNodes = append(introspected.DataSource.RootNodes, typeField)
+		default:
+			introspected.DataSource.ChildNodes = append(introspected.DataSource.ChildNodes, typeField)
+		}
+	}
+
+	return introspected, nil
+}
+
+// IntrospectUpstream sends the IntrospectionQuery to the Fetch URL of the configuration and derives the configuration of the upstream from the response
+// The request is sent by the Client of the factory, the client of the Transport of the configuration or the DefaultNetHttpClient.
+func IntrospectUpstream(ctx context.Context, config Configuration, factory *Factory) (*IntrospectedConfiguration, error) {
+	client, err := introspectionClient(config, factory)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"query":         IntrospectionQuery,
+		"operationName": "IntrospectionQuery",
+	})
+	if err != nil {
+		return nil, err
+	}
+	input := httpclient.SetInputURL(nil, []byte(config.Fetch.URL))
+	input = httpclient.SetInputMethod(input, literal.HTTP_METHOD_POST)
+	input = httpclient.SetInputBody(input, body)
+	if len(config.Fetch.Header) != 0 {
+		header, err := json.Marshal(config.Fetch.Header)
+		if err != nil {
+			return nil, err
+		}
+		input = httpclient.SetInputHeader(input, header)
+	}
+
+	out := &bytes.Buffer{}
+	if err = client.Do(ctx, input, out); err != nil {
+		return nil, fmt.Errorf("introspect %s: %s", config.Fetch.URL, err.Error())
+	}
+	if message, err := jsonparser.GetString(out.Bytes(), "errors", "[0]", "message"); err == nil {
+		return nil, fmt.Errorf("introspect %s: %s", config.Fetch.URL, message)
+	}
+	return ConfigurationFromIntrospection(out.Bytes(), config, factory)
+}
+
+func introspectionClient(config Configuration, factory *Factory) (httpclient.Client, error) {
+	if factory != nil && factory.Client != nil {
+		return factory.Client, nil
+	}
+	if config.Transport != nil {
+		return httpclient.DefaultClientCache.NetHttpClient(*config.Transport)
+	}
+	return httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), nil
+}
+
+// IntrospectionRefreshConfiguration configures the periodic introspection of an upstream
+type IntrospectionRefreshConfiguration struct {
+	// Interval between two introspections, defaults to DefaultIntrospectionRefreshInterval
+	Interval time.Duration
+	// OnChange is called with the configuration of the first successful introspection and whenever the SDL of the upstream changed
+	// Rebuild the engine configuration with it, e.g. with the merged SDLs of all upstreams.
+	OnChange func(introspected *IntrospectedConfiguration)
+	// OnError is called with the errors of failed introspections, the last configuration stays in use
+	OnError func(err error)
+}
+
+// RefreshIntrospection introspects the upstream periodically until the context is done
+// The first introspection is sent immediately.
+func RefreshIntrospection(ctx context.Context, config Configuration, factory *Factory, refresh IntrospectionRefreshConfiguration) {
+	if refresh.Interval <= 0 {
+		refresh.Interval = DefaultIntrospectionRefreshInterval
+	}
+
+	ticker := time.NewTicker(refresh.Interval)
+	defer ticker.Stop()
+
+	lastSDL := ""
+	for {
+		introspected, err := IntrospectUpstream(ctx, config, factory)
+		switch {
+		case err != nil:
+			if refresh.OnError != nil && ctx.Err() == nil {
+				refresh.OnError(err)
+			}
+		case introspected.SDL != lastSDL:
+			lastSDL = introspected.SDL
+			if refresh.OnChange != nil {
+				refresh.OnChange(introspected)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package graphql_datasource
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/astparser"
+	"github.com/jensneuse/graphql-go-tools/pkg/asttransform"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
+	"github.com/jensneuse/graphql-go-tools/pkg/introspection"
+)
+
+func introspectionResponse(t *testing.T, sdl string) []byte {
+	definition, report := astparser.ParseGraphqlDocumentString(sdl)
+	require.False(t, report.HasErrors(), report.Error())
+	require.NoError(t, asttransform.MergeDefinitionWithBaseSchema(&definition))
+
+	var data introspection.Data
+	introspection.NewGenerator().Generate(&definition, &report, &data)
+	require.False(t, report.HasErrors(), report.Error())
+
+	response, err := json.Marshal(map[string]interface{}{"data": data})
+	require.NoError(t, err)
+	return response
+}
+
+const introspectionUpstreamSDL = `
+type Query { user(id: ID!): User users(first: Int, after: String): [User] }
+type Mutation { updateUser(id: ID!, input: UserInput!): User }
+interface Node { id: ID! }
+type User implements Node { id: ID! name: String friends(first: Int): [User] }
+input UserInput { name: String }
+`
+
+func TestConfigurationFromIntrospection(t *testing.T) {
+	config := Configuration{
+		Fetch: FetchConfiguration{
+			URL: "https://users.service",
+		},
+	}
+
+	t.Run("derives nodes and arguments", func(t *testing.T) {
+		introspected, err := ConfigurationFromIntrospection(introspectionResponse(t, introspectionUpstreamSDL), config, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []plan.TypeField{
+			{TypeName: "Query", FieldNames: []string{"user", "users"}},
+			{TypeName: "Mutation", FieldNames: []string{"updateUser"}},
+		}, introspected.DataSource.RootNodes)
+		assert.Equal(t, []plan.TypeField{
+			{TypeName: "Node", FieldNames: []string{"id"}},
+			{TypeName: "User", FieldNames: []string{"id", "name", "friends"}},
+		}, introspected.DataSource.ChildNodes)
+		assert.Equal(t, plan.FieldConfigurations{
+			{TypeName: "Query", FieldName: "user", Arguments: plan.ArgumentsConfigurations{{Name: "id", SourceType: plan.FieldArgumentSource}}},
+			{TypeName: "Query", FieldName: "users", Arguments: plan.ArgumentsConfigurations{{Name: "first", SourceType: plan.FieldArgumentSource}, {Name: "after", SourceType: plan.FieldArgumentSource}}},
+			{TypeName: "Mutation", FieldName: "updateUser", Arguments: plan.ArgumentsConfigurations{{Name: "id", SourceType: plan.FieldArgumentSource}, {Name: "input", SourceType: plan.FieldArgumentSource}}},
+			{TypeName: "User", FieldName: "friends", Arguments: plan.ArgumentsConfigurations{{Name: "first", SourceType: plan.FieldArgumentSource}}},
+		}, introspected.Fields)
+		assert.Equal(t, &Factory{}, introspected.DataSource.Factory)
+		assert.Equal(t, ConfigJson(config), introspected.DataSource.Custom)
+		assert.Contains(t, introspected.SDL, "updateUser(id: ID!, input: UserInput!): User")
+	})
+
+	t.Run("rejects invalid introspection json", func(t *testing.T) {
+		_, err := ConfigurationFromIntrospection([]byte(`{"data":{"__schema":{"types":[]}}}`), config, nil)
+		assert.EqualError(t, err, "introspection json has no query type")
+	})
+}
+
+func TestIntrospectUpstream(t *testing.T) {
+	var (
+		mux      sync.Mutex
+		sdl      = introspectionUpstreamSDL
+		failures int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var request struct {
+			Query string `json:"query"`
+		}
+		require.NoError(t, json.Unmarshal(body, &request))
+		assert.Equal(t, IntrospectionQuery, request.Query)
+
+		if atomic.LoadInt32(&failures) > 0 {
+			atomic.AddInt32(&failures, -1)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"introspection disabled"}]}`))
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		_, _ = w.Write(introspectionResponse(t, sdl))
+	}))
+	defer server.Close()
+
+	config := Configuration{
+		Fetch: FetchConfiguration{
+			URL:    server.URL,
+			Header: http.Header{"Authorization": {"secret"}},
+		},
+	}
+
+	t.Run("introspects the upstream", func(t *testing.T) {
+		introspected, err := IntrospectUpstream(context.Background(), config, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []plan.TypeField{
+			{TypeName: "Query", FieldNames: []string{"user", "users"}},
+			{TypeName: "Mutation", FieldNames: []string{"updateUser"}},
+		}, introspected.DataSource.RootNodes)
+
+		atomic.StoreInt32(&failures, 1)
+		_, err = IntrospectUpstream(context.Background(), config, nil)
+		assert.EqualError(t, err, "introspect "+server.URL+": introspection disabled")
+	})
+
+	t.Run("refreshes the configuration on changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		changes := make(chan *IntrospectedConfiguration)
+		errs := make(chan error, 1)
+		go RefreshIntrospection(ctx, config, nil, IntrospectionRefreshConfiguration{
+			Interval: 10 * time.Millisecond,
+			OnChange: func(introspected *IntrospectedConfiguration) {
+				changes <- introspected
+			},
+			OnError: func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			},
+		})
+
+		first := <-changes
+		assert.Contains(t, first.SDL, "updateUser")
+
+		atomic.StoreInt32(&failures, 1)
+		assert.EqualError(t, <-errs, "introspect "+server.URL+": introspection disabled")
+
+		mux.Lock()
+		sdl = `type Query { user(id: ID!): User } type User { id: ID! }`
+		mux.Unlock()
+
+		second := <-changes
+		assert.NotContains(t, second.SDL, "updateUser")
+		assert.Equal(t, []plan.TypeField{{TypeName: "Query", FieldNames: []string{"user"}}}, second.DataSource.RootNodes)
+	})
+}
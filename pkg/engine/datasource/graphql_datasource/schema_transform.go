@@ -0,0 +1,551 @@
+package graphql_datasource
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/buger/jsonparser"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
+	"github.com/jensneuse/graphql-go-tools/pkg/astparser"
+	"github.com/jensneuse/graphql-go-tools/pkg/astprinter"
+	"github.com/jensneuse/graphql-go-tools/pkg/astvisitor"
+	"github.com/jensneuse/graphql-go-tools/pkg/operationreport"
+)
+
+// SchemaTransforms rename, prefix, filter and namespace the types and fields of an upstream schema before it gets merged
+// Type and field names of the transforms are the names of the upstream schema.
+type SchemaTransforms struct {
+	// TypePrefix is prepended to the names of all types defined by the upstream schema except the root operation types
+	TypePrefix string
+	// RenameTypes maps type names to new names, it takes precedence over the TypePrefix
+	RenameTypes map[string]string
+	// RenameFields renames fields of object and interface types
+	RenameFields []FieldRename
+	// FilterTypes keeps the types it returns true for, fields and union members of removed types are removed as well
+	FilterTypes func(typeName string) bool
+	// FilterFields keeps the fields of object and interface types it returns true for
+	FilterFields func(typeName, fieldName string) bool
+	// Namespaces wrap all root fields of an operation type into a single root field
+	Namespaces []Namespace
+}
+
+type FieldRename struct {
+	TypeName  string
+	FieldName string
+	RenameTo  string
+}
+
+// Namespace wraps the root fields of the query or mutation type into the field FieldName of type TypeName
+// e.g. { github { repository(name: "graphql-go-tools") { id } } }
+// Operations can select a namespace field only once, aliases of an already selected namespace field are rejected.
+type Namespace struct {
+	OperationType ast.OperationType
+	FieldName     string
+	TypeName      string
+}
+
+// SchemaMapping reverses the SchemaTransforms of an upstream schema, it is part of the Configuration of the data source
+// Type and field names of the mapping are the names of the transformed schema.
+type SchemaMapping struct {
+	// Types maps renamed types to the upstream type names
+	Types map[string]string `json:"types,omitempty"`
+	// Fields maps renamed fields per type to the upstream field names
+	Fields map[string]map[string]string `json:"fields,omitempty"`
+	// Namespaces are the namespace root fields which are removed from upstream operations
+	Namespaces []NamespaceMapping `json:"namespaces,omitempty"`
+}
+
+type NamespaceMapping struct {
+	TypeName  string `json:"typeName"`
+	FieldName string `json:"fieldName"`
+}
+
+// TransformSchema applies the transforms to the upstream SDL
+// It returns the transformed SDL to merge and the mapping to configure the data source of the upstream with.
+func TransformSchema(upstreamSDL string, transforms SchemaTransforms) (string, *SchemaMapping, error) {
+	document, report := astparser.ParseGraphqlDocumentString(upstreamSDL)
+	if report.HasErrors() {
+		return "", nil, report
+	}
+
+	t := &schemaTransformer{
+		document:   &document,
+		transforms: transforms,
+		mapping:    &SchemaMapping{},
+		typeNames:  map[string]string{},
+	}
+	t.collectRootTypeNames()
+	t.filter()
+	if err := t.collectTypeNames(); err != nil {
+		return "", nil, err
+	}
+	t.renameFields()
+	t.renameTypes()
+
+	sdl, err := astprinter.PrintStringIndent(t.document, nil, "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	if len(transforms.Namespaces) == 0 {
+		return sdl, t.mapping, nil
+	}
+
+	rootTypes, report := astparser.ParseGraphqlDocumentString(t.namespaceRootTypes())
+	if report.HasErrors() {
+		return "", nil, report
+	}
+	rootTypesSDL, err := astprinter.PrintStringIndent(&rootTypes, nil, "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	return sdl + "\n\n" + rootTypesSDL, t.mapping, nil
+}
+
+type schemaTransformer struct {
+	document   *ast.Document
+	transforms SchemaTransforms
+	mapping    *SchemaMapping
+	// rootTypeNames are the upstream names of the root operation types
+	rootTypeNames map[ast.OperationType]string
+	// typeNames maps the upstream names of renamed types to the new names
+	typeNames map[string]string
+}
+
+func (t *schemaTransformer) collectRootTypeNames() {
+	t.rootTypeNames = map[ast.OperationType]string{
+		ast.OperationTypeQuery:        "Query",
+		ast.OperationTypeMutation:     "Mutation",
+		ast.OperationTypeSubscription: "Subscription",
+	}
+	for i := range t.document.RootOperationTypeDefinitions {
+		definition := t.document.RootOperationTypeDefinitions[i]
+		t.rootTypeNames[definition.OperationType] = t.document.Input.ByteSliceString(definition.NamedType.Name)
+	}
+}
+
+func (t *schemaTransformer) isRootTypeName(typeName string) bool {
+	for _, rootTypeName := range t.rootTypeNames {
+		if rootTypeName == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// collectTypeNames computes the new names of all types defined by the upstream schema
+func (t *schemaTransformer) collectTypeNames() error {
+	for typeName := range t.transforms.RenameTypes {
+		if t.isRootTypeName(typeName) {
+			return fmt.Errorf("root operation type %s can't be renamed, use a namespace instead", typeName)
+		}
+	}
+
+	for _, namespace := range t.transforms.Namespaces {
+		if namespace.OperationType != ast.OperationTypeQuery && namespace.OperationType != ast.OperationTypeMutation {
+			return fmt.Errorf("namespace %s: only query and mutation fields can be wrapped into namespaces", namespace.FieldName)
+		}
+		rootTypeName := t.rootTypeNames[namespace.OperationType]
+		t.typeNames[rootTypeName] = namespace.TypeName
+		t.mapping.Namespaces = append(t.mapping.Namespaces, NamespaceMapping{
+			TypeName:  rootTypeName,
+			FieldName: namespace.FieldName,
+		})
+	}
+
+	for _, node := range t.document.RootNodes {
+		name := t.typeNameReference(node)
+		if name == nil {
+			continue
+		}
+		typeName := t.document.Input.ByteSliceString(*name)
+		if t.isRootTypeName(typeName) || strings.HasPrefix(typeName, "__") {
+			continue
+		}
+		if renameTo, ok := t.transforms.RenameTypes[typeName]; ok {
+			t.typeNames[typeName] = renameTo
+			continue
+		}
+		if t.transforms.TypePrefix != "" {
+			t.typeNames[typeName] = t.transforms.TypePrefix + typeName
+		}
+	}
+
+	for typeName, renameTo := range t.typeNames {
+		if typeName == renameTo {
+			continue
+		}
+		if t.mapping.Types == nil {
+			t.mapping.Types = map[string]string{}
+		}
+		t.mapping.Types[renameTo] = typeName
+	}
+	return nil
+}
+
+// typeNameReference returns the name of type definitions and extensions or nil for other nodes
+func (t *schemaTransformer) typeNameReference(node ast.Node) *ast.ByteSliceReference {
+	switch node.Kind {
+	case ast.NodeKindObjectTypeDefinition:
+		return &t.document.ObjectTypeDefinitions[node.Ref].Name
+	case ast.NodeKindObjectTypeExtension:
+		return &t.document.ObjectTypeExtensions[node.Ref].Name
+	case ast.NodeKindInterfaceTypeDefinition:
+		return &t.document.InterfaceTypeDefinitions[node.Ref].Name
+	case ast.NodeKindInterfaceTypeExtension:
+		return &t.document.InterfaceTypeExtensions[node.Ref].Name
+	case ast.NodeKindUnionTypeDefinition:
+		return &t.document.UnionTypeDefinitions[node.Ref].Name
+	case ast.NodeKindUnionTypeExtension:
+		return &t.document.UnionTypeExtensions[node.Ref].Name
+	case ast.NodeKindEnumTypeDefinition:
+		return &t.document.EnumTypeDefinitions[node.Ref].Name
+	case ast.NodeKindEnumTypeExtension:
+		return &t.document.EnumTypeExtensions[node.Ref].Name
+	case ast.NodeKindInputObjectTypeDefinition:
+		return &t.document.InputObjectTypeDefinitions[node.Ref].Name
+	case ast.NodeKindInputObjectTypeExtension:
+		return &t.document.InputObjectTypeExtensions[node.Ref].Name
+	case ast.NodeKindScalarTypeDefinition:
+		return &t.document.ScalarTypeDefinitions[node.Ref].Name
+	case ast.NodeKindScalarTypeExtension:
+		return &t.document.ScalarTypeExtensions[node.Ref].Name
+	}
+	return nil
+}
+
+// fieldsDefinition returns the fields of object and interface types and the input fields of input object types
+func (t *schemaTransformer) fieldsDefinition(node ast.Node) (refs *[]int, isInput bool) {
+	switch node.Kind {
+	case ast.NodeKindObjectTypeDefinition:
+		return &t.document.ObjectTypeDefinitions[node.Ref].FieldsDefinition.Refs, false
+	case ast.NodeKindObjectTypeExtension:
+		return &t.document.ObjectTypeExtensions[node.Ref].FieldsDefinition.Refs, false
+	case ast.NodeKindInterfaceTypeDefinition:
+		return &t.document.InterfaceTypeDefinitions[node.Ref].FieldsDefinition.Refs, false
+	case ast.NodeKindInterfaceTypeExtension:
+		return &t.document.InterfaceTypeExtensions[node.Ref].FieldsDefinition.Refs, false
+	case ast.NodeKindInputObjectTypeDefinition:
+		return &t.document.InputObjectTypeDefinitions[node.Ref].InputFieldsDefinition.Refs, true
+	case ast.NodeKindInputObjectTypeExtension:
+		return &t.document.InputObjectTypeExtensions[node.Ref].InputFieldsDefinition.Refs, true
+	}
+	return nil, false
+}
+
+// typeLists returns the union members and implemented interfaces of the node
+func (t *schemaTransformer) typeLists(node ast.Node) *[]int {
+	switch node.Kind {
+	case ast.NodeKindObjectTypeDefinition:
+		return &t.document.ObjectTypeDefinitions[node.Ref].ImplementsInterfaces.Refs
+	case ast.NodeKindObjectTypeExtension:
+		return &t.document.ObjectTypeExtensions[node.Ref].ImplementsInterfaces.Refs
+	case ast.NodeKindUnionTypeDefinition:
+		return &t.document.UnionTypeDefinitions[node.Ref].UnionMemberTypes.Refs
+	case ast.NodeKindUnionTypeExtension:
+		return &t.document.UnionTypeExtensions[node.Ref].UnionMemberTypes.Refs
+	}
+	return nil
+}
+
+// filter removes the types and fields rejected by the filters and all fields, arguments and members referring to removed types
+func (t *schemaTransformer) filter() {
+	if t.transforms.FilterTypes == nil && t.transforms.FilterFields == nil {
+		return
+	}
+
+	removedTypes := map[string]bool{}
+	rootNodes := t.document.RootNodes[:0]
+	for _, node := range t.document.RootNodes {
+		if name := t.typeNameReference(node); name != nil && t.transforms.FilterTypes != nil {
+			typeName := t.document.Input.ByteSliceString(*name)
+			if !t.isRootTypeName(typeName) && !t.transforms.FilterTypes(typeName) {
+				removedTypes[typeName] = true
+				continue
+			}
+		}
+		rootNodes = append(rootNodes, node)
+	}
+	t.document.RootNodes = rootNodes
+
+	for _, node := range t.document.RootNodes {
+		if refs := t.typeLists(node); refs != nil {
+			kept := (*refs)[:0]
+			for _, ref := range *refs {
+				if !removedTypes[t.document.TypeNameString(ref)] {
+					kept = append(kept, ref)
+				}
+			}
+			*refs = kept
+		}
+
+		refs, isInput := t.fieldsDefinition(node)
+		if refs == nil {
+			continue
+		}
+		typeName := t.document.Input.ByteSliceString(*t.typeNameReference(node))
+		kept := (*refs)[:0]
+		for _, ref := range *refs {
+			if isInput {
+				if !removedTypes[t.document.ResolveTypeNameString(t.document.InputValueDefinitions[ref].Type)] {
+					kept = append(kept, ref)
+				}
+				continue
+			}
+			fieldName := t.document.FieldDefinitionNameString(ref)
+			if t.transforms.FilterFields != nil && !t.transforms.FilterFields(typeName, fieldName) {
+				continue
+			}
+			if t.fieldRefersTo(ref, removedTypes) {
+				continue
+			}
+			kept = append(kept, ref)
+		}
+		*refs = kept
+	}
+}
+
+func (t *schemaTransformer) fieldRefersTo(fieldDefinition int, typeNames map[string]bool) bool {
+	if typeNames[t.document.ResolveTypeNameString(t.document.FieldDefinitions[fieldDefinition].Type)] {
+		return true
+	}
+	for _, argument := range t.document.FieldDefinitions[fieldDefinition].ArgumentsDefinition.Refs {
+		if typeNames[t.document.ResolveTypeNameString(t.document.InputValueDefinitions[argument].Type)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *schemaTransformer) renameFields() {
+	for _, rename := range t.transforms.RenameFields {
+		for _, node := range t.document.RootNodes {
+			name := t.typeNameReference(node)
+			refs, isInput := t.fieldsDefinition(node)
+			if refs == nil || isInput || t.document.Input.ByteSliceString(*name) != rename.TypeName {
+				continue
+			}
+			for _, ref := range *refs {
+				if t.document.FieldDefinitionNameString(ref) != rename.FieldName {
+					continue
+				}
+				t.document.FieldDefinitions[ref].Name = t.document.Input.AppendInputString(rename.RenameTo)
+				t.addFieldMapping(rename)
+			}
+		}
+	}
+}
+
+func (t *schemaTransformer) addFieldMapping(rename FieldRename) {
+	typeName := rename.TypeName
+	if renameTo, ok := t.typeNames[typeName]; ok {
+		typeName = renameTo
+	}
+	if t.mapping.Fields == nil {
+		t.mapping.Fields = map[string]map[string]string{}
+	}
+	if t.mapping.Fields[typeName] == nil {
+		t.mapping.Fields[typeName] = map[string]string{}
+	}
+	t.mapping.Fields[typeName][rename.RenameTo] = rename.FieldName
+}
+
+// renameTypes renames the type definitions and all references to them
+// The named types of the schema definition aren't part of the Types of the document and keep pointing to the root types.
+func (t *schemaTransformer) renameTypes() {
+	if len(t.typeNames) == 0 {
+		return
+	}
+	for _, node := range t.document.RootNodes {
+		name := t.typeNameReference(node)
+		if name == nil {
+			continue
+		}
+		if renameTo, ok := t.typeNames[t.document.Input.ByteSliceString(*name)]; ok {
+			*name = t.document.Input.AppendInputString(renameTo)
+		}
+	}
+	for i := range t.document.Types {
+		if t.document.Types[i].TypeKind != ast.TypeKindNamed {
+			continue
+		}
+		if renameTo, ok := t.typeNames[t.document.TypeNameString(i)]; ok {
+			t.document.Types[i].Name = t.document.Input.AppendInputString(renameTo)
+		}
+	}
+}
+
+// namespaceRootTypes returns the SDL of the root operation types with the namespace fields
+func (t *schemaTransformer) namespaceRootTypes() string {
+	out := &strings.Builder{}
+	for _, namespace := range t.transforms.Namespaces {
+		fmt.Fprintf(out, "type %s { %s: %s! }\n", t.rootTypeNames[namespace.OperationType], namespace.FieldName, namespace.TypeName)
+	}
+	return out.String()
+}
+
+func (m *SchemaMapping) upstreamTypeName(typeName string) string {
+	if m == nil {
+		return typeName
+	}
+	if upstream, ok := m.Types[typeName]; ok {
+		return upstream
+	}
+	return typeName
+}
+
+// typeNames maps the upstream type names to the transformed type names, nil if no types are renamed
+func (m *SchemaMapping) typeNames() map[string]string {
+	if m == nil || len(m.Types) == 0 {
+		return nil
+	}
+	typeNames := make(map[string]string, len(m.Types))
+	for typeName, upstream := range m.Types {
+		typeNames[upstream] = typeName
+	}
+	return typeNames
+}
+
+func (m *SchemaMapping) isNamespace(typeName, fieldName string) bool {
+	if m == nil {
+		return false
+	}
+	for i := range m.Namespaces {
+		if m.Namespaces[i].TypeName == typeName && m.Namespaces[i].FieldName == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseOperation rewrites the normalized upstream operation from the transformed schema onto the upstream schema
+// Renamed fields keep their name in the response by an alias, namespace fields are replaced by their selections.
+func (m *SchemaMapping) reverseOperation(operation, definition *ast.Document, report *operationreport.Report) {
+	if m == nil {
+		return
+	}
+
+	walker := astvisitor.NewWalker(48)
+	visitor := &reverseOperationVisitor{
+		Walker:     &walker,
+		mapping:    m,
+		operation:  operation,
+		definition: definition,
+	}
+	walker.RegisterEnterFieldVisitor(visitor)
+	walker.Walk(operation, definition, report)
+	if report.HasErrors() {
+		return
+	}
+
+	for _, rename := range visitor.renamedFields {
+		if !operation.Fields[rename.field].Alias.IsDefined {
+			operation.Fields[rename.field].Alias = ast.Alias{
+				IsDefined: true,
+				Name:      operation.Fields[rename.field].Name,
+			}
+		}
+		operation.Fields[rename.field].Name = operation.Input.AppendInputString(rename.upstreamName)
+	}
+
+	for i := range operation.Types {
+		if operation.Types[i].TypeKind != ast.TypeKindNamed {
+			continue
+		}
+		if upstream, ok := m.Types[operation.TypeNameString(i)]; ok {
+			operation.Types[i].Name = operation.Input.AppendInputString(upstream)
+		}
+	}
+
+	if len(visitor.namespaceFields) == 0 {
+		return
+	}
+	for i := range operation.OperationDefinitions {
+		set := operation.OperationDefinitions[i].SelectionSet
+		selections := make([]int, 0, len(operation.SelectionSets[set].SelectionRefs))
+		for _, selection := range operation.SelectionSets[set].SelectionRefs {
+			if operation.Selections[selection].Kind == ast.SelectionKindField && visitor.namespaceFields[operation.Selections[selection].Ref] {
+				field := operation.Selections[selection].Ref
+				selections = append(selections, operation.SelectionSets[operation.Fields[field].SelectionSet].SelectionRefs...)
+				continue
+			}
+			selections = append(selections, selection)
+		}
+		operation.SelectionSets[set].SelectionRefs = selections
+	}
+}
+
+type renamedField struct {
+	field        int
+	upstreamName string
+}
+
+type reverseOperationVisitor struct {
+	*astvisitor.Walker
+	mapping               *SchemaMapping
+	operation, definition *ast.Document
+	renamedFields         []renamedField
+	namespaceFields       map[int]bool
+}
+
+func (v *reverseOperationVisitor) EnterField(ref int) {
+	typeName := v.EnclosingTypeDefinition.NameString(v.definition)
+	fieldName := v.operation.FieldNameString(ref)
+
+	// root fields have the operation definition and its selection set as ancestors
+	if len(v.Ancestors) == 2 && v.mapping.isNamespace(typeName, fieldName) {
+		if v.namespaceFields == nil {
+			v.namespaceFields = map[int]bool{}
+		}
+		v.namespaceFields[ref] = true
+		return
+	}
+
+	if upstreamName, ok := v.mapping.Fields[typeName][fieldName]; ok {
+		v.renamedFields = append(v.renamedFields, renamedField{field: ref, upstreamName: upstreamName})
+	}
+}
+
+// renameResponseTypeNames rewrites the __typename values of the upstream response to the transformed type names
+func renameResponseTypeNames(out *bytes.Buffer, value []byte, dataType jsonparser.ValueType, typeNames map[string]string) {
+	switch dataType {
+	case jsonparser.Object:
+		out.WriteByte('{')
+		first := true
+		_ = jsonparser.ObjectEach(value, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			if !first {
+				out.WriteByte(',')
+			}
+			first = false
+			out.WriteByte('"')
+			out.Write(key)
+			out.WriteString(`":`)
+			if dataType == jsonparser.String && string(key) == "__typename" {
+				if typeName, ok := typeNames[string(value)]; ok {
+					value = []byte(typeName)
+				}
+			}
+			renameResponseTypeNames(out, value, dataType, typeNames)
+			return nil
+		})
+		out.WriteByte('}')
+	case jsonparser.Array:
+		out.WriteByte('[')
+		first := true
+		_, _ = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if !first {
+				out.WriteByte(',')
+			}
+			first = false
+			renameResponseTypeNames(out, value, dataType, typeNames)
+		})
+		out.WriteByte(']')
+	case jsonparser.String:
+		out.WriteByte('"')
+		out.Write(value)
+		out.WriteByte('"')
+	default:
+		out.Write(value)
+	}
+}
@@ -0,0 +1,302 @@
+package graphql_datasource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/internal/pkg/unsafeparser"
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
+	"github.com/jensneuse/graphql-go-tools/pkg/astnormalization"
+	"github.com/jensneuse/graphql-go-tools/pkg/asttransform"
+	"github.com/jensneuse/graphql-go-tools/pkg/astvalidation"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	. "github.com/jensneuse/graphql-go-tools/pkg/engine/datasourcetesting"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
+	"github.com/jensneuse/graphql-go-tools/pkg/operationreport"
+)
+
+const transformUpstreamSDL = `
+schema { query: Query mutation: Mutation }
+type Query { user(id: ID!): User search(filter: Filter): [Result] secret: Secret }
+type Mutation { updateUser(id: ID!): User }
+interface Node { id: ID! }
+type User implements Node { id: ID! name: String status: Status internal: String secret: Secret }
+type Secret { value: String }
+enum Status { ACTIVE INACTIVE }
+input Filter { status: Status secret: SecretInput }
+input SecretInput { value: String }
+union Result = User | Secret
+`
+
+var testSchemaTransforms = SchemaTransforms{
+	TypePrefix:  "Users",
+	RenameTypes: map[string]string{"User": "Account"},
+	RenameFields: []FieldRename{
+		{TypeName: "User", FieldName: "name", RenameTo: "fullName"},
+		{TypeName: "Query", FieldName: "user", RenameTo: "account"},
+	},
+	FilterTypes: func(typeName string) bool {
+		return typeName != "Secret" && typeName != "SecretInput"
+	},
+	FilterFields: func(typeName, fieldName string) bool {
+		return fieldName != "internal"
+	},
+	Namespaces: []Namespace{
+		{OperationType: ast.OperationTypeQuery, FieldName: "users", TypeName: "UsersQuery"},
+	},
+}
+
+func TestTransformSchema(t *testing.T) {
+	t.Run("renames, prefixes, filters and namespaces the schema", func(t *testing.T) {
+		sdl, mapping, err := TransformSchema(transformUpstreamSDL, testSchemaTransforms)
+		require.NoError(t, err)
+		assert.Equal(t, `schema {
+    query: Query
+    mutation: Mutation
+}
+
+type UsersQuery {
+    account(id: ID!): Account
+    search(filter: UsersFilter): [UsersResult]
+}
+
+type Mutation {
+    updateUser(id: ID!): Account
+}
+
+interface UsersNode {
+    id: ID!
+}
+
+type Account implements UsersNode {
+    id: ID!
+    fullName: String
+    status: UsersStatus
+}
+
+enum UsersStatus {
+    ACTIVE
+    INACTIVE
+}
+
+input UsersFilter {
+    status: UsersStatus
+}
+
+union UsersResult = Account
+
+type Query {
+    users: UsersQuery!
+}`, sdl)
+		assert.Equal(t, &SchemaMapping{
+			Types: map[string]string{
+				"Account":     "User",
+				"UsersFilter": "Filter",
+				"UsersNode":   "Node",
+				"UsersQuery":  "Query",
+				"UsersResult": "Result",
+				"UsersStatus": "Status",
+			},
+			Fields: map[string]map[string]string{
+				"Account":    {"fullName": "name"},
+				"UsersQuery": {"account": "user"},
+			},
+			Namespaces: []NamespaceMapping{
+				{TypeName: "Query", FieldName: "users"},
+			},
+		}, mapping)
+	})
+
+	t.Run("rejects renaming root operation types", func(t *testing.T) {
+		_, _, err := TransformSchema(transformUpstreamSDL, SchemaTransforms{RenameTypes: map[string]string{"Query": "UsersQuery"}})
+		assert.EqualError(t, err, "root operation type Query can't be renamed, use a namespace instead")
+	})
+
+	t.Run("rejects subscription namespaces", func(t *testing.T) {
+		_, _, err := TransformSchema(transformUpstreamSDL, SchemaTransforms{Namespaces: []Namespace{
+			{OperationType: ast.OperationTypeSubscription, FieldName: "users", TypeName: "UsersSubscription"},
+		}})
+		assert.EqualError(t, err, "namespace users: only query and mutation fields can be wrapped into namespaces")
+	})
+}
+
+func TestGraphQLDataSource_SchemaMapping(t *testing.T) {
+	sdl, mapping, err := TransformSchema(transformUpstreamSDL, testSchemaTransforms)
+	require.NoError(t, err)
+
+	config := plan.Configuration{
+		DataSources: []plan.DataSourceConfiguration{
+			{
+				RootNodes: []plan.TypeField{
+					{TypeName: "Query", FieldNames: []string{"users"}},
+				},
+				ChildNodes: []plan.TypeField{
+					{TypeName: "UsersQuery", FieldNames: []string{"account", "search"}},
+					{TypeName: "Account", FieldNames: []string{"id", "fullName", "status"}},
+				},
+				Custom: ConfigJson(Configuration{
+					Fetch: FetchConfiguration{
+						URL: "https://users.service",
+					},
+					SchemaMapping: mapping,
+				}),
+				Factory: &Factory{},
+			},
+		},
+		Fields: []plan.FieldConfiguration{
+			{
+				TypeName:  "UsersQuery",
+				FieldName: "account",
+				Arguments: []plan.ArgumentConfiguration{
+					{Name: "id", SourceType: plan.FieldArgumentSource},
+				},
+			},
+			{
+				TypeName:  "UsersQuery",
+				FieldName: "search",
+				Arguments: []plan.ArgumentConfiguration{
+					{Name: "filter", SourceType: plan.FieldArgumentSource},
+				},
+			},
+		},
+	}
+
+	t.Run("reverses the transforms in the upstream operation", RunTest(sdl, `
+		query Accounts($id: ID!, $filter: UsersFilter) {
+			users {
+				account(id: $id) { __typename id fullName }
+				search(filter: $filter) { ... on Account { fullName } }
+			}
+		}`,
+		"Accounts",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fetch: &resolve.SingleFetch{
+						BufferId: 0,
+						Input:    `{"method":"POST","url":"https://users.service","body":{"query":"query($id: ID!, $filter: Filter){account: user(id: $id){__typename id fullName: name} search(filter: $filter){__typename ... on User {fullName: name}}}","variables":{"filter":$$1$$,"id":"$$0$$"}}}`,
+						DataSource: &Source{
+							typeNames: mapping.typeNames(),
+							namespace: "users",
+						},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"id"},
+							},
+							&resolve.ContextVariable{
+								Path: []string{"filter"},
+							},
+						),
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("users"),
+							Value: &resolve.Object{
+								Path: []string{"users"},
+								Fields: []*resolve.Field{
+									{
+										Name: []byte("account"),
+										Value: &resolve.Object{
+											Path:     []string{"account"},
+											Nullable: true,
+											Fields: []*resolve.Field{
+												{
+													Name: []byte("__typename"),
+													Value: &resolve.String{
+														Path: []string{"__typename"},
+													},
+												},
+												{
+													Name: []byte("id"),
+													Value: &resolve.String{
+														Path: []string{"id"},
+													},
+												},
+												{
+													Name: []byte("fullName"),
+													Value: &resolve.String{
+														Path:     []string{"fullName"},
+														Nullable: true,
+													},
+												},
+											},
+										},
+									},
+									{
+										Name: []byte("search"),
+										Value: &resolve.Array{
+											Path:     []string{"search"},
+											Nullable: true,
+											Item: &resolve.Object{
+												Nullable: true,
+												Fields: []*resolve.Field{
+													{
+														Name: []byte("fullName"),
+														Value: &resolve.String{
+															Path:     []string{"fullName"},
+															Nullable: true,
+														},
+														OnTypeName: []byte("Account"),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		config,
+	))
+
+	t.Run("rejects aliases of a namespace within one fetch", func(t *testing.T) {
+		// the account fields of both aliases would collide in the upstream operation
+		def := unsafeparser.ParseGraphqlDocumentString(sdl)
+		require.NoError(t, asttransform.MergeDefinitionWithBaseSchema(&def))
+		op := unsafeparser.ParseGraphqlDocumentString(`
+			query Accounts {
+				first: users { account(id: "1") { id } }
+				second: users { account(id: "2") { id } }
+			}`)
+		report := operationreport.Report{}
+		astnormalization.NewNormalizer(true, true).NormalizeOperation(&op, &def, &report)
+		astvalidation.DefaultOperationValidator().Validate(&op, &def, &report)
+		require.False(t, report.HasErrors(), report.Error())
+
+		closer := make(chan struct{})
+		defer close(closer)
+		plan.NewPlanner(config, closer).Plan(&op, &def, "Accounts", &report)
+		require.Len(t, report.ExternalErrors, 1)
+		assert.Equal(t, "namespace field users can only be selected once per operation, got first and second", report.ExternalErrors[0].Message)
+	})
+
+	t.Run("renames __typename and wraps the response into the namespace", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"errors":[{"message":"denied","path":["account","fullName"]}],"data":{"account":{"__typename":"User","id":"1","fullName":null},"search":[{"__typename":"User","fullName":"Jens \"J\""}]}}`))
+		}))
+		defer server.Close()
+
+		source := &Source{
+			client:    httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient),
+			typeNames: mapping.typeNames(),
+			namespace: "users",
+		}
+		bufPair := resolve.NewBufPair()
+		require.NoError(t, source.Load(context.Background(), httpclient.SetInputURL(nil, []byte(server.URL)), bufPair))
+
+		data := `{"account":{"__typename":"Account","id":"1","fullName":null},"search":[{"__typename":"Account","fullName":"Jens \"J\""}]}`
+		assert.Equal(t, `{"users":`+data+`}`, bufPair.Data.String())
+		assert.Equal(t, `{"message":"denied","path":["users","account","fullName"]}`, bufPair.Errors.String())
+	})
+}
@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultRequestCompressionThresholdBytes = 1024
+)
+
+// ContentEncoding is the compression of a request or response body
+type ContentEncoding string
+
+const (
+	ContentEncodingGzip    ContentEncoding = "gzip"
+	ContentEncodingDeflate ContentEncoding = "deflate"
+	// ContentEncodingBrotli is negotiated once a decoder got registered with RegisterDecoder
+	ContentEncodingBrotli   ContentEncoding = "br"
+	ContentEncodingIdentity ContentEncoding = "identity"
+)
+
+// CompressionConfiguration defines the compression of request and response bodies
+// FastHttpClient and NetHttpClient negotiate and decompress responses the same way.
+type CompressionConfiguration struct {
+	// DisableResponseCompression requests uncompressed responses
+	DisableResponseCompression bool
+	// RequestEncoding compresses request bodies with at least RequestThresholdBytes, ContentEncodingGzip or ContentEncodingDeflate
+	// Request bodies aren't compressed if empty or if the request input sets a Content-Encoding header.
+	RequestEncoding ContentEncoding
+	// RequestThresholdBytes defaults to DefaultRequestCompressionThresholdBytes
+	RequestThresholdBytes int
+}
+
+// Decoder decompresses a response body
+type Decoder func(body io.Reader) (io.ReadCloser, error)
+
+var (
+	decodersMux sync.RWMutex
+	decoders    = map[ContentEncoding]Decoder{
+		ContentEncodingGzip: func(body io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(body)
+		},
+		ContentEncodingDeflate: newDeflateReader,
+	}
+	decoderEncodings = []ContentEncoding{ContentEncodingGzip, ContentEncodingDeflate}
+)
+
+// RegisterDecoder adds or replaces the decoder of a content encoding and adds the encoding to the Accept-Encoding header
+// Register a brotli decoder to negotiate ContentEncodingBrotli with upstreams.
+func RegisterDecoder(encoding ContentEncoding, decoder Decoder) {
+	decodersMux.Lock()
+	defer decodersMux.Unlock()
+
+	if _, ok := decoders[encoding]; !ok {
+		decoderEncodings = append(decoderEncodings, encoding)
+	}
+	decoders[encoding] = decoder
+}
+
+// acceptEncoding returns the Accept-Encoding header value for the configuration
+func (c CompressionConfiguration) acceptEncoding() string {
+	if c.DisableResponseCompression {
+		return string(ContentEncodingIdentity)
+	}
+
+	decodersMux.RLock()
+	defer decodersMux.RUnlock()
+
+	encodings := make([]string, len(decoderEncodings))
+	for i := range decoderEncodings {
+		encodings[i] = string(decoderEncodings[i])
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// compressesRequestBody is true if request bodies of the size get compressed
+func (c CompressionConfiguration) compressesRequestBody(size int64) bool {
+	threshold := int64(c.RequestThresholdBytes)
+	if threshold == 0 {
+		threshold = DefaultRequestCompressionThresholdBytes
+	}
+	return c.RequestEncoding != "" && c.RequestEncoding != ContentEncodingIdentity && size != 0 && size >= threshold
+}
+
+// compressRequestBody compresses the body with the request encoding
+func (c CompressionConfiguration) compressRequestBody(body []byte) ([]byte, error) {
+	compressed := &bytes.Buffer{}
+	var writer io.WriteCloser
+	switch c.RequestEncoding {
+	case ContentEncodingGzip:
+		writer = gzip.NewWriter(compressed)
+	case ContentEncodingDeflate:
+		writer = zlib.NewWriter(compressed)
+	default:
+		return nil, fmt.Errorf("unsupported request content encoding: %s", c.RequestEncoding)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// decodeResponseBody writes the body decompressed according to the Content-Encoding header to out
+// Content codings applied one after another, e.g. "deflate, gzip", are removed in reverse order.
+func decodeResponseBody(contentEncoding string, body io.Reader, out io.Writer) error {
+	encodings := strings.Split(contentEncoding, ",")
+	reader := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := ContentEncoding(strings.ToLower(strings.TrimSpace(encodings[i])))
+		if encoding == "" || encoding == ContentEncodingIdentity {
+			continue
+		}
+
+		decodersMux.RLock()
+		decoder, ok := decoders[encoding]
+		decodersMux.RUnlock()
+		if !ok {
+			return fmt.Errorf("unsupported response content encoding: %s", encoding)
+		}
+
+		buffered := bufio.NewReader(reader)
+		if _, err := buffered.Peek(1); err == io.EOF {
+			// empty bodies, e.g. of HEAD requests or 204 responses, aren't encoded
+			return nil
+		}
+		decoded, err := decoder(buffered)
+		if err != nil {
+			return err
+		}
+		defer decoded.Close()
+		reader = decoded
+	}
+
+	_, err := io.Copy(out, reader)
+	return err
+}
+
+// decodedResponseHeader removes the headers describing the encoded body after decompression
+func decodedResponseHeader(header http.Header) http.Header {
+	if header.Get("Content-Encoding") == "" {
+		return header
+	}
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	return header
+}
+
+// newDeflateReader reads zlib wrapped deflate bodies and raw deflate bodies sent by some servers
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return ioutil.NopCloser(flate.NewReader(buffered)), nil
+}
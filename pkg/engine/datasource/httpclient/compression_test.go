@@ -0,0 +1,196 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpClientCompression(t *testing.T) {
+	compress := func(encoding string, data []byte) []byte {
+		buf := &bytes.Buffer{}
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(buf)
+		case "deflate":
+			writer = zlib.NewWriter(buf)
+		case "raw-deflate":
+			writer, _ = flate.NewWriter(buf, flate.DefaultCompression)
+		}
+		_, _ = writer.Write(data)
+		_ = writer.Close()
+		return buf.Bytes()
+	}
+
+	decompress := func(t *testing.T, encoding string, data []byte) []byte {
+		out := &bytes.Buffer{}
+		require.NoError(t, decodeResponseBody(encoding, bytes.NewReader(data), out))
+		return out.Bytes()
+	}
+
+	// clients returns a FastHttpClient and a NetHttpClient with the same compression configuration
+	clients := func(compression CompressionConfiguration) map[string]Client {
+		return map[string]Client{
+			"fast": NewFastHttpClient(DefaultFastHttpClient, WithCompression(compression)),
+			"net":  NewNetHttpClient(DefaultNetHttpClient, WithNetHttpCompression(compression)),
+		}
+	}
+
+	input := func(url string, body string, header string) []byte {
+		in := SetInputURL(nil, []byte(url))
+		in = SetInputMethod(in, []byte("POST"))
+		in = SetInputBody(in, []byte(body))
+		return SetInputHeader(in, []byte(header))
+	}
+
+	t.Run("response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+			encoding := r.URL.Query().Get("encoding")
+			body := []byte(`{"data":{"hello":"world"}}`)
+			switch encoding {
+			case "gzip", "deflate", "raw-deflate":
+				body = compress(encoding, body)
+			case "deflate, gzip":
+				body = compress("gzip", compress("deflate", body))
+			case "empty":
+				encoding, body = "gzip", nil
+			}
+			w.Header().Set("Content-Encoding", strings.TrimPrefix(encoding, "raw-"))
+			_, _ = w.Write(body)
+		}))
+		defer server.Close()
+
+		for name, client := range clients(CompressionConfiguration{}) {
+			client := client
+			t.Run(name, func(t *testing.T) {
+				for _, encoding := range []string{"", "identity", "gzip", "deflate", "raw-deflate", "deflate, gzip"} {
+					ctx, response := InjectResponseContext(context.Background())
+					out := &bytes.Buffer{}
+					err := client.Do(ctx, input(server.URL+"?encoding="+url.QueryEscape(encoding), `{}`, ``), out)
+					assert.NoError(t, err)
+					assert.Equal(t, `{"data":{"hello":"world"}}`, out.String(), encoding)
+					assert.Equal(t, "", response.Header.Get("Content-Encoding"))
+				}
+
+				out := &bytes.Buffer{}
+				err := client.Do(context.Background(), input(server.URL+"?encoding=empty", `{}`, ``), out)
+				assert.NoError(t, err)
+				assert.Equal(t, "", out.String())
+
+				err = client.Do(context.Background(), input(server.URL+"?encoding=compress", `{}`, ``), out)
+				assert.EqualError(t, err, "unsupported response content encoding: compress")
+			})
+		}
+	})
+
+	t.Run("disabled response compression", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Header.Get("Accept-Encoding")))
+		}))
+		defer server.Close()
+
+		for name, client := range clients(CompressionConfiguration{DisableResponseCompression: true}) {
+			out := &bytes.Buffer{}
+			err := client.Do(context.Background(), input(server.URL, `{}`, ``), out)
+			assert.NoError(t, err)
+			assert.Equal(t, "identity", out.String(), name)
+
+			// a configured Accept-Encoding header takes precedence
+			out.Reset()
+			err = client.Do(context.Background(), input(server.URL, `{}`, `{"Accept-Encoding":["gzip"]}`), out)
+			assert.NoError(t, err)
+			assert.Equal(t, "gzip", out.String(), name)
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		// the server responds with the Content-Encoding and the decompressed body of the request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			contentEncoding := r.Header.Get("Content-Encoding")
+			if contentEncoding != "" && contentEncoding != "custom" {
+				body = decompress(t, contentEncoding, body)
+			}
+			_, _ = w.Write([]byte(contentEncoding + " " + string(body)))
+		}))
+		defer server.Close()
+
+		large := `{"query":"` + strings.Repeat("a", DefaultRequestCompressionThresholdBytes) + `"}`
+
+		for name, client := range clients(CompressionConfiguration{RequestEncoding: ContentEncodingGzip}) {
+			out := &bytes.Buffer{}
+			err := client.Do(context.Background(), input(server.URL, `{"query":"small"}`, ``), out)
+			assert.NoError(t, err)
+			assert.Equal(t, ` {"query":"small"}`, out.String(), name)
+
+			out.Reset()
+			err = client.Do(context.Background(), input(server.URL, large, ``), out)
+			assert.NoError(t, err)
+			assert.Equal(t, `gzip `+large, out.String(), name)
+
+			// bodies with configured Content-Encoding are sent as is
+			out.Reset()
+			err = client.Do(context.Background(), input(server.URL, large, `{"Content-Encoding":["custom"]}`), out)
+			assert.NoError(t, err)
+			assert.Equal(t, `custom `+large, out.String(), name)
+		}
+
+		for name, client := range clients(CompressionConfiguration{RequestEncoding: ContentEncodingDeflate, RequestThresholdBytes: 5}) {
+			out := &bytes.Buffer{}
+			err := client.Do(context.Background(), input(server.URL, `{"query":"small"}`, ``), out)
+			assert.NoError(t, err)
+			assert.Equal(t, `deflate {"query":"small"}`, out.String(), name)
+		}
+
+		for name, client := range clients(CompressionConfiguration{RequestEncoding: ContentEncodingBrotli}) {
+			err := client.Do(context.Background(), input(server.URL, large, ``), &bytes.Buffer{})
+			assert.EqualError(t, err, "unsupported request content encoding: br", name)
+		}
+	})
+
+	t.Run("registered decoder", func(t *testing.T) {
+		defer func(registered map[ContentEncoding]Decoder, encodings []ContentEncoding) {
+			decoders, decoderEncodings = registered, encodings
+		}(decoders, decoderEncodings)
+		decoders = map[ContentEncoding]Decoder{ContentEncodingGzip: decoders[ContentEncodingGzip]}
+		decoderEncodings = []ContentEncoding{ContentEncodingGzip}
+
+		// a reversing decoder standing in for brotli
+		RegisterDecoder(ContentEncodingBrotli, func(body io.Reader) (io.ReadCloser, error) {
+			data, err := ioutil.ReadAll(body)
+			for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+				data[i], data[j] = data[j], data[i]
+			}
+			return ioutil.NopCloser(bytes.NewReader(data)), err
+		})
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "gzip, br", r.Header.Get("Accept-Encoding"))
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte(`}{`))
+		}))
+		defer server.Close()
+
+		for name, client := range clients(CompressionConfiguration{}) {
+			out := &bytes.Buffer{}
+			err := client.Do(context.Background(), input(server.URL, `{}`, ``), out)
+			assert.NoError(t, err)
+			assert.Equal(t, `{}`, out.String(), name)
+		}
+	})
+}
@@ -4,18 +4,21 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/jensneuse/abstractlogger"
 	"github.com/valyala/fasthttp"
 
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 )
 
 type FastHttpClient struct {
-	client *fasthttp.Client
-	log    abstractlogger.Logger
+	client      *fasthttp.Client
+	log         abstractlogger.Logger
+	compression CompressionConfiguration
 }
 
 type Option func(c *FastHttpClient)
@@ -26,6 +29,13 @@ func WithLogger(logger abstractlogger.Logger) Option {
 	}
 }
 
+// WithCompression configures the compression of request and response bodies
+func WithCompression(compression CompressionConfiguration) Option {
+	return func(c *FastHttpClient) {
+		c.compression = compression
+	}
+}
+
 func NewFastHttpClient(client *fasthttp.Client, options ...Option) *FastHttpClient {
 	c := &FastHttpClient{
 		client: client,
@@ -45,13 +55,14 @@ var (
 	queryParamsKeys = [][]string{
 		{"name"},
 		{"value"},
+		{"array_style"},
 	}
 	applicationJsonBytes = []byte("application/json")
 	acceptBytes          = []byte("accept")
 	acceptEncodingBytes  = []byte("Accept-Encoding")
-	gzipEncodingBytes    = []byte("gzip")
 	userAgentBytes       = []byte("graphql-go-client")
 	contentEncoding      = []byte("Content-Encoding")
+	contentTypeBytes     = []byte("Content-Type")
 )
 
 func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Writer) (err error) {
@@ -60,7 +71,7 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 		responseBody []byte
 	)
 
-	url, method, body, headers, queryParams := requestInputParams(requestInput)
+	url, method, body, headers, queryParams, forwardedHeaders := requestInputParams(requestInput)
 
 	req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
 	defer func() {
@@ -89,6 +100,10 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 				if err != nil {
 					return
 				}
+				if bytes.EqualFold(key, contentTypeBytes) {
+					req.Header.SetContentTypeBytes(value)
+					return
+				}
 				req.Header.AddBytesKV(key, value)
 			})
 			return err
@@ -98,10 +113,25 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 		}
 	}
 
+	configuredHeader := headerNames(headers)
+	err = eachForwardedHeader(forwardedHeaders, func(key, value string) {
+		if configuredHeader[http.CanonicalHeaderKey(key)] {
+			return
+		}
+		if http.CanonicalHeaderKey(key) == "Content-Type" {
+			req.Header.SetContentType(value)
+			return
+		}
+		req.Header.Add(key, value)
+	})
+	if err != nil {
+		return err
+	}
+
 	if queryParams != nil {
 		_, err = jsonparser.ArrayEach(queryParams, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 			var (
-				parameterName, parameterValue []byte
+				parameterName, parameterValue, arrayStyle []byte
 			)
 			jsonparser.EachKey(value, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 				switch i {
@@ -109,10 +139,14 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 					parameterName = bytes
 				case 1:
 					parameterValue = bytes
+				case 2:
+					arrayStyle = bytes
 				}
 			}, queryParamsKeys...)
 			if len(parameterName) != 0 && len(parameterValue) != 0 {
-				if bytes.Equal(parameterValue[:1], literal.LBRACK) {
+				if bytes.Equal(parameterValue[:1], literal.LBRACK) && bytes.Equal(arrayStyle, commaArrayStyle) {
+					req.URI().QueryArgs().AddBytesKV(parameterName, joinArrayValues(parameterValue))
+				} else if bytes.Equal(parameterValue[:1], literal.LBRACK) {
 					_, _ = jsonparser.ArrayEach(parameterValue, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 						req.URI().QueryArgs().AddBytesKV(parameterName, value)
 					})
@@ -127,8 +161,32 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 	}
 
 	req.Header.SetBytesKV(acceptBytes, applicationJsonBytes)
-	req.Header.SetBytesKV(acceptEncodingBytes, gzipEncodingBytes)
-	req.Header.SetContentTypeBytes(applicationJsonBytes)
+	if len(req.Header.PeekBytes(acceptEncodingBytes)) == 0 {
+		req.Header.SetBytesK(acceptEncodingBytes, f.compression.acceptEncoding())
+	}
+	if len(req.Header.ContentType()) == 0 {
+		req.Header.SetContentTypeBytes(applicationJsonBytes)
+	}
+	if traceparent := tracing.Traceparent(ctx); traceparent != "" {
+		req.Header.Set(tracing.TraceparentHeader, traceparent)
+	}
+	if multipartInput, _, _, err := jsonparser.Get(requestInput, MULTIPART); err == nil {
+		multipartBody, contentType, err := multipartBody(ctx, body, multipartInput)
+		if err != nil {
+			return err
+		}
+		defer multipartBody.Close()
+		// the files are streamed chunked and never compressed
+		req.SetBodyStream(multipartBody, -1)
+		req.Header.SetContentType(contentType)
+	} else if f.compression.compressesRequestBody(int64(len(body))) && len(req.Header.PeekBytes(contentEncoding)) == 0 {
+		compressed, err := f.compression.compressRequestBody(body)
+		if err != nil {
+			return err
+		}
+		req.SetBody(compressed)
+		req.Header.SetBytesK(contentEncoding, string(f.compression.RequestEncoding))
+	}
 
 	if deadline, ok := ctx.Deadline(); ok {
 		err = f.client.DoDeadline(req, res, deadline)
@@ -140,15 +198,15 @@ func (f *FastHttpClient) Do(ctx context.Context, requestInput []byte, out io.Wri
 		return
 	}
 
-	if bytes.Equal(res.Header.PeekBytes(contentEncoding), gzipEncodingBytes) {
-		responseBody, err = res.BodyGunzip()
-		if err != nil {
-			return err
-		}
-	} else {
-		responseBody = res.Body()
+	if responseContext := responseContextFrom(ctx); responseContext != nil {
+		responseContext.StatusCode = res.StatusCode()
+		responseContext.Header = make(http.Header)
+		res.Header.VisitAll(func(key, value []byte) {
+			responseContext.Header.Add(string(key), string(value))
+		})
+		responseContext.Header = decodedResponseHeader(responseContext.Header)
 	}
 
-	_, err = out.Write(responseBody)
-	return err
+	responseBody = res.Body()
+	return decodeResponseBody(string(res.Header.PeekBytes(contentEncoding)), bytes.NewReader(responseBody), out)
 }
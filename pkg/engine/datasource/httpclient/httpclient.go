@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net/http"
 
 	"github.com/buger/jsonparser"
 	byte_template "github.com/jensneuse/byte-template"
@@ -23,6 +24,9 @@ const (
 	BODY        = "body"
 	HEADER      = "header"
 	QUERYPARAMS = "query_params"
+	// FORWARDEDHEADER holds the client request headers selected by the header forwarding rules
+	// Headers set in HEADER take precedence over forwarded headers with the same name.
+	FORWARDEDHEADER = "forwarded_header"
 
 	SCHEME = "scheme"
 	HOST   = "host"
@@ -35,7 +39,9 @@ var (
 		{BODY},
 		{HEADER},
 		{QUERYPARAMS},
+		{FORWARDEDHEADER},
 	}
+	commaArrayStyle        = []byte("comma")
 	subscriptionInputPaths = [][]string{
 		{URL},
 		{HEADER},
@@ -47,6 +53,19 @@ type Client interface {
 	Do(ctx context.Context, requestInput []byte, out io.Writer) (err error)
 }
 
+// joinArrayValues renders all items of a JSON array as a single comma separated value
+// e.g. ["foo","bar"] becomes foo,bar
+func joinArrayValues(array []byte) []byte {
+	var out []byte
+	_, _ = jsonparser.ArrayEach(array, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if len(out) != 0 {
+			out = append(out, literal.COMMA...)
+		}
+		out = append(out, value...)
+	})
+	return out
+}
+
 func wrapQuotesIfString(b []byte) []byte {
 
 	if bytes.HasPrefix(b, []byte("$$")) && bytes.HasSuffix(b, []byte("$$")) {
@@ -134,6 +153,14 @@ func SetInputHeader(input, headers []byte) []byte {
 	return out
 }
 
+func SetInputForwardedHeader(input, forwardedHeader []byte) []byte {
+	if len(forwardedHeader) == 0 {
+		return input
+	}
+	out, _ := sjson.SetRawBytes(input, FORWARDEDHEADER, wrapQuotesIfString(forwardedHeader))
+	return out
+}
+
 func SetInputQueryParams(input, queryParams []byte) []byte {
 	if len(queryParams) == 0 {
 		return input
@@ -166,7 +193,7 @@ func SetInputPath(input, path []byte) []byte {
 	return out
 }
 
-func requestInputParams(input []byte) (url, method, body, headers, queryParams []byte) {
+func requestInputParams(input []byte) (url, method, body, headers, queryParams, forwardedHeaders []byte) {
 	jsonparser.EachKey(input, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 		switch i {
 		case 0:
@@ -175,15 +202,57 @@ func requestInputParams(input []byte) (url, method, body, headers, queryParams [
 			method = bytes
 		case 2:
 			body = bytes
+			if valueType == jsonparser.String {
+				// string bodies (e.g. form encoded or multipart) are stored escaped inside the input JSON
+				body, _ = jsonparser.Unescape(bytes, nil)
+			}
 		case 3:
 			headers = bytes
 		case 4:
 			queryParams = bytes
+		case 5:
+			forwardedHeaders = bytes
 		}
 	}, inputPaths...)
 	return
 }
 
+// headerNames returns the canonical names of the headers of the request input
+func headerNames(headers []byte) map[string]bool {
+	names := map[string]bool{}
+	_ = jsonparser.ObjectEach(headers, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		names[http.CanonicalHeaderKey(string(key))] = true
+		return nil
+	})
+	return names
+}
+
+// eachForwardedHeader calls fn with the unescaped name and value of all forwarded headers
+func eachForwardedHeader(forwardedHeaders []byte, fn func(key, value string)) error {
+	if forwardedHeaders == nil {
+		return nil
+	}
+	return jsonparser.ObjectEach(forwardedHeaders, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		name, err := jsonparser.ParseString(key)
+		if err != nil {
+			return err
+		}
+		var valueErr error
+		_, err = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			parsed, err := jsonparser.ParseString(value)
+			if err != nil {
+				valueErr = err
+				return
+			}
+			fn(name, parsed)
+		})
+		if valueErr != nil {
+			return valueErr
+		}
+		return err
+	})
+}
+
 func GetSubscriptionInput(input []byte) (url, header, body []byte) {
 	jsonparser.EachKey(input, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 		switch i {
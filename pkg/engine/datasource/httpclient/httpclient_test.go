@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/jensneuse/graphql-go-tools/internal/pkg/quotes"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 )
 
@@ -164,6 +165,42 @@ func TestHttpClientDo(t *testing.T) {
 		t.Run("net", runTest(net, background, input, `ok`))
 	})
 
+	t.Run("query params array as comma separated value", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fooValues := r.URL.Query()["foo"]
+			assert.Len(t, fooValues, 1)
+			assert.Equal(t, "bar,baz", fooValues[0])
+			_, err := w.Write([]byte("ok"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var input []byte
+		input = SetInputMethod(input, []byte("GET"))
+		input = SetInputURL(input, []byte(server.URL))
+		input = SetInputQueryParams(input, []byte(`[{"name":"foo","value":["bar","baz"],"array_style":"comma"}]`))
+		t.Run("fast", runTest(fast, background, input, `ok`))
+		t.Run("net", runTest(net, background, input, `ok`))
+	})
+
+	t.Run("post with string body and content type", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+			actualBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `foo="bar"&baz=1`, string(actualBody))
+			_, err = w.Write([]byte("ok"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var input []byte
+		input = SetInputMethod(input, []byte("POST"))
+		input = SetInputBody(input, []byte(`"foo=\"bar\"&baz=1"`))
+		input = SetInputHeader(input, []byte(`{"Content-Type":["application/x-www-form-urlencoded"]}`))
+		input = SetInputURL(input, []byte(server.URL))
+		t.Run("fast", runTest(fast, background, input, `ok`))
+		t.Run("net", runTest(net, background, input, `ok`))
+	})
+
 	t.Run("post", func(t *testing.T) {
 		body := []byte(`{"foo":"bar"}`)
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -186,7 +223,7 @@ func TestHttpClientDo(t *testing.T) {
 		body := []byte(`{"foo":"bar"}`)
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			acceptEncoding := r.Header.Get("Accept-Encoding")
-			assert.Equal(t, "gzip", acceptEncoding)
+			assert.Equal(t, "gzip, deflate", acceptEncoding)
 			actualBody, err := ioutil.ReadAll(r.Body)
 			assert.NoError(t, err)
 			assert.Equal(t, string(body), string(actualBody))
@@ -204,4 +241,79 @@ func TestHttpClientDo(t *testing.T) {
 		t.Run("fast", runTest(fast, background, input, `ok`))
 		t.Run("net", runTest(net, background, input, `ok`))
 	})
+
+	t.Run("forwarded headers", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, []string{"Bearer \"token\""}, r.Header["Authorization"])
+			assert.Equal(t, []string{"a", "b"}, r.Header["X-Forwarded"])
+			assert.Equal(t, []string{"configured"}, r.Header["X-Configured"])
+			_, err := w.Write([]byte("ok"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var input []byte
+		input = SetInputMethod(input, []byte("GET"))
+		input = SetInputURL(input, []byte(server.URL))
+		input = SetInputHeader(input, []byte(`{"X-Configured":["configured"]}`))
+		input = SetInputForwardedHeader(input, []byte(`{"Authorization":["Bearer \"token\""],"X-Forwarded":["a","b"],"x-configured":["forwarded"]}`))
+		t.Run("fast", runTest(fast, background, input, `ok`))
+		t.Run("net", runTest(net, background, input, `ok`))
+	})
+}
+
+func TestHttpClientResponseContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://example.com?page=2>; rel="next"`)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var input []byte
+	input = SetInputMethod(input, []byte("GET"))
+	input = SetInputURL(input, []byte(server.URL))
+
+	run := func(client Client) func(t *testing.T) {
+		return func(t *testing.T) {
+			ctx, response := InjectResponseContext(context.Background())
+			out := &bytes.Buffer{}
+			assert.NoError(t, client.Do(ctx, input, out))
+			assert.Equal(t, "ok", out.String())
+			assert.Equal(t, http.StatusAccepted, response.StatusCode)
+			assert.Equal(t, `<https://example.com?page=2>; rel="next"`, response.Header.Get("Link"))
+		}
+	}
+
+	t.Run("fast", run(NewFastHttpClient(DefaultFastHttpClient)))
+	t.Run("net", run(NewNetHttpClient(DefaultNetHttpClient)))
+}
+
+func TestHttpClientTraceparent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("traceparent")))
+	}))
+	defer server.Close()
+
+	var input []byte
+	input = SetInputMethod(input, []byte("GET"))
+	input = SetInputURL(input, []byte(server.URL))
+
+	run := func(client Client) func(t *testing.T) {
+		return func(t *testing.T) {
+			exporter := tracing.NewInMemoryExporter()
+			ctx, span := exporter.Start(context.Background(), "fetch")
+			defer span.End()
+
+			out := &bytes.Buffer{}
+			assert.NoError(t, client.Do(ctx, input, out))
+			assert.Equal(t, span.SpanContext().Traceparent(), out.String())
+
+			out.Reset()
+			assert.NoError(t, client.Do(context.Background(), input, out))
+			assert.Equal(t, "", out.String())
+		}
+	}
+
+	t.Run("fast", run(NewFastHttpClient(DefaultFastHttpClient)))
+	t.Run("net", run(NewNetHttpClient(DefaultNetHttpClient)))
 }
@@ -0,0 +1,190 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// MULTIPART turns the request into a GraphQL multipart request, it holds the map and the files of the request
+	// e.g. {"map":{"0":["variables.file"]},"files":{"0":"<placeholder>"}}
+	MULTIPART = "multipart"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// File is a file of a GraphQL multipart request (file upload)
+type File interface {
+	FileName() string
+	ContentType() string
+	Open() (io.ReadCloser, error)
+}
+
+// Files are the files of a client request by the placeholder used as variable value
+type Files map[string]File
+
+type filesKey struct{}
+
+// InjectFiles makes the files of a client request available to the clients sending upstream requests
+func InjectFiles(ctx context.Context, files Files) context.Context {
+	return context.WithValue(ctx, filesKey{}, files)
+}
+
+// FilesFromContext returns the files of the client request, if any
+func FilesFromContext(ctx context.Context) Files {
+	files, _ := ctx.Value(filesKey{}).(Files)
+	return files
+}
+
+// SetInputFiles turns the input into a multipart request if the variables of the body contain file placeholders
+// Placeholders get replaced with null and their paths are added to the map, as defined by the GraphQL multipart request spec.
+func SetInputFiles(input []byte, files Files) ([]byte, error) {
+	if len(files) == 0 {
+		return input, nil
+	}
+	variables, dataType, _, err := jsonparser.Get(input, BODY, "variables")
+	if err != nil || dataType != jsonparser.Object {
+		return input, nil
+	}
+
+	var (
+		placeholders []string
+		paths        = map[string][]string{}
+	)
+	walkStrings(variables, dataType, "variables", func(path string, value string) {
+		if _, ok := files[value]; !ok {
+			return
+		}
+		if _, ok := paths[value]; !ok {
+			placeholders = append(placeholders, value)
+		}
+		paths[value] = append(paths[value], path)
+	})
+	if len(placeholders) == 0 {
+		return input, nil
+	}
+
+	fileMap := make(map[string][]string, len(placeholders))
+	fileParts := make(map[string]string, len(placeholders))
+	for i, placeholder := range placeholders {
+		name := strconv.Itoa(i)
+		fileMap[name] = paths[placeholder]
+		fileParts[name] = placeholder
+		for _, path := range paths[placeholder] {
+			input, err = sjson.SetRawBytes(input, BODY+"."+path, []byte("null"))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return sjson.SetBytes(input, MULTIPART, map[string]interface{}{
+		"map":   fileMap,
+		"files": fileParts,
+	})
+}
+
+// walkStrings calls fn with the dot separated path of all string values
+func walkStrings(value []byte, dataType jsonparser.ValueType, path string, fn func(path string, value string)) {
+	switch dataType {
+	case jsonparser.String:
+		str, err := jsonparser.ParseString(value)
+		if err == nil {
+			fn(path, str)
+		}
+	case jsonparser.Object:
+		_ = jsonparser.ObjectEach(value, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			walkStrings(value, dataType, path+"."+string(key), fn)
+			return nil
+		})
+	case jsonparser.Array:
+		i := 0
+		_, _ = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			walkStrings(value, dataType, path+"."+strconv.Itoa(i), fn)
+			i++
+		})
+	}
+}
+
+// multipartBody streams the body as operations field followed by the map and the files of the multipart input
+// The returned body must be closed to stop streaming.
+func multipartBody(ctx context.Context, body, multipartInput []byte) (io.ReadCloser, string, error) {
+	fileMap, _, _, err := jsonparser.Get(multipartInput, "map")
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid multipart input: %s", err.Error())
+	}
+
+	files := FilesFromContext(ctx)
+	var (
+		names []string
+		parts = map[string]File{}
+	)
+	err = jsonparser.ObjectEach(multipartInput, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		placeholder, err := jsonparser.ParseString(value)
+		if err != nil {
+			return err
+		}
+		file, ok := files[placeholder]
+		if !ok {
+			return fmt.Errorf("file %s of multipart input is missing", string(key))
+		}
+		names = append(names, string(key))
+		parts[string(key)] = file
+		return nil
+	}, "files")
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader, writer := io.Pipe()
+	multipartWriter := multipart.NewWriter(writer)
+	go func() {
+		_ = writer.CloseWithError(writeMultipart(multipartWriter, body, fileMap, names, parts))
+	}()
+	return reader, multipartWriter.FormDataContentType(), nil
+}
+
+func writeMultipart(writer *multipart.Writer, operations, fileMap []byte, names []string, parts map[string]File) error {
+	if err := writer.WriteField("operations", string(operations)); err != nil {
+		return err
+	}
+	if err := writer.WriteField("map", string(fileMap)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := writeFilePart(writer, name, parts[name]); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeFilePart(writer *multipart.Writer, name string, file File) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, name, quoteEscaper.Replace(file.FileName())))
+	contentType := file.ContentType()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	_, err = io.Copy(part, content)
+	return err
+}
@@ -0,0 +1,93 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFile struct {
+	name    string
+	content string
+}
+
+func (t testFile) FileName() string {
+	return t.name
+}
+
+func (t testFile) ContentType() string {
+	return "text/plain"
+}
+
+func (t testFile) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(t.content))), nil
+}
+
+func TestSetInputFiles(t *testing.T) {
+	files := Files{
+		"upload:a": testFile{name: "a.txt", content: "a"},
+		"upload:b": testFile{name: "b.txt", content: "b"},
+	}
+
+	t.Run("replaces placeholders with null and adds the map", func(t *testing.T) {
+		input := SetInputBody(nil, []byte(`{"query":"mutation","variables":{"file":"upload:a","files":["upload:b","upload:a"],"name":"upload:c"}}`))
+		input, err := SetInputFiles(input, files)
+		require.NoError(t, err)
+		assert.Equal(t, `{"multipart":{"files":{"0":"upload:a","1":"upload:b"},"map":{"0":["variables.file","variables.files.1"],"1":["variables.files.0"]}},"body":{"query":"mutation","variables":{"file":null,"files":[null,null],"name":"upload:c"}}}`, string(input))
+	})
+
+	t.Run("keeps inputs without placeholders", func(t *testing.T) {
+		input := SetInputBody(nil, []byte(`{"query":"query","variables":{"name":"a"}}`))
+		out, err := SetInputFiles(input, files)
+		require.NoError(t, err)
+		assert.Equal(t, string(input), string(out))
+	})
+}
+
+func TestHttpClientMultipart(t *testing.T) {
+	// the server responds with the operations, the map and the files of the multipart request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		_, _ = w.Write([]byte(r.FormValue("operations") + " " + r.FormValue("map")))
+		for _, name := range []string{"0", "1"} {
+			file, header, err := r.FormFile(name)
+			require.NoError(t, err)
+			content, err := ioutil.ReadAll(file)
+			require.NoError(t, err)
+			_, _ = w.Write([]byte(" " + header.Filename + ":" + header.Header.Get("Content-Type") + ":" + string(content)))
+		}
+	}))
+	defer server.Close()
+
+	files := Files{
+		"upload:a": testFile{name: `a "quoted".txt`, content: "a"},
+		"upload:b": testFile{name: "b.txt", content: "b"},
+	}
+	input := SetInputURL(nil, []byte(server.URL))
+	input = SetInputMethod(input, []byte("POST"))
+	input = SetInputBody(input, []byte(`{"variables":{"a":"upload:a","b":"upload:b"}}`))
+	input = SetInputHeader(input, []byte(`{"Content-Type":["application/json"]}`))
+	input, err := SetInputFiles(input, files)
+	require.NoError(t, err)
+
+	clients := map[string]Client{
+		"fast": NewFastHttpClient(DefaultFastHttpClient, WithCompression(CompressionConfiguration{RequestEncoding: ContentEncodingGzip, RequestThresholdBytes: 1})),
+		"net":  NewNetHttpClient(DefaultNetHttpClient, WithNetHttpCompression(CompressionConfiguration{RequestEncoding: ContentEncodingGzip, RequestThresholdBytes: 1})),
+	}
+	for name, client := range clients {
+		out := &bytes.Buffer{}
+		err := client.Do(InjectFiles(context.Background(), files), input, out)
+		assert.NoError(t, err, name)
+		assert.Equal(t, `{"variables":{"a":null,"b":null}} {"0":["variables.a"],"1":["variables.b"]} a "quoted".txt:text/plain:a b.txt:text/plain:b`, out.String(), name)
+
+		err = client.Do(context.Background(), input, out)
+		assert.EqualError(t, err, "file 0 of multipart input is missing", name)
+	}
+}
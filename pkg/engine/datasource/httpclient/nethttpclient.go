@@ -4,22 +4,38 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
 	"github.com/buger/jsonparser"
 
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 )
 
 type NetHttpClient struct {
-	client *http.Client
+	client      *http.Client
+	compression CompressionConfiguration
 }
 
-func NewNetHttpClient(client *http.Client) *NetHttpClient {
-	return &NetHttpClient{
+type NetHttpOption func(c *NetHttpClient)
+
+// WithNetHttpCompression configures the compression of request and response bodies
+func WithNetHttpCompression(compression CompressionConfiguration) NetHttpOption {
+	return func(c *NetHttpClient) {
+		c.compression = compression
+	}
+}
+
+func NewNetHttpClient(client *http.Client, options ...NetHttpOption) *NetHttpClient {
+	c := &NetHttpClient{
 		client: client,
 	}
+	for i := range options {
+		options[i](c)
+	}
+	return c
 }
 
 var (
@@ -34,12 +50,74 @@ var (
 
 func (n *NetHttpClient) Do(ctx context.Context, requestInput []byte, out io.Writer) (err error) {
 
-	url, method, body, headers, queryParams := requestInputParams(requestInput)
+	request, err := NewRequest(ctx, requestInput)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("accept", "application/json")
+	if request.Header.Get("content-type") == "" {
+		request.Header.Add("content-type", "application/json")
+	}
+	if request.Header.Get("Accept-Encoding") == "" {
+		request.Header.Set("Accept-Encoding", n.compression.acceptEncoding())
+	}
+	tracing.Inject(ctx, request.Header)
+
+	err = n.compressRequestBody(request)
+	if err != nil {
+		return err
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	contentEncoding := response.Header.Get("Content-Encoding")
+	if responseContext := responseContextFrom(ctx); responseContext != nil {
+		responseContext.StatusCode = response.StatusCode
+		responseContext.Header = decodedResponseHeader(response.Header)
+	}
+
+	return decodeResponseBody(contentEncoding, response.Body, out)
+}
+
+func (n *NetHttpClient) compressRequestBody(request *http.Request) error {
+	if !n.compression.compressesRequestBody(request.ContentLength) || request.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	compressed, err := n.compression.compressRequestBody(body)
+	if err != nil {
+		return err
+	}
+
+	request.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	request.ContentLength = int64(len(compressed))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	request.Header.Set("Content-Encoding", string(n.compression.RequestEncoding))
+	return nil
+}
+
+// NewRequest creates a request from the url, method, body, header, forwarded_header, query_params and multipart of the request input
+// It doesn't set any default headers.
+func NewRequest(ctx context.Context, requestInput []byte) (*http.Request, error) {
+
+	url, method, body, headers, queryParams, forwardedHeaders := requestInputParams(requestInput)
 
 	// Change to `http.NewRequestWithContext` when support for go 1.12 is dropped
 	request, err := NewRequestWithContext(ctx, string(method), string(url), bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if headers != nil {
@@ -53,15 +131,25 @@ func (n *NetHttpClient) Do(ctx context.Context, requestInput []byte, out io.Writ
 			return err
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	configuredHeader := headerNames(headers)
+	err = eachForwardedHeader(forwardedHeaders, func(key, value string) {
+		if !configuredHeader[http.CanonicalHeaderKey(key)] {
+			request.Header.Add(key, value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	if queryParams != nil {
 		query := request.URL.Query()
 		_, err = jsonparser.ArrayEach(queryParams, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 			var (
-				parameterName, parameterValue []byte
+				parameterName, parameterValue, arrayStyle []byte
 			)
 			jsonparser.EachKey(value, func(i int, bytes []byte, valueType jsonparser.ValueType, err error) {
 				switch i {
@@ -69,10 +157,14 @@ func (n *NetHttpClient) Do(ctx context.Context, requestInput []byte, out io.Writ
 					parameterName = bytes
 				case 1:
 					parameterValue = bytes
+				case 2:
+					arrayStyle = bytes
 				}
 			}, queryParamsKeys...)
 			if len(parameterName) != 0 && len(parameterValue) != 0 {
-				if bytes.Equal(parameterValue[:1], literal.LBRACK) {
+				if bytes.Equal(parameterValue[:1], literal.LBRACK) && bytes.Equal(arrayStyle, commaArrayStyle) {
+					query.Add(string(parameterName), string(joinArrayValues(parameterValue)))
+				} else if bytes.Equal(parameterValue[:1], literal.LBRACK) {
 					_, _ = jsonparser.ArrayEach(parameterValue, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 						query.Add(string(parameterName), string(value))
 					})
@@ -82,21 +174,22 @@ func (n *NetHttpClient) Do(ctx context.Context, requestInput []byte, out io.Writ
 			}
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		request.URL.RawQuery = query.Encode()
 	}
 
-	request.Header.Add("accept", "application/json")
-	request.Header.Add("content-type", "application/json")
-
-	response, err := n.client.Do(request)
-	if err != nil {
-		return err
+	if multipartInput, _, _, err := jsonparser.Get(requestInput, MULTIPART); err == nil {
+		multipartBody, contentType, err := multipartBody(ctx, body, multipartInput)
+		if err != nil {
+			return nil, err
+		}
+		// the files are streamed, the content length is unknown
+		request.Body = multipartBody
+		request.ContentLength = -1
+		request.GetBody = nil
+		request.Header.Set("Content-Type", contentType)
 	}
 
-	defer response.Body.Close()
-
-	_, err = io.Copy(out, response.Body)
-	return
+	return request, nil
 }
@@ -0,0 +1,371 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	DefaultOAuth2ExpiryDelta        = 10 * time.Second
+	DefaultOAuth2SubjectTokenHeader = "Authorization"
+	DefaultOAuth2SubjectTokenType   = "urn:ietf:params:oauth:token-type:access_token"
+	DefaultOAuth2MaxCachedTokens    = 10000
+)
+
+// OAuth2GrantType is the grant used to obtain tokens from the token endpoint
+type OAuth2GrantType string
+
+const (
+	// OAuth2GrantTypeClientCredentials obtains a token for the service itself
+	OAuth2GrantTypeClientCredentials OAuth2GrantType = "client_credentials"
+	// OAuth2GrantTypeTokenExchange exchanges the token of the end user for an upstream token (RFC 8693)
+	OAuth2GrantTypeTokenExchange OAuth2GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+var (
+	// ErrOAuth2SubjectTokenMissing rejects token exchange requests without end user token
+	ErrOAuth2SubjectTokenMissing = errors.New("oauth2 subject token is missing")
+)
+
+// OAuth2Configuration defines how an OAuth2Client obtains the tokens for an upstream
+type OAuth2Configuration struct {
+	// TokenURL is the token endpoint of the authorization server
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Audience is sent as audience parameter if not empty, e.g. the URL of the upstream
+	Audience string
+	// GrantType defaults to OAuth2GrantTypeClientCredentials
+	GrantType OAuth2GrantType
+	// SubjectTokenHeader is the header holding the end user token for token exchange
+	// It is looked up in the headers of the request input, the forwarded headers and the client request header
+	// injected with InjectClientRequestHeader, in this order. Defaults to DefaultOAuth2SubjectTokenHeader.
+	SubjectTokenHeader string
+	// SubjectTokenType defaults to DefaultOAuth2SubjectTokenType
+	SubjectTokenType string
+	// ExpiryDelta refreshes tokens before they expire, defaults to DefaultOAuth2ExpiryDelta
+	ExpiryDelta time.Duration
+	// TokenClient sends the token requests, defaults to DefaultNetHttpClient
+	TokenClient *http.Client
+	// MaxCachedTokens limits the cached tokens, defaults to DefaultOAuth2MaxCachedTokens
+	// The least recently used token is evicted once the limit is reached.
+	MaxCachedTokens int
+}
+
+type clientRequestHeaderKey struct{}
+
+// InjectClientRequestHeader makes the header of the client request available to the clients sending upstream requests
+// The OAuth2Client reads the subject token for token exchange from it.
+func InjectClientRequestHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, clientRequestHeaderKey{}, header)
+}
+
+// ClientRequestHeaderFromContext returns the header of the client request, if any
+func ClientRequestHeaderFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(clientRequestHeaderKey{}).(http.Header)
+	return header
+}
+
+// OAuth2TokenError is returned if the token endpoint didn't issue a token
+type OAuth2TokenError struct {
+	StatusCode int
+	// Code and Description are the error and error_description of the token response
+	Code        string
+	Description string
+}
+
+func (e *OAuth2TokenError) Error() string {
+	message := fmt.Sprintf("oauth2 token request failed with status code %d", e.StatusCode)
+	if e.Code != "" {
+		message += ": " + e.Code
+	}
+	if e.Description != "" {
+		message += " (" + e.Description + ")"
+	}
+	return message
+}
+
+// oauth2Token is the cached token of a subject token
+// accessToken and expiry are written while holding both, mux and the mux of the OAuth2Client.
+// lastUsed is guarded by the mux of the OAuth2Client.
+type oauth2Token struct {
+	mux         sync.Mutex
+	accessToken string
+	expiry      time.Time
+	lastUsed    uint64
+}
+
+// OAuth2Client decorates a Client with the Authorization header of an OAuth2 access token
+// Tokens are cached until they expire, per subject token for token exchange, up to MaxCachedTokens.
+// Requests rejected with status code 401 are retried once with a new token.
+// Failures to obtain a token are returned as *RequestError.
+type OAuth2Client struct {
+	client Client
+	config OAuth2Configuration
+	now    func() time.Time
+	mux    sync.Mutex
+	tokens map[string]*oauth2Token
+	uses   uint64
+}
+
+// NewOAuth2Client creates an OAuth2Client with the defaults applied to the configuration
+// Token exchange needs the end user token in the request input or the client request header,
+// see OAuth2Configuration.SubjectTokenHeader. Requests without it fail with ErrOAuth2SubjectTokenMissing.
+func NewOAuth2Client(client Client, config OAuth2Configuration) *OAuth2Client {
+	if config.GrantType == "" {
+		config.GrantType = OAuth2GrantTypeClientCredentials
+	}
+	if config.SubjectTokenHeader == "" {
+		config.SubjectTokenHeader = DefaultOAuth2SubjectTokenHeader
+	}
+	if config.SubjectTokenType == "" {
+		config.SubjectTokenType = DefaultOAuth2SubjectTokenType
+	}
+	if config.ExpiryDelta == 0 {
+		config.ExpiryDelta = DefaultOAuth2ExpiryDelta
+	}
+	if config.TokenClient == nil {
+		config.TokenClient = DefaultNetHttpClient
+	}
+	if config.MaxCachedTokens <= 0 {
+		config.MaxCachedTokens = DefaultOAuth2MaxCachedTokens
+	}
+	return &OAuth2Client{
+		client: client,
+		config: config,
+		now:    time.Now,
+		tokens: map[string]*oauth2Token{},
+	}
+}
+
+func (o *OAuth2Client) Do(ctx context.Context, requestInput []byte, out io.Writer) (err error) {
+	url, _, _, headers, _, forwardedHeaders := requestInputParams(requestInput)
+
+	var subjectToken string
+	if o.config.GrantType == OAuth2GrantTypeTokenExchange {
+		subjectToken = o.subjectToken(ctx, headers, forwardedHeaders)
+		if subjectToken == "" {
+			return &RequestError{URL: string(url), Err: ErrOAuth2SubjectTokenMissing}
+		}
+	}
+
+	var (
+		rejectedToken string
+		buf           = &bytes.Buffer{}
+	)
+	for attempt := 1; ; attempt++ {
+		accessToken, err := o.token(ctx, subjectToken, rejectedToken)
+		if err != nil {
+			return &RequestError{URL: string(url), Err: err}
+		}
+
+		buf.Reset()
+		statusCode, err := o.attempt(ctx, setAuthorizationHeader(requestInput, headers, accessToken), buf)
+		if err != nil {
+			return err
+		}
+		if statusCode == http.StatusUnauthorized && attempt == 1 {
+			rejectedToken = accessToken
+			continue
+		}
+		_, err = out.Write(buf.Bytes())
+		return err
+	}
+}
+
+func (o *OAuth2Client) attempt(ctx context.Context, requestInput []byte, out io.Writer) (statusCode int, err error) {
+	callerResponseContext := responseContextFrom(ctx)
+	attemptCtx, responseContext := InjectResponseContext(ctx)
+	err = o.client.Do(attemptCtx, requestInput, out)
+	if callerResponseContext != nil {
+		*callerResponseContext = *responseContext
+	}
+	return responseContext.StatusCode, err
+}
+
+// subjectToken returns the end user token from the configured, forwarded or client request headers without "Bearer " prefix
+func (o *OAuth2Client) subjectToken(ctx context.Context, headers, forwardedHeaders []byte) string {
+	for _, header := range [][]byte{headers, forwardedHeaders} {
+		var token string
+		_ = jsonparser.ObjectEach(header, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			if token != "" || !strings.EqualFold(string(key), o.config.SubjectTokenHeader) {
+				return nil
+			}
+			if dataType == jsonparser.Array {
+				value, dataType, _, _ = jsonparser.Get(value, "[0]")
+			}
+			if dataType == jsonparser.String {
+				token, _ = jsonparser.ParseString(value)
+			}
+			return nil
+		})
+		if token = trimBearer(token); token != "" {
+			return token
+		}
+	}
+	return trimBearer(ClientRequestHeaderFromContext(ctx).Get(o.config.SubjectTokenHeader))
+}
+
+func trimBearer(token string) string {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
+
+// token returns a cached token or requests a new one if it expired or equals the rejected token
+func (o *OAuth2Client) token(ctx context.Context, subjectToken, rejectedToken string) (string, error) {
+	o.mux.Lock()
+	token, ok := o.tokens[subjectToken]
+	if !ok {
+		o.removeExpiredTokens()
+		if len(o.tokens) >= o.config.MaxCachedTokens {
+			o.removeLeastRecentlyUsedToken()
+		}
+		token = &oauth2Token{}
+		o.tokens[subjectToken] = token
+	}
+	o.uses++
+	token.lastUsed = o.uses
+	o.mux.Unlock()
+
+	// concurrent requests wait for the token requested by the first one
+	token.mux.Lock()
+	defer token.mux.Unlock()
+
+	if token.accessToken != "" && token.accessToken != rejectedToken && !o.expired(token) {
+		return token.accessToken, nil
+	}
+
+	accessToken, expiresIn, err := o.requestToken(ctx, subjectToken)
+	if err != nil {
+		// failed subject tokens must not stay in the cache, they are likely invalid
+		o.mux.Lock()
+		if o.tokens[subjectToken] == token {
+			delete(o.tokens, subjectToken)
+		}
+		o.mux.Unlock()
+		return "", err
+	}
+	o.mux.Lock()
+	token.accessToken = accessToken
+	token.expiry = time.Time{}
+	if expiresIn > 0 {
+		token.expiry = o.now().Add(expiresIn)
+	}
+	o.mux.Unlock()
+	return accessToken, nil
+}
+
+// expired is true if the token expires within the expiry delta
+// Tokens without expiry are used until the upstream rejects them.
+func (o *OAuth2Client) expired(token *oauth2Token) bool {
+	return !token.expiry.IsZero() && !o.now().Add(o.config.ExpiryDelta).Before(token.expiry)
+}
+
+func (o *OAuth2Client) removeExpiredTokens() {
+	now := o.now()
+	for subjectToken, token := range o.tokens {
+		if token.accessToken != "" && !token.expiry.IsZero() && now.After(token.expiry) {
+			delete(o.tokens, subjectToken)
+		}
+	}
+}
+
+func (o *OAuth2Client) removeLeastRecentlyUsedToken() {
+	var (
+		leastRecentlyUsed string
+		lastUsed          uint64
+		found             bool
+	)
+	for subjectToken, token := range o.tokens {
+		if !found || token.lastUsed < lastUsed {
+			leastRecentlyUsed, lastUsed, found = subjectToken, token.lastUsed, true
+		}
+	}
+	if found {
+		delete(o.tokens, leastRecentlyUsed)
+	}
+}
+
+func (o *OAuth2Client) requestToken(ctx context.Context, subjectToken string) (accessToken string, expiresIn time.Duration, err error) {
+	form := url.Values{}
+	form.Set("grant_type", string(o.config.GrantType))
+	if len(o.config.Scopes) != 0 {
+		form.Set("scope", strings.Join(o.config.Scopes, " "))
+	}
+	if o.config.Audience != "" {
+		form.Set("audience", o.config.Audience)
+	}
+	if o.config.GrantType == OAuth2GrantTypeTokenExchange {
+		form.Set("subject_token", subjectToken)
+		form.Set("subject_token_type", o.config.SubjectTokenType)
+	}
+
+	request, err := NewRequestWithContext(ctx, http.MethodPost, o.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if o.config.ClientID != "" {
+		request.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+	}
+
+	response, err := o.config.TokenClient.Do(request)
+	if err != nil {
+		return "", 0, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var tokenResponse struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &tokenResponse)
+
+	if response.StatusCode != http.StatusOK || tokenResponse.AccessToken == "" {
+		return "", 0, &OAuth2TokenError{
+			StatusCode:  response.StatusCode,
+			Code:        tokenResponse.Error,
+			Description: tokenResponse.ErrorDescription,
+		}
+	}
+
+	return tokenResponse.AccessToken, time.Duration(tokenResponse.ExpiresIn) * time.Second, nil
+}
+
+// setAuthorizationHeader replaces the Authorization header of the request input
+func setAuthorizationHeader(requestInput, headers []byte, accessToken string) []byte {
+	out := append([]byte(nil), requestInput...)
+	_ = jsonparser.ObjectEach(headers, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		if strings.EqualFold(string(key), "Authorization") {
+			out = jsonparser.Delete(out, HEADER, string(key))
+		}
+		return nil
+	})
+	out, _ = sjson.SetBytes(out, HEADER+".Authorization", []string{"Bearer " + accessToken})
+	return out
+}
@@ -0,0 +1,244 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestOAuth2Client(t *testing.T) {
+	// tokenServer issues the tokens token-1, token-2, ... or the exchanged subject token with the issued token count
+	tokenServer := func(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int64) {
+		issued := &atomic.Int64{}
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID, clientSecret, ok := r.BasicAuth()
+			if !ok || clientID != "client" || clientSecret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"unknown client"}`))
+				return
+			}
+			require.NoError(t, r.ParseForm())
+			count := issued.Inc()
+			token := fmt.Sprintf("token-%d", count)
+			switch r.PostForm.Get("grant_type") {
+			case string(OAuth2GrantTypeClientCredentials):
+				assert.Equal(t, "read write", r.PostForm.Get("scope"))
+			case string(OAuth2GrantTypeTokenExchange):
+				assert.Equal(t, DefaultOAuth2SubjectTokenType, r.PostForm.Get("subject_token_type"))
+				token = fmt.Sprintf("%s-exchanged-%d", r.PostForm.Get("subject_token"), count)
+			}
+			_, _ = fmt.Fprintf(w, `{"access_token":"%s","token_type":"Bearer","expires_in":%d}`, token, expiresIn)
+		})), issued
+	}
+
+	// upstream responds with the Authorization header and rejects the tokens in rejected
+	upstream := func(rejected ...string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			for i := range rejected {
+				if authorization == "Bearer "+rejected[i] {
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`unauthorized`))
+					return
+				}
+			}
+			_, _ = w.Write([]byte(authorization))
+		}))
+	}
+
+	do := func(client Client, input []byte) (string, error) {
+		out := &bytes.Buffer{}
+		err := client.Do(context.Background(), input, out)
+		return out.String(), err
+	}
+
+	input := func(url string, header string) []byte {
+		in := SetInputURL(nil, []byte(url))
+		in = SetInputMethod(in, []byte("GET"))
+		return SetInputHeader(in, []byte(header))
+	}
+
+	t.Run("client credentials", func(t *testing.T) {
+		tokens, issued := tokenServer(t, 3600)
+		defer tokens.Close()
+		server := upstream()
+		defer server.Close()
+
+		client := NewOAuth2Client(NewNetHttpClient(DefaultNetHttpClient), OAuth2Configuration{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+		})
+		now := time.Now()
+		client.now = func() time.Time {
+			return now
+		}
+
+		// the configured Authorization header gets replaced
+		out, err := do(client, input(server.URL, `{"authorization":["Basic foo"]}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-1", out)
+		out, err = do(client, input(server.URL, ``))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-1", out)
+
+		// tokens get refreshed within the expiry delta
+		now = now.Add(time.Hour - DefaultOAuth2ExpiryDelta)
+		out, err = do(client, input(server.URL, ``))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-2", out)
+		assert.Equal(t, int64(2), issued.Load())
+	})
+
+	t.Run("retry once on 401", func(t *testing.T) {
+		tokens, issued := tokenServer(t, 0)
+		defer tokens.Close()
+		server := upstream("token-1")
+		defer server.Close()
+
+		client := NewOAuth2Client(NewFastHttpClient(DefaultFastHttpClient), OAuth2Configuration{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+		})
+
+		ctx, response := InjectResponseContext(context.Background())
+		out := &bytes.Buffer{}
+		err := client.Do(ctx, input(server.URL, ``), out)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-2", out.String())
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		// tokens without expiry are cached until they get rejected
+		output, err := do(client, input(server.URL, ``))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-2", output)
+		assert.Equal(t, int64(2), issued.Load())
+
+		rejectAll := upstream("token-2", "token-3")
+		defer rejectAll.Close()
+		output, err = do(client, input(rejectAll.URL, ``))
+		assert.NoError(t, err)
+		assert.Equal(t, "unauthorized", output)
+		assert.Equal(t, int64(3), issued.Load())
+	})
+
+	t.Run("token exchange", func(t *testing.T) {
+		tokens, issued := tokenServer(t, 3600)
+		defer tokens.Close()
+		server := upstream()
+		defer server.Close()
+
+		client := NewOAuth2Client(NewNetHttpClient(DefaultNetHttpClient), OAuth2Configuration{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			GrantType:    OAuth2GrantTypeTokenExchange,
+		})
+
+		user := func(subjectToken string) []byte {
+			in := input(server.URL, ``)
+			return SetInputForwardedHeader(in, []byte(fmt.Sprintf(`{"Authorization":["Bearer %s"]}`, subjectToken)))
+		}
+
+		out, err := do(client, user("alice"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer alice-exchanged-1", out)
+		out, err = do(client, user("bob"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer bob-exchanged-2", out)
+		out, err = do(client, user("alice"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer alice-exchanged-1", out)
+		assert.Equal(t, int64(2), issued.Load())
+
+		_, err = do(client, input(server.URL, ``))
+		assert.Equal(t, &RequestError{URL: server.URL, Err: ErrOAuth2SubjectTokenMissing}, err)
+	})
+
+	t.Run("token exchange with client request header", func(t *testing.T) {
+		tokens, _ := tokenServer(t, 3600)
+		defer tokens.Close()
+		server := upstream()
+		defer server.Close()
+
+		client := NewOAuth2Client(NewNetHttpClient(DefaultNetHttpClient), OAuth2Configuration{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			GrantType:    OAuth2GrantTypeTokenExchange,
+		})
+
+		ctx := InjectClientRequestHeader(context.Background(), http.Header{"Authorization": []string{"Bearer alice"}})
+		out := &bytes.Buffer{}
+		err := client.Do(ctx, input(server.URL, ``), out)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer alice-exchanged-1", out.String())
+	})
+
+	t.Run("cached tokens are limited", func(t *testing.T) {
+		tokens, issued := tokenServer(t, 0)
+		defer tokens.Close()
+		server := upstream()
+		defer server.Close()
+
+		client := NewOAuth2Client(NewNetHttpClient(DefaultNetHttpClient), OAuth2Configuration{
+			TokenURL:        tokens.URL,
+			ClientID:        "client",
+			ClientSecret:    "secret",
+			GrantType:       OAuth2GrantTypeTokenExchange,
+			MaxCachedTokens: 2,
+		})
+
+		user := func(subjectToken string) []byte {
+			return input(server.URL, fmt.Sprintf(`{"Authorization":["Bearer %s"]}`, subjectToken))
+		}
+
+		for _, subjectToken := range []string{"alice", "bob", "alice", "carol"} {
+			_, err := do(client, user(subjectToken))
+			require.NoError(t, err)
+		}
+		assert.Len(t, client.tokens, 2)
+		assert.Equal(t, int64(3), issued.Load())
+
+		// bob was the least recently used token
+		out, err := do(client, user("alice"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer alice-exchanged-1", out)
+		out, err = do(client, user("bob"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer bob-exchanged-4", out)
+	})
+
+	t.Run("token request failure", func(t *testing.T) {
+		tokens, _ := tokenServer(t, 3600)
+		defer tokens.Close()
+		server := upstream()
+		defer server.Close()
+
+		client := NewOAuth2Client(NewNetHttpClient(DefaultNetHttpClient), OAuth2Configuration{
+			TokenURL:     tokens.URL,
+			ClientID:     "unknown",
+			ClientSecret: "secret",
+		})
+
+		_, err := do(client, input(server.URL, ``))
+		require.Error(t, err)
+		message, ok := RequestErrorMessage(err)
+		assert.True(t, ok)
+		assert.Equal(t, "upstream request rejected: oauth2 token request failed with status code 401: invalid_client (unknown client)", string(message))
+
+		// failed tokens are not cached
+		assert.Len(t, client.tokens, 0)
+	})
+}
@@ -0,0 +1,442 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+const (
+	DefaultRetryInitialInterval = 100 * time.Millisecond
+	DefaultRetryMaxInterval     = 2 * time.Second
+	DefaultRetryMultiplier      = 2
+
+	DefaultCircuitBreakerOpenDuration = 30 * time.Second
+)
+
+var (
+	// DefaultRetryMethods are the idempotent methods which get retried if no methods are configured
+	DefaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+	// DefaultRetryableStatusCodes get retried if no status codes are configured
+	DefaultRetryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+var (
+	// ErrCircuitOpen rejects requests while the circuit breaker of an upstream is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrBulkheadFull rejects requests if all concurrent request slots of an upstream are taken
+	ErrBulkheadFull = errors.New("too many concurrent requests")
+)
+
+// ResilienceConfiguration defines how a ResilientClient protects the requests to an upstream
+type ResilienceConfiguration struct {
+	// Timeout bounds every attempt, zero only applies the deadline of the request context
+	Timeout time.Duration
+	// Retry defines which failed requests get retried
+	Retry RetryConfiguration
+	// CircuitBreaker stops sending requests to an upstream which keeps failing
+	CircuitBreaker CircuitBreakerConfiguration
+	// MaxConcurrentRequests limits the number of requests in flight (bulkhead), zero means unlimited
+	MaxConcurrentRequests int
+	// MaxConcurrentRequestsWait is the time a request waits for a free slot, zero rejects it immediately
+	MaxConcurrentRequestsWait time.Duration
+}
+
+// RetryConfiguration defines how failed requests get retried with exponential backoff
+type RetryConfiguration struct {
+	// MaxRetries is the number of retries after the first attempt, zero disables retries
+	MaxRetries int
+	// Methods are the methods which get retried, defaults to DefaultRetryMethods
+	// Add POST for upstreams which handle POST requests idempotently, e.g. GraphQL queries.
+	Methods []string
+	// StatusCodes are the response status codes which get retried, defaults to DefaultRetryableStatusCodes
+	StatusCodes []int
+	// InitialInterval is the wait time before the first retry, defaults to DefaultRetryInitialInterval
+	InitialInterval time.Duration
+	// MaxInterval caps the wait time between retries, defaults to DefaultRetryMaxInterval
+	MaxInterval time.Duration
+	// Multiplier grows the wait time after each retry, defaults to DefaultRetryMultiplier
+	Multiplier float64
+	// Jitter randomizes the wait time by up to the given fraction, e.g. 0.2 for +/- 20%
+	Jitter float64
+}
+
+// backoff returns the wait time before the given retry, starting at 1
+func (c RetryConfiguration) backoff(retry int) time.Duration {
+	initialInterval := c.InitialInterval
+	if initialInterval <= 0 {
+		initialInterval = DefaultRetryInitialInterval
+	}
+	maxInterval := c.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultRetryMaxInterval
+	}
+	multiplier := c.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	interval := float64(initialInterval) * math.Pow(multiplier, float64(retry-1))
+	if interval > float64(maxInterval) {
+		interval = float64(maxInterval)
+	}
+	if c.Jitter > 0 {
+		interval += interval * c.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(interval)
+}
+
+func (c RetryConfiguration) retriesMethod(method string) bool {
+	if method == "" {
+		method = http.MethodGet
+	}
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = DefaultRetryMethods
+	}
+	for i := range methods {
+		if strings.EqualFold(methods[i], method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c RetryConfiguration) retriesStatusCode(statusCode int) bool {
+	statusCodes := c.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = DefaultRetryableStatusCodes
+	}
+	for i := range statusCodes {
+		if statusCodes[i] == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// CircuitBreakerState is the state of the circuit breaker of a ResilientClient
+type CircuitBreakerState string
+
+const (
+	// CircuitBreakerClosed lets all requests pass
+	CircuitBreakerClosed CircuitBreakerState = "closed"
+	// CircuitBreakerOpen rejects all requests with ErrCircuitOpen
+	CircuitBreakerOpen CircuitBreakerState = "open"
+	// CircuitBreakerHalfOpen lets a limited number of probe requests pass, a successful probe closes the circuit breaker
+	CircuitBreakerHalfOpen CircuitBreakerState = "half_open"
+)
+
+// CircuitBreakerConfiguration defines when the circuit breaker of a ResilientClient opens
+// Failed requests are transport errors, timeouts and responses with a status code of 500 or above.
+type CircuitBreakerConfiguration struct {
+	// FailureThreshold is the number of consecutive failed requests which open the circuit breaker, zero disables it
+	FailureThreshold int
+	// OpenDuration is the time the circuit breaker stays open before probing the upstream, defaults to DefaultCircuitBreakerOpenDuration
+	OpenDuration time.Duration
+	// HalfOpenMaxRequests is the number of concurrent probe requests while half open, defaults to one
+	HalfOpenMaxRequests int
+	// OnStateChange is called whenever the state of the circuit breaker changes, e.g. to alert on failing upstreams
+	OnStateChange func(from, to CircuitBreakerState)
+}
+
+type circuitBreaker struct {
+	config           CircuitBreakerConfiguration
+	mux              sync.Mutex
+	state            CircuitBreakerState
+	failures         int
+	openedAt         time.Time
+	halfOpenRequests int
+	now              func() time.Time
+}
+
+func newCircuitBreaker(config CircuitBreakerConfiguration) *circuitBreaker {
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = DefaultCircuitBreakerOpenDuration
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = 1
+	}
+	return &circuitBreaker{
+		config: config,
+		state:  CircuitBreakerClosed,
+		now:    time.Now,
+	}
+}
+
+func (c *circuitBreaker) State() CircuitBreakerState {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.state == CircuitBreakerOpen && c.now().Sub(c.openedAt) >= c.config.OpenDuration {
+		return CircuitBreakerHalfOpen
+	}
+	return c.state
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent
+func (c *circuitBreaker) allow() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.state == CircuitBreakerOpen {
+		if c.now().Sub(c.openedAt) < c.config.OpenDuration {
+			return ErrCircuitOpen
+		}
+		c.setState(CircuitBreakerHalfOpen)
+		c.halfOpenRequests = 0
+	}
+
+	if c.state == CircuitBreakerHalfOpen {
+		if c.halfOpenRequests >= c.config.HalfOpenMaxRequests {
+			return ErrCircuitOpen
+		}
+		c.halfOpenRequests++
+	}
+
+	return nil
+}
+
+// record updates the state with the outcome of an allowed request
+func (c *circuitBreaker) record(success bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	switch c.state {
+	case CircuitBreakerClosed:
+		if success {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= c.config.FailureThreshold {
+			c.open()
+		}
+	case CircuitBreakerHalfOpen:
+		if success {
+			c.failures = 0
+			c.setState(CircuitBreakerClosed)
+			return
+		}
+		c.open()
+	}
+}
+
+func (c *circuitBreaker) open() {
+	c.openedAt = c.now()
+	c.setState(CircuitBreakerOpen)
+}
+
+func (c *circuitBreaker) setState(state CircuitBreakerState) {
+	if c.state == state {
+		return
+	}
+	from := c.state
+	c.state = state
+	if c.config.OnStateChange != nil {
+		c.config.OnStateChange(from, state)
+	}
+}
+
+// RequestError is returned by a ResilientClient if a request to an upstream failed
+// Data sources forward its message as GraphQL error, unless the request has a resolve.ErrorPolicy which classifies the error by its cause.
+type RequestError struct {
+	URL string
+	// StatusCode is the status code of the last attempt, zero if no response was received
+	StatusCode int
+	// Attempts is the number of requests sent to the upstream
+	Attempts int
+	// Err is the cause, e.g. ErrCircuitOpen, ErrBulkheadFull or a transport error
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("upstream request failed with status code %d after %d attempt(s)", e.StatusCode, e.Attempts)
+	}
+	if e.Attempts == 0 {
+		return fmt.Sprintf("upstream request rejected: %s", e.Err)
+	}
+	return fmt.Sprintf("upstream request failed after %d attempt(s): %s", e.Attempts, e.Err)
+}
+
+// Unwrap returns the cause of the failure, nil if the upstream responded with a retryable status code
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// timeoutError is the cause of failed attempts exceeding the timeout of the ResilientClient
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timeout after %s", e.timeout)
+}
+
+func (e timeoutError) Timeout() bool {
+	return true
+}
+
+func (e timeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// RequestErrorMessage returns the JSON escaped message of a *RequestError to be written as GraphQL error
+// ok is false for any other error.
+func RequestErrorMessage(err error) (message []byte, ok bool) {
+	requestErr, ok := err.(*RequestError)
+	if !ok {
+		return nil, false
+	}
+	quoted, _ := json.Marshal(requestErr.Error())
+	return quoted[1 : len(quoted)-1], true
+}
+
+// ResilientClient decorates a Client with timeouts, retries, a circuit breaker and a bulkhead
+// Create one ResilientClient per upstream so that the circuit breaker and bulkhead only affect the failing upstream.
+type ResilientClient struct {
+	client   Client
+	config   ResilienceConfiguration
+	breaker  *circuitBreaker
+	bulkhead chan struct{}
+}
+
+func NewResilientClient(client Client, config ResilienceConfiguration) *ResilientClient {
+	r := &ResilientClient{
+		client: client,
+		config: config,
+	}
+	if config.CircuitBreaker.FailureThreshold > 0 {
+		r.breaker = newCircuitBreaker(config.CircuitBreaker)
+	}
+	if config.MaxConcurrentRequests > 0 {
+		r.bulkhead = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+	return r
+}
+
+// CircuitBreakerState returns the current state of the circuit breaker, it's always closed if the circuit breaker is disabled
+func (r *ResilientClient) CircuitBreakerState() CircuitBreakerState {
+	if r.breaker == nil {
+		return CircuitBreakerClosed
+	}
+	return r.breaker.State()
+}
+
+func (r *ResilientClient) Do(ctx context.Context, requestInput []byte, out io.Writer) (err error) {
+	url, _ := jsonparser.GetString(requestInput, URL)
+	method, _ := jsonparser.GetString(requestInput, METHOD)
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return &RequestError{URL: url, Err: err}
+	}
+	defer release()
+
+	maxRetries := 0
+	if r.config.Retry.retriesMethod(method) {
+		maxRetries = r.config.Retry.MaxRetries
+	}
+
+	buf := &bytes.Buffer{}
+	for attempt := 1; ; attempt++ {
+		if r.breaker != nil {
+			if err = r.breaker.allow(); err != nil {
+				return &RequestError{URL: url, Attempts: attempt - 1, Err: err}
+			}
+		}
+
+		buf.Reset()
+		statusCode, err := r.attempt(ctx, requestInput, buf)
+		if r.breaker != nil {
+			r.breaker.record(err == nil && statusCode < http.StatusInternalServerError)
+		}
+
+		retryableStatusCode := err == nil && r.config.Retry.retriesStatusCode(statusCode)
+		if err == nil && !retryableStatusCode {
+			_, err = out.Write(buf.Bytes())
+			return err
+		}
+
+		if attempt > maxRetries || ctx.Err() != nil {
+			if retryableStatusCode {
+				return &RequestError{URL: url, StatusCode: statusCode, Attempts: attempt}
+			}
+			return &RequestError{URL: url, Attempts: attempt, Err: err}
+		}
+
+		select {
+		case <-time.After(r.config.Retry.backoff(attempt)):
+		case <-ctx.Done():
+			return &RequestError{URL: url, Attempts: attempt, Err: ctx.Err()}
+		}
+	}
+}
+
+// attempt sends a single request bounded by the timeout and returns the status code of the response
+func (r *ResilientClient) attempt(ctx context.Context, requestInput []byte, out io.Writer) (statusCode int, err error) {
+	attemptCtx := ctx
+	if r.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, r.config.Timeout)
+		defer cancel()
+	}
+
+	callerResponseContext := responseContextFrom(ctx)
+	attemptCtx, responseContext := InjectResponseContext(attemptCtx)
+
+	err = r.client.Do(attemptCtx, requestInput, out)
+	if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = timeoutError{timeout: r.config.Timeout}
+	}
+
+	if callerResponseContext != nil {
+		*callerResponseContext = *responseContext
+	}
+
+	return responseContext.StatusCode, err
+}
+
+// acquire takes a slot of the bulkhead, release must be called once the request is done
+func (r *ResilientClient) acquire(ctx context.Context) (release func(), err error) {
+	if r.bulkhead == nil {
+		return func() {}, nil
+	}
+
+	release = func() {
+		<-r.bulkhead
+	}
+
+	select {
+	case r.bulkhead <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if r.config.MaxConcurrentRequestsWait <= 0 {
+		return nil, ErrBulkheadFull
+	}
+
+	timer := time.NewTimer(r.config.MaxConcurrentRequestsWait)
+	defer timer.Stop()
+
+	select {
+	case r.bulkhead <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBulkheadFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,211 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestResilientClient(t *testing.T) {
+	// statusServer responds with the status codes in order and with the last one once all got used
+	statusServer := func(statusCodes ...int) (*httptest.Server, *atomic.Int64) {
+		requests := &atomic.Int64{}
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i := int(requests.Inc()) - 1
+			if i >= len(statusCodes) {
+				i = len(statusCodes) - 1
+			}
+			w.WriteHeader(statusCodes[i])
+			_, _ = fmt.Fprintf(w, `{"status":%d}`, statusCodes[i])
+		})), requests
+	}
+
+	input := func(method, url string) []byte {
+		in := SetInputURL(nil, []byte(url))
+		return SetInputMethod(in, []byte(method))
+	}
+
+	retry := RetryConfiguration{
+		MaxRetries:      2,
+		InitialInterval: time.Millisecond,
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			Timeout: 10 * time.Millisecond,
+		})
+
+		err := client.Do(context.Background(), input("GET", server.URL), &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Equal(t, "upstream request failed after 1 attempt(s): timeout after 10ms", err.Error())
+	})
+
+	t.Run("retry idempotent requests", func(t *testing.T) {
+		server, requests := statusServer(http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+		defer server.Close()
+
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			Retry: retry,
+		})
+
+		ctx, responseContext := InjectResponseContext(context.Background())
+		out := &bytes.Buffer{}
+		err := client.Do(ctx, input("GET", server.URL), out)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"status":200}`, out.String())
+		assert.Equal(t, http.StatusOK, responseContext.StatusCode)
+		assert.Equal(t, int64(3), requests.Load())
+	})
+
+	t.Run("give up retrying", func(t *testing.T) {
+		server, requests := statusServer(http.StatusServiceUnavailable)
+		defer server.Close()
+
+		client := NewResilientClient(NewFastHttpClient(DefaultFastHttpClient), ResilienceConfiguration{
+			Retry: retry,
+		})
+
+		out := &bytes.Buffer{}
+		err := client.Do(context.Background(), input("GET", server.URL), out)
+		assert.Equal(t, &RequestError{URL: server.URL, StatusCode: http.StatusServiceUnavailable, Attempts: 3}, err)
+		assert.Equal(t, "upstream request failed with status code 503 after 3 attempt(s)", err.Error())
+		assert.Equal(t, 0, out.Len())
+		assert.Equal(t, int64(3), requests.Load())
+	})
+
+	t.Run("don't retry non idempotent requests", func(t *testing.T) {
+		server, requests := statusServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+		defer server.Close()
+
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			Retry: retry,
+		})
+
+		err := client.Do(context.Background(), input("POST", server.URL), &bytes.Buffer{})
+		assert.Equal(t, &RequestError{URL: server.URL, StatusCode: http.StatusServiceUnavailable, Attempts: 1}, err)
+		assert.Equal(t, int64(1), requests.Load())
+
+		postRetry := retry
+		postRetry.Methods = []string{"POST"}
+		client = NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			Retry: postRetry,
+		})
+		err = client.Do(context.Background(), input("POST", server.URL), &bytes.Buffer{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), requests.Load())
+	})
+
+	t.Run("pass non retryable status codes", func(t *testing.T) {
+		server, _ := statusServer(http.StatusInternalServerError)
+		defer server.Close()
+
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			Retry: retry,
+		})
+
+		out := &bytes.Buffer{}
+		err := client.Do(context.Background(), input("GET", server.URL), out)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"status":500}`, out.String())
+	})
+
+	t.Run("circuit breaker", func(t *testing.T) {
+		server, requests := statusServer(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+		defer server.Close()
+
+		var stateChanges []string
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			CircuitBreaker: CircuitBreakerConfiguration{
+				FailureThreshold: 2,
+				OpenDuration:     time.Minute,
+				OnStateChange: func(from, to CircuitBreakerState) {
+					stateChanges = append(stateChanges, string(from)+"->"+string(to))
+				},
+			},
+		})
+		now := time.Now()
+		client.breaker.now = func() time.Time {
+			return now
+		}
+
+		do := func() error {
+			return client.Do(context.Background(), input("GET", server.URL), &bytes.Buffer{})
+		}
+
+		assert.NoError(t, do())
+		assert.Equal(t, CircuitBreakerClosed, client.CircuitBreakerState())
+		assert.NoError(t, do())
+		assert.Equal(t, CircuitBreakerOpen, client.CircuitBreakerState())
+
+		err := do()
+		assert.Equal(t, &RequestError{URL: server.URL, Err: ErrCircuitOpen}, err)
+		assert.Equal(t, "upstream request rejected: circuit breaker is open", err.Error())
+		assert.Equal(t, int64(2), requests.Load())
+
+		// the failed probe opens the circuit breaker again
+		now = now.Add(time.Minute)
+		assert.Equal(t, CircuitBreakerHalfOpen, client.CircuitBreakerState())
+		assert.NoError(t, do())
+		assert.Equal(t, CircuitBreakerOpen, client.CircuitBreakerState())
+
+		now = now.Add(time.Minute)
+		assert.NoError(t, do())
+		assert.Equal(t, CircuitBreakerClosed, client.CircuitBreakerState())
+		assert.Equal(t, int64(4), requests.Load())
+
+		assert.Equal(t, []string{"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed"}, stateChanges)
+	})
+
+	t.Run("bulkhead", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+
+		client := NewResilientClient(NewNetHttpClient(DefaultNetHttpClient), ResilienceConfiguration{
+			MaxConcurrentRequests:     1,
+			MaxConcurrentRequestsWait: 10 * time.Millisecond,
+		})
+
+		done := make(chan error)
+		go func() {
+			done <- client.Do(context.Background(), input("GET", server.URL), &bytes.Buffer{})
+		}()
+		require.Eventually(t, func() bool {
+			return len(client.bulkhead) == 1
+		}, time.Second, time.Millisecond)
+
+		err := client.Do(context.Background(), input("GET", server.URL), &bytes.Buffer{})
+		assert.Equal(t, &RequestError{URL: server.URL, Err: ErrBulkheadFull}, err)
+
+		close(release)
+		assert.NoError(t, <-done)
+		assert.Equal(t, 0, len(client.bulkhead))
+	})
+}
+
+func TestRequestErrorMessage(t *testing.T) {
+	message, ok := RequestErrorMessage(&RequestError{Attempts: 1, Err: fmt.Errorf(`dial "upstream"`)})
+	assert.True(t, ok)
+	assert.Equal(t, `upstream request failed after 1 attempt(s): dial \"upstream\"`, string(message))
+
+	_, ok = RequestErrorMessage(fmt.Errorf("other"))
+	assert.False(t, ok)
+}
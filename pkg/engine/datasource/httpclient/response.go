@@ -0,0 +1,26 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// ResponseContext holds the status code and headers of an upstream response
+// Callers which need more than the response body can inject it into the context passed to Client.Do.
+type ResponseContext struct {
+	StatusCode int
+	Header     http.Header
+}
+
+type responseContextKey struct{}
+
+// InjectResponseContext returns a context which makes clients record the upstream response into the returned ResponseContext
+func InjectResponseContext(ctx context.Context) (context.Context, *ResponseContext) {
+	responseContext := &ResponseContext{}
+	return context.WithValue(ctx, responseContextKey{}, responseContext), responseContext
+}
+
+func responseContextFrom(ctx context.Context) *ResponseContext {
+	responseContext, _ := ctx.Value(responseContextKey{}).(*ResponseContext)
+	return responseContext
+}
@@ -0,0 +1,316 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	DefaultTransportTimeoutMillis             = 10000
+	DefaultTransportDialTimeoutMillis         = 30000
+	DefaultTransportKeepAliveMillis           = 30000
+	DefaultTransportTLSHandshakeTimeoutMillis = 10000
+	DefaultTransportIdleConnTimeoutMillis     = 90000
+	DefaultTransportMaxIdleConnsPerHost       = 1024
+)
+
+// HTTP2Mode defines if and how HTTP/2 is used with the upstream
+type HTTP2Mode string
+
+const (
+	// HTTP2Auto negotiates HTTP/2 with TLS upstreams and uses HTTP/1.1 otherwise
+	HTTP2Auto HTTP2Mode = ""
+	// HTTP2Disabled always uses HTTP/1.1
+	HTTP2Disabled HTTP2Mode = "disabled"
+	// HTTP2PriorKnowledge uses HTTP/2 without negotiation, for plain text upstreams as well (h2c)
+	// Connections are multiplexed, the pool, keepalive and proxy settings don't apply.
+	HTTP2PriorKnowledge HTTP2Mode = "prior_knowledge"
+)
+
+// TransportConfiguration defines the http client used with the upstream of a data source
+// Durations are in milliseconds, zero values use the defaults. Negative durations disable the timeout or keepalive.
+type TransportConfiguration struct {
+	TLS   *TLSConfiguration
+	HTTP2 HTTP2Mode
+	// TimeoutMillis limits the duration of a request including reading the response body
+	TimeoutMillis             int64
+	DialTimeoutMillis         int64
+	KeepAliveMillis           int64
+	TLSHandshakeTimeoutMillis int64
+	IdleConnTimeoutMillis     int64
+	// MaxIdleConns is the maximum number of idle connections to all hosts, zero means no limit
+	MaxIdleConns int
+	// MaxIdleConnsPerHost defaults to DefaultTransportMaxIdleConnsPerHost
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per host including active ones, zero means no limit
+	MaxConnsPerHost   int
+	DisableKeepAlives bool
+	// Compression configures the negotiation of compressed responses and the compression of request bodies
+	Compression CompressionConfiguration
+	// ProxyURL sends all requests through the proxy, e.g. http://proxy:3128
+	ProxyURL string
+	// ProxyFromEnvironment uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables if ProxyURL is empty
+	ProxyFromEnvironment bool
+}
+
+// TLSConfiguration defines the CAs trusted for the upstream and the client certificate for mutual TLS
+// Certificates and keys are PEM encoded, either inline or as file.
+type TLSConfiguration struct {
+	// CACert and CACertFile replace the system CAs if set
+	CACert     string
+	CACertFile string
+	// Cert and Key or CertFile and KeyFile are the client certificate presented to the upstream
+	Cert     string
+	Key      string
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the certificate of the upstream
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// NewTransportClient builds a http client from the transport configuration
+// It fails on invalid certificates, keys or proxy URLs.
+func NewTransportClient(config TransportConfiguration) (*http.Client, error) {
+	tlsConfig, err := config.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: transportDuration(config.TimeoutMillis, DefaultTransportTimeoutMillis),
+	}
+
+	if config.HTTP2 == HTTP2PriorKnowledge {
+		client.Transport = newPriorKnowledgeTransport(config, tlsConfig)
+		return client, nil
+	}
+
+	maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = DefaultTransportMaxIdleConnsPerHost
+	}
+
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   transportDuration(config.DialTimeoutMillis, DefaultTransportDialTimeoutMillis),
+			KeepAlive: keepAlive(config.KeepAliveMillis),
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: transportDuration(config.TLSHandshakeTimeoutMillis, DefaultTransportTLSHandshakeTimeoutMillis),
+		IdleConnTimeout:     transportDuration(config.IdleConnTimeoutMillis, DefaultTransportIdleConnTimeoutMillis),
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		MaxConnsPerHost:     config.MaxConnsPerHost,
+		DisableKeepAlives:   config.DisableKeepAlives,
+		// the NetHttpClient negotiates and decompresses responses
+		DisableCompression: true,
+	}
+
+	switch {
+	case config.ProxyURL != "":
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	case config.ProxyFromEnvironment:
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	switch config.HTTP2 {
+	case HTTP2Auto:
+		// transports with a custom TLS config or dialer don't negotiate HTTP/2 on their own
+		err = http2.ConfigureTransport(transport)
+		if err != nil {
+			return nil, err
+		}
+	case HTTP2Disabled:
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	default:
+		return nil, fmt.Errorf("unknown http2 mode: %s", config.HTTP2)
+	}
+
+	client.Transport = transport
+	return client, nil
+}
+
+// priorKnowledgeTransport sends requests with HTTP/2 over TLS or plain text connections depending on the scheme
+type priorKnowledgeTransport struct {
+	tls       *http2.Transport
+	plainText *http2.Transport
+}
+
+func newPriorKnowledgeTransport(config TransportConfiguration, tlsConfig *tls.Config) *priorKnowledgeTransport {
+	dialer := &net.Dialer{
+		Timeout:   transportDuration(config.DialTimeoutMillis, DefaultTransportDialTimeoutMillis),
+		KeepAlive: keepAlive(config.KeepAliveMillis),
+	}
+	return &priorKnowledgeTransport{
+		tls: &http2.Transport{
+			TLSClientConfig:    tlsConfig,
+			DisableCompression: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return tls.DialWithDialer(dialer, network, addr, cfg)
+			},
+		},
+		plainText: &http2.Transport{
+			DisableCompression: true,
+			AllowHTTP:          true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		},
+	}
+}
+
+func (p *priorKnowledgeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Scheme == "http" {
+		return p.plainText.RoundTrip(request)
+	}
+	return p.tls.RoundTrip(request)
+}
+
+func (p *priorKnowledgeTransport) CloseIdleConnections() {
+	p.tls.CloseIdleConnections()
+	p.plainText.CloseIdleConnections()
+}
+
+// transportDuration returns the default for zero and no duration for negative milliseconds
+func transportDuration(millis, defaultMillis int64) time.Duration {
+	switch {
+	case millis == 0:
+		millis = defaultMillis
+	case millis < 0:
+		millis = 0
+	}
+	return time.Duration(millis) * time.Millisecond
+}
+
+// keepAlive returns a negative duration to disable TCP keepalive, zero would enable the default of the dialer
+func keepAlive(millis int64) time.Duration {
+	if millis < 0 {
+		return -1
+	}
+	return transportDuration(millis, DefaultTransportKeepAliveMillis)
+}
+
+func (t *TLSConfiguration) tlsConfig() (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	caCert, err := pemOrFile(t.CACert, t.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	if caCert != nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("invalid ca certificate")
+		}
+	}
+
+	cert, err := pemOrFile(t.Cert, t.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pemOrFile(t.Key, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil || key != nil {
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %s", err.Error())
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+func pemOrFile(pem, file string) ([]byte, error) {
+	if pem != "" {
+		return []byte(pem), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+// ClientCache builds the http clients of transport configurations once and shares them between data sources with equal configurations
+type ClientCache struct {
+	mux     sync.Mutex
+	clients map[string]*http.Client
+}
+
+func NewClientCache() *ClientCache {
+	return &ClientCache{
+		clients: map[string]*http.Client{},
+	}
+}
+
+var (
+	// DefaultClientCache is used by data sources planned without the ClientCache of an engine
+	DefaultClientCache = NewClientCache()
+)
+
+// Client returns the cached client of the configuration or builds a new one
+func (c *ClientCache) Client(config TransportConfiguration) (*http.Client, error) {
+	key, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if client, ok := c.clients[string(key)]; ok {
+		return client, nil
+	}
+	client, err := NewTransportClient(config)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[string(key)] = client
+	return client, nil
+}
+
+// NetHttpClient returns a NetHttpClient using the cached client of the configuration
+func (c *ClientCache) NetHttpClient(config TransportConfiguration) (*NetHttpClient, error) {
+	client, err := c.Client(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetHttpClient(client, WithNetHttpCompression(config.Compression)), nil
+}
+
+// CloseIdleConnections closes the idle connections of all cached clients
+func (c *ClientCache) CloseIdleConnections() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, client := range c.clients {
+		if closer, ok := client.Transport.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
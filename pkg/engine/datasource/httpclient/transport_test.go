@@ -0,0 +1,240 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// testCertificate is a PEM encoded certificate and key signed by the parent or self signed without parent
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     string
+	keyPEM      string
+}
+
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestNewTransportClient(t *testing.T) {
+	// protoHandler responds with the protocol and the common name of the client certificate
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName := ""
+		if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+			commonName = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		_, _ = fmt.Fprintf(w, "%s %s", r.Proto, commonName)
+	})
+
+	get := func(t *testing.T, client *http.Client, url string) (string, error) {
+		response, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		buf := &bytes.Buffer{}
+		_, err = buf.ReadFrom(response.Body)
+		return buf.String(), err
+	}
+
+	t.Run("mutual tls", func(t *testing.T) {
+		ca := newTestCertificate(t, "ca", nil)
+		serverCertificate := newTestCertificate(t, "server", ca)
+		clientCertificate := newTestCertificate(t, "client", ca)
+
+		certificate, err := tls.X509KeyPair([]byte(serverCertificate.certPEM), []byte(serverCertificate.keyPEM))
+		require.NoError(t, err)
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca.certificate)
+
+		server := httptest.NewUnstartedServer(protoHandler)
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			NextProtos:   []string{http2.NextProtoTLS, "http/1.1"},
+		}
+		require.NoError(t, http2.ConfigureServer(server.Config, nil))
+		server.StartTLS()
+		defer server.Close()
+
+		tlsConfiguration := &TLSConfiguration{
+			CACert: ca.certPEM,
+			Cert:   clientCertificate.certPEM,
+			Key:    clientCertificate.keyPEM,
+		}
+
+		client, err := NewTransportClient(TransportConfiguration{TLS: tlsConfiguration})
+		require.NoError(t, err)
+		out, err := get(t, client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0 client", out)
+
+		client, err = NewTransportClient(TransportConfiguration{TLS: tlsConfiguration, HTTP2: HTTP2Disabled})
+		require.NoError(t, err)
+		out, err = get(t, client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 client", out)
+
+		client, err = NewTransportClient(TransportConfiguration{TLS: tlsConfiguration, HTTP2: HTTP2PriorKnowledge})
+		require.NoError(t, err)
+		out, err = get(t, client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0 client", out)
+
+		// without client certificate
+		client, err = NewTransportClient(TransportConfiguration{TLS: &TLSConfiguration{CACert: ca.certPEM}})
+		require.NoError(t, err)
+		_, err = get(t, client, server.URL)
+		assert.Error(t, err)
+
+		// without the private CA
+		client, err = NewTransportClient(TransportConfiguration{})
+		require.NoError(t, err)
+		_, err = get(t, client, server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("http2 with prior knowledge", func(t *testing.T) {
+		server := httptest.NewServer(h2c.NewHandler(protoHandler, &http2.Server{}))
+		defer server.Close()
+
+		client, err := NewTransportClient(TransportConfiguration{HTTP2: HTTP2PriorKnowledge})
+		require.NoError(t, err)
+		out, err := get(t, client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0 ", out)
+
+		client, err = NewTransportClient(TransportConfiguration{})
+		require.NoError(t, err)
+		out, err = get(t, client, server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 ", out)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "proxied %s", r.URL.String())
+		}))
+		defer proxy.Close()
+
+		client, err := NewTransportClient(TransportConfiguration{ProxyURL: proxy.URL})
+		require.NoError(t, err)
+		out, err := get(t, client, "http://upstream.example/graphql")
+		assert.NoError(t, err)
+		assert.Equal(t, "proxied http://upstream.example/graphql", out)
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := NewTransportClient(TransportConfiguration{TLS: &TLSConfiguration{CACert: "invalid"}})
+		assert.EqualError(t, err, "invalid ca certificate")
+		_, err = NewTransportClient(TransportConfiguration{TLS: &TLSConfiguration{Cert: "invalid"}})
+		assert.Error(t, err)
+		_, err = NewTransportClient(TransportConfiguration{TLS: &TLSConfiguration{CACertFile: "./testdata/missing.pem"}})
+		assert.Error(t, err)
+		_, err = NewTransportClient(TransportConfiguration{HTTP2: "h3"})
+		assert.EqualError(t, err, "unknown http2 mode: h3")
+	})
+
+	t.Run("durations", func(t *testing.T) {
+		client, err := NewTransportClient(TransportConfiguration{IdleConnTimeoutMillis: 500, TimeoutMillis: -1, MaxConnsPerHost: 8})
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), client.Timeout)
+		transport := client.Transport.(*http.Transport)
+		assert.Equal(t, 500*time.Millisecond, transport.IdleConnTimeout)
+		assert.Equal(t, DefaultTransportTLSHandshakeTimeoutMillis*time.Millisecond, transport.TLSHandshakeTimeout)
+		assert.Equal(t, DefaultTransportMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, 8, transport.MaxConnsPerHost)
+	})
+}
+
+func TestClientCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	clientCache := NewClientCache()
+
+	first, err := clientCache.Client(TransportConfiguration{MaxConnsPerHost: 10})
+	require.NoError(t, err)
+	second, err := clientCache.Client(TransportConfiguration{MaxConnsPerHost: 10})
+	require.NoError(t, err)
+	other, err := clientCache.Client(TransportConfiguration{MaxConnsPerHost: 20})
+	require.NoError(t, err)
+	assert.True(t, first == second)
+	assert.False(t, first == other)
+
+	client, err := clientCache.NetHttpClient(TransportConfiguration{})
+	require.NoError(t, err)
+	defaults, err := clientCache.Client(TransportConfiguration{})
+	require.NoError(t, err)
+	assert.True(t, client.client == defaults)
+
+	out := &bytes.Buffer{}
+	err = client.Do(context.Background(), SetInputURL(nil, []byte(server.URL)), out)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ok":true}`, out.String())
+
+	compressed, err := clientCache.NetHttpClient(TransportConfiguration{Compression: CompressionConfiguration{RequestEncoding: ContentEncodingGzip}})
+	require.NoError(t, err)
+	assert.Equal(t, ContentEncodingGzip, compressed.compression.RequestEncoding)
+
+	clientCache.CloseIdleConnections()
+
+	_, err = clientCache.Client(TransportConfiguration{ProxyURL: "://invalid"})
+	assert.Error(t, err)
+}
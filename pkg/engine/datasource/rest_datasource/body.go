@@ -0,0 +1,327 @@
+package rest_datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
+)
+
+var (
+	templateRegex = regexp.MustCompile(`{{.*?}}`)
+)
+
+type bodyPlaceholder struct {
+	template    string
+	placeholder string
+	omitted     bool
+	quote       bool
+}
+
+// prepareBody prepares the body template for rendering
+// Templates used as JSON values (not inside a JSON string) get quoted if the argument is of a string like type.
+// Values and array items of arguments which are not defined by the operation get removed from the body.
+func (p *Planner) prepareBody(field int, body string) string {
+	locations := templateRegex.FindAllStringIndex(body, -1)
+	if len(locations) == 0 {
+		return body
+	}
+
+	var (
+		placeholders     []bodyPlaceholder
+		withPlaceholders strings.Builder
+		last             int
+		anyOmitted       bool
+	)
+
+	for _, location := range locations {
+		if insideJSONString(body[:location[0]]) {
+			continue
+		}
+		template := body[location[0]:location[1]]
+		placeholder := bodyPlaceholder{
+			template:    template,
+			placeholder: fmt.Sprintf(`"__rest_body_placeholder_%d__"`, len(placeholders)),
+		}
+		placeholder.omitted, placeholder.quote = p.bodyArgument(field, template)
+		anyOmitted = anyOmitted || placeholder.omitted
+		placeholders = append(placeholders, placeholder)
+
+		withPlaceholders.WriteString(body[last:location[0]])
+		withPlaceholders.WriteString(placeholder.placeholder)
+		last = location[1]
+	}
+	if len(placeholders) == 0 {
+		return body
+	}
+	withPlaceholders.WriteString(body[last:])
+
+	prepared := []byte(withPlaceholders.String())
+	if !json.Valid(prepared) {
+		return body
+	}
+
+	if anyOmitted {
+		omitted := make(map[string]bool, len(placeholders))
+		for i := range placeholders {
+			if placeholders[i].omitted {
+				omitted[placeholders[i].placeholder] = true
+			}
+		}
+		value, dataType, _, err := jsonparser.Get(prepared)
+		if err != nil {
+			return body
+		}
+		prepared = removeOmittedValues(nil, value, dataType, omitted)
+	}
+
+	for i := range placeholders {
+		replacement := placeholders[i].template
+		if placeholders[i].quote {
+			replacement = `"` + replacement + `"`
+		}
+		prepared = bytes.Replace(prepared, []byte(placeholders[i].placeholder), []byte(replacement), 1)
+	}
+
+	return string(prepared)
+}
+
+// bodyArgument checks whether an argument template is omitted from the operation or needs to be quoted
+func (p *Planner) bodyArgument(field int, template string) (omitted, quote bool) {
+	selectors := selectorRegex.FindStringSubmatch(template)
+	if len(selectors) != 2 {
+		return false, false
+	}
+	elements := strings.Split(strings.TrimPrefix(selectors[1], "."), ".")
+	if len(elements) < 2 || elements[0] != "arguments" {
+		return false, false
+	}
+	variableDefinition, ok := p.argumentVariableDefinition(field, elements[1])
+	if !ok {
+		return true, false
+	}
+	return false, p.v.Operation.TypeValueNeedsQuotes(p.v.Operation.VariableDefinitions[variableDefinition].Type, p.v.Definition)
+}
+
+// argumentVariableDefinition returns the variable definition of the variable used for the argument of a field
+// Arguments not set by the operation render empty, so ok is false for them.
+func (p *Planner) argumentVariableDefinition(field int, argumentName string) (variableDefinition int, ok bool) {
+	arg, ok := p.v.Operation.FieldArgument(field, []byte(argumentName))
+	if !ok {
+		return -1, false
+	}
+	value := p.v.Operation.Arguments[arg].Value
+	if value.Kind != ast.ValueKindVariable {
+		return -1, false
+	}
+	return p.v.Operation.VariableDefinitionByNameAndOperation(p.operationDefinition, p.v.Operation.VariableValueNameBytes(value.Ref))
+}
+
+// unquoteListArguments renders list arguments as JSON arrays so that the http client can apply the array style
+func (p *Planner) unquoteListArguments(field int, query []byte) []byte {
+	for _, template := range templateRegex.FindAllString(string(query), -1) {
+		if !p.isListArgument(field, template) {
+			continue
+		}
+		query = bytes.Replace(query, []byte(`"`+template+`"`), []byte(template), -1)
+	}
+	return query
+}
+
+func (p *Planner) isListArgument(field int, template string) bool {
+	selectors := selectorRegex.FindStringSubmatch(template)
+	if len(selectors) != 2 {
+		return false
+	}
+	elements := strings.Split(strings.TrimPrefix(selectors[1], "."), ".")
+	if len(elements) != 2 || elements[0] != "arguments" {
+		return false
+	}
+	variableDefinition, ok := p.argumentVariableDefinition(field, elements[1])
+	if !ok {
+		return false
+	}
+	typeRef := p.v.Operation.VariableDefinitions[variableDefinition].Type
+	if p.v.Operation.Types[typeRef].TypeKind == ast.TypeKindNonNull {
+		typeRef = p.v.Operation.Types[typeRef].OfType
+	}
+	return p.v.Operation.Types[typeRef].TypeKind == ast.TypeKindList
+}
+
+// insideJSONString reports whether the end of the given JSON prefix is inside of a string
+func insideJSONString(prefix string) bool {
+	inside := false
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '\\':
+			if inside {
+				i++
+			}
+		case '"':
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func removeOmittedValues(out, value []byte, dataType jsonparser.ValueType, omitted map[string]bool) []byte {
+	switch dataType {
+	case jsonparser.Object:
+		out = append(out, literal.LBRACE...)
+		first := true
+		_ = jsonparser.ObjectEach(value, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			if isOmitted(value, dataType, omitted) {
+				return nil
+			}
+			if !first {
+				out = append(out, literal.COMMA...)
+			}
+			first = false
+			escapedKey, _ := json.Marshal(string(key))
+			out = append(out, escapedKey...)
+			out = append(out, literal.COLON...)
+			out = removeOmittedValues(out, value, dataType, omitted)
+			return nil
+		})
+		return append(out, literal.RBRACE...)
+	case jsonparser.Array:
+		out = append(out, literal.LBRACK...)
+		first := true
+		_, _ = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if isOmitted(value, dataType, omitted) {
+				return
+			}
+			if !first {
+				out = append(out, literal.COMMA...)
+			}
+			first = false
+			out = removeOmittedValues(out, value, dataType, omitted)
+		})
+		return append(out, literal.RBRACK...)
+	case jsonparser.String:
+		out = append(out, literal.QUOTE...)
+		out = append(out, value...)
+		return append(out, literal.QUOTE...)
+	default:
+		return append(out, value...)
+	}
+}
+
+func isOmitted(value []byte, dataType jsonparser.ValueType, omitted map[string]bool) bool {
+	if dataType != jsonparser.String {
+		return false
+	}
+	return omitted[`"`+string(value)+`"`]
+}
+
+type bodyField struct {
+	name  string
+	value string
+}
+
+// encodeBody encodes the JSON body of the input according to the body encoding
+// The encoded body is stored as a string in the input and the content-type header is set accordingly.
+func encodeBody(input []byte) ([]byte, error) {
+	encoding, err := jsonparser.GetString(input, "body_encoding")
+	if err != nil || encoding == "" {
+		return input, nil
+	}
+	body, dataType, _, err := jsonparser.Get(input, "body")
+	if err != nil || dataType == jsonparser.NotExist {
+		return input, nil
+	}
+	if dataType != jsonparser.Object {
+		return nil, fmt.Errorf("rest_datasource: body must be a JSON object for %s encoding", encoding)
+	}
+
+	fields, err := flattenBody(nil, "", body, dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		encoded     []byte
+		contentType string
+	)
+
+	switch BodyEncoding(encoding) {
+	case BodyEncodingForm:
+		values := make([]string, 0, len(fields))
+		for i := range fields {
+			values = append(values, url.QueryEscape(fields[i].name)+"="+url.QueryEscape(fields[i].value))
+		}
+		encoded = []byte(strings.Join(values, "&"))
+		contentType = "application/x-www-form-urlencoded"
+	case BodyEncodingMultipart:
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		for i := range fields {
+			if err = writer.WriteField(fields[i].name, fields[i].value); err != nil {
+				return nil, err
+			}
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+		encoded = buf.Bytes()
+		contentType = writer.FormDataContentType()
+	default:
+		return input, nil
+	}
+
+	input = jsonparser.Delete(input, "body_encoding")
+	quotedBody, err := json.Marshal(string(encoded))
+	if err != nil {
+		return nil, err
+	}
+	input = httpclient.SetInputBody(input, quotedBody)
+	return sjson.SetBytes(input, "header.Content-Type", []string{contentType})
+}
+
+// flattenBody flattens a JSON value into ordered fields
+// Arrays repeat the field name, nested objects use bracket notation, e.g. input[name], null values are skipped.
+func flattenBody(fields []bodyField, name string, value []byte, dataType jsonparser.ValueType) ([]bodyField, error) {
+	var err error
+	switch dataType {
+	case jsonparser.Object:
+		err = jsonparser.ObjectEach(value, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			fieldName := string(key)
+			if name != "" {
+				fieldName = name + "[" + fieldName + "]"
+			}
+			fields, err = flattenBody(fields, fieldName, value, dataType)
+			return err
+		})
+	case jsonparser.Array:
+		var itemErr error
+		_, err = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if itemErr != nil {
+				return
+			}
+			fields, itemErr = flattenBody(fields, name, value, dataType)
+		})
+		if itemErr != nil {
+			return nil, itemErr
+		}
+	case jsonparser.String:
+		var unescaped []byte
+		unescaped, err = jsonparser.Unescape(value, nil)
+		if err == nil {
+			fields = append(fields, bodyField{name: name, value: string(unescaped)})
+		}
+	case jsonparser.Null:
+	default:
+		fields = append(fields, bodyField{name: name, value: string(value)})
+	}
+	return fields, err
+}
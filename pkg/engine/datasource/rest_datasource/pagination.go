@@ -0,0 +1,301 @@
+package rest_datasource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
+	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
+)
+
+type PaginationStrategy string
+
+const (
+	// PaginationStrategyLinkHeader follows the URL of the Link header with rel="next"
+	PaginationStrategyLinkHeader PaginationStrategy = "link_header"
+	// PaginationStrategyCursor sends the cursor found at CursorPath of the previous page as CursorParameter
+	PaginationStrategyCursor PaginationStrategy = "cursor"
+	// PaginationStrategyPage counts up PageParameter starting at the first page until a page is empty or incomplete
+	// Without PerPage pages can't be incomplete, so it also stops at a page repeating the items of the previous one.
+	PaginationStrategyPage PaginationStrategy = "page"
+)
+
+const (
+	DefaultPaginationMaxPages = 100
+)
+
+type PaginationConfiguration struct {
+	Strategy PaginationStrategy `json:"strategy"`
+	// ItemsPath is the path to the list of items inside of a page, an empty path means the page itself is the list
+	// The concatenated items replace the list of the first page.
+	ItemsPath []string `json:"items_path,omitempty"`
+	// CursorPath is the path to the cursor of the next page inside of a page
+	CursorPath      []string `json:"cursor_path,omitempty"`
+	CursorParameter string   `json:"cursor_parameter,omitempty"`
+	// PageParameter defaults to "page"
+	PageParameter string `json:"page_parameter,omitempty"`
+	// PerPageParameter defaults to "per_page", it is only sent when PerPage is set
+	PerPageParameter string `json:"per_page_parameter,omitempty"`
+	PerPage          int    `json:"per_page,omitempty"`
+	// ZeroBasedPages makes the first page 0 instead of 1
+	ZeroBasedPages bool `json:"zero_based_pages,omitempty"`
+	// MaxPages defaults to DefaultPaginationMaxPages
+	MaxPages int `json:"max_pages,omitempty"`
+	// MaxItems limits the number of concatenated items, zero means no limit
+	MaxItems int `json:"max_items,omitempty"`
+	// RelayArguments maps the Relay connection arguments "first" onto MaxItems and "after" onto the initial cursor
+	RelayArguments bool `json:"relay_arguments,omitempty"`
+}
+
+type paginationInput struct {
+	PaginationConfiguration
+	First int    `json:"first,omitempty"`
+	After string `json:"after,omitempty"`
+}
+
+func (p *Planner) configurePagination(input []byte) []byte {
+	if p.config.Fetch.Pagination == nil {
+		return input
+	}
+	pagination, err := json.Marshal(p.config.Fetch.Pagination)
+	if err != nil {
+		return input
+	}
+	input, _ = sjson.SetRawBytes(input, "pagination", pagination)
+	if !p.config.Fetch.Pagination.RelayArguments {
+		return input
+	}
+	input = p.configureRelayArgument(input, "first", []byte("{{ .arguments.first }}"))
+	return p.configureRelayArgument(input, "after", []byte(`"{{ .arguments.after }}"`))
+}
+
+// configureRelayArgument sets a Relay connection argument of the root field as pagination parameter
+// Variables are rendered with the template, inline values are set as is.
+func (p *Planner) configureRelayArgument(input []byte, argumentName string, template []byte) []byte {
+	arg, ok := p.v.Operation.FieldArgument(p.rootField, []byte(argumentName))
+	if !ok {
+		return input
+	}
+	value := p.v.Operation.ArgumentValue(arg)
+	switch value.Kind {
+	case ast.ValueKindVariable:
+		if _, ok := p.argumentVariableDefinition(p.rootField, argumentName); ok {
+			input, _ = sjson.SetRawBytes(input, "pagination."+argumentName, template)
+		}
+	case ast.ValueKindInteger:
+		input, _ = sjson.SetBytes(input, "pagination."+argumentName, p.v.Operation.IntValueAsInt(value.Ref))
+	case ast.ValueKindString:
+		input, _ = sjson.SetBytes(input, "pagination."+argumentName, p.v.Operation.StringValueContentString(value.Ref))
+	}
+	return input
+}
+
+// loadPages loads all pages of a paginated upstream and writes the first page with all concatenated items to out
+func (s *Source) loadPages(ctx context.Context, input, pagination []byte, out io.Writer) error {
+	var config paginationInput
+	if err := json.Unmarshal(pagination, &config); err != nil {
+		return err
+	}
+
+	maxPages := config.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultPaginationMaxPages
+	}
+	maxItems := config.MaxItems
+	if config.First > 0 && (maxItems == 0 || config.First < maxItems) {
+		maxItems = config.First
+	}
+	page := 1
+	if config.ZeroBasedPages {
+		page = 0
+	}
+	cursor := config.After
+
+	var (
+		firstPage     []byte
+		previousItems []byte
+		items         [][]byte
+		buf           = &bytes.Buffer{}
+		pageInput     = input
+	)
+
+	for pages := 1; ; pages++ {
+		switch config.Strategy {
+		case PaginationStrategyCursor:
+			if cursor != "" {
+				pageInput = addQueryParameter(input, config.CursorParameter, cursor)
+			}
+		case PaginationStrategyPage:
+			pageInput = addQueryParameter(input, orDefault(config.PageParameter, "page"), strconv.Itoa(page))
+			if config.PerPage > 0 {
+				pageInput = addQueryParameter(pageInput, orDefault(config.PerPageParameter, "per_page"), strconv.Itoa(config.PerPage))
+			}
+		}
+
+		buf.Reset()
+		pageCtx, response := httpclient.InjectResponseContext(ctx)
+		if err := s.client.Do(pageCtx, pageInput, buf); err != nil {
+			return err
+		}
+		if fetchResponse := resolve.FetchResponseFromContext(ctx); fetchResponse != nil && firstPage == nil {
+			// the first page stands for the whole response
+			fetchResponse.StatusCode, fetchResponse.Header = response.StatusCode, response.Header
+		}
+
+		pageData := append([]byte(nil), buf.Bytes()...)
+		pageItems, dataType, _, err := jsonparser.Get(pageData, config.ItemsPath...)
+		if err != nil || dataType != jsonparser.Array {
+			if firstPage == nil {
+				// not a list, e.g. an error response: hand it to the resolver as is
+				_, err = out.Write(pageData)
+				return err
+			}
+			break
+		}
+		if firstPage == nil {
+			firstPage = pageData
+		}
+		if previousItems != nil && bytes.Equal(pageItems, previousItems) {
+			// the upstream ignores the page parameter and returns the same page again
+			break
+		}
+		previousItems = pageItems
+
+		pageItemCount := 0
+		_, _ = jsonparser.ArrayEach(pageItems, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			pageItemCount++
+			if maxItems > 0 && len(items) >= maxItems {
+				return
+			}
+			if dataType == jsonparser.String {
+				value = append(append([]byte(`"`), value...), '"')
+			}
+			items = append(items, value)
+		})
+
+		if pages >= maxPages || (maxItems > 0 && len(items) >= maxItems) || pageItemCount == 0 {
+			break
+		}
+
+		var hasNext bool
+		switch config.Strategy {
+		case PaginationStrategyLinkHeader:
+			var next string
+			current, _ := jsonparser.GetString(pageInput, httpclient.URL)
+			next, hasNext = nextLink(response.Header, current)
+			if hasNext {
+				pageInput = httpclient.SetInputURL(jsonparser.Delete(pageInput, httpclient.QUERYPARAMS), []byte(next))
+			}
+		case PaginationStrategyCursor:
+			cursor, hasNext = nextCursor(pageData, config.CursorPath)
+		case PaginationStrategyPage:
+			page++
+			hasNext = config.PerPage == 0 || pageItemCount >= config.PerPage
+		}
+		if !hasNext {
+			break
+		}
+	}
+
+	concatenated := make([]byte, 0, len(firstPage))
+	concatenated = append(concatenated, literal.LBRACK...)
+	concatenated = append(concatenated, bytes.Join(items, literal.COMMA)...)
+	concatenated = append(concatenated, literal.RBRACK...)
+
+	if len(config.ItemsPath) == 0 {
+		_, err := out.Write(concatenated)
+		return err
+	}
+	result, err := sjson.SetRawBytes(firstPage, strings.Join(config.ItemsPath, "."), concatenated)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(result)
+	return err
+}
+
+func addQueryParameter(input []byte, name, value string) []byte {
+	parameter, _ := json.Marshal(QueryConfiguration{
+		Name:  name,
+		Value: value,
+	})
+	out := make([]byte, len(input))
+	copy(out, input)
+	out, _ = sjson.SetRawBytes(out, httpclient.QUERYPARAMS+".-1", parameter)
+	return out
+}
+
+func nextCursor(page []byte, cursorPath []string) (string, bool) {
+	value, dataType, _, err := jsonparser.Get(page, cursorPath...)
+	if err != nil {
+		return "", false
+	}
+	switch dataType {
+	case jsonparser.String:
+		cursor, err := jsonparser.ParseString(value)
+		return cursor, err == nil && cursor != ""
+	case jsonparser.Number:
+		return string(value), true
+	default:
+		return "", false
+	}
+}
+
+// nextLink returns the URL of the Link header with rel="next", resolved against the URL of the current page
+func nextLink(header http.Header, current string) (string, bool) {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			segments := strings.Split(link, ";")
+			if len(segments) < 2 {
+				continue
+			}
+			target := strings.TrimSpace(segments[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range segments[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "rel=") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimPrefix(param, "rel="), `"`)) {
+					if rel != "next" {
+						continue
+					}
+					return resolveLink(current, target[1:len(target)-1])
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func resolveLink(current, target string) (string, bool) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	currentURL, err := url.Parse(current)
+	if err != nil {
+		return "", false
+	}
+	return currentURL.ResolveReference(targetURL).String(), true
+}
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -8,11 +8,15 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+
 	"github.com/jensneuse/graphql-go-tools/pkg/ast"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/http_polling"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/sse_subscription"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 )
 
@@ -26,6 +30,8 @@ type Planner struct {
 	config              Configuration
 	rootField           int
 	operationDefinition int
+	headerForwarding    *resolve.HeaderForwarding
+	variables           resolve.Variables
 }
 
 func (p *Planner) DownstreamResponseFieldAlias(downstreamFieldRef int) (alias string, exists bool) {
@@ -45,10 +51,14 @@ func (p *Planner) EnterOperationDefinition(ref int) {
 }
 
 type Factory struct {
+	// Client sends the requests of the data source
+	// Use a httpclient.ResilientClient per data source for timeouts, retries, circuit breaking and concurrency limits
+	// and a httpclient.OAuth2Client for upstreams which require OAuth2 access tokens.
+	// Without Client the Transport of the Configuration is used.
 	Client httpclient.Client
 }
 
-func (f *Factory) Planner(<- chan struct{}) plan.DataSourcePlanner {
+func (f *Factory) Planner(<-chan struct{}) plan.DataSourcePlanner {
 	return &Planner{
 		client: f.Client,
 	}
@@ -57,6 +67,12 @@ func (f *Factory) Planner(<- chan struct{}) plan.DataSourcePlanner {
 type Configuration struct {
 	Fetch        FetchConfiguration
 	Subscription SubscriptionConfiguration
+	// HeaderForwarding forwards client request headers with fetches and subscriptions
+	// It defaults to the DefaultHeaderForwarding of the plan.Configuration.
+	HeaderForwarding *resolve.HeaderForwardingRules
+	// Transport configures the http client if the Factory has no Client
+	// Clients are cached by the ClientCache of the plan.Configuration and shared by data sources with equal transports.
+	Transport *httpclient.TransportConfiguration
 }
 
 func ConfigJSON(config Configuration) json.RawMessage {
@@ -67,6 +83,8 @@ func ConfigJSON(config Configuration) json.RawMessage {
 type SubscriptionConfiguration struct {
 	PollingIntervalMillis   int64
 	SkipPublishSameResponse bool
+	// UseSSE subscribes to the Fetch request as Server-Sent Events stream instead of polling it
+	UseSSE bool
 }
 
 type FetchConfiguration struct {
@@ -74,19 +92,74 @@ type FetchConfiguration struct {
 	Method string
 	Header http.Header
 	Query  []QueryConfiguration
-	Body   string
+	// Body is the template for the request body
+	// Argument templates which are used as JSON values, e.g. {"input": {{ .arguments.input }}},
+	// get rendered as JSON, arguments omitted by the client get removed from the body.
+	Body string
+	// BodyEncoding defines how the rendered Body is sent to the upstream, defaults to BodyEncodingJSON
+	// For BodyEncodingForm and BodyEncodingMultipart the Body must render to a JSON object.
+	BodyEncoding BodyEncoding
+	// Pagination makes the Source follow all pages of a paginated upstream and concatenate the items
+	Pagination *PaginationConfiguration
 }
 
+type BodyEncoding string
+
+const (
+	BodyEncodingJSON      BodyEncoding = "json"
+	BodyEncodingForm      BodyEncoding = "form"
+	BodyEncodingMultipart BodyEncoding = "multipart"
+)
+
 type QueryConfiguration struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+	// ArrayStyle defines how list arguments are rendered, defaults to QueryArrayStyleRepeat
+	ArrayStyle QueryArrayStyle `json:"array_style,omitempty"`
 }
 
+type QueryArrayStyle string
+
+const (
+	// QueryArrayStyleRepeat renders list values as repeated parameters, e.g. ?names=foo&names=bar
+	QueryArrayStyleRepeat QueryArrayStyle = "repeat"
+	// QueryArrayStyleComma renders list values as a single comma separated parameter, e.g. ?names=foo,bar
+	QueryArrayStyleComma QueryArrayStyle = "comma"
+)
+
 func (p *Planner) Register(visitor *plan.Visitor, customConfiguration json.RawMessage, isNested bool) error {
 	p.v = visitor
 	visitor.Walker.RegisterEnterFieldVisitor(p)
 	visitor.Walker.RegisterEnterOperationVisitor(p)
-	return json.Unmarshal(customConfiguration, &p.config)
+	err := json.Unmarshal(customConfiguration, &p.config)
+	if err != nil {
+		return err
+	}
+
+	headerForwarding := p.config.HeaderForwarding
+	if headerForwarding == nil {
+		headerForwarding = visitor.Config.DefaultHeaderForwarding
+	}
+	p.headerForwarding = nil
+	if headerForwarding != nil {
+		p.headerForwarding, err = resolve.NewHeaderForwarding(*headerForwarding)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.client == nil && p.config.Transport != nil {
+		clientCache := visitor.Config.ClientCache
+		if clientCache == nil {
+			clientCache = httpclient.DefaultClientCache
+		}
+		client, err := clientCache.NetHttpClient(*p.config.Transport)
+		if err != nil {
+			return err
+		}
+		p.client = client
+	}
+	return nil
 }
 
 func (p *Planner) EnterField(ref int) {
@@ -97,16 +170,30 @@ func (p *Planner) configureInput() []byte {
 
 	input := httpclient.SetInputURL(nil, []byte(p.config.Fetch.URL))
 	input = httpclient.SetInputMethod(input, []byte(p.config.Fetch.Method))
-	input = httpclient.SetInputBody(input, []byte(p.config.Fetch.Body))
+	input = httpclient.SetInputBody(input, []byte(p.prepareBody(p.rootField, p.config.Fetch.Body)))
+
+	switch p.config.Fetch.BodyEncoding {
+	case BodyEncodingForm, BodyEncodingMultipart:
+		input, _ = sjson.SetBytes(input, "body_encoding", string(p.config.Fetch.BodyEncoding))
+	}
 
 	header, err := json.Marshal(p.config.Fetch.Header)
 	if err == nil && len(header) != 0 && !bytes.Equal(header, literal.NULL) {
 		input = httpclient.SetInputHeader(input, header)
 	}
 
+	p.variables = nil
+	if p.headerForwarding != nil {
+		variable, _ := p.variables.AddVariable(&resolve.HeaderForwardingVariable{
+			Forwarding: p.headerForwarding,
+		}, false)
+		input = httpclient.SetInputForwardedHeader(input, []byte(variable))
+	}
+
 	preparedQuery := p.prepareQueryParams(p.rootField, p.config.Fetch.Query)
 	query, err := json.Marshal(preparedQuery)
 	if err == nil && len(preparedQuery) != 0 {
+		query = p.unquoteListArguments(p.rootField, query)
 		input = httpclient.SetInputQueryParams(input, query)
 	}
 	return input
@@ -114,9 +201,10 @@ func (p *Planner) configureInput() []byte {
 
 func (p *Planner) ConfigureFetch() plan.FetchConfiguration {
 	input := p.configureInput()
+	input = p.configurePagination(input)
 	return plan.FetchConfiguration{
 		Input:     string(input),
-		Variables: nil,
+		Variables: p.variables,
 		DataSource: &Source{
 			client: p.client,
 		},
@@ -128,6 +216,14 @@ func (p *Planner) ConfigureSubscription() plan.SubscriptionConfiguration {
 
 	input := p.configureInput()
 
+	if p.config.Subscription.UseSSE {
+		return plan.SubscriptionConfiguration{
+			Input:                 string(input),
+			SubscriptionManagerID: string(sse_subscription.ServerSentEvents),
+			Variables:             p.variables,
+		}
+	}
+
 	var httpPollingInput []byte
 	httpPollingInput = http_polling.SetSkipPublishSameResponse(httpPollingInput, p.config.Subscription.SkipPublishSameResponse)
 	httpPollingInput = http_polling.SetRequestInput(httpPollingInput, input)
@@ -136,7 +232,7 @@ func (p *Planner) ConfigureSubscription() plan.SubscriptionConfiguration {
 	return plan.SubscriptionConfiguration{
 		Input:                 string(httpPollingInput),
 		SubscriptionManagerID: "http_polling_stream",
-		Variables:             nil,
+		Variables:             p.variables,
 	}
 }
 
@@ -193,5 +289,36 @@ func (_ *Source) UniqueIdentifier() []byte {
 }
 
 func (s *Source) Load(ctx context.Context, input []byte, bufPair *resolve.BufPair) (err error) {
+	err = s.load(ctx, input, bufPair)
+	if message, ok := httpclient.RequestErrorMessage(err); ok {
+		// failures of a httpclient.ResilientClient are returned as GraphQL errors unless an ErrorPolicy classifies them
+		bufPair.Data.Reset()
+		if resolve.ErrorPolicyFromContext(ctx) != nil {
+			return err
+		}
+		bufPair.WriteErr(message, nil, nil)
+		return nil
+	}
+	return err
+}
+
+func (s *Source) load(ctx context.Context, input []byte, bufPair *resolve.BufPair) (err error) {
+	input, err = encodeBody(input)
+	if err != nil {
+		return err
+	}
+	pagination, _, _, _ := jsonparser.Get(input, "pagination")
+	if pagination != nil {
+		// copy the configuration because deleting it from the input overrides it
+		pagination = append([]byte(nil), pagination...)
+		return s.loadPages(ctx, jsonparser.Delete(input, "pagination"), pagination, bufPair.Data)
+	}
+	if fetchResponse := resolve.FetchResponseFromContext(ctx); fetchResponse != nil {
+		var response *httpclient.ResponseContext
+		ctx, response = httpclient.InjectResponseContext(ctx)
+		defer func() {
+			fetchResponse.StatusCode, fetchResponse.Header = response.StatusCode, response.Header
+		}()
+	}
 	return s.client.Do(ctx, input, bufPair.Data)
 }
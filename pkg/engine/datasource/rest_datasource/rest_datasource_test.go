@@ -1,12 +1,14 @@
 package rest_datasource
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -23,6 +25,13 @@ const (
 			friend: Friend
 			withArgument(id: String!, name: String, optional: String): Friend
 			withArrayArguments(names: [String]): Friend
+			search(filter: FriendFilter!, name: String, limit: Int): Friend
+			friends(first: Int, after: String): [Friend]
+		}
+
+		input FriendFilter {
+			name: String
+			petNames: [String!]
 		}
 
 		type Subscription {
@@ -100,6 +109,30 @@ const (
 		}
 	`
 
+	inputObjectArgumentOperation = `
+		query SearchQuery($filter: FriendFilter!, $name: String) {
+			search(filter: $filter, name: $name) {
+				name
+			}
+		}
+	`
+
+	connectionArgumentOperation = `
+		query FriendsQuery($first: Int) {
+			friends(first: $first) {
+				name
+			}
+		}
+	`
+
+	inlineConnectionArgumentOperation = `
+		query FriendsQuery {
+			friends(first: 2, after: "abc") {
+				name
+			}
+		}
+	`
+
 	arrayArgumentOperation = `
 		query ArgumentQuery {
 			withArrayArguments(names: ["foo","bar"]) {
@@ -583,6 +616,74 @@ func TestFastHttpJsonDataSourcePlanning(t *testing.T) {
 			},
 		},
 	))
+	t.Run("sse subscription get request with argument", datasourcetesting.RunTest(schema, argumentSubscription, "ArgumentQuery",
+		&plan.SubscriptionResponsePlan{
+			Response: resolve.GraphQLSubscription{
+				Trigger: resolve.GraphQLSubscriptionTrigger{
+					Input:     `{"method":"GET","url":"https://example.com/$$0$$/$$1$$"}`,
+					ManagerID: []byte("sse_stream"),
+					Variables: resolve.NewVariables(
+						&resolve.ContextVariable{
+							Path: []string{"idVariable"},
+						},
+						&resolve.ContextVariable{
+							Path: []string{"a"},
+						},
+					),
+				},
+				Response: &resolve.GraphQLResponse{
+					Data: &resolve.Object{
+						Fields: []*resolve.Field{
+							{
+								Name: []byte("withArgument"),
+								Value: &resolve.Object{
+									Nullable: true,
+									Fields: []*resolve.Field{
+										{
+											Name: []byte("name"),
+											Value: &resolve.String{
+												Path:     []string{"name"},
+												Nullable: true,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Subscription",
+							FieldNames: []string{"withArgument"},
+						},
+					},
+					Custom: ConfigJSON(Configuration{
+						Fetch: FetchConfiguration{
+							URL:    "https://example.com/{{ .arguments.id }}/{{ .arguments.name }}",
+							Method: "GET",
+						},
+						Subscription: SubscriptionConfiguration{
+							UseSSE: true,
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:              "Subscription",
+					FieldName:             "withArgument",
+					DisableDefaultMapping: true,
+				},
+			},
+		},
+	))
 	t.Run("post request with body", datasourcetesting.RunTest(schema, simpleOperation, "",
 		&plan.SynchronousResponsePlan{
 			Response: &resolve.GraphQLResponse{
@@ -643,6 +744,292 @@ func TestFastHttpJsonDataSourcePlanning(t *testing.T) {
 			},
 		},
 	))
+	t.Run("post request with body from input object and omitted optional argument", datasourcetesting.RunTest(schema, inputObjectArgumentOperation, "SearchQuery",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fetch: &resolve.SingleFetch{
+						BufferId:   0,
+						Input:      `{"body":{"filter":$$0$$,"name":"$$1$$","tags":["static"],"static":""},"method":"POST","url":"https://example.com/friend"}`,
+						DataSource: &Source{},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"filter"},
+							},
+							&resolve.ContextVariable{
+								Path: []string{"name"},
+							},
+						),
+						DisallowSingleFlight: true,
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("search"),
+							Value: &resolve.Object{
+								Nullable: true,
+								Fields: []*resolve.Field{
+									{
+										Name: []byte("name"),
+										Value: &resolve.String{
+											Path:     []string{"name"},
+											Nullable: true,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Query",
+							FieldNames: []string{"search"},
+						},
+					},
+					Custom: ConfigJSON(Configuration{
+						Fetch: FetchConfiguration{
+							URL:    "https://example.com/friend",
+							Method: "POST",
+							Body:   `{"filter":{{ .arguments.filter }},"name":{{ .arguments.name }},"limit":{{ .arguments.limit }},"tags":["static",{{ .arguments.limit }}],"static":"{{ .arguments.limit }}"}`,
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:              "Query",
+					FieldName:             "search",
+					DisableDefaultMapping: true,
+				},
+			},
+		},
+	))
+	t.Run("post request with form encoded body", datasourcetesting.RunTest(schema, inputObjectArgumentOperation, "SearchQuery",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fetch: &resolve.SingleFetch{
+						BufferId:   0,
+						Input:      `{"body_encoding":"form","body":{"filter":$$0$$},"method":"POST","url":"https://example.com/friend"}`,
+						DataSource: &Source{},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"filter"},
+							},
+						),
+						DisallowSingleFlight: true,
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("search"),
+							Value: &resolve.Object{
+								Nullable: true,
+								Fields: []*resolve.Field{
+									{
+										Name: []byte("name"),
+										Value: &resolve.String{
+											Path:     []string{"name"},
+											Nullable: true,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Query",
+							FieldNames: []string{"search"},
+						},
+					},
+					Custom: ConfigJSON(Configuration{
+						Fetch: FetchConfiguration{
+							URL:          "https://example.com/friend",
+							Method:       "POST",
+							Body:         `{"filter":{{ .arguments.filter }}}`,
+							BodyEncoding: BodyEncodingForm,
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:              "Query",
+					FieldName:             "search",
+					DisableDefaultMapping: true,
+				},
+			},
+		},
+	))
+	t.Run("get request with pagination and relay arguments", datasourcetesting.RunTest(schema, connectionArgumentOperation, "FriendsQuery",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					Fetch: &resolve.SingleFetch{
+						BufferId:   0,
+						Input:      `{"pagination":{"first":$$0$$,"strategy":"cursor","items_path":["items"],"cursor_path":["next"],"cursor_parameter":"cursor","relay_arguments":true},"method":"GET","url":"https://example.com/friends"}`,
+						DataSource: &Source{},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"first"},
+							},
+						),
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("friends"),
+							Value: &resolve.Array{
+								Nullable: true,
+								Path:     []string{"items"},
+								Item: &resolve.Object{
+									Nullable: true,
+									Fields: []*resolve.Field{
+										{
+											Name: []byte("name"),
+											Value: &resolve.String{
+												Path:     []string{"name"},
+												Nullable: true,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Query",
+							FieldNames: []string{"friends"},
+						},
+					},
+					Custom: ConfigJSON(Configuration{
+						Fetch: FetchConfiguration{
+							URL:    "https://example.com/friends",
+							Method: "GET",
+							Pagination: &PaginationConfiguration{
+								Strategy:        PaginationStrategyCursor,
+								ItemsPath:       []string{"items"},
+								CursorPath:      []string{"next"},
+								CursorParameter: "cursor",
+								RelayArguments:  true,
+							},
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:  "Query",
+					FieldName: "friends",
+					Path:      []string{"items"},
+				},
+			},
+		},
+	))
+	t.Run("get request with pagination and inline relay arguments", datasourcetesting.RunTest(schema, inlineConnectionArgumentOperation, "FriendsQuery",
+		&plan.SynchronousResponsePlan{
+			Response: &resolve.GraphQLResponse{
+				Data: &resolve.Object{
+					// the normalization extracts the inline arguments into variables
+					Fetch: &resolve.SingleFetch{
+						BufferId:   0,
+						Input:      `{"pagination":{"after":"$$0$$","first":$$1$$,"strategy":"cursor","items_path":["items"],"cursor_path":["next"],"cursor_parameter":"cursor","relay_arguments":true},"method":"GET","url":"https://example.com/friends"}`,
+						DataSource: &Source{},
+						Variables: resolve.NewVariables(
+							&resolve.ContextVariable{
+								Path: []string{"b"},
+							},
+							&resolve.ContextVariable{
+								Path: []string{"a"},
+							},
+						),
+					},
+					Fields: []*resolve.Field{
+						{
+							BufferID:  0,
+							HasBuffer: true,
+							Name:      []byte("friends"),
+							Value: &resolve.Array{
+								Nullable: true,
+								Path:     []string{"items"},
+								Item: &resolve.Object{
+									Nullable: true,
+									Fields: []*resolve.Field{
+										{
+											Name: []byte("name"),
+											Value: &resolve.String{
+												Path:     []string{"name"},
+												Nullable: true,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		plan.Configuration{
+			DataSources: []plan.DataSourceConfiguration{
+				{
+					RootNodes: []plan.TypeField{
+						{
+							TypeName:   "Query",
+							FieldNames: []string{"friends"},
+						},
+					},
+					Custom: ConfigJSON(Configuration{
+						Fetch: FetchConfiguration{
+							URL:    "https://example.com/friends",
+							Method: "GET",
+							Pagination: &PaginationConfiguration{
+								Strategy:        PaginationStrategyCursor,
+								ItemsPath:       []string{"items"},
+								CursorPath:      []string{"next"},
+								CursorParameter: "cursor",
+								RelayArguments:  true,
+							},
+						},
+					}),
+					Factory: &Factory{},
+				},
+			},
+			Fields: []plan.FieldConfiguration{
+				{
+					TypeName:  "Query",
+					FieldName: "friends",
+					Path:      []string{"items"},
+				},
+			},
+		},
+	))
 	t.Run("get request with headers", datasourcetesting.RunTest(schema, simpleOperation, "",
 		&plan.SynchronousResponsePlan{
 			Response: &resolve.GraphQLResponse{
@@ -806,7 +1193,7 @@ func TestFastHttpJsonDataSourcePlanning(t *testing.T) {
 				Data: &resolve.Object{
 					Fetch: &resolve.SingleFetch{
 						BufferId:   0,
-						Input:      `{"query_params":[{"name":"names","value":"$$0$$"}],"method":"GET","url":"https://example.com/friend"}`,
+						Input:      `{"query_params":[{"name":"names","value":$$0$$}],"method":"GET","url":"https://example.com/friend"}`,
 						DataSource: &Source{},
 						Variables: resolve.NewVariables(
 							&resolve.ContextVariable{
@@ -869,6 +1256,73 @@ func TestFastHttpJsonDataSourcePlanning(t *testing.T) {
 			},
 		},
 	))
+	t.Run("transport", func(t *testing.T) {
+		transport := httpclient.TransportConfiguration{MaxConnsPerHost: 8, HTTP2: httpclient.HTTP2Disabled}
+		clientCache := httpclient.NewClientCache()
+		client, err := clientCache.NetHttpClient(transport)
+		assert.NoError(t, err)
+
+		datasourcetesting.RunTest(schema, simpleOperation, "",
+			&plan.SynchronousResponsePlan{
+				Response: &resolve.GraphQLResponse{
+					Data: &resolve.Object{
+						Fetch: &resolve.SingleFetch{
+							BufferId:   0,
+							Input:      `{"method":"GET","url":"https://example.com/friend"}`,
+							DataSource: &Source{client: client},
+						},
+						Fields: []*resolve.Field{
+							{
+								BufferID:  0,
+								HasBuffer: true,
+								Name:      []byte("friend"),
+								Value: &resolve.Object{
+									Nullable: true,
+									Fields: []*resolve.Field{
+										{
+											Name: []byte("name"),
+											Value: &resolve.String{
+												Path:     []string{"name"},
+												Nullable: true,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			plan.Configuration{
+				DataSources: []plan.DataSourceConfiguration{
+					{
+						RootNodes: []plan.TypeField{
+							{
+								TypeName:   "Query",
+								FieldNames: []string{"friend"},
+							},
+						},
+						Custom: ConfigJSON(Configuration{
+							Fetch: FetchConfiguration{
+								URL:    "https://example.com/friend",
+								Method: "GET",
+							},
+							Transport: &transport,
+						}),
+						Factory: &Factory{},
+					},
+				},
+				Fields: []plan.FieldConfiguration{
+					{
+						TypeName:              "Query",
+						FieldName:             "friend",
+						DisableDefaultMapping: true,
+					},
+				},
+				ClientCache: clientCache,
+			},
+		)(t)
+	})
 }
 
 func TestHttpJsonDataSource_Load(t *testing.T) {
@@ -953,6 +1407,162 @@ func TestHttpJsonDataSource_Load(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, `ok`, pair.Data.String())
 		})
+		t.Run("post with form encoded body", func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+				actualBody, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "name=Jens+%26+Co&age=30&filter%5BpetNames%5D=Woof&filter%5BpetNames%5D=Mi%22au", string(actualBody))
+				_, _ = w.Write([]byte(`ok`))
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"method":"POST","url":"%s","body_encoding":"form","body":{"name":"Jens & Co","age":30,"missing":null,"filter":{"petNames":["Woof","Mi\"au"]}}}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `ok`, pair.Data.String())
+		})
+		t.Run("post with multipart body", func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.NoError(t, r.ParseMultipartForm(1024))
+				assert.Equal(t, []string{"Jens"}, r.MultipartForm.Value["name"])
+				assert.Equal(t, []string{"a", "b"}, r.MultipartForm.Value["tags"])
+				_, _ = w.Write([]byte(`ok`))
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"method":"POST","url":"%s","body_encoding":"multipart","body":{"name":"Jens","tags":["a","b"]}}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `ok`, pair.Data.String())
+		})
+		t.Run("get with comma separated array query parameter", func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, []string{"foo,bar"}, r.URL.Query()["names"])
+				assert.Equal(t, []string{"foo", "bar"}, r.URL.Query()["repeated"])
+				_, _ = w.Write([]byte(`ok`))
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"query_params":[{"name":"names","value":["foo","bar"],"array_style":"comma"},{"name":"repeated","value":["foo","bar"]}],"method":"GET","url":"%s"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `ok`, pair.Data.String())
+		})
+		t.Run("pagination with link header", func(t *testing.T) {
+
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("page") {
+				case "":
+					assert.Equal(t, "bar", r.URL.Query().Get("foo"))
+					w.Header().Set("Link", fmt.Sprintf(`<%s/friends?page=2>; rel="next", <%s/friends?page=3>; rel="last"`, server.URL, server.URL))
+					_, _ = w.Write([]byte(`[{"name":"a"},{"name":"b"}]`))
+				case "2":
+					w.Header().Set("Link", `</friends?page=3>; rel="next"`)
+					_, _ = w.Write([]byte(`[{"name":"c"}]`))
+				case "3":
+					_, _ = w.Write([]byte(`[{"name":"d"}]`))
+				}
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"pagination":{"strategy":"link_header"},"query_params":[{"name":"foo","value":"bar"}],"method":"GET","url":"%s/friends"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `[{"name":"a"},{"name":"b"},{"name":"c"},{"name":"d"}]`, pair.Data.String())
+		})
+		t.Run("pagination with cursor and relay arguments", func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("cursor") {
+				case "start":
+					_, _ = w.Write([]byte(`{"items":["a","b"],"next":"c1","total":5}`))
+				case "c1":
+					_, _ = w.Write([]byte(`{"items":["c","d"],"next":"c2"}`))
+				default:
+					t.Errorf("unexpected cursor: %s", r.URL.Query().Get("cursor"))
+				}
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"pagination":{"strategy":"cursor","items_path":["items"],"cursor_path":["next"],"cursor_parameter":"cursor","relay_arguments":true,"first":3,"after":"start"},"method":"GET","url":"%s"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"items":["a","b","c"],"next":"c1","total":5}`, pair.Data.String())
+		})
+		t.Run("pagination with page and per page", func(t *testing.T) {
+
+			requestedPages := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestedPages++
+				assert.Equal(t, "2", r.URL.Query().Get("size"))
+				switch r.URL.Query().Get("page") {
+				case "0":
+					_, _ = w.Write([]byte(`[1,2]`))
+				case "1":
+					_, _ = w.Write([]byte(`[3,4]`))
+				case "2":
+					_, _ = w.Write([]byte(`[5]`))
+				}
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"pagination":{"strategy":"page","per_page_parameter":"size","per_page":2,"zero_based_pages":true,"max_pages":5},"method":"GET","url":"%s"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `[1,2,3,4,5]`, pair.Data.String())
+			assert.Equal(t, 3, requestedPages)
+		})
+		t.Run("pagination with page stops at a repeated page", func(t *testing.T) {
+
+			requestedPages := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestedPages++
+				// the upstream ignores the page parameter
+				_, _ = w.Write([]byte(`[1,2]`))
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"pagination":{"strategy":"page"},"method":"GET","url":"%s"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `[1,2]`, pair.Data.String())
+			assert.Equal(t, 2, requestedPages)
+		})
+		t.Run("pagination with max pages", func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(fmt.Sprintf(`[%s]`, r.URL.Query().Get("page"))))
+			}))
+
+			defer server.Close()
+
+			input := []byte(fmt.Sprintf(`{"pagination":{"strategy":"page","max_pages":2},"method":"GET","url":"%s"}`, server.URL))
+			pair := resolve.NewBufPair()
+			err := source.Load(context.Background(), input, pair)
+			assert.NoError(t, err)
+			assert.Equal(t, `[1,2]`, pair.Data.String())
+		})
 	}
 
 	t.Run("net/http", func(t *testing.T) {
@@ -967,4 +1577,89 @@ func TestHttpJsonDataSource_Load(t *testing.T) {
 		}
 		runTests(t, source)
 	})
+	t.Run("resilient client", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		source := &Source{
+			client: httpclient.NewResilientClient(httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), httpclient.ResilienceConfiguration{
+				Retry: httpclient.RetryConfiguration{
+					MaxRetries:      1,
+					InitialInterval: time.Millisecond,
+				},
+			}),
+		}
+
+		input := []byte(fmt.Sprintf(`{"method":"GET","url":"%s"}`, server.URL))
+		pair := resolve.NewBufPair()
+		err := source.Load(context.Background(), input, pair)
+		assert.NoError(t, err)
+		assert.Equal(t, ``, pair.Data.String())
+		assert.Equal(t, `{"message":"upstream request failed with status code 503 after 2 attempt(s)"}`, pair.Errors.String())
+	})
+	t.Run("resilient client with error policy", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer server.Close()
+
+		source := &Source{
+			client: httpclient.NewResilientClient(httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient), httpclient.ResilienceConfiguration{
+				Timeout: 10 * time.Millisecond,
+			}),
+		}
+
+		response := &resolve.GraphQLResponse{
+			Data: &resolve.Object{
+				Fetch: &resolve.SingleFetch{
+					BufferId:   0,
+					DataSource: source,
+					InputTemplate: resolve.InputTemplate{
+						Segments: []resolve.TemplateSegment{
+							{
+								SegmentType: resolve.StaticSegmentType,
+								Data:        []byte(fmt.Sprintf(`{"method":"GET","url":"%s"}`, server.URL)),
+							},
+						},
+					},
+				},
+				Fields: []*resolve.Field{
+					{
+						BufferID:  0,
+						HasBuffer: true,
+						Name:      []byte("name"),
+						Value: &resolve.String{
+							Path:     []string{"name"},
+							Nullable: true,
+						},
+					},
+				},
+			},
+		}
+
+		var reported []resolve.ReportedError
+		ctx := resolve.NewContext(context.Background())
+		ctx.SetErrorPolicy(&resolve.ErrorPolicy{
+			MaskMessages: true,
+			CorrelationID: func(ctx context.Context) string {
+				return "abc"
+			},
+			OnError: func(ctx context.Context, err resolve.ReportedError) {
+				reported = append(reported, err)
+			},
+		})
+		out := &bytes.Buffer{}
+		err := resolve.New().ResolveGraphQLResponse(ctx, response, nil, out)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"request timed out","extensions":{"code":"TIMEOUT","correlationId":"abc"}}],"data":{"name":null}}`, out.String())
+		if assert.Len(t, reported, 1) {
+			assert.Equal(t, resolve.ErrorCategoryTimeout, reported[0].Category)
+			assert.Equal(t, "upstream request failed after 1 attempt(s): timeout after 10ms", reported[0].Message)
+		}
+	})
 }
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jensneuse/graphql-go-tools/pkg/ast"
 	"github.com/jensneuse/graphql-go-tools/pkg/astimport"
 	"github.com/jensneuse/graphql-go-tools/pkg/astvisitor"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 	"github.com/jensneuse/graphql-go-tools/pkg/operationreport"
@@ -35,6 +37,11 @@ type Configuration struct {
 	DefaultFlushInterval int64
 	DataSources          []DataSourceConfiguration
 	Fields               FieldConfigurations
+	// DefaultHeaderForwarding is used by all data sources which don't configure their own header forwarding rules
+	DefaultHeaderForwarding *resolve.HeaderForwardingRules
+	// ClientCache builds the http clients of data sources configured with a transport instead of a client
+	// It defaults to the httpclient.DefaultClientCache.
+	ClientCache *httpclient.ClientCache
 }
 
 type FieldConfigurations []FieldConfiguration
@@ -82,6 +89,9 @@ type ArgumentConfiguration struct {
 }
 
 type DataSourceConfiguration struct {
+	// ID identifies the data source, e.g. in the spans of its fetches
+	// The UniqueIdentifier of the DataSource is used instead if it's empty.
+	ID         string
 	RootNodes  []TypeField
 	ChildNodes []TypeField
 	Factory    PlannerFactory
@@ -302,6 +312,7 @@ type objectFetchConfiguration struct {
 	bufferID       int
 	isSubscription bool
 	fieldRef       int
+	dataSourceID   string
 }
 
 func (v *Visitor) AllowVisitor(kind astvisitor.VisitorKind, ref int, visitor interface{}) bool {
@@ -351,6 +362,8 @@ func (v *Visitor) EnterDirective(ref int) {
 					v.plan.SetFlushInterval(v.Operation.IntValueAsInt(value.Ref))
 				}
 			}
+		case "live":
+			v.configureLiveQuery(ref)
 		}
 	case ast.NodeKindField:
 		switch directiveName {
@@ -370,6 +383,40 @@ func (v *Visitor) EnterDirective(ref int) {
 	}
 }
 
+// configureLiveQuery makes a query a live query, it gets invalidated by the coordinates of its root fields, e.g. "Query.orders"
+// The optional pollingIntervalMillis argument resolves it periodically in addition.
+func (v *Visitor) configureLiveQuery(ref int) {
+	synchronousResponsePlan, ok := v.plan.(*SynchronousResponsePlan)
+	if !ok {
+		return
+	}
+	liveQuery := &resolve.LiveQuery{}
+	if value, ok := v.Operation.DirectiveArgumentValueByName(ref, literal.POLLING_INTERVAL_MILLIS); ok {
+		if value.Kind == ast.ValueKindInteger {
+			liveQuery.PollingInterval = time.Duration(v.Operation.IntValueAsInt(value.Ref)) * time.Millisecond
+		}
+	}
+	synchronousResponsePlan.LiveQuery = liveQuery
+}
+
+// addLiveQueryInvalidationKey adds the coordinate of a root field to the invalidation keys of a live query
+func (v *Visitor) addLiveQueryInvalidationKey(ref int) {
+	synchronousResponsePlan, ok := v.plan.(*SynchronousResponsePlan)
+	if !ok || synchronousResponsePlan.LiveQuery == nil {
+		return
+	}
+	if len(v.Walker.Ancestors) != 2 || v.Walker.Ancestors[0].Kind != ast.NodeKindOperationDefinition {
+		return
+	}
+	key := v.Walker.EnclosingTypeDefinition.NameString(v.Definition) + "." + v.Operation.FieldNameString(ref)
+	for _, existing := range synchronousResponsePlan.LiveQuery.InvalidationKeys {
+		if existing == key {
+			return
+		}
+	}
+	synchronousResponsePlan.LiveQuery.InvalidationKeys = append(synchronousResponsePlan.LiveQuery.InvalidationKeys, key)
+}
+
 func (v *Visitor) LeaveSelectionSet(ref int) {
 
 }
@@ -404,6 +451,8 @@ func (v *Visitor) EnterField(ref int) {
 		return
 	}
 
+	v.addLiveQueryInvalidationKey(ref)
+
 	var (
 		hasFetchConfig bool
 		i              int
@@ -746,6 +795,14 @@ func (v *Visitor) configureSubscription(config objectFetchConfiguration) {
 	config.trigger.ManagerID = []byte(subscription.SubscriptionManagerID)
 	config.trigger.Variables = subscription.Variables
 	v.resolveInputTemplates(config, &config.trigger.Input, &config.trigger.Variables)
+	for i := range subscription.Filters {
+		filter := resolve.SubscriptionFilter{
+			FieldPath: subscription.Filters[i].FieldPath,
+			Value:     subscription.Filters[i].Value,
+		}
+		v.resolveInputTemplates(config, &filter.Value, &filter.Variables)
+		config.trigger.Filters = append(config.trigger.Filters, filter)
+	}
 }
 
 func (v *Visitor) configureObjectFetch(config objectFetchConfiguration) {
@@ -776,6 +833,7 @@ func (v *Visitor) configureSingleFetch(internal objectFetchConfiguration, extern
 		BufferId:             internal.bufferID,
 		Input:                external.Input,
 		DataSource:           external.DataSource,
+		DataSourceID:         internal.dataSourceID,
 		Variables:            external.Variables,
 		DisallowSingleFlight: external.DisallowSingleFlight,
 	}
@@ -797,6 +855,8 @@ type Plan interface {
 type SynchronousResponsePlan struct {
 	Response      *resolve.GraphQLResponse
 	FlushInterval int64
+	// LiveQuery is set for queries with the @live directive, they get resolved again on every invalidation
+	LiveQuery *resolve.LiveQuery
 }
 
 func (s *SynchronousResponsePlan) SetFlushInterval(interval int64) {
@@ -888,6 +948,17 @@ type SubscriptionConfiguration struct {
 	Input                 string
 	SubscriptionManagerID string
 	Variables             resolve.Variables
+	// Filters are evaluated per subscriber before delivering an event, all of them must match.
+	// Subscribers with different filters share the same upstream subscription as long as the Input is equal.
+	Filters []SubscriptionFilterConfiguration
+}
+
+// SubscriptionFilterConfiguration matches events with the value at FieldPath equal to Value
+type SubscriptionFilterConfiguration struct {
+	// FieldPath is the path of the compared field in the event of the stream, e.g. []string{"data","orderUpdated","customerId"}
+	FieldPath []string
+	// Value supports the same templates as the Input, e.g. {{ .arguments.customerId }} or {{ .request.headers.X-Customer-Id }}
+	Value string
 }
 
 type FetchConfiguration struct {
@@ -1069,6 +1140,7 @@ func (c *configurationVisitor) EnterField(ref int) {
 				planner:        planner,
 				isSubscription: isSubscription,
 				fieldRef:       ref,
+				dataSourceID:   config.ID,
 			})
 			return
 		}
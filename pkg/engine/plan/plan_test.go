@@ -2,6 +2,7 @@ package plan
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -154,6 +155,58 @@ func TestPlanner_Plan(t *testing.T) {
 		DefaultFlushInterval: 0,
 	}))
 
+	t.Run("live query", test(testDefinition, `
+		query MyHero @live(pollingIntervalMillis: 500) {
+			hero {
+				name
+			}
+			aliased: hero {
+				name
+			}
+		}
+	`, "MyHero", &SynchronousResponsePlan{
+		LiveQuery: &resolve.LiveQuery{
+			PollingInterval:  500 * time.Millisecond,
+			InvalidationKeys: []string{"Query.hero"},
+		},
+		Response: &resolve.GraphQLResponse{
+			Data: &resolve.Object{
+				Fields: []*resolve.Field{
+					{
+						Name: []byte("hero"),
+						Value: &resolve.Object{
+							Path:     []string{"hero"},
+							Nullable: true,
+							Fields: []*resolve.Field{
+								{
+									Name: []byte("name"),
+									Value: &resolve.String{
+										Path: []string{"name"},
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: []byte("aliased"),
+						Value: &resolve.Object{
+							Path:     []string{"hero"},
+							Nullable: true,
+							Fields: []*resolve.Field{
+								{
+									Name: []byte("name"),
+									Value: &resolve.String{
+										Path: []string{"name"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, Configuration{}))
+
 	t.Run("operation selection", func(t *testing.T) {
 		t.Run("should successfully plan a single named query by providing an operation name", test(testDefinition, `
 				query MyHero {
@@ -255,6 +308,8 @@ directive @defer on FIELD
 
 directive @flushInterval(milliSeconds: Int!) on QUERY | SUBSCRIPTION
 
+directive @live(pollingIntervalMillis: Int) on QUERY
+
 directive @stream(initialBatchSize: Int) on FIELD
 
 union SearchResult = Human | Droid | Starship
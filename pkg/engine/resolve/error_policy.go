@@ -0,0 +1,249 @@
+package resolve
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/buger/jsonparser"
+	errors "golang.org/x/xerrors"
+)
+
+// ErrorCategory classifies the errors of a response
+type ErrorCategory string
+
+const (
+	// ErrorCategoryValidation are errors of the client operation, e.g. unknown fields or invalid variables
+	ErrorCategoryValidation ErrorCategory = "validation"
+	// ErrorCategoryUpstream are errors responded by upstreams
+	ErrorCategoryUpstream ErrorCategory = "upstream"
+	// ErrorCategoryInternal are errors returned by data sources, e.g. dial errors, and errors of the engine
+	ErrorCategoryInternal ErrorCategory = "internal"
+	// ErrorCategoryTimeout are errors of data sources or the engine exceeding a deadline
+	ErrorCategoryTimeout ErrorCategory = "timeout"
+)
+
+// Code returns the stable extensions.code of the errors of the category
+func (c ErrorCategory) Code() string {
+	switch c {
+	case ErrorCategoryValidation:
+		return "GRAPHQL_VALIDATION_FAILED"
+	case ErrorCategoryUpstream:
+		return "UPSTREAM_ERROR"
+	case ErrorCategoryTimeout:
+		return "TIMEOUT"
+	default:
+		return "INTERNAL_SERVER_ERROR"
+	}
+}
+
+var defaultErrorMessages = map[ErrorCategory]string{
+	ErrorCategoryValidation: "invalid operation",
+	ErrorCategoryUpstream:   "upstream error",
+	ErrorCategoryInternal:   "internal server error",
+	ErrorCategoryTimeout:    "request timed out",
+}
+
+// ReportedError holds the full details of an error, they are passed to the OnError hook of the ErrorPolicy only
+type ReportedError struct {
+	Category      ErrorCategory
+	Code          string
+	CorrelationID string
+	// Message is the original message, e.g. of the upstream error
+	Message string
+	// Path is the JSON encoded path of the error in the response, if any
+	Path []byte
+	// Extensions are the JSON encoded extensions of upstream errors
+	Extensions []byte
+	// DataSource is the unique identifier of the data source of upstream, internal and timeout errors of fetches
+	DataSource string
+	// Err is the error returned by the data source or the engine, nil for upstream errors
+	Err error
+}
+
+// ErrorPolicy classifies the errors of responses, attaches a stable code and a correlation ID to them and optionally masks their messages
+// Errors returned by DataSource.Load become errors of the response instead of failing the request.
+type ErrorPolicy struct {
+	// MaskMessages replaces the messages and extensions of upstream, internal and timeout errors with generic text, e.g. in production
+	// Messages of validation errors are kept, they describe the operation of the client.
+	MaskMessages bool
+	// Messages override the generic messages per category
+	Messages map[ErrorCategory]string
+	// CorrelationID returns the correlation ID of the errors of a request, it defaults to a random ID
+	CorrelationID func(ctx context.Context) string
+	// OnError receives the full details of every error, e.g. to log them
+	OnError func(ctx context.Context, err ReportedError)
+}
+
+func (p *ErrorPolicy) message(category ErrorCategory, message string) string {
+	if !p.MaskMessages || category == ErrorCategoryValidation {
+		return message
+	}
+	if custom, ok := p.Messages[category]; ok {
+		return custom
+	}
+	return defaultErrorMessages[category]
+}
+
+// errorPolicy applies the ErrorPolicy to the errors of a request, it is shared by clones of the Context
+type errorPolicy struct {
+	policy            *ErrorPolicy
+	correlationIDOnce sync.Once
+	correlationID     string
+}
+
+type errorPolicyKey struct{}
+
+// ErrorPolicyFromContext returns the ErrorPolicy of the request a data source loads data for, nil if there is none
+// Data sources return failed requests as error instead of writing them as GraphQL errors if there is an ErrorPolicy, so that the policy can classify them.
+func ErrorPolicyFromContext(ctx context.Context) *ErrorPolicy {
+	policy, _ := ctx.Value(errorPolicyKey{}).(*ErrorPolicy)
+	return policy
+}
+
+// SetErrorPolicy applies the policy to the errors of the request, nil disables it
+func (c *Context) SetErrorPolicy(policy *ErrorPolicy) {
+	if policy == nil {
+		c.errorPolicy = nil
+		return
+	}
+	c.errorPolicy = &errorPolicy{policy: policy}
+}
+
+// CorrelationID returns the correlation ID of the errors of the request or an empty string without ErrorPolicy
+// The ID is created once on first use.
+func (c *Context) CorrelationID() string {
+	if c.errorPolicy == nil {
+		return ""
+	}
+	c.errorPolicy.correlationIDOnce.Do(func() {
+		if c.errorPolicy.policy.CorrelationID != nil {
+			c.errorPolicy.correlationID = c.errorPolicy.policy.CorrelationID(c.Context)
+			return
+		}
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+		c.errorPolicy.correlationID = hex.EncodeToString(id)
+	})
+	return c.errorPolicy.correlationID
+}
+
+// ReportError reports an error which isn't part of the resolved response, e.g. a validation error, to the ErrorPolicy
+// It returns the message for the client. Without ErrorPolicy the message is returned as is.
+func (c *Context) ReportError(category ErrorCategory, message string, err error) string {
+	if c.errorPolicy == nil {
+		return message
+	}
+	c.reportError(ReportedError{
+		Category: category,
+		Message:  message,
+		Err:      err,
+	})
+	return c.errorPolicy.policy.message(category, message)
+}
+
+func (c *Context) reportError(reported ReportedError) {
+	reported.Code = reported.Category.Code()
+	reported.CorrelationID = c.CorrelationID()
+	if c.errorPolicy.policy.OnError != nil {
+		c.errorPolicy.policy.OnError(c.Context, reported)
+	}
+}
+
+// applyErrorPolicy turns the error returned by the data source into an error of the response and rewrites the upstream errors of the fetch
+func (c *Context) applyErrorPolicy(fetch *SingleFetch, buf *BufPair, loadErr error) error {
+	if c.errorPolicy == nil || (loadErr == nil && !buf.HasErrors()) {
+		return loadErr
+	}
+
+	dataSource := string(fetch.DataSource.UniqueIdentifier())
+
+	if buf.HasErrors() {
+		upstreamErrors := make([]byte, buf.Errors.Len())
+		copy(upstreamErrors, buf.Errors.Bytes())
+		buf.Errors.Reset()
+		_, _ = jsonparser.ArrayEach(append(append([]byte{'['}, upstreamErrors...), ']'), func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			message, _ := jsonparser.GetString(value, "message")
+			path, _, _, _ := jsonparser.Get(value, "path")
+			extensions, _, _, _ := jsonparser.Get(value, "extensions")
+			c.reportError(ReportedError{
+				Category:   ErrorCategoryUpstream,
+				Message:    message,
+				Path:       path,
+				Extensions: extensions,
+				DataSource: dataSource,
+			})
+			c.writePolicyError(buf, ErrorCategoryUpstream, message, path, extensions)
+		})
+	}
+
+	if loadErr != nil {
+		category := ClassifyError(c.Context, loadErr)
+		path := c.fetchErrorPath()
+		c.reportError(ReportedError{
+			Category:   category,
+			Message:    loadErr.Error(),
+			Path:       path,
+			DataSource: dataSource,
+			Err:        loadErr,
+		})
+		c.writePolicyError(buf, category, loadErr.Error(), path, nil)
+	}
+
+	return nil
+}
+
+// writePolicyError writes the error with the code and correlation ID added to the extensions
+// Masked errors lose all other extensions.
+func (c *Context) writePolicyError(buf *BufPair, category ErrorCategory, message string, path, extensions []byte) {
+	policy := c.errorPolicy.policy
+	if policy.MaskMessages || len(extensions) == 0 || extensions[0] != '{' {
+		extensions = []byte(`{}`)
+	} else {
+		// Set may write into the slice, which is part of the errors being iterated
+		extensions = append([]byte(nil), extensions...)
+	}
+	if _, _, _, err := jsonparser.Get(extensions, "code"); err != nil {
+		extensions, _ = jsonparser.Set(extensions, []byte(strconv.Quote(category.Code())), "code")
+	}
+	correlationID, _ := json.Marshal(c.CorrelationID())
+	extensions, _ = jsonparser.Set(extensions, correlationID, "correlationId")
+
+	clientMessage, _ := json.Marshal(policy.message(category, message))
+	// WriteErrWithExtensions expects the message without quotes
+	buf.WriteErrWithExtensions(clientMessage[1:len(clientMessage)-1], nil, path, extensions)
+}
+
+// fetchErrorPath returns the JSON encoded fetch path or nil for fetches of the root object
+func (c *Context) fetchErrorPath() []byte {
+	fetchPath := c.fetchPath()
+	if len(fetchPath) == 0 {
+		return nil
+	}
+	path := make([]interface{}, len(fetchPath))
+	for i := range fetchPath {
+		if index, err := strconv.Atoi(fetchPath[i]); err == nil {
+			path[i] = index
+			continue
+		}
+		path[i] = fetchPath[i]
+	}
+	out, _ := json.Marshal(path)
+	return out
+}
+
+// ClassifyError returns ErrorCategoryTimeout for errors caused by an exceeded deadline and ErrorCategoryInternal otherwise
+// Wrapped errors, e.g. the cause of a httpclient.RequestError, are classified by their cause.
+func ClassifyError(ctx context.Context, err error) ErrorCategory {
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return ErrorCategoryTimeout
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return ErrorCategoryTimeout
+	}
+	return ErrorCategoryInternal
+}
@@ -0,0 +1,143 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// _errorDataSource responds with upstream errors or returns an error
+type _errorDataSource struct {
+	upstreamErrors []string
+	err            error
+}
+
+func (_ *_errorDataSource) UniqueIdentifier() []byte {
+	return []byte("errors")
+}
+
+func (e *_errorDataSource) Load(ctx context.Context, input []byte, pair *BufPair) (err error) {
+	if e.err != nil {
+		return e.err
+	}
+	for i := range e.upstreamErrors {
+		if pair.Errors.Len() != 0 {
+			pair.Errors.WriteBytes(comma)
+		}
+		pair.Errors.WriteString(e.upstreamErrors[i])
+	}
+	return nil
+}
+
+func TestResolver_ErrorPolicy(t *testing.T) {
+	response := func(dataSource DataSource) *GraphQLResponse {
+		return &GraphQLResponse{
+			Data: &Object{
+				Fetch: &SingleFetch{
+					BufferId:   0,
+					DataSource: dataSource,
+				},
+				Fields: []*Field{
+					{
+						BufferID:  0,
+						HasBuffer: true,
+						Name:      []byte("name"),
+						Value: &String{
+							Path:     []string{"name"},
+							Nullable: true,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	resolve := func(t *testing.T, policy *ErrorPolicy, dataSource DataSource) (string, error) {
+		ctx := NewContext(context.Background())
+		ctx.SetErrorPolicy(policy)
+		out := &bytes.Buffer{}
+		err := New().ResolveGraphQLResponse(ctx, response(dataSource), nil, out)
+		return out.String(), err
+	}
+
+	correlationID := func(ctx context.Context) string {
+		return "abc"
+	}
+
+	upstream := &_errorDataSource{
+		upstreamErrors: []string{
+			`{"message":"user service at 10.0.0.1 failed","path":["name"],"extensions":{"code":"FORBIDDEN","detail":"internal"}}`,
+			`{"message":"other \"error\""}`,
+		},
+	}
+
+	t.Run("should add code and correlation ID to upstream errors", func(t *testing.T) {
+		out, err := resolve(t, &ErrorPolicy{CorrelationID: correlationID}, upstream)
+		require.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"user service at 10.0.0.1 failed","path":["name"],"extensions":{"code":"FORBIDDEN","detail":"internal","correlationId":"abc"}},{"message":"other \"error\"","extensions":{"code":"UPSTREAM_ERROR","correlationId":"abc"}}],"data":{"name":null}}`, out)
+	})
+
+	t.Run("should mask upstream errors", func(t *testing.T) {
+		out, err := resolve(t, &ErrorPolicy{MaskMessages: true, CorrelationID: correlationID}, upstream)
+		require.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"upstream error","path":["name"],"extensions":{"code":"UPSTREAM_ERROR","correlationId":"abc"}},{"message":"upstream error","extensions":{"code":"UPSTREAM_ERROR","correlationId":"abc"}}],"data":{"name":null}}`, out)
+	})
+
+	t.Run("should turn errors of data sources into masked response errors", func(t *testing.T) {
+		var reported []ReportedError
+		policy := &ErrorPolicy{
+			MaskMessages:  true,
+			Messages:      map[ErrorCategory]string{ErrorCategoryInternal: "something went wrong"},
+			CorrelationID: correlationID,
+			OnError: func(ctx context.Context, err ReportedError) {
+				reported = append(reported, err)
+			},
+		}
+		loadErr := errors.New("dial tcp 10.0.0.1:4001: connection refused")
+		out, err := resolve(t, policy, &_errorDataSource{err: loadErr})
+		require.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"something went wrong","extensions":{"code":"INTERNAL_SERVER_ERROR","correlationId":"abc"}}],"data":{"name":null}}`, out)
+
+		require.Len(t, reported, 1)
+		assert.Equal(t, ReportedError{
+			Category:      ErrorCategoryInternal,
+			Code:          "INTERNAL_SERVER_ERROR",
+			CorrelationID: "abc",
+			Message:       "dial tcp 10.0.0.1:4001: connection refused",
+			DataSource:    "errors",
+			Err:           loadErr,
+		}, reported[0])
+	})
+
+	t.Run("should classify deadline errors as timeout", func(t *testing.T) {
+		out, err := resolve(t, &ErrorPolicy{MaskMessages: true, CorrelationID: correlationID}, &_errorDataSource{err: context.DeadlineExceeded})
+		require.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"request timed out","extensions":{"code":"TIMEOUT","correlationId":"abc"}}],"data":{"name":null}}`, out)
+	})
+
+	t.Run("should generate one correlation ID per request", func(t *testing.T) {
+		ctx := NewContext(context.Background())
+		assert.Equal(t, "", ctx.CorrelationID())
+		ctx.SetErrorPolicy(&ErrorPolicy{})
+		id := ctx.CorrelationID()
+		assert.Len(t, id, 32)
+		clone := ctx.Clone()
+		assert.Equal(t, id, clone.CorrelationID())
+	})
+
+	t.Run("should keep the messages of validation errors", func(t *testing.T) {
+		ctx := NewContext(context.Background())
+		ctx.SetErrorPolicy(&ErrorPolicy{MaskMessages: true})
+		assert.Equal(t, "field foo is unknown", ctx.ReportError(ErrorCategoryValidation, "field foo is unknown", nil))
+		assert.Equal(t, "internal server error", ctx.ReportError(ErrorCategoryInternal, "plan failed", nil))
+	})
+
+	t.Run("should fail without error policy", func(t *testing.T) {
+		_, err := resolve(t, nil, &_errorDataSource{err: errors.New("failed")})
+		assert.EqualError(t, err, "failed")
+	})
+}
@@ -0,0 +1,59 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
+)
+
+type fetchPathKey struct{}
+
+// FetchPathFromContext returns the path of the object in the downstream response a data source loads data for
+// The path consists of field names and list indices, e.g. ["me","reviews","0"], and is empty for fetches of the root object.
+// Data sources use it to rewrite the paths of upstream errors onto the downstream response.
+func FetchPathFromContext(ctx context.Context) []string {
+	path, _ := ctx.Value(fetchPathKey{}).([]string)
+	return path
+}
+
+// fetchPathContext computes the fetch path only if a data source asks for it
+// It must only be used while the data source loads, the path of the resolve Context changes afterwards.
+type fetchPathContext struct {
+	context.Context
+	resolveContext *Context
+}
+
+func (f *fetchPathContext) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case fetchPathKey:
+		return f.resolveContext.fetchPath()
+	case errorPolicyKey:
+		if f.resolveContext.errorPolicy == nil {
+			return nil
+		}
+		return f.resolveContext.errorPolicy.policy
+	}
+	return f.Context.Value(key)
+}
+
+// fetchPath returns the current path without the data prefix
+// Unlike path it doesn't use pooled buffers, so it's safe to call from the goroutines of parallel fetches.
+func (c *Context) fetchPath() []string {
+	path := make([]string, 0, len(c.pathElements))
+	if len(c.pathPrefix) != 0 {
+		for i, elem := range bytes.Split(c.pathPrefix, literal.SLASH) {
+			if len(elem) == 0 || (i == 1 && bytes.Equal(elem, literal.DATA)) {
+				continue
+			}
+			path = append(path, string(elem))
+		}
+	}
+	for i := range c.pathElements {
+		if i == 0 && bytes.Equal(literal.DATA, c.pathElements[0]) {
+			continue
+		}
+		path = append(path, string(c.pathElements[i]))
+	}
+	return path
+}
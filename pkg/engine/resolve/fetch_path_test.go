@@ -0,0 +1,82 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// _pathDataSource responds with the fetch path it loads data for
+type _pathDataSource struct{}
+
+func (_ *_pathDataSource) UniqueIdentifier() []byte {
+	return []byte("path")
+}
+
+func (_ *_pathDataSource) Load(ctx context.Context, input []byte, pair *BufPair) (err error) {
+	pair.Data.WriteString(`{"path":"` + strings.Join(FetchPathFromContext(ctx), "/") + `"}`)
+	return
+}
+
+func TestResolver_FetchPath(t *testing.T) {
+	pathField := &Field{
+		BufferID:  1,
+		HasBuffer: true,
+		Name:      []byte("fetchPath"),
+		Value: &String{
+			Path: []string{"path"},
+		},
+	}
+
+	response := &GraphQLResponse{
+		Data: &Object{
+			Fetch: &ParallelFetch{
+				Fetches: []*SingleFetch{
+					{
+						BufferId:   0,
+						DataSource: FakeDataSource(`{"users":[{"id":"1"},{"id":"2"}]}`),
+					},
+					{
+						BufferId:   1,
+						DataSource: &_pathDataSource{},
+					},
+				},
+			},
+			Fields: []*Field{
+				pathField,
+				{
+					BufferID:  0,
+					HasBuffer: true,
+					Name:      []byte("users"),
+					Value: &Array{
+						Path: []string{"users"},
+						Item: &Object{
+							Fetch: &SingleFetch{
+								BufferId:   1,
+								DataSource: &_pathDataSource{},
+							},
+							Fields: []*Field{
+								{
+									Name: []byte("id"),
+									Value: &String{
+										Path: []string{"id"},
+									},
+								},
+								pathField,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	err := New().ResolveGraphQLResponse(NewContext(context.Background()), response, nil, out)
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":{"fetchPath":"","users":[{"id":"1","fetchPath":"users/0"},{"id":"2","fetchPath":"users/1"}]}}`, out.String())
+}
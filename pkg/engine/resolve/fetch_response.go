@@ -0,0 +1,176 @@
+package resolve
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FetchResponse holds the status code and headers of the upstream response of a fetch
+type FetchResponse struct {
+	StatusCode int
+	Header     http.Header
+}
+
+type fetchResponseKey struct{}
+
+// FetchResponseFromContext returns the FetchResponse a data source records its upstream response into
+// It is nil if neither the AfterFetchHook nor header propagation make use of the upstream response.
+func FetchResponseFromContext(ctx context.Context) *FetchResponse {
+	response, _ := ctx.Value(fetchResponseKey{}).(*FetchResponse)
+	return response
+}
+
+// fetchContext returns the context data sources load with
+// The context carries the fetch path and a FetchResponse if anything makes use of the upstream response.
+// The parent is the context of the Context or the context carrying the span of the fetch.
+func (c *Context) fetchContext(parent context.Context) (context.Context, *FetchResponse) {
+	ctx := &fetchPathContext{Context: parent, resolveContext: c}
+	if c.afterFetchHook == nil && c.headerPropagation == nil {
+		return ctx, nil
+	}
+	response := &FetchResponse{}
+	return context.WithValue(ctx, fetchResponseKey{}, response), response
+}
+
+// HeaderPropagationAlgorithm defines how the values of an upstream response header get merged into the downstream response
+type HeaderPropagationAlgorithm string
+
+const (
+	// HeaderPropagationFirst keeps the values of the first upstream response carrying the header
+	HeaderPropagationFirst HeaderPropagationAlgorithm = "first"
+	// HeaderPropagationLast keeps the values of the last upstream response carrying the header
+	HeaderPropagationLast HeaderPropagationAlgorithm = "last"
+	// HeaderPropagationAppend adds the values of all upstream responses, e.g. for Set-Cookie
+	HeaderPropagationAppend HeaderPropagationAlgorithm = "append"
+	// HeaderPropagationMostRestrictiveCacheControl merges Cache-Control headers into the most restrictive one
+	// no-store wins over everything else, no-cache and private win over public and the smallest max-age and s-maxage win.
+	HeaderPropagationMostRestrictiveCacheControl HeaderPropagationAlgorithm = "most_restrictive_cache_control"
+)
+
+// HeaderPropagationRule propagates an upstream response header to the downstream response
+type HeaderPropagationRule struct {
+	// Name is the name of the header, e.g. "Set-Cookie"
+	Name string
+	// Algorithm defaults to HeaderPropagationFirst
+	Algorithm HeaderPropagationAlgorithm
+}
+
+// headerPropagation merges the headers of all upstream responses of a request into the downstream response header
+type headerPropagation struct {
+	mux        sync.Mutex
+	rules      []HeaderPropagationRule
+	header     http.Header
+	propagated map[string]bool
+}
+
+// SetHeaderPropagation merges the upstream response headers selected by the rules into header
+// Headers get merged while fetching, before ResolveGraphQLResponse writes the response.
+func (c *Context) SetHeaderPropagation(rules []HeaderPropagationRule, header http.Header) {
+	if len(rules) == 0 || header == nil {
+		c.headerPropagation = nil
+		return
+	}
+	c.headerPropagation = &headerPropagation{
+		rules:      rules,
+		header:     header,
+		propagated: map[string]bool{},
+	}
+}
+
+func (c *Context) propagateHeaders(response *FetchResponse) {
+	if c.headerPropagation == nil || response == nil || response.Header == nil {
+		return
+	}
+	c.headerPropagation.propagate(response.Header)
+}
+
+func (h *headerPropagation) propagate(upstream http.Header) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, rule := range h.rules {
+		name := http.CanonicalHeaderKey(rule.Name)
+		values := upstream[name]
+		if len(values) == 0 {
+			continue
+		}
+		propagated := h.propagated[name]
+		h.propagated[name] = true
+		switch rule.Algorithm {
+		case HeaderPropagationLast:
+			h.header[name] = append([]string(nil), values...)
+		case HeaderPropagationAppend:
+			h.header[name] = append(h.header[name], values...)
+		case HeaderPropagationMostRestrictiveCacheControl:
+			if !propagated {
+				h.header.Set(name, mergeCacheControl(values))
+				continue
+			}
+			h.header.Set(name, mergeCacheControl(append(h.header[name], values...)))
+		default:
+			if !propagated {
+				h.header[name] = append([]string(nil), values...)
+			}
+		}
+	}
+}
+
+// mergeCacheControl merges Cache-Control values into the most restrictive one
+func mergeCacheControl(values []string) string {
+	var (
+		noStore, noCache, private, public bool
+		maxAge, sMaxAge                   = -1, -1
+	)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-store":
+				noStore = true
+			case directive == "no-cache":
+				noCache = true
+			case directive == "private":
+				private = true
+			case directive == "public":
+				public = true
+			case strings.HasPrefix(directive, "max-age="):
+				maxAge = minAge(maxAge, directive[len("max-age="):])
+			case strings.HasPrefix(directive, "s-maxage="):
+				sMaxAge = minAge(sMaxAge, directive[len("s-maxage="):])
+			}
+		}
+	}
+
+	if noStore {
+		return "no-store"
+	}
+	var directives []string
+	if noCache {
+		directives = append(directives, "no-cache")
+	}
+	if private {
+		directives = append(directives, "private")
+	} else if public {
+		directives = append(directives, "public")
+	}
+	if maxAge != -1 {
+		directives = append(directives, "max-age="+strconv.Itoa(maxAge))
+	}
+	if sMaxAge != -1 && !private {
+		directives = append(directives, "s-maxage="+strconv.Itoa(sMaxAge))
+	}
+	return strings.Join(directives, ", ")
+}
+
+func minAge(current int, value string) int {
+	age, err := strconv.Atoi(value)
+	if err != nil || age < 0 {
+		return current
+	}
+	if current == -1 || age < current {
+		return age
+	}
+	return current
+}
@@ -0,0 +1,131 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// _responseDataSource records its upstream response like http based data sources do
+type _responseDataSource struct {
+	data     string
+	response FetchResponse
+}
+
+func (s *_responseDataSource) UniqueIdentifier() []byte {
+	return []byte("response")
+}
+
+func (s *_responseDataSource) Load(ctx context.Context, input []byte, pair *BufPair) (err error) {
+	if fetchResponse := FetchResponseFromContext(ctx); fetchResponse != nil {
+		*fetchResponse = s.response
+	}
+	pair.Data.WriteString(s.data)
+	return
+}
+
+type _recordingAfterFetchHook struct {
+	responses []*FetchResponse
+}
+
+func (r *_recordingAfterFetchHook) OnData(ctx HookContext, output []byte, singleFlight bool) {
+	r.responses = append(r.responses, ctx.Response)
+}
+
+func (r *_recordingAfterFetchHook) OnError(ctx HookContext, output []byte, singleFlight bool) {}
+
+func TestResolver_FetchResponse(t *testing.T) {
+	fetchResponse := func(header http.Header) *GraphQLResponse {
+		return &GraphQLResponse{
+			Data: &Object{
+				Fetch: &ParallelFetch{
+					Fetches: []*SingleFetch{
+						{
+							BufferId:   0,
+							DataSource: &_responseDataSource{data: `{"name":"Jens"}`, response: FetchResponse{StatusCode: 200, Header: header}},
+						},
+					},
+				},
+				Fields: []*Field{
+					{
+						BufferID:  0,
+						HasBuffer: true,
+						Name:      []byte("name"),
+						Value: &String{
+							Path: []string{"name"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("after fetch hook", func(t *testing.T) {
+		header := http.Header{"Cache-Control": {"max-age=60"}}
+		hook := &_recordingAfterFetchHook{}
+		ctx := NewContext(context.Background())
+		ctx.SetAfterFetchHook(hook)
+
+		out := &bytes.Buffer{}
+		err := New().ResolveGraphQLResponse(ctx, fetchResponse(header), nil, out)
+		require.NoError(t, err)
+		assert.Equal(t, `{"data":{"name":"Jens"}}`, out.String())
+		assert.Equal(t, []*FetchResponse{{StatusCode: 200, Header: header}}, hook.responses)
+	})
+
+	t.Run("no consumer", func(t *testing.T) {
+		ctx, response := NewContext(context.Background()).fetchContext(context.Background())
+		assert.Nil(t, response)
+		assert.Nil(t, FetchResponseFromContext(ctx))
+	})
+
+	t.Run("header propagation", func(t *testing.T) {
+		downstream := http.Header{}
+		ctx := NewContext(context.Background())
+		ctx.SetHeaderPropagation([]HeaderPropagationRule{{Name: "cache-control", Algorithm: HeaderPropagationMostRestrictiveCacheControl}}, downstream)
+
+		err := New().ResolveGraphQLResponse(ctx, fetchResponse(http.Header{"Cache-Control": {"public, max-age=60"}}), nil, &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.Equal(t, http.Header{"Cache-Control": {"public, max-age=60"}}, downstream)
+	})
+}
+
+func TestHeaderPropagation(t *testing.T) {
+	run := func(rule HeaderPropagationRule, upstreams []http.Header, expected http.Header) func(t *testing.T) {
+		return func(t *testing.T) {
+			downstream := http.Header{}
+			ctx := NewContext(context.Background())
+			ctx.SetHeaderPropagation([]HeaderPropagationRule{rule}, downstream)
+			for i := range upstreams {
+				ctx.propagateHeaders(&FetchResponse{StatusCode: 200, Header: upstreams[i]})
+			}
+			assert.Equal(t, expected, downstream)
+		}
+	}
+
+	upstreams := []http.Header{
+		{"X-Version": {"1"}, "Set-Cookie": {"a=1"}},
+		{},
+		{"X-Version": {"2"}, "Set-Cookie": {"b=2", "c=3"}},
+	}
+
+	t.Run("first", run(HeaderPropagationRule{Name: "x-version"}, upstreams, http.Header{"X-Version": {"1"}}))
+	t.Run("last", run(HeaderPropagationRule{Name: "X-Version", Algorithm: HeaderPropagationLast}, upstreams, http.Header{"X-Version": {"2"}}))
+	t.Run("append", run(HeaderPropagationRule{Name: "Set-Cookie", Algorithm: HeaderPropagationAppend}, upstreams, http.Header{"Set-Cookie": {"a=1", "b=2", "c=3"}}))
+	t.Run("missing", run(HeaderPropagationRule{Name: "Retry-After"}, upstreams, http.Header{}))
+	t.Run("most restrictive cache control", run(HeaderPropagationRule{Name: "Cache-Control", Algorithm: HeaderPropagationMostRestrictiveCacheControl}, []http.Header{
+		{"Cache-Control": {"public, max-age=60, s-maxage=120"}},
+		{"Cache-Control": {"Max-Age=30, s-maxage=300"}},
+	}, http.Header{"Cache-Control": {"public, max-age=30, s-maxage=120"}}))
+}
+
+func TestMergeCacheControl(t *testing.T) {
+	assert.Equal(t, "no-store", mergeCacheControl([]string{"public, max-age=60", "no-store"}))
+	assert.Equal(t, "no-cache, private, max-age=10", mergeCacheControl([]string{"public, max-age=60, s-maxage=60", "private, max-age=10", "no-cache"}))
+	assert.Equal(t, "max-age=0", mergeCacheControl([]string{"max-age=invalid", "max-age=0"}))
+	assert.Equal(t, "", mergeCacheControl([]string{"must-revalidate"}))
+}
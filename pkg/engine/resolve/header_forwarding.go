@@ -0,0 +1,185 @@
+package resolve
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/fastbuffer"
+)
+
+// HeaderForwardingRules select the client request headers which get forwarded to upstreams
+// Hop-by-hop headers are never forwarded.
+// Sensitive headers (Authorization, Cookie and Proxy-Authorization) are only forwarded if they are allowed or renamed by name.
+type HeaderForwardingRules struct {
+	// Allow forwards the client request headers with these names, e.g. "Authorization"
+	Allow []string
+	// AllowRegex forwards the client request headers with names matching one of the regular expressions, e.g. "^X-"
+	// Names are matched in their canonical form.
+	AllowRegex []string
+	// Rename forwards client request headers with a different name, e.g. {"X-User":"X-Upstream-User"}
+	Rename map[string]string
+	// Inject adds static headers to every upstream request, they replace forwarded headers with the same name
+	Inject http.Header
+	// Remove drops headers after all other rules got applied
+	Remove []string
+}
+
+var (
+	hopByHopHeaders = map[string]bool{
+		"Connection":               true,
+		"Keep-Alive":               true,
+		"Proxy-Connection":         true,
+		"Proxy-Authenticate":       true,
+		"Te":                       true,
+		"Trailer":                  true,
+		"Transfer-Encoding":        true,
+		"Upgrade":                  true,
+		"Host":                     true,
+		"Content-Length":           true,
+		"Sec-Websocket-Key":        true,
+		"Sec-Websocket-Version":    true,
+		"Sec-Websocket-Extensions": true,
+		"Sec-Websocket-Protocol":   true,
+	}
+	sensitiveHeaders = map[string]bool{
+		"Authorization":       true,
+		"Cookie":              true,
+		"Proxy-Authorization": true,
+	}
+)
+
+// HeaderForwarding applies HeaderForwardingRules to client request headers
+type HeaderForwarding struct {
+	rules      HeaderForwardingRules
+	allow      map[string]bool
+	allowRegex []*regexp.Regexp
+	rename     map[string]string
+	remove     map[string]bool
+}
+
+// NewHeaderForwarding compiles the rules, it fails on invalid regular expressions
+func NewHeaderForwarding(rules HeaderForwardingRules) (*HeaderForwarding, error) {
+	forwarding := &HeaderForwarding{
+		rules:  rules,
+		allow:  map[string]bool{},
+		rename: map[string]string{},
+		remove: map[string]bool{},
+	}
+	for _, name := range rules.Allow {
+		forwarding.allow[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, expression := range rules.AllowRegex {
+		allowRegex, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		forwarding.allowRegex = append(forwarding.allowRegex, allowRegex)
+	}
+	for from, to := range rules.Rename {
+		forwarding.rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+	for _, name := range rules.Remove {
+		forwarding.remove[http.CanonicalHeaderKey(name)] = true
+	}
+	return forwarding, nil
+}
+
+// Rules returns the rules the HeaderForwarding got compiled from
+func (h *HeaderForwarding) Rules() HeaderForwardingRules {
+	return h.rules
+}
+
+// Apply returns the headers to send upstream for the client request header
+func (h *HeaderForwarding) Apply(client http.Header) http.Header {
+	forwarded := http.Header{}
+
+	// headers listed in the Connection header are hop-by-hop headers as well
+	connectionHeaders := map[string]bool{}
+	for _, value := range client["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			connectionHeaders[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+
+	for name, values := range client {
+		name = http.CanonicalHeaderKey(name)
+		if hopByHopHeaders[name] || connectionHeaders[name] {
+			continue
+		}
+		if to, ok := h.rename[name]; ok {
+			forwarded[to] = append(forwarded[to], values...)
+			continue
+		}
+		if !h.allows(name) {
+			continue
+		}
+		forwarded[name] = append(forwarded[name], values...)
+	}
+
+	for name, values := range h.rules.Inject {
+		forwarded[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+
+	for name := range forwarded {
+		if h.remove[name] || hopByHopHeaders[name] {
+			delete(forwarded, name)
+		}
+	}
+
+	return forwarded
+}
+
+func (h *HeaderForwarding) allows(name string) bool {
+	if h.allow[name] {
+		return true
+	}
+	if sensitiveHeaders[name] {
+		return false
+	}
+	for _, allowRegex := range h.allowRegex {
+		if allowRegex.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderForwardingVariable renders the forwarded client request headers as JSON object, e.g. {"Authorization":["Bearer token"]}
+type HeaderForwardingVariable struct {
+	Forwarding *HeaderForwarding
+}
+
+func (h *HeaderForwardingVariable) TemplateSegment() TemplateSegment {
+	return TemplateSegment{
+		SegmentType:      VariableSegmentType,
+		VariableSource:   VariableSourceHeaderForwarding,
+		HeaderForwarding: h.Forwarding,
+	}
+}
+
+func (h *HeaderForwardingVariable) VariableKind() VariableKind {
+	return VariableKindHeaderForwarding
+}
+
+func (h *HeaderForwardingVariable) Equals(another Variable) bool {
+	if another == nil {
+		return false
+	}
+	if another.VariableKind() != h.VariableKind() {
+		return false
+	}
+	anotherHeaderForwardingVariable := another.(*HeaderForwardingVariable)
+	return reflect.DeepEqual(h.Forwarding.Rules(), anotherHeaderForwardingVariable.Forwarding.Rules())
+}
+
+func (i *InputTemplate) renderHeaderForwardingVariable(ctx *Context, forwarding *HeaderForwarding, preparedInput *fastbuffer.FastBuffer) error {
+	forwarded, err := json.Marshal(forwarding.Apply(ctx.Request.Header))
+	if err != nil {
+		return err
+	}
+	preparedInput.WriteBytes(forwarded)
+	return nil
+}
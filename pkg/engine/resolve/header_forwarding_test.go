@@ -0,0 +1,99 @@
+package resolve
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/fastbuffer"
+)
+
+func TestHeaderForwarding_Apply(t *testing.T) {
+	client := http.Header{
+		"Authorization":     {"Bearer token"},
+		"Cookie":            {"session=1"},
+		"Connection":        {"keep-alive, X-Per-Hop"},
+		"X-Per-Hop":         {"1"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"websocket"},
+		"X-Request-Id":      {"abc"},
+		"X-Tenant":          {"wundergraph"},
+		"X-User":            {"jens"},
+		"Accept-Language":   {"de", "en"},
+	}
+
+	run := func(rules HeaderForwardingRules, expected http.Header) func(t *testing.T) {
+		return func(t *testing.T) {
+			forwarding, err := NewHeaderForwarding(rules)
+			require.NoError(t, err)
+			assert.Equal(t, expected, forwarding.Apply(client))
+		}
+	}
+
+	t.Run("nothing allowed", run(HeaderForwardingRules{}, http.Header{}))
+	t.Run("allow list", run(HeaderForwardingRules{
+		Allow: []string{"authorization", "Accept-Language", "X-Per-Hop", "Connection"},
+	}, http.Header{
+		"Authorization":   {"Bearer token"},
+		"Accept-Language": {"de", "en"},
+	}))
+	t.Run("regex doesn't allow sensitive headers", run(HeaderForwardingRules{
+		AllowRegex: []string{"^X-", "^Auth", "^Cook"},
+	}, http.Header{
+		"X-Request-Id": {"abc"},
+		"X-Tenant":     {"wundergraph"},
+		"X-User":       {"jens"},
+	}))
+	t.Run("rename", run(HeaderForwardingRules{
+		Allow:  []string{"X-Tenant"},
+		Rename: map[string]string{"x-user": "X-Upstream-User", "Cookie": "X-Session"},
+	}, http.Header{
+		"X-Tenant":        {"wundergraph"},
+		"X-Upstream-User": {"jens"},
+		"X-Session":       {"session=1"},
+	}))
+	t.Run("inject and remove", run(HeaderForwardingRules{
+		AllowRegex: []string{"^X-"},
+		Inject:     http.Header{"X-Tenant": {"static"}, "X-Source": {"gateway"}, "Host": {"example.com"}},
+		Remove:     []string{"X-Request-Id"},
+	}, http.Header{
+		"X-Tenant": {"static"},
+		"X-User":   {"jens"},
+		"X-Source": {"gateway"},
+	}))
+
+	t.Run("invalid regex", func(t *testing.T) {
+		_, err := NewHeaderForwarding(HeaderForwardingRules{AllowRegex: []string{"("}})
+		assert.Error(t, err)
+	})
+}
+
+func TestHeaderForwardingVariable(t *testing.T) {
+	forwarding, err := NewHeaderForwarding(HeaderForwardingRules{Allow: []string{"Authorization"}})
+	require.NoError(t, err)
+	other, err := NewHeaderForwarding(HeaderForwardingRules{Allow: []string{"Authorization"}})
+	require.NoError(t, err)
+
+	variable := &HeaderForwardingVariable{Forwarding: forwarding}
+	assert.True(t, variable.Equals(&HeaderForwardingVariable{Forwarding: other}))
+	assert.False(t, variable.Equals(&HeaderVariable{Path: []string{"Authorization"}}))
+
+	template := InputTemplate{
+		Segments: []TemplateSegment{
+			{SegmentType: StaticSegmentType, Data: []byte(`{"forwarded_header":`)},
+			variable.TemplateSegment(),
+			{SegmentType: StaticSegmentType, Data: []byte(`}`)},
+		},
+	}
+	ctx := &Context{
+		Context: context.Background(),
+		Request: Request{Header: http.Header{"Authorization": {`Bearer "token"`}, "X-Other": {"1"}}},
+	}
+	out := fastbuffer.New()
+	err = template.Render(ctx, nil, out)
+	require.NoError(t, err)
+	assert.Equal(t, `{"forwarded_header":{"Authorization":["Bearer \"token\""]}}`, string(out.Bytes()))
+}
@@ -0,0 +1,153 @@
+package resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// createJSONPatch creates a JSON Patch (RFC 6902) which transforms previous into next
+// The patch is an empty array if both documents are equal.
+func createJSONPatch(previous, next []byte) ([]byte, error) {
+	previousValue, err := decodeJSON(previous)
+	if err != nil {
+		return nil, err
+	}
+	nextValue, err := decodeJSON(next)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &jsonPatch{}
+	patch.WriteByte('[')
+	err = patch.diff("", previousValue, nextValue)
+	if err != nil {
+		return nil, err
+	}
+	patch.WriteByte(']')
+	return patch.Bytes(), nil
+}
+
+func decodeJSON(data []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	return
+}
+
+type jsonPatch struct {
+	bytes.Buffer
+	operations int
+}
+
+func (p *jsonPatch) diff(path string, previous, next interface{}) error {
+	switch previousValue := previous.(type) {
+	case map[string]interface{}:
+		nextValue, ok := next.(map[string]interface{})
+		if !ok {
+			return p.write("replace", path, next)
+		}
+		return p.diffObjects(path, previousValue, nextValue)
+	case []interface{}:
+		nextValue, ok := next.([]interface{})
+		if !ok {
+			return p.write("replace", path, next)
+		}
+		return p.diffArrays(path, previousValue, nextValue)
+	default:
+		if reflect.DeepEqual(previous, next) {
+			return nil
+		}
+		return p.write("replace", path, next)
+	}
+}
+
+func (p *jsonPatch) diffObjects(path string, previous, next map[string]interface{}) error {
+	keys := make([]string, 0, len(previous)+len(next))
+	for key := range previous {
+		keys = append(keys, key)
+	}
+	for key := range next {
+		if _, ok := previous[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + escapeJSONPointer(key)
+		previousValue, inPrevious := previous[key]
+		nextValue, inNext := next[key]
+		var err error
+		switch {
+		case !inNext:
+			err = p.write("remove", keyPath, nil)
+		case !inPrevious:
+			err = p.write("add", keyPath, nextValue)
+		default:
+			err = p.diff(keyPath, previousValue, nextValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *jsonPatch) diffArrays(path string, previous, next []interface{}) error {
+	common := len(previous)
+	if len(next) < common {
+		common = len(next)
+	}
+	for i := 0; i < common; i++ {
+		err := p.diff(path+"/"+strconv.Itoa(i), previous[i], next[i])
+		if err != nil {
+			return err
+		}
+	}
+	// remove from the end so that the indices of the remaining items don't shift
+	for i := len(previous) - 1; i >= common; i-- {
+		err := p.write("remove", path+"/"+strconv.Itoa(i), nil)
+		if err != nil {
+			return err
+		}
+	}
+	for i := common; i < len(next); i++ {
+		err := p.write("add", path+"/"+strconv.Itoa(i), next[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *jsonPatch) write(op, path string, value interface{}) error {
+	if p.operations != 0 {
+		p.WriteByte(',')
+	}
+	p.operations++
+
+	encodedPath, err := json.Marshal(path)
+	if err != nil {
+		return err
+	}
+	p.WriteString(`{"op":"` + op + `","path":`)
+	p.Write(encodedPath)
+	if op != "remove" {
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		p.WriteString(`,"value":`)
+		p.Write(encodedValue)
+	}
+	p.WriteByte('}')
+	return nil
+}
+
+func escapeJSONPointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
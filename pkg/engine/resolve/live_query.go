@@ -0,0 +1,141 @@
+package resolve
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// LiveQuery configures when the response of a query with the @live directive gets resolved again
+type LiveQuery struct {
+	// PollingInterval resolves the response periodically if greater than zero
+	PollingInterval time.Duration
+	// InvalidationKeys resolve the response whenever one of them gets invalidated, e.g. "Query.orders"
+	InvalidationKeys []string
+}
+
+// liveQueryInvalidations notifies live queries about invalidated keys
+type liveQueryInvalidations struct {
+	mux         sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func (l *liveQueryInvalidations) subscribe(keys []string) chan struct{} {
+	invalidated := make(chan struct{}, 1)
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, key := range keys {
+		if l.subscribers[key] == nil {
+			l.subscribers[key] = map[chan struct{}]struct{}{}
+		}
+		l.subscribers[key][invalidated] = struct{}{}
+	}
+	return invalidated
+}
+
+func (l *liveQueryInvalidations) unsubscribe(keys []string, invalidated chan struct{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, key := range keys {
+		delete(l.subscribers[key], invalidated)
+		if len(l.subscribers[key]) == 0 {
+			delete(l.subscribers, key)
+		}
+	}
+}
+
+func (l *liveQueryInvalidations) invalidate(keys []string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, key := range keys {
+		for invalidated := range l.subscribers[key] {
+			select {
+			case invalidated <- struct{}{}:
+			default:
+				// the live query is already about to be resolved again
+			}
+		}
+	}
+}
+
+// InvalidateLiveQueries resolves all live queries again which depend on one of the keys
+func (r *Resolver) InvalidateLiveQueries(keys ...string) {
+	r.liveQueries.invalidate(keys)
+}
+
+// ResolveGraphQLLiveQuery resolves the response until the context is done
+// The first result is written as is, afterwards only changed results are written as JSON Patch (RFC 6902),
+// e.g. {"patch":[{"op":"replace","path":"/data/counter","value":2}]}.
+// Every result is flushed.
+func (r *Resolver) ResolveGraphQLLiveQuery(ctx *Context, response *GraphQLResponse, liveQuery *LiveQuery, writer FlushWriter) (err error) {
+	invalidated := r.liveQueries.subscribe(liveQuery.InvalidationKeys)
+	defer r.liveQueries.unsubscribe(liveQuery.InvalidationKeys, invalidated)
+
+	var poll <-chan time.Time
+	if liveQuery.PollingInterval > 0 {
+		ticker := time.NewTicker(liveQuery.PollingInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	var (
+		previous []byte
+		current  = &bytes.Buffer{}
+		done     = ctx.Context.Done()
+	)
+
+	for {
+		current.Reset()
+		err = r.ResolveGraphQLResponse(ctx, response, nil, current)
+		if err != nil {
+			return err
+		}
+
+		err = r.writeLiveQueryResult(previous, current.Bytes(), writer)
+		if err != nil {
+			return err
+		}
+		previous = append(previous[:0], current.Bytes()...)
+
+		select {
+		case <-done:
+			return nil
+		case <-poll:
+		case <-invalidated:
+		}
+	}
+}
+
+func (r *Resolver) writeLiveQueryResult(previous, current []byte, writer FlushWriter) error {
+	if previous == nil {
+		_, err := writer.Write(current)
+		if err != nil {
+			return err
+		}
+		writer.Flush()
+		return nil
+	}
+
+	if bytes.Equal(previous, current) {
+		return nil
+	}
+
+	patch, err := createJSONPatch(previous, current)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(patch, emptyJSONPatch) {
+		return nil
+	}
+
+	_, err = writer.Write(append(append([]byte(`{"patch":`), patch...), '}'))
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return nil
+}
+
+var (
+	emptyJSONPatch = []byte("[]")
+)
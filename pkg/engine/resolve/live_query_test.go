@@ -0,0 +1,191 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateJSONPatch(t *testing.T) {
+	run := func(previous, next, expectedPatch string) func(t *testing.T) {
+		return func(t *testing.T) {
+			patch, err := createJSONPatch([]byte(previous), []byte(next))
+			require.NoError(t, err)
+			assert.Equal(t, expectedPatch, string(patch))
+
+			decoded, err := jsonpatch.DecodePatch(patch)
+			require.NoError(t, err)
+			patched, err := decoded.Apply([]byte(previous))
+			require.NoError(t, err)
+			assert.JSONEq(t, next, string(patched))
+		}
+	}
+
+	t.Run("equal", run(
+		`{"data":{"a":1,"b":[1,2]}}`,
+		`{"data":{"a":1,"b":[1,2]}}`,
+		`[]`,
+	))
+	t.Run("replace scalar", run(
+		`{"data":{"a":1,"b":"foo"}}`,
+		`{"data":{"a":2,"b":"foo"}}`,
+		`[{"op":"replace","path":"/data/a","value":2}]`,
+	))
+	t.Run("add and remove fields", run(
+		`{"data":{"a":1,"b":null}}`,
+		`{"data":{"b":null,"c":{"d":true}},"errors":[{"message":"e"}]}`,
+		`[{"op":"remove","path":"/data/a"},{"op":"add","path":"/data/c","value":{"d":true}},{"op":"add","path":"/errors","value":[{"message":"e"}]}]`,
+	))
+	t.Run("grow and shrink arrays", run(
+		`{"a":[1,2,3],"b":[{"id":1}]}`,
+		`{"a":[1,5],"b":[{"id":1},{"id":2}]}`,
+		`[{"op":"replace","path":"/a/1","value":5},{"op":"remove","path":"/a/2"},{"op":"add","path":"/b/1","value":{"id":2}}]`,
+	))
+	t.Run("change types", run(
+		`{"a":[1],"b":{"c":1},"c":1}`,
+		`{"a":{"b":1},"b":null,"c":[1]}`,
+		`[{"op":"replace","path":"/a","value":{"b":1}},{"op":"replace","path":"/b","value":null},{"op":"replace","path":"/c","value":[1]}]`,
+	))
+	t.Run("escape keys", run(
+		`{"a/b":1,"c~d":1}`,
+		`{"a/b":2,"c~d":2}`,
+		`[{"op":"replace","path":"/a~1b","value":2},{"op":"replace","path":"/c~0d","value":2}]`,
+	))
+}
+
+// _sequenceDataSource returns the next response on every load and the last one once all got returned
+type _sequenceDataSource struct {
+	responses []string
+	loads     int
+	loaded    chan struct{}
+}
+
+func (s *_sequenceDataSource) UniqueIdentifier() []byte {
+	return []byte("sequence")
+}
+
+func (s *_sequenceDataSource) Load(ctx context.Context, input []byte, pair *BufPair) (err error) {
+	i := s.loads
+	if i > len(s.responses)-1 {
+		i = len(s.responses) - 1
+	}
+	s.loads++
+	pair.Data.WriteString(s.responses[i])
+	select {
+	case s.loaded <- struct{}{}:
+	default:
+	}
+	return
+}
+
+type _liveQueryWriter struct {
+	buf     bytes.Buffer
+	flushed chan string
+}
+
+func (l *_liveQueryWriter) Write(p []byte) (n int, err error) {
+	return l.buf.Write(p)
+}
+
+func (l *_liveQueryWriter) Flush() {
+	l.flushed <- l.buf.String()
+	l.buf.Reset()
+}
+
+func TestResolver_ResolveGraphQLLiveQuery(t *testing.T) {
+	liveQueryResponse := func(dataSource DataSource) *GraphQLResponse {
+		return &GraphQLResponse{
+			Data: &Object{
+				Fetch: &SingleFetch{
+					BufferId:   0,
+					DataSource: dataSource,
+				},
+				Fields: []*Field{
+					{
+						BufferID:  0,
+						HasBuffer: true,
+						Name:      []byte("counter"),
+						Value: &Integer{
+							Path: []string{"counter"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	start := func(t *testing.T, liveQuery *LiveQuery, dataSource DataSource) (resolver *Resolver, writer *_liveQueryWriter, stop func()) {
+		resolver = New()
+		writer = &_liveQueryWriter{
+			flushed: make(chan string),
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := resolver.ResolveGraphQLLiveQuery(&Context{Context: ctx}, liveQueryResponse(dataSource), liveQuery, writer)
+			assert.NoError(t, err)
+		}()
+		return resolver, writer, func() {
+			cancel()
+			<-done
+		}
+	}
+
+	next := func(t *testing.T, writer *_liveQueryWriter) string {
+		select {
+		case flushed := <-writer.flushed:
+			return flushed
+		case <-time.After(time.Second):
+			t.Fatal("no result flushed")
+			return ""
+		}
+	}
+
+	t.Run("invalidation", func(t *testing.T) {
+		dataSource := &_sequenceDataSource{
+			responses: []string{`{"counter":0}`, `{"counter":0}`, `{"counter":1}`},
+			loaded:    make(chan struct{}, 3),
+		}
+		resolver, writer, stop := start(t, &LiveQuery{InvalidationKeys: []string{"Query.counter"}}, dataSource)
+		defer stop()
+
+		assert.Equal(t, `{"data":{"counter":0}}`, next(t, writer))
+		<-dataSource.loaded
+
+		resolver.InvalidateLiveQueries("Query.other")
+		resolver.InvalidateLiveQueries("Query.counter")
+		// the unchanged result doesn't get flushed
+		<-dataSource.loaded
+		resolver.InvalidateLiveQueries("Query.counter")
+
+		assert.Equal(t, `{"patch":[{"op":"replace","path":"/data/counter","value":1}]}`, next(t, writer))
+		<-dataSource.loaded
+		assert.Equal(t, 3, dataSource.loads)
+	})
+
+	t.Run("polling", func(t *testing.T) {
+		dataSource := &_sequenceDataSource{
+			responses: []string{`{"counter":0}`, `{"counter":1}`, `{"counter":2}`},
+			loaded:    make(chan struct{}),
+		}
+		_, writer, stop := start(t, &LiveQuery{PollingInterval: time.Millisecond}, dataSource)
+
+		assert.Equal(t, `{"data":{"counter":0}}`, next(t, writer))
+		assert.Equal(t, `{"patch":[{"op":"replace","path":"/data/counter","value":1}]}`, next(t, writer))
+		assert.Equal(t, `{"patch":[{"op":"replace","path":"/data/counter","value":2}]}`, next(t, writer))
+
+		go func() {
+			// drain results flushed while stopping
+			for range writer.flushed {
+			}
+		}()
+		stop()
+		close(writer.flushed)
+	})
+}
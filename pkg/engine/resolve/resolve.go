@@ -5,6 +5,7 @@ package resolve
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"hash"
 	"io"
@@ -22,25 +23,27 @@ import (
 
 	"github.com/jensneuse/graphql-go-tools/internal/pkg/unsafebytes"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/fastbuffer"
 	"github.com/jensneuse/graphql-go-tools/pkg/lexer/literal"
 	"github.com/jensneuse/graphql-go-tools/pkg/pool"
 )
 
 var (
-	lBrace           = []byte("{")
-	rBrace           = []byte("}")
-	lBrack           = []byte("[")
-	rBrack           = []byte("]")
-	comma            = []byte(",")
-	colon            = []byte(":")
-	quote            = []byte("\"")
-	null             = []byte("null")
-	literalData      = []byte("data")
-	literalErrors    = []byte("errors")
-	literalMessage   = []byte("message")
-	literalLocations = []byte("locations")
-	literalPath      = []byte("path")
+	lBrace            = []byte("{")
+	rBrace            = []byte("}")
+	lBrack            = []byte("[")
+	rBrack            = []byte("]")
+	comma             = []byte(",")
+	colon             = []byte(":")
+	quote             = []byte("\"")
+	null              = []byte("null")
+	literalData       = []byte("data")
+	literalErrors     = []byte("errors")
+	literalMessage    = []byte("message")
+	literalLocations  = []byte("locations")
+	literalPath       = []byte("path")
+	literalExtensions = []byte("extensions")
 )
 
 var errNonNullableFieldValueIsNull = errors.New("non Nullable field value is null")
@@ -71,6 +74,9 @@ const (
 
 type HookContext struct {
 	CurrentPath []byte
+	// Response is the upstream response of the fetch
+	// It is nil before the fetch and for data sources which don't record their upstream response.
+	Response *FetchResponse
 }
 
 type BeforeFetchHook interface {
@@ -94,6 +100,11 @@ type Context struct {
 	pathPrefix      []byte
 	beforeFetchHook BeforeFetchHook
 	afterFetchHook  AfterFetchHook
+	// headerPropagation is shared by clones because all of them contribute to the same downstream response
+	headerPropagation *headerPropagation
+	// errorPolicy is shared by clones so that all errors of a request get the same correlation ID
+	errorPolicy *errorPolicy
+	tracer      tracing.Tracer
 }
 
 type Request struct {
@@ -136,17 +147,20 @@ func (c *Context) Clone() Context {
 		copy(patches[i].data, c.patches[i].data)
 	}
 	return Context{
-		Context:         c.Context,
-		Variables:       variables,
-		Request:         c.Request,
-		pathElements:    pathElements,
-		patches:         patches,
-		usedBuffers:     make([]*bytes.Buffer, 0, 48),
-		currentPatch:    c.currentPatch,
-		maxPatch:        c.maxPatch,
-		pathPrefix:      pathPrefix,
-		beforeFetchHook: c.beforeFetchHook,
-		afterFetchHook:  c.afterFetchHook,
+		Context:           c.Context,
+		Variables:         variables,
+		Request:           c.Request,
+		pathElements:      pathElements,
+		patches:           patches,
+		usedBuffers:       make([]*bytes.Buffer, 0, 48),
+		currentPatch:      c.currentPatch,
+		maxPatch:          c.maxPatch,
+		pathPrefix:        pathPrefix,
+		beforeFetchHook:   c.beforeFetchHook,
+		afterFetchHook:    c.afterFetchHook,
+		headerPropagation: c.headerPropagation,
+		errorPolicy:       c.errorPolicy,
+		tracer:            c.tracer,
 	}
 }
 
@@ -164,6 +178,9 @@ func (c *Context) Free() {
 	c.maxPatch = -1
 	c.beforeFetchHook = nil
 	c.afterFetchHook = nil
+	c.headerPropagation = nil
+	c.errorPolicy = nil
+	c.tracer = nil
 	c.Request.Header = nil
 }
 
@@ -250,6 +267,7 @@ type Resolver struct {
 	inflightFetchMu          sync.Mutex
 	inflightFetches          map[uint64]*inflightFetch
 	triggerManagers          map[uint64]*subscription.Manager
+	liveQueries              *liveQueryInvalidations
 }
 
 func (r *Resolver) RegisterTriggerManager(m *subscription.Manager) {
@@ -260,11 +278,24 @@ func (r *Resolver) RegisterTriggerManager(m *subscription.Manager) {
 	r.triggerManagers[managerID] = m
 }
 
+// ShutdownTriggerManagers shuts down all registered trigger managers, see subscription.Manager.Shutdown
+func (r *Resolver) ShutdownTriggerManagers(ctx context.Context) error {
+	var shutdownErr error
+	for _, manager := range r.triggerManagers {
+		err := manager.Shutdown(ctx)
+		if err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
+
 type inflightFetch struct {
 	waitLoad sync.WaitGroup
 	waitFree sync.WaitGroup
 	err      error
 	bufPair  BufPair
+	response *FetchResponse
 }
 
 func New() *Resolver {
@@ -324,6 +355,9 @@ func New() *Resolver {
 		},
 		inflightFetches: map[uint64]*inflightFetch{},
 		triggerManagers: map[uint64]*subscription.Manager{},
+		liveQueries: &liveQueryInvalidations{
+			subscribers: map[string]map[chan struct{}]struct{}{},
+		},
 	}
 }
 
@@ -434,13 +468,32 @@ func (r *Resolver) ResolveGraphQLSubscription(ctx *Context, subscription *GraphQ
 	triggerInput := make([]byte, len(rendered))
 	copy(triggerInput, rendered)
 	r.freeBufPair(buf)
+	triggerInput = traceTriggerInput(ctx, triggerInput)
 
-	trigger := manager.StartTrigger(triggerInput)
+	filter, err := r.renderSubscriptionFilter(ctx, subscription.Trigger.Filters)
+	if err != nil {
+		return err
+	}
+
+	trigger := manager.StartTriggerWithFilter(triggerInput, filter)
 	defer manager.StopTrigger(trigger)
 
 	for {
 		data, ok := trigger.Next(ctx)
 		if !ok {
+			if ctx.Context.Err() != nil {
+				return nil
+			}
+			// the stream terminated the subscription, forward the error as last response
+			_, err = trigger.Terminated()
+			if err == nil {
+				return nil
+			}
+			err = r.writeSubscriptionError(err, writer)
+			if err != nil {
+				return err
+			}
+			writer.Flush()
 			return nil
 		}
 		err = r.ResolveGraphQLResponse(ctx, subscription.Response, data, writer)
@@ -451,6 +504,50 @@ func (r *Resolver) ResolveGraphQLSubscription(ctx *Context, subscription *GraphQ
 	}
 }
 
+// renderSubscriptionFilter renders the filter values for the current subscriber
+func (r *Resolver) renderSubscriptionFilter(ctx *Context, filters []SubscriptionFilter) (subscription.Filter, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	buf := r.getBufPair()
+	defer r.freeBufPair(buf)
+
+	fieldFilters := make([]subscription.Filter, 0, len(filters))
+	for i := range filters {
+		buf.Data.Reset()
+		err := filters[i].ValueTemplate.Render(ctx, nil, buf.Data)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, buf.Data.Len())
+		copy(value, buf.Data.Bytes())
+		fieldFilters = append(fieldFilters, subscription.FieldEquals(filters[i].FieldPath, value))
+	}
+
+	return subscription.All(fieldFilters...), nil
+}
+
+// writeSubscriptionError writes the error which terminated a subscription as GraphQL response
+// Upstream GraphQL errors are forwarded as is, other errors become a single GraphQL error.
+func (r *Resolver) writeSubscriptionError(terminalErr error, writer io.Writer) (err error) {
+	var graphQLErrors []byte
+	if upstreamError, ok := terminalErr.(*subscription.UpstreamError); ok && len(upstreamError.Errors) != 0 {
+		graphQLErrors = upstreamError.Errors
+	} else {
+		message, _ := json.Marshal(terminalErr.Error())
+		graphQLErrors = []byte(`[{"message":` + string(message) + `}]`)
+	}
+	err = r.writeSafe(err, writer, lBrace)
+	err = r.writeSafe(err, writer, quote)
+	err = r.writeSafe(err, writer, literalErrors)
+	err = r.writeSafe(err, writer, quote)
+	err = r.writeSafe(err, writer, colon)
+	err = r.writeSafe(err, writer, graphQLErrors)
+	err = r.writeSafe(err, writer, rBrace)
+	return
+}
+
 func (r *Resolver) ResolveGraphQLStreamingResponse(ctx *Context, response *GraphQLStreamingResponse, data []byte, writer FlushWriter) (err error) {
 
 	if err := r.validateContext(ctx); err != nil {
@@ -966,20 +1063,32 @@ func (r *Resolver) prepareSingleFetch(ctx *Context, fetch *SingleFetch, data []b
 	return
 }
 
-func (r *Resolver) resolveSingleFetch(ctx *Context, fetch *SingleFetch, preparedInput *fastbuffer.FastBuffer, buf *BufPair) (err error) {
+func (r *Resolver) resolveSingleFetch(ctx *Context, fetch *SingleFetch, preparedInput *fastbuffer.FastBuffer, buf *BufPair) error {
+	if ctx.tracer != nil {
+		return r.traceSingleFetch(ctx, fetch, preparedInput, buf)
+	}
+	_, err := r.loadSingleFetch(ctx, ctx.Context, fetch, preparedInput, buf)
+	return ctx.applyErrorPolicy(fetch, buf, err)
+}
+
+// loadSingleFetch loads the fetch with the data source, parent is the context the fetch context derives from
+// singleFlight is true if the data got loaded by another fetch with the same input.
+func (r *Resolver) loadSingleFetch(ctx *Context, parent context.Context, fetch *SingleFetch, preparedInput *fastbuffer.FastBuffer, buf *BufPair) (singleFlight bool, err error) {
 
 	if ctx.beforeFetchHook != nil {
 		ctx.beforeFetchHook.OnBeforeFetch(r.hookCtx(ctx), preparedInput.Bytes())
 	}
 
 	if !r.EnableSingleFlightLoader || fetch.DisallowSingleFlight {
-		err = fetch.DataSource.Load(ctx.Context, preparedInput.Bytes(), buf)
+		fetchCtx, response := ctx.fetchContext(parent)
+		err = fetch.DataSource.Load(fetchCtx, preparedInput.Bytes(), buf)
+		ctx.propagateHeaders(response)
 		if ctx.afterFetchHook != nil {
 			if buf.HasData() {
-				ctx.afterFetchHook.OnData(r.hookCtx(ctx), buf.Data.Bytes(), false)
+				ctx.afterFetchHook.OnData(r.afterFetchHookCtx(ctx, response), buf.Data.Bytes(), false)
 			}
 			if buf.HasErrors() {
-				ctx.afterFetchHook.OnError(r.hookCtx(ctx), buf.Errors.Bytes(), false)
+				ctx.afterFetchHook.OnError(r.afterFetchHookCtx(ctx, response), buf.Errors.Bytes(), false)
 			}
 		}
 		return
@@ -998,19 +1107,20 @@ func (r *Resolver) resolveSingleFetch(ctx *Context, fetch *SingleFetch, prepared
 		defer inflight.waitFree.Done()
 		r.inflightFetchMu.Unlock()
 		inflight.waitLoad.Wait()
+		ctx.propagateHeaders(inflight.response)
 		if inflight.bufPair.HasData() {
 			if ctx.afterFetchHook != nil {
-				ctx.afterFetchHook.OnData(r.hookCtx(ctx), inflight.bufPair.Data.Bytes(), true)
+				ctx.afterFetchHook.OnData(r.afterFetchHookCtx(ctx, inflight.response), inflight.bufPair.Data.Bytes(), true)
 			}
 			buf.Data.WriteBytes(inflight.bufPair.Data.Bytes())
 		}
 		if inflight.bufPair.HasErrors() {
 			if ctx.afterFetchHook != nil {
-				ctx.afterFetchHook.OnError(r.hookCtx(ctx), inflight.bufPair.Errors.Bytes(), true)
+				ctx.afterFetchHook.OnError(r.afterFetchHookCtx(ctx, inflight.response), inflight.bufPair.Errors.Bytes(), true)
 			}
 			buf.Errors.WriteBytes(inflight.bufPair.Errors.Bytes())
 		}
-		return inflight.err
+		return true, inflight.err
 	}
 
 	inflight = r.getInflightFetch()
@@ -1019,19 +1129,22 @@ func (r *Resolver) resolveSingleFetch(ctx *Context, fetch *SingleFetch, prepared
 
 	r.inflightFetchMu.Unlock()
 
-	err = fetch.DataSource.Load(ctx.Context, preparedInput.Bytes(), &inflight.bufPair)
+	fetchCtx, response := ctx.fetchContext(parent)
+	err = fetch.DataSource.Load(fetchCtx, preparedInput.Bytes(), &inflight.bufPair)
 	inflight.err = err
+	inflight.response = response
+	ctx.propagateHeaders(response)
 
 	if inflight.bufPair.HasData() {
 		if ctx.afterFetchHook != nil {
-			ctx.afterFetchHook.OnData(r.hookCtx(ctx), inflight.bufPair.Data.Bytes(), false)
+			ctx.afterFetchHook.OnData(r.afterFetchHookCtx(ctx, response), inflight.bufPair.Data.Bytes(), false)
 		}
 		buf.Data.WriteBytes(inflight.bufPair.Data.Bytes())
 	}
 
 	if inflight.bufPair.HasErrors() {
 		if ctx.afterFetchHook != nil {
-			ctx.afterFetchHook.OnError(r.hookCtx(ctx), inflight.bufPair.Errors.Bytes(), true)
+			ctx.afterFetchHook.OnError(r.afterFetchHookCtx(ctx, response), inflight.bufPair.Errors.Bytes(), true)
 		}
 		buf.Errors.WriteBytes(inflight.bufPair.Errors.Bytes())
 	}
@@ -1056,6 +1169,12 @@ func (r *Resolver) hookCtx(ctx *Context) HookContext {
 	}
 }
 
+func (r *Resolver) afterFetchHookCtx(ctx *Context, response *FetchResponse) HookContext {
+	hookCtx := r.hookCtx(ctx)
+	hookCtx.Response = response
+	return hookCtx
+}
+
 type Object struct {
 	Nullable bool
 	Path     []string
@@ -1116,7 +1235,9 @@ type SingleFetch struct {
 	BufferId   int
 	Input      string
 	DataSource DataSource
-	Variables  Variables
+	// DataSourceID is the ID of the configuration of the DataSource, it identifies the data source in traces
+	DataSourceID string
+	Variables    Variables
 	// DisallowSingleFlight is used for write operations like mutations, POST, DELETE etc. to disable singleFlight
 	// By default SingleFlight for fetches is disabled and needs to be enabled on the Resolver first
 	// If the resolver allows SingleFlight it's up the each individual DataSource Planner to decide whether an Operation
@@ -1142,6 +1263,8 @@ func (i *InputTemplate) Render(ctx *Context, data []byte, preparedInput *fastbuf
 				err = i.renderContextVariable(ctx, i.Segments[j].VariableSourcePath, i.Segments[j].RenderAsGraphQLValue, preparedInput)
 			case VariableSourceRequestHeader:
 				err = i.renderHeaderVariable(ctx, i.Segments[j].VariableSourcePath, preparedInput)
+			case VariableSourceHeaderForwarding:
+				err = i.renderHeaderForwardingVariable(ctx, i.Segments[j].HeaderForwarding, preparedInput)
 			default:
 				err = fmt.Errorf("InputTemplate.Render: cannot resolve variable of kind: %d", i.Segments[j].VariableSource)
 			}
@@ -1263,6 +1386,7 @@ const (
 	VariableSourceObject VariableSource = iota + 1
 	VariableSourceContext
 	VariableSourceRequestHeader
+	VariableSourceHeaderForwarding
 )
 
 type TemplateSegment struct {
@@ -1271,6 +1395,7 @@ type TemplateSegment struct {
 	VariableSource       VariableSource
 	VariableSourcePath   []string
 	RenderAsGraphQLValue bool
+	HeaderForwarding     *HeaderForwarding
 }
 
 func (_ *SingleFetch) FetchKind() FetchKind {
@@ -1383,6 +1508,7 @@ const (
 	VariableKindContext VariableKind = iota + 1
 	VariableKindObject
 	VariableKindHeader
+	VariableKindHeaderForwarding
 )
 
 type ContextVariable struct {
@@ -1502,6 +1628,16 @@ type GraphQLSubscriptionTrigger struct {
 	Input         string
 	InputTemplate InputTemplate
 	Variables     Variables
+	// Filters are rendered per subscriber, a subscriber only receives the events matching all of them
+	Filters []SubscriptionFilter
+}
+
+// SubscriptionFilter matches events with the value at FieldPath equal to the rendered ValueTemplate
+type SubscriptionFilter struct {
+	FieldPath     []string
+	Value         string
+	ValueTemplate InputTemplate
+	Variables     Variables
 }
 
 type FlushWriter interface {
@@ -1555,6 +1691,11 @@ func (b *BufPair) writeErrors(data []byte) {
 }
 
 func (b *BufPair) WriteErr(message, locations, path []byte) {
+	b.WriteErrWithExtensions(message, locations, path, nil)
+}
+
+// WriteErrWithExtensions writes an error with the extensions object, e.g. {"code":"UNAUTHENTICATED"}, if not nil
+func (b *BufPair) WriteErrWithExtensions(message, locations, path, extensions []byte) {
 	if b.HasErrors() {
 		b.writeErrors(comma)
 	}
@@ -1582,6 +1723,14 @@ func (b *BufPair) WriteErr(message, locations, path []byte) {
 		b.writeErrors(colon)
 		b.writeErrors(path)
 	}
+	if extensions != nil {
+		b.writeErrors(comma)
+		b.writeErrors(quote)
+		b.writeErrors(literalExtensions)
+		b.writeErrors(quote)
+		b.writeErrors(colon)
+		b.writeErrors(extensions)
+	}
 	b.writeErrors(rBrace)
 }
 
@@ -1662,6 +1811,7 @@ func (r *Resolver) freeInflightFetch(f *inflightFetch) {
 	f.bufPair.Data.Reset()
 	f.bufPair.Errors.Reset()
 	f.err = nil
+	f.response = nil
 	r.inflightFetchPool.Put(f)
 }
 
@@ -1632,6 +1632,155 @@ func TestResolver_ResolveGraphQLSubscription(t *testing.T) {
 	assert.Equal(t, `{"data":{"counter":2}}`, out.flushed[2])
 }
 
+type TerminatingFakeStream struct {
+	err error
+}
+
+func (f *TerminatingFakeStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	_ = f.StartWithError(input, next, stop)
+}
+
+func (f *TerminatingFakeStream) StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error {
+	next <- []byte(`{"counter":0}`)
+	return f.err
+}
+
+func (f *TerminatingFakeStream) UniqueIdentifier() []byte {
+	return []byte("terminating_fake")
+}
+
+func TestResolver_ResolveGraphQLSubscription_Terminated(t *testing.T) {
+	run := func(terminalErr error, expectedResponses ...string) func(t *testing.T) {
+		return func(t *testing.T) {
+			resolver := New()
+			man := subscription.NewManager(&TerminatingFakeStream{
+				err: terminalErr,
+			})
+			manCtx, cancelMan := context.WithCancel(context.Background())
+			defer cancelMan()
+			man.Run(manCtx.Done())
+			resolver.RegisterTriggerManager(man)
+			plan := &GraphQLSubscription{
+				Trigger: GraphQLSubscriptionTrigger{
+					ManagerID: []byte("terminating_fake"),
+				},
+				Response: &GraphQLResponse{
+					Data: &Object{
+						Fields: []*Field{
+							{
+								Name: []byte("counter"),
+								Value: &Integer{
+									Path: []string{"counter"},
+								},
+							},
+						},
+					},
+				},
+			}
+			ctx := Context{
+				Context: context.Background(),
+			}
+			out := &TestFlushWriter{
+				buf: bytes.Buffer{},
+			}
+			err := resolver.ResolveGraphQLSubscription(&ctx, plan, out)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedResponses, out.flushed)
+		}
+	}
+
+	t.Run("upstream complete", run(nil,
+		`{"data":{"counter":0}}`,
+	))
+	t.Run("upstream errors", run(&subscription.UpstreamError{Errors: []byte(`[{"message":"unauthorized"}]`)},
+		`{"data":{"counter":0}}`,
+		`{"errors":[{"message":"unauthorized"}]}`,
+	))
+	t.Run("connection lost", run(subscription.ErrConnectionLost,
+		`{"data":{"counter":0}}`,
+		`{"errors":[{"message":"upstream connection lost"}]}`,
+	))
+}
+
+type SequenceFakeStream struct {
+	results []string
+}
+
+func (f *SequenceFakeStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	_ = f.StartWithError(input, next, stop)
+}
+
+func (f *SequenceFakeStream) StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error {
+	for i := range f.results {
+		select {
+		case next <- []byte(f.results[i]):
+		case <-stop:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *SequenceFakeStream) UniqueIdentifier() []byte {
+	return []byte("sequence_fake")
+}
+
+func TestResolver_ResolveGraphQLSubscription_Filter(t *testing.T) {
+	resolver := New()
+	man := subscription.NewManager(&SequenceFakeStream{
+		results: []string{
+			`{"counter":0,"customerId":"1"}`,
+			`{"counter":1,"customerId":"2"}`,
+			`{"counter":2,"customerId":"1"}`,
+		},
+	})
+	manCtx, cancelMan := context.WithCancel(context.Background())
+	defer cancelMan()
+	man.Run(manCtx.Done())
+	resolver.RegisterTriggerManager(man)
+	plan := &GraphQLSubscription{
+		Trigger: GraphQLSubscriptionTrigger{
+			ManagerID: []byte("sequence_fake"),
+			Filters: []SubscriptionFilter{
+				{
+					FieldPath: []string{"customerId"},
+					ValueTemplate: InputTemplate{
+						Segments: []TemplateSegment{
+							{
+								SegmentType:        VariableSegmentType,
+								VariableSource:     VariableSourceContext,
+								VariableSourcePath: []string{"customerId"},
+							},
+						},
+					},
+				},
+			},
+		},
+		Response: &GraphQLResponse{
+			Data: &Object{
+				Fields: []*Field{
+					{
+						Name: []byte("counter"),
+						Value: &Integer{
+							Path: []string{"counter"},
+						},
+					},
+				},
+			},
+		},
+	}
+	ctx := Context{
+		Context:   context.Background(),
+		Variables: []byte(`{"customerId":"1"}`),
+	}
+	out := &TestFlushWriter{
+		buf: bytes.Buffer{},
+	}
+	err := resolver.ResolveGraphQLSubscription(&ctx, plan, out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"data":{"counter":0}}`, `{"data":{"counter":2}}`}, out.flushed)
+}
+
 func BenchmarkResolver_ResolveNode(b *testing.B) {
 
 	resolver := New()
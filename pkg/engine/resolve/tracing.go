@@ -0,0 +1,70 @@
+package resolve
+
+import (
+	"strings"
+
+	"github.com/buger/jsonparser"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
+	"github.com/jensneuse/graphql-go-tools/pkg/fastbuffer"
+)
+
+const fetchSpanName = "graphql.fetch"
+
+// Attributes of the spans of fetches
+const (
+	FetchAttributeDataSource   = "graphql.fetch.data_source"
+	FetchAttributeURL          = "graphql.fetch.url"
+	FetchAttributePath         = "graphql.fetch.path"
+	FetchAttributeRequestSize  = "graphql.fetch.request_size"
+	FetchAttributeResponseSize = "graphql.fetch.response_size"
+	FetchAttributeSingleFlight = "graphql.fetch.single_flight"
+	FetchAttributeErrors       = "graphql.fetch.upstream_errors"
+)
+
+// SetTracer enables a span per SingleFetch, the spans are children of the span of the context
+// The span is propagated to the data source with the context it loads with, e.g. to send the traceparent header upstream.
+func (c *Context) SetTracer(tracer tracing.Tracer) {
+	c.tracer = tracer
+}
+
+// traceSingleFetch resolves the fetch in a span
+func (r *Resolver) traceSingleFetch(ctx *Context, fetch *SingleFetch, preparedInput *fastbuffer.FastBuffer, buf *BufPair) error {
+	input := preparedInput.Bytes()
+	dataSourceID := fetch.DataSourceID
+	if dataSourceID == "" {
+		dataSourceID = string(fetch.DataSource.UniqueIdentifier())
+	}
+	attributes := []tracing.Attribute{
+		tracing.String(FetchAttributeDataSource, dataSourceID),
+		tracing.String(FetchAttributePath, strings.Join(ctx.fetchPath(), ".")),
+		tracing.Int(FetchAttributeRequestSize, len(input)),
+	}
+	if url, err := jsonparser.GetString(input, "url"); err == nil {
+		attributes = append(attributes, tracing.String(FetchAttributeURL, url))
+	}
+
+	spanCtx, span := ctx.tracer.Start(ctx.Context, fetchSpanName, attributes...)
+	defer span.End()
+
+	singleFlight, err := r.loadSingleFetch(ctx, spanCtx, fetch, preparedInput, buf)
+	span.SetAttributes(
+		tracing.Int(FetchAttributeResponseSize, buf.Data.Len()+buf.Errors.Len()),
+		tracing.Bool(FetchAttributeSingleFlight, singleFlight),
+		tracing.Bool(FetchAttributeErrors, buf.HasErrors()),
+	)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return ctx.applyErrorPolicy(fetch, buf, err)
+}
+
+// traceTriggerInput adds the traceparent of the context to the input of a subscription trigger
+func traceTriggerInput(ctx *Context, input []byte) []byte {
+	if traceparent := tracing.Traceparent(ctx.Context); traceparent != "" {
+		return subscription.SetInputTraceparent(input, traceparent)
+	}
+	return input
+}
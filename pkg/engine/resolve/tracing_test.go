@@ -0,0 +1,134 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
+)
+
+// _tracedDataSource records the traceparent it loads with
+type _tracedDataSource struct {
+	data        string
+	err         error
+	traceparent string
+}
+
+func (s *_tracedDataSource) UniqueIdentifier() []byte {
+	return []byte("traced")
+}
+
+func (s *_tracedDataSource) Load(ctx context.Context, input []byte, pair *BufPair) (err error) {
+	s.traceparent = tracing.Traceparent(ctx)
+	if s.err != nil {
+		return s.err
+	}
+	pair.Data.WriteString(s.data)
+	return
+}
+
+func TestResolver_Tracing(t *testing.T) {
+	response := func(dataSource DataSource) *GraphQLResponse {
+		return &GraphQLResponse{
+			Data: &Object{
+				Fetch: &SingleFetch{
+					BufferId:   0,
+					DataSource: dataSource,
+					InputTemplate: InputTemplate{
+						Segments: []TemplateSegment{
+							{
+								SegmentType: StaticSegmentType,
+								Data:        []byte(`{"method":"POST","url":"https://users.service"}`),
+							},
+						},
+					},
+				},
+				Fields: []*Field{
+					{
+						BufferID:  0,
+						HasBuffer: true,
+						Name:      []byte("name"),
+						Value: &String{
+							Path:     []string{"name"},
+							Nullable: true,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	resolveResponse := func(t *testing.T, response *GraphQLResponse) (*tracing.InMemoryExporter, tracing.Span, error) {
+		exporter := tracing.NewInMemoryExporter()
+		parentCtx, parent := exporter.Start(context.Background(), "graphql.resolve")
+		defer parent.End()
+
+		ctx := NewContext(parentCtx)
+		ctx.SetTracer(exporter)
+		err := New().ResolveGraphQLResponse(ctx, response, nil, &bytes.Buffer{})
+		return exporter, parent, err
+	}
+
+	resolve := func(t *testing.T, dataSource DataSource) (*tracing.InMemoryExporter, tracing.Span, error) {
+		return resolveResponse(t, response(dataSource))
+	}
+
+	t.Run("should trace fetches as children of the span of the context", func(t *testing.T) {
+		dataSource := &_tracedDataSource{data: `{"name":"Jens"}`}
+		exporter, parent, err := resolve(t, dataSource)
+		require.NoError(t, err)
+
+		spans := exporter.SpansByName("graphql.fetch")
+		require.Len(t, spans, 1)
+		assert.Equal(t, parent.SpanContext(), spans[0].Parent)
+		assert.Equal(t, spans[0].SpanContext.Traceparent(), dataSource.traceparent)
+		assert.Equal(t, map[string]interface{}{
+			FetchAttributeDataSource:   "traced",
+			FetchAttributeURL:          "https://users.service",
+			FetchAttributePath:         "",
+			FetchAttributeRequestSize:  47,
+			FetchAttributeResponseSize: 15,
+			FetchAttributeSingleFlight: false,
+			FetchAttributeErrors:       false,
+		}, spans[0].Attributes)
+		assert.Empty(t, spans[0].Errors)
+	})
+
+	t.Run("should trace fetches with the ID of the data source configuration", func(t *testing.T) {
+		res := response(&_tracedDataSource{data: `{"name":"Jens"}`})
+		res.Data.(*Object).Fetch.(*SingleFetch).DataSourceID = "users"
+		exporter, _, err := resolveResponse(t, res)
+		require.NoError(t, err)
+
+		spans := exporter.SpansByName("graphql.fetch")
+		require.Len(t, spans, 1)
+		assert.Equal(t, "users", spans[0].Attributes[FetchAttributeDataSource])
+	})
+
+	t.Run("should record errors of data sources", func(t *testing.T) {
+		loadErr := errors.New("connection refused")
+		exporter, _, err := resolve(t, &_tracedDataSource{err: loadErr})
+		assert.Equal(t, loadErr, err)
+
+		spans := exporter.SpansByName("graphql.fetch")
+		require.Len(t, spans, 1)
+		assert.Equal(t, []error{loadErr}, spans[0].Errors)
+	})
+
+	t.Run("should add the traceparent to subscription trigger inputs", func(t *testing.T) {
+		exporter := tracing.NewInMemoryExporter()
+		spanCtx, span := exporter.Start(context.Background(), "graphql.resolve")
+		defer span.End()
+
+		input := []byte(`{"url":"wss://users.service"}`)
+		assert.Equal(t, input, traceTriggerInput(NewContext(context.Background()), input))
+		traced := traceTriggerInput(NewContext(spanCtx), input)
+		assert.Equal(t, span.SpanContext().Traceparent(), subscription.InputTraceparent(traced))
+	})
+}
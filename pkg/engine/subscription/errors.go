@@ -0,0 +1,24 @@
+package subscription
+
+import (
+	"errors"
+)
+
+var (
+	// ErrConnectionLost terminates all subscriptions of an upstream connection which got closed unexpectedly
+	ErrConnectionLost = errors.New("upstream connection lost")
+	// ErrSlowConsumer terminates subscribers which can't keep up with the stream if SlowConsumerPolicyDisconnect is used
+	ErrSlowConsumer = errors.New("subscriber too slow")
+	// ErrShuttingDown terminates triggers started while the Manager is shutting down
+	ErrShuttingDown = errors.New("subscription manager is shutting down")
+)
+
+// UpstreamError terminates a subscription with the GraphQL errors sent by the upstream
+type UpstreamError struct {
+	// Errors is the JSON array of GraphQL errors
+	Errors []byte
+}
+
+func (e *UpstreamError) Error() string {
+	return "upstream error: " + string(e.Errors)
+}
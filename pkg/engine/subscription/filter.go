@@ -0,0 +1,44 @@
+package subscription
+
+import (
+	"bytes"
+
+	"github.com/buger/jsonparser"
+)
+
+// Filter decides if a result of the stream gets delivered to a trigger
+type Filter func(result []byte) bool
+
+// FieldEquals returns a Filter matching all results with the value at path equal to value
+// String values are compared without quotes, so the string "1" equals the number 1.
+// If the value at path is an array, the result matches if any of its items equals value.
+func FieldEquals(path []string, value []byte) Filter {
+	return func(result []byte) bool {
+		fieldValue, dataType, _, err := jsonparser.Get(result, path...)
+		if err != nil {
+			return false
+		}
+		if dataType != jsonparser.Array {
+			return bytes.Equal(fieldValue, value)
+		}
+		matches := false
+		_, _ = jsonparser.ArrayEach(fieldValue, func(item []byte, _ jsonparser.ValueType, _ int, _ error) {
+			if !matches && bytes.Equal(item, value) {
+				matches = true
+			}
+		})
+		return matches
+	}
+}
+
+// All returns a Filter matching all results which match every filter
+func All(filters ...Filter) Filter {
+	return func(result []byte) bool {
+		for i := range filters {
+			if !filters[i](result) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -1,19 +1,88 @@
 package graphql_websocket_subscription
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
 
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 )
 
 var (
 	uniqueIdentifier = []byte("graphql_websocket_subscription")
 )
 
+func SetInputProtocol(input []byte, protocol string) []byte {
+	out, _ := sjson.SetBytes(input, "protocol", protocol)
+	return out
+}
+
+func SetInputConnectionInitPayload(input, payload []byte) []byte {
+	out, _ := sjson.SetRawBytes(input, "connection_init_payload", payload)
+	return out
+}
+
+// parseHeader parses a JSON object with string or string array values, e.g. {"Authorization":["Bearer token"]}
+func parseHeader(rawHeader []byte) http.Header {
+	if len(rawHeader) == 0 {
+		return nil
+	}
+	header := http.Header{}
+	_ = jsonparser.ObjectEach(rawHeader, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		name := http.CanonicalHeaderKey(string(key))
+		if dataType == jsonparser.String {
+			parsed, err := jsonparser.ParseString(value)
+			if err == nil {
+				header[name] = append(header[name], parsed)
+			}
+			return nil
+		}
+		_, _ = jsonparser.ArrayEach(value, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			parsed, err := jsonparser.ParseString(value)
+			if err == nil {
+				header[name] = append(header[name], parsed)
+			}
+		})
+		return nil
+	})
+	return header
+}
+
+// mergeConnectionInitPayload adds the forwarded headers as fields to the connection_init payload, e.g. {"Authorization":"Bearer token"}
+// Fields of the configured payload take precedence.
+func mergeConnectionInitPayload(payload []byte, forwarded http.Header) []byte {
+	if len(payload) == 0 {
+		payload = []byte(`{}`)
+	}
+	// don't modify the input the payload is part of
+	payload = append([]byte(nil), payload...)
+	names := make([]string, 0, len(forwarded))
+	for name := range forwarded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, _, _, err := jsonparser.Get(payload, name); err == nil {
+			continue
+		}
+		value, err := json.Marshal(strings.Join(forwarded[name], ", "))
+		if err != nil {
+			continue
+		}
+		payload, _ = jsonparser.Set(payload, value, name)
+	}
+	return payload
+}
+
 type Config struct {
 	Scheme string
 	Host   string
@@ -23,76 +92,144 @@ type Config struct {
 }
 
 type GraphQLWebsocketSubscriptionStream struct {
-	wsClients    map[string]*WebsocketClient
-	wsClientsMux sync.Mutex
+	wsClients        map[string]*WebsocketClient
+	wsClientsMux     sync.Mutex
+	shuttingDown     bool
+	reconnect        ReconnectConfiguration
+	keepAliveTimeout time.Duration
+	onReconnect      func(event ReconnectEvent)
+}
+
+type Option func(g *GraphQLWebsocketSubscriptionStream)
+
+// WithReconnect makes the upstream connections reconnect and resubscribe after they got lost
+func WithReconnect(config ReconnectConfiguration) Option {
+	return func(g *GraphQLWebsocketSubscriptionStream) {
+		g.reconnect = config
+	}
+}
+
+// WithKeepAliveTimeout treats upstream connections as lost which didn't receive any message within the timeout
+func WithKeepAliveTimeout(timeout time.Duration) Option {
+	return func(g *GraphQLWebsocketSubscriptionStream) {
+		g.keepAliveTimeout = timeout
+	}
+}
+
+// WithOnReconnect registers a hook which gets called after every reconnect attempt of an upstream connection
+func WithOnReconnect(hook func(event ReconnectEvent)) Option {
+	return func(g *GraphQLWebsocketSubscriptionStream) {
+		g.onReconnect = hook
+	}
 }
 
-func New() *GraphQLWebsocketSubscriptionStream {
-	return &GraphQLWebsocketSubscriptionStream{
+func New(options ...Option) *GraphQLWebsocketSubscriptionStream {
+	stream := &GraphQLWebsocketSubscriptionStream{
 		wsClients: map[string]*WebsocketClient{},
 	}
+	for i := range options {
+		options[i](stream)
+	}
+	return stream
 }
 
 func (g *GraphQLWebsocketSubscriptionStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	_ = g.StartWithError(input, next, stop)
+}
+
+// StartWithError starts the upstream subscription and returns when it ends
+// It returns nil if the upstream completed the subscription, an *subscription.UpstreamError for upstream errors
+// and subscription.ErrConnectionLost if the upstream connection could not be established or got lost.
+func (g *GraphQLWebsocketSubscriptionStream) StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error {
 
 	rawURL, rawHeader, body := httpclient.GetSubscriptionInput(input)
+	header := parseHeader(rawHeader)
 
-	var (
-		header http.Header
-	)
+	url := string(rawURL)
+	protocol, _ := jsonparser.GetString(input, "protocol")
+	connectionInitPayload, _, _, _ := jsonparser.Get(input, "connection_init_payload")
 
-	if len(rawHeader) != 0 {
-		header = map[string][]string{}
-		_ = jsonparser.ObjectEach(rawHeader, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
-			header[string(key)] = []string{string(value)}
-			return nil
-		})
+	// forwarded client request headers are sent with the handshake and the connection_init payload
+	forwardedHeader, _, _, _ := jsonparser.Get(input, httpclient.FORWARDEDHEADER)
+	if forwarded := parseHeader(forwardedHeader); len(forwarded) != 0 {
+		if header == nil {
+			header = http.Header{}
+		}
+		for key := range forwarded {
+			if _, ok := header[key]; !ok {
+				header[key] = forwarded[key]
+			}
+		}
+		connectionInitPayload = mergeConnectionInitPayload(connectionInitPayload, forwarded)
 	}
 
-	url := string(rawURL)
+	// the handshake of a new connection continues the trace of the subscription opening it
+	if traceparent := subscription.InputTraceparent(input); traceparent != "" {
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set(tracing.TraceparentHeader, traceparent)
+	}
+
+	// connections can only be shared by subscriptions with the same protocol, forwarded headers and connection_init payload
+	clientKey := url + protocol + string(forwardedHeader) + string(connectionInitPayload)
 
 	g.wsClientsMux.Lock()
-	client, ok := g.wsClients[url]
-	if !ok {
-		client = &WebsocketClient{}
+	if g.shuttingDown {
+		g.wsClientsMux.Unlock()
+		return subscription.ErrShuttingDown
+	}
+	client, ok := g.wsClients[clientKey]
+	if !ok || client.Closed() {
+		client = &WebsocketClient{
+			Protocol:              protocol,
+			ConnectionInitPayload: connectionInitPayload,
+			Reconnect:             g.reconnect,
+			KeepAliveTimeout:      g.keepAliveTimeout,
+			OnReconnect:           g.onReconnect,
+		}
 		err := client.Open(url, header)
 		if err != nil {
 			g.wsClientsMux.Unlock()
-			return
+			return subscription.ErrConnectionLost
 		}
-		g.wsClients[url] = client
+		g.wsClients[clientKey] = client
 	}
 	g.wsClientsMux.Unlock()
 
 	defer func() {
 		g.wsClientsMux.Lock()
-		client, ok := g.wsClients[url]
-		if ok {
+		current, ok := g.wsClients[clientKey]
+		if ok && current == client {
 			closed := client.CloseIfNoSubscriptions()
 			if closed {
-				delete(g.wsClients, url)
+				delete(g.wsClients, clientKey)
 			}
 		}
 		g.wsClientsMux.Unlock()
 	}()
 
-	subscription, ok := client.Subscribe(body)
+	upstream, ok := client.Subscribe(body)
 	if !ok {
-		return
+		if client.isShuttingDown() {
+			return subscription.ErrShuttingDown
+		}
+		return subscription.ErrConnectionLost
 	}
 
 	defer func() {
-		client.Unsubscribe(subscription)
+		client.Unsubscribe(upstream)
 	}()
 
 	for {
 		select {
 		case <-stop:
-			return
+			return nil
 		default:
-			data, ok := subscription.Next(stop)
+			data, ok := upstream.Next(stop)
 			if !ok {
-				return
+				_, err := upstream.Terminated()
+				return err
 			}
 			content, _, _, err := jsonparser.Get(data, "data")
 			if err != nil || len(content) == 0 {
@@ -101,12 +238,30 @@ func (g *GraphQLWebsocketSubscriptionStream) Start(input []byte, next chan<- []b
 			select {
 			case next <- content:
 			case <-stop:
-				return
+				return nil
 			}
 		}
 	}
 }
 
+// Shutdown closes all upstream connections gracefully, new subscriptions get terminated with subscription.ErrShuttingDown
+func (g *GraphQLWebsocketSubscriptionStream) Shutdown(ctx context.Context) error {
+	g.wsClientsMux.Lock()
+	g.shuttingDown = true
+	clients := g.wsClients
+	g.wsClients = map[string]*WebsocketClient{}
+	g.wsClientsMux.Unlock()
+
+	var shutdownErr error
+	for _, client := range clients {
+		err := client.Shutdown(ctx)
+		if err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
+
 func (g *GraphQLWebsocketSubscriptionStream) UniqueIdentifier() []byte {
 	return uniqueIdentifier
 }
@@ -3,10 +3,14 @@ package graphql_websocket_subscription
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/buger/jsonparser"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/atomic"
 
@@ -27,7 +31,7 @@ func TestGraphQLWebsocketSubscriptionStream(t *testing.T) {
 
 		manager.Run(ctx.Done())
 
-		input := fmt.Sprintf(`{"scheme":"ws","host":"%s","path":"","body":{"query":"subscription{counter{count}}","variables":{}}}`, host)
+		input := fmt.Sprintf(`{"url":"ws://%s","body":{"query":"subscription{counter{count}}","variables":{}}}`, host)
 
 		totalMessages := atomic.NewInt64(0)
 
@@ -103,3 +107,67 @@ func TestGraphQLWebsocketSubscriptionStream(t *testing.T) {
 	<-time.After(time.Second)
 	t.SkipNow()
 }
+
+func TestForwardedHeader(t *testing.T) {
+	t.Run("parse header", func(t *testing.T) {
+		assert.Nil(t, parseHeader(nil))
+		assert.Equal(t, http.Header{
+			"Authorization": {`Bearer "token"`},
+			"X-Values":      {"a", "b"},
+		}, parseHeader([]byte(`{"authorization":"Bearer \"token\"","X-Values":["a","b"]}`)))
+	})
+	t.Run("merge connection_init payload", func(t *testing.T) {
+		forwarded := http.Header{"Authorization": {"Bearer token"}, "X-Values": {"a", "b"}}
+		assert.Equal(t, `{"Authorization":"Bearer token","X-Values":"a, b"}`, string(mergeConnectionInitPayload(nil, forwarded)))
+
+		payload := []byte(`{"Authorization":"configured"}`)
+		assert.Equal(t, `{"Authorization":"configured","X-Values":"a, b"}`, string(mergeConnectionInitPayload(payload, forwarded)))
+		assert.Equal(t, `{"Authorization":"configured"}`, string(payload))
+	})
+}
+
+func TestGraphQLWebsocketSubscriptionStream_Traceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, traceparent, r.Header.Get("traceparent"))
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _, _ = c.ReadMessage()
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		id, _ := jsonparser.GetString(message, "id")
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"data","id":"`+id+`","payload":{"data":{"counter":{"count":0}}}}`))
+		_, _, _ = c.ReadMessage()
+	}))
+	defer server.Close()
+
+	input := fmt.Sprintf(`{"url":"ws://%s","body":{"query":"subscription{counter{count}}"}}`, server.Listener.Addr().String())
+	input = string(subscription.SetInputTraceparent([]byte(input), traceparent))
+
+	stream := New()
+	next := make(chan []byte)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- stream.StartWithError([]byte(input), next, stop)
+	}()
+
+	select {
+	case data := <-next:
+		assert.Equal(t, `{"counter":{"count":0}}`, string(data))
+	case err := <-done:
+		t.Fatalf("subscription ended: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("subscription didn't receive data")
+	}
+	close(stop)
+	assert.NoError(t, <-done)
+	assert.NoError(t, stream.Shutdown(context.Background()))
+}
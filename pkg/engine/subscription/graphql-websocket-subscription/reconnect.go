@@ -0,0 +1,68 @@
+package graphql_websocket_subscription
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultReconnectInitialInterval = 500 * time.Millisecond
+	DefaultReconnectMaxInterval     = 30 * time.Second
+	DefaultReconnectMultiplier      = 2
+)
+
+// ReconnectConfiguration defines how a WebsocketClient reconnects after the upstream connection got lost
+// Active subscriptions are re-sent with their original payloads after reconnecting.
+type ReconnectConfiguration struct {
+	// MaxAttempts is the number of reconnect attempts before all subscriptions get terminated, zero disables reconnecting
+	MaxAttempts int
+	// InitialInterval is the wait time before the first attempt, defaults to DefaultReconnectInitialInterval
+	InitialInterval time.Duration
+	// MaxInterval caps the wait time between attempts, defaults to DefaultReconnectMaxInterval
+	MaxInterval time.Duration
+	// Multiplier grows the wait time after each attempt, defaults to DefaultReconnectMultiplier
+	Multiplier float64
+	// Jitter randomizes the wait time by up to the given fraction, e.g. 0.2 for +/- 20%
+	Jitter float64
+}
+
+// backoff returns the wait time before the given attempt, starting at 1
+func (c ReconnectConfiguration) backoff(attempt int) time.Duration {
+	initialInterval := c.InitialInterval
+	if initialInterval <= 0 {
+		initialInterval = DefaultReconnectInitialInterval
+	}
+	maxInterval := c.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultReconnectMaxInterval
+	}
+	multiplier := c.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultReconnectMultiplier
+	}
+
+	interval := float64(initialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if interval > float64(maxInterval) {
+		interval = float64(maxInterval)
+	}
+	if c.Jitter > 0 {
+		interval += interval * c.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(interval)
+}
+
+// ReconnectEvent is passed to the OnReconnect hook of a WebsocketClient after every reconnect attempt
+type ReconnectEvent struct {
+	URL string
+	// Attempt is the number of the attempt, starting at 1
+	Attempt int
+	// Cause is the reason the connection got lost
+	Cause error
+	// Err is the error of the attempt, nil if it succeeded
+	Err error
+	// Reconnected is true if the attempt succeeded
+	Reconnected bool
+	// GaveUp is true if the last attempt failed and all subscriptions got terminated
+	GaveUp bool
+}
@@ -2,38 +2,78 @@ package graphql_websocket_subscription
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/gorilla/websocket"
 	byte_template "github.com/jensneuse/byte-template"
 
 	"github.com/jensneuse/graphql-go-tools/internal/pkg/unsafebytes"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
 	"github.com/jensneuse/graphql-go-tools/pkg/pool"
 )
 
+const (
+	// ProtocolGraphQLWS is the legacy subscriptions-transport-ws protocol
+	ProtocolGraphQLWS = "graphql-ws"
+	// ProtocolGraphQLTransportWS is the graphql-ws protocol
+	ProtocolGraphQLTransportWS = "graphql-transport-ws"
+)
+
 var (
 	defaultHeader = http.Header{
-		"Sec-WebSocket-Protocol": []string{"graphql-ws"},
-		"Sec-WebSocket-Version":  []string{"13"},
+		"Sec-WebSocket-Version": []string{"13"},
 	}
 
 	connectionInitMessage = []byte(`{"type":"connection_init"}`)
+	connectionInitPayload = []byte(`{"type":"connection_init","payload":{{ .payload }}}`)
 	startMessage          = []byte(`{"type":"start","id":"{{ .id }}","payload":{{ .payload }}}`)
 	stopMessage           = []byte(`{"type":"stop","id":"{{ .id }}"}`)
+	subscribeMessage      = []byte(`{"type":"subscribe","id":"{{ .id }}","payload":{{ .payload }}}`)
+	completeMessage       = []byte(`{"type":"complete","id":"{{ .id }}"}`)
+	pongMessage           = []byte(`{"type":"pong"}`)
+	pingMessage           = []byte(`{"type":"ping"}`)
+
+	errShuttingDown = errors.New("websocket client is shutting down")
 )
 
 type WebsocketClient struct {
+	// Protocol is the subprotocol to use, if empty both protocols are offered and the upstream decides
+	Protocol string
+	// ConnectionInitPayload is sent as the payload of the connection_init message, e.g. to authenticate
+	ConnectionInitPayload []byte
+	// Reconnect defines if and how the client reconnects after the upstream connection got lost
+	Reconnect ReconnectConfiguration
+	// KeepAliveTimeout treats connections as lost which didn't receive any message within the timeout, zero disables it
+	// graphql-transport-ws upstreams get pinged twice per timeout because they don't send keep alive messages on their own.
+	KeepAliveTimeout time.Duration
+	// OnReconnect is called after every reconnect attempt, e.g. to alert on flapping upstreams
+	OnReconnect func(event ReconnectEvent)
+
+	url                    string
+	header                 http.Header
 	conn                   *websocket.Conn
+	writeMux               sync.Mutex
 	done                   chan struct{}
+	closeOnce              sync.Once
+	shuttingDown           chan struct{}
+	shutdownOnce           sync.Once
 	tmpl                   *byte_template.Template
+	tmplMux                sync.Mutex
 	addSubscription        chan addSubscriptionCmd
 	removeSubscription     chan removeSubscriptionCmd
 	subscriptions          map[uint64]Subscription
+	subscriptionsMux       sync.RWMutex
+	lastSubscriptionID     uint64
 	closeIfNoSubscriptions chan chan bool
 }
 
@@ -44,21 +84,18 @@ func (w *WebsocketClient) Open(url string, header http.Header) (err error) {
 	w.subscriptions = map[uint64]Subscription{}
 	w.closeIfNoSubscriptions = make(chan chan bool)
 	w.done = make(chan struct{})
+	w.shuttingDown = make(chan struct{})
 
-	if header != nil && len(header) != 0 {
-		for key := range defaultHeader {
-			header[key] = defaultHeader[key]
-		}
-	} else {
-		header = defaultHeader
+	w.url = url
+	w.header = http.Header{}
+	for key := range header {
+		w.header[key] = header[key]
 	}
-
-	w.conn, _, err = websocket.DefaultDialer.Dial(url, header)
-	if err != nil {
-		return err
+	for key := range defaultHeader {
+		w.header[key] = defaultHeader[key]
 	}
 
-	err = w.connectionInit()
+	w.conn, w.Protocol, err = w.dial()
 	if err != nil {
 		return err
 	}
@@ -68,38 +105,173 @@ func (w *WebsocketClient) Open(url string, header http.Header) (err error) {
 	return
 }
 
+// dial opens and initialises a connection to the upstream and returns it with the negotiated protocol
+func (w *WebsocketClient) dial() (conn *websocket.Conn, protocol string, err error) {
+	requestHeader := http.Header{}
+	for key := range w.header {
+		requestHeader[key] = w.header[key]
+	}
+
+	switch w.Protocol {
+	case ProtocolGraphQLWS, ProtocolGraphQLTransportWS:
+		requestHeader.Set("Sec-WebSocket-Protocol", w.Protocol)
+	default:
+		requestHeader.Set("Sec-WebSocket-Protocol", ProtocolGraphQLTransportWS+", "+ProtocolGraphQLWS)
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(w.url, requestHeader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// upstreams not responding with a subprotocol are expected to speak the legacy protocol
+	protocol = conn.Subprotocol()
+	if protocol != ProtocolGraphQLTransportWS {
+		protocol = ProtocolGraphQLWS
+	}
+
+	err = w.connectionInit(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+
+	return conn, protocol, nil
+}
+
 func (w *WebsocketClient) Close() {
-	close(w.done)
-	_ = w.conn.Close()
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.writeMux.Lock()
+		_ = w.conn.Close()
+		w.writeMux.Unlock()
+	})
+}
+
+// Closed returns true if the client was closed, e.g. because the upstream connection got lost
+func (w *WebsocketClient) Closed() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown completes all subscriptions, stops them at the upstream and closes the connection with a close frame
+// It waits until the upstream acknowledged the close frame or the context is done, then the connection gets closed.
+// Subscribe fails once Shutdown got called.
+func (w *WebsocketClient) Shutdown(ctx context.Context) error {
+	w.shutdownOnce.Do(func() {
+		close(w.shuttingDown)
+	})
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.Close()
+		return ctx.Err()
+	}
+}
+
+func (w *WebsocketClient) isShuttingDown() bool {
+	select {
+	case <-w.shuttingDown:
+		return true
+	default:
+		return false
+	}
 }
 
 func (w *WebsocketClient) CloseIfNoSubscriptions() (closed bool) {
 	closedChan := make(chan bool)
-	w.closeIfNoSubscriptions <- closedChan
+	select {
+	case <-w.done:
+		return true
+	case w.closeIfNoSubscriptions <- closedChan:
+	}
 	return <-closedChan
 }
 
-func (w *WebsocketClient) connectionInit() (err error) {
-	err = w.conn.WriteMessage(websocket.TextMessage, connectionInitMessage)
-	if err != nil {
-		return err
+func (w *WebsocketClient) connectionInit(conn *websocket.Conn) (err error) {
+	message := connectionInitMessage
+	if len(w.ConnectionInitPayload) != 0 {
+		message, err = w.renderMessage(connectionInitPayload, "", w.ConnectionInitPayload)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, connectionAckMessage, err := w.conn.ReadMessage()
+	err = conn.WriteMessage(websocket.TextMessage, message)
 	if err != nil {
 		return err
 	}
 
-	connectionAck, err := jsonparser.GetString(connectionAckMessage, "type")
-	if err != nil {
-		return err
+	for {
+		w.setReadDeadline(conn)
+		_, connectionAckMessage, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		messageType, err := jsonparser.GetString(connectionAckMessage, "type")
+		if err != nil {
+			return err
+		}
+
+		switch messageType {
+		case "connection_ack":
+			return nil
+		case "ka":
+			continue
+		case "ping":
+			if err = conn.WriteMessage(websocket.TextMessage, pongMessage); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("ws connection_init not acked")
+		}
 	}
+}
 
-	if connectionAck != "connection_ack" {
-		return fmt.Errorf("ws connection_init not acked")
+// write serializes writes because the message handling loop answers pings concurrently to the control flow
+func (w *WebsocketClient) write(message []byte) error {
+	w.writeMux.Lock()
+	defer w.writeMux.Unlock()
+	return w.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+func (w *WebsocketClient) setReadDeadline(conn *websocket.Conn) {
+	if w.KeepAliveTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(w.KeepAliveTimeout))
 	}
+}
 
-	return nil
+func (w *WebsocketClient) renderMessage(template []byte, id string, payload []byte) ([]byte, error) {
+	w.tmplMux.Lock()
+	defer w.tmplMux.Unlock()
+
+	buf := pool.BytesBuffer.Get()
+	defer pool.BytesBuffer.Put(buf)
+
+	_, err := w.tmpl.Execute(buf, template, func(w io.Writer, path []byte) (n int, err error) {
+		if bytes.Equal(path, []byte(".id")) {
+			return w.Write(unsafebytes.StringToBytes(id))
+		}
+		if bytes.Equal(path, []byte(".payload")) {
+			return w.Write(payload)
+		}
+		return 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, buf.Len())
+	copy(message, buf.Bytes())
+	return message, nil
 }
 
 func (w *WebsocketClient) run() {
@@ -110,15 +282,41 @@ func (w *WebsocketClient) run() {
 			case <-w.done:
 				return
 			default:
-				w.handleNextMessage()
+				err := w.handleNextMessage()
+				if err == nil {
+					continue
+				}
+				if w.isShuttingDown() {
+					// the upstream acknowledged the close frame
+					w.Close()
+					return
+				}
+				if lost, ok := err.(*connectionLostError); ok {
+					if w.reconnect(lost.cause) {
+						continue
+					}
+					err = subscription.ErrConnectionLost
+				}
+				w.terminateAll(err)
+				w.Close()
+				return
 			}
 		}
 	}()
 
+	if w.KeepAliveTimeout > 0 && w.Protocol == ProtocolGraphQLTransportWS {
+		go w.ping()
+	}
+
+	shuttingDown := w.shuttingDown
 	for {
 		select {
 		case <-w.done:
 			return
+		case <-shuttingDown:
+			// handle the shutdown only once
+			shuttingDown = nil
+			w.handleShutdown()
 		case add := <-w.addSubscription:
 			w.handleAdd(add)
 		case remove := <-w.removeSubscription:
@@ -133,46 +331,130 @@ func (w *WebsocketClient) run() {
 	}
 }
 
-func (w *WebsocketClient) handleNextMessage() {
+// handleNextMessage reads and dispatches the next upstream message
+// An error is returned if the connection is unusable, it terminates all subscriptions.
+func (w *WebsocketClient) handleNextMessage() error {
+	w.setReadDeadline(w.conn)
 	_, data, err := w.conn.ReadMessage()
 	if err != nil {
-		return
+		if w.Closed() {
+			return nil
+		}
+		return &connectionLostError{cause: err}
 	}
 
-	payload, _, _, err := jsonparser.Get(data, "payload")
+	messageType, err := jsonparser.GetString(data, "type")
 	if err != nil {
-		return
+		return nil
 	}
 
-	id, err := jsonparser.GetString(data, "id")
-	if err != nil {
-		return
+	switch messageType {
+	case "data", "next":
+		payload, _, _, err := jsonparser.Get(data, "payload")
+		if err != nil {
+			return nil
+		}
+		sub, ok := w.subscription(data)
+		if !ok {
+			return nil
+		}
+		select {
+		case sub.next <- payload:
+		case <-sub.unsubscribe:
+		}
+	case "error":
+		sub, ok := w.subscription(data)
+		if !ok {
+			return nil
+		}
+		sub.terminate(&subscription.UpstreamError{Errors: upstreamErrors(data)})
+	case "complete":
+		sub, ok := w.subscription(data)
+		if !ok {
+			return nil
+		}
+		sub.terminate(nil)
+	case "connection_error":
+		return &subscription.UpstreamError{Errors: upstreamErrors(data)}
+	case "ping":
+		_ = w.write(pongMessage)
+	case "ka", "pong":
+	default:
+		if w.Protocol == ProtocolGraphQLTransportWS {
+			// graphql-transport-ws doesn't allow unknown messages
+			return fmt.Errorf("unexpected message type from upstream: %s", messageType)
+		}
 	}
 
-	messageType, err := jsonparser.GetString(data, "type")
+	return nil
+}
+
+// subscription returns the subscription of the id of a message
+func (w *WebsocketClient) subscription(message []byte) (Subscription, bool) {
+	id, err := jsonparser.GetString(message, "id")
 	if err != nil {
-		return
+		return Subscription{}, false
 	}
+	w.subscriptionsMux.RLock()
+	defer w.subscriptionsMux.RUnlock()
+	sub, ok := w.subscriptions[uint64(unsafebytes.BytesToInt64(unsafebytes.StringToBytes(id)))]
+	return sub, ok
+}
 
-	switch messageType {
-	case "data":
-	case "complete":
-		return
+func (w *WebsocketClient) terminateAll(err error) {
+	w.subscriptionsMux.RLock()
+	defer w.subscriptionsMux.RUnlock()
+	for _, sub := range w.subscriptions {
+		sub.terminate(err)
+	}
+}
+
+// upstreamErrors returns the payload of an error message as JSON array of GraphQL errors
+// graphql-ws sends a single error object, graphql-transport-ws a list of errors.
+func upstreamErrors(message []byte) []byte {
+	payload, dataType, _, err := jsonparser.Get(message, "payload")
+	if err != nil {
+		return []byte(`[{"message":"upstream error"}]`)
+	}
+	switch dataType {
+	case jsonparser.Array:
+		return payload
+	case jsonparser.Object:
+		return append(append([]byte("["), payload...), ']')
+	case jsonparser.String:
+		errorMessage, _ := json.Marshal(string(payload))
+		return []byte(`[{"message":` + string(errorMessage) + `}]`)
 	default:
-		return
+		return []byte(`[{"message":"upstream error"}]`)
 	}
+}
 
-	sub, ok := w.subscriptions[uint64(unsafebytes.BytesToInt64(unsafebytes.StringToBytes(id)))]
-	if !ok {
-		return
+// handleShutdown completes all subscriptions and sends a close frame to the upstream
+func (w *WebsocketClient) handleShutdown() {
+	w.subscriptionsMux.Lock()
+	subscriptions := w.subscriptions
+	w.subscriptions = map[uint64]Subscription{}
+	w.subscriptionsMux.Unlock()
+
+	for id, sub := range subscriptions {
+		close(sub.stop)
+		sub.terminate(nil)
+		w.sendStop(id)
 	}
 
-	select {
-	case sub.next <- payload:
-	case <-sub.unsubscribe:
+	err := w.writeClose(websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		w.Close()
 	}
 }
 
+// writeClose writes a close frame
+func (w *WebsocketClient) writeClose(message []byte) error {
+	w.writeMux.Lock()
+	defer w.writeMux.Unlock()
+	return w.conn.WriteMessage(websocket.CloseMessage, message)
+}
+
 func (w *WebsocketClient) handleAdd(add addSubscriptionCmd) {
 	var (
 		err error
@@ -185,83 +467,189 @@ func (w *WebsocketClient) handleAdd(add addSubscriptionCmd) {
 		}
 	}()
 
-	id, err = w.nextSubscriptionID()
-	if err != nil {
+	if w.isShuttingDown() {
+		err = errShuttingDown
 		return
 	}
 
-	idStr := strconv.FormatUint(id, 10)
-
-	buf := pool.BytesBuffer.Get()
-	defer pool.BytesBuffer.Put(buf)
-
-	_, err = w.tmpl.Execute(buf, startMessage, func(w io.Writer, path []byte) (n int, err error) {
-		if bytes.Equal(path, []byte(".id")) {
-			return w.Write(unsafebytes.StringToBytes(idStr))
-		}
-		if bytes.Equal(path, []byte(".payload")) {
-			return w.Write(add.payload)
-		}
-		return 0, nil
-	})
+	id, err = w.nextSubscriptionID()
 	if err != nil {
 		return
 	}
 
-	message := buf.Bytes()
-
-	err = w.conn.WriteMessage(websocket.TextMessage, message)
+	message, err := w.renderMessage(w.subscribeTemplate(), strconv.FormatUint(id, 10), add.payload)
 	if err != nil {
 		return
 	}
 
-	subscription := Subscription{
+	sub := Subscription{
 		id:          id,
+		payload:     append([]byte(nil), add.payload...),
 		next:        make(chan []byte),
 		stop:        make(chan struct{}),
 		unsubscribe: make(chan struct{}),
+		terminal:    &terminal{done: make(chan struct{})},
+	}
+
+	// registering and sending must not interleave with re-sending all subscriptions after a reconnect
+	w.writeMux.Lock()
+	w.subscriptionsMux.Lock()
+	w.subscriptions[id] = sub
+	w.subscriptionsMux.Unlock()
+	err = w.conn.WriteMessage(websocket.TextMessage, message)
+	w.writeMux.Unlock()
+
+	if err != nil {
+		if w.Reconnect.MaxAttempts > 0 {
+			// the subscription gets sent again as soon as the connection is re-established
+			err = nil
+		} else {
+			w.subscriptionsMux.Lock()
+			delete(w.subscriptions, id)
+			w.subscriptionsMux.Unlock()
+			return
+		}
 	}
 
-	w.subscriptions[id] = subscription
-	add.getSubscription <- subscription
+	add.getSubscription <- sub
 }
 
-func (w *WebsocketClient) handleRemove(remove removeSubscriptionCmd) {
-	close(w.subscriptions[remove.id].stop)
-	delete(w.subscriptions, remove.id)
+func (w *WebsocketClient) subscribeTemplate() []byte {
+	if w.Protocol == ProtocolGraphQLTransportWS {
+		return subscribeMessage
+	}
+	return startMessage
+}
 
-	buf := pool.BytesBuffer.Get()
-	defer pool.BytesBuffer.Put(buf)
+// reconnect re-establishes a lost upstream connection according to the reconnect configuration
+// It returns false if all attempts failed or the client got closed.
+func (w *WebsocketClient) reconnect(cause error) bool {
+	for attempt := 1; attempt <= w.Reconnect.MaxAttempts; attempt++ {
+		select {
+		case <-w.done:
+			return false
+		case <-time.After(w.Reconnect.backoff(attempt)):
+		}
 
-	idStr := strconv.FormatUint(remove.id, 10)
+		conn, protocol, err := w.dial()
+		if err == nil && protocol != w.Protocol {
+			_ = conn.Close()
+			err = fmt.Errorf("upstream changed protocol from %s to %s", w.Protocol, protocol)
+		}
+		if err == nil {
+			err = w.resubscribe(conn)
+		}
 
-	_, err := w.tmpl.Execute(buf, stopMessage, func(w io.Writer, path []byte) (n int, err error) {
-		if bytes.Equal(path, []byte(".id")) {
-			return w.Write(unsafebytes.StringToBytes(idStr))
+		if w.OnReconnect != nil {
+			w.OnReconnect(ReconnectEvent{
+				URL:         w.url,
+				Attempt:     attempt,
+				Cause:       cause,
+				Err:         err,
+				Reconnected: err == nil,
+				GaveUp:      err != nil && attempt == w.Reconnect.MaxAttempts,
+			})
 		}
-		return 0, nil
-	})
-	if err != nil {
+
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resubscribe replaces the lost connection and re-sends all active subscriptions with their original payloads
+func (w *WebsocketClient) resubscribe(conn *websocket.Conn) error {
+	w.writeMux.Lock()
+	defer w.writeMux.Unlock()
+
+	if w.Closed() {
+		_ = conn.Close()
+		return fmt.Errorf("client closed")
+	}
+
+	_ = w.conn.Close()
+	w.conn = conn
+
+	w.subscriptionsMux.RLock()
+	defer w.subscriptionsMux.RUnlock()
+
+	for id, sub := range w.subscriptions {
+		message, err := w.renderMessage(w.subscribeTemplate(), strconv.FormatUint(id, 10), sub.payload)
+		if err != nil {
+			return err
+		}
+		err = w.conn.WriteMessage(websocket.TextMessage, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ping keeps graphql-transport-ws upstreams sending messages so that the keep alive timeout detects half-open connections
+func (w *WebsocketClient) ping() {
+	ticker := time.NewTicker(w.KeepAliveTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			_ = w.write(pingMessage)
+		}
+	}
+}
+
+// connectionLostError is returned by handleNextMessage if reading from the upstream connection failed
+type connectionLostError struct {
+	cause error
+}
+
+func (e *connectionLostError) Error() string {
+	return "upstream connection lost: " + e.cause.Error()
+}
+
+func (w *WebsocketClient) handleRemove(remove removeSubscriptionCmd) {
+	w.subscriptionsMux.Lock()
+	sub, ok := w.subscriptions[remove.id]
+	if ok {
+		delete(w.subscriptions, remove.id)
+	}
+	w.subscriptionsMux.Unlock()
+
+	if !ok {
+		// the subscription got already completed by a shutdown
 		return
 	}
 
-	message := buf.Bytes()
+	close(sub.stop)
+	w.sendStop(remove.id)
+}
 
-	err = w.conn.WriteMessage(websocket.TextMessage, message)
+// sendStop tells the upstream to stop a subscription
+func (w *WebsocketClient) sendStop(id uint64) {
+	template := stopMessage
+	if w.Protocol == ProtocolGraphQLTransportWS {
+		template = completeMessage
+	}
+
+	message, err := w.renderMessage(template, strconv.FormatUint(id, 10), nil)
 	if err != nil {
 		return
 	}
+
+	_ = w.write(message)
 }
 
+// nextSubscriptionID never reuses the ID of a stopped subscription, the upstream still answers its stop with a complete message
 func (w *WebsocketClient) nextSubscriptionID() (uint64, error) {
-	for i := uint64(1); i < math.MaxInt64; i++ {
-		_, exists := w.subscriptions[i]
-		if exists {
-			continue
-		}
-		return i, nil
+	if w.lastSubscriptionID == math.MaxInt64 {
+		return 0, fmt.Errorf("too many subscriptions")
 	}
-	return 0, fmt.Errorf("too many open subscriptions")
+	w.lastSubscriptionID++
+	return w.lastSubscriptionID, nil
 }
 
 type addSubscriptionCmd struct {
@@ -296,15 +684,28 @@ func (w *WebsocketClient) Unsubscribe(subscription Subscription) {
 	cmd := removeSubscriptionCmd{
 		id: subscription.id,
 	}
-	w.removeSubscription <- cmd
+	select {
+	case <-w.done:
+		return
+	case w.removeSubscription <- cmd:
+	}
 	<-subscription.stop
 }
 
 type Subscription struct {
 	next        chan []byte
 	id          uint64
+	payload     []byte
 	stop        chan struct{}
 	unsubscribe chan struct{}
+	terminal    *terminal
+}
+
+// terminal holds the terminal event of a subscription, err is nil if the upstream completed the subscription
+type terminal struct {
+	once sync.Once
+	done chan struct{}
+	err  error
 }
 
 func (s *Subscription) Next(done <-chan struct{}) (data []byte, ok bool) {
@@ -313,7 +714,26 @@ func (s *Subscription) Next(done <-chan struct{}) (data []byte, ok bool) {
 		return nil, false
 	case <-done:
 		return nil, false
+	case <-s.terminal.done:
+		return nil, false
 	case data = <-s.next:
 		return data, true
 	}
 }
+
+// Terminated returns true if the upstream ended the subscription and the error it ended with
+func (s *Subscription) Terminated() (terminated bool, err error) {
+	select {
+	case <-s.terminal.done:
+		return true, s.terminal.err
+	default:
+		return false, nil
+	}
+}
+
+func (s *Subscription) terminate(err error) {
+	s.terminal.once.Do(func() {
+		s.terminal.err = err
+		close(s.terminal.done)
+	})
+}
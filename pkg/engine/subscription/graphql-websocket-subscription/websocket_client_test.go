@@ -13,7 +13,10 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
 )
 
 func TestWebsocketClient(t *testing.T) {
@@ -58,10 +61,465 @@ func TestWebsocketClient(t *testing.T) {
 	go subscribe(wg, 3)
 
 	wg.Wait()
-	assert.Equal(t, 0, len(client.subscriptions))
+	assert.Equal(t, 0, activeSubscriptions(client))
 	assert.Equal(t, int64(6), totalMessages.Load())
 }
 
+func TestWebsocketClient_SubscriptionIDs(t *testing.T) {
+	server := FakeGraphQLSubscriptionServer(t)
+	defer server.Close()
+
+	client := &WebsocketClient{}
+	err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+	defer client.Close()
+	require.NoError(t, err)
+
+	first, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+	require.True(t, ok)
+	client.Unsubscribe(first)
+
+	// the upstream completes the first subscription after it got stopped, which must not complete the second one
+	second, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+	require.True(t, ok)
+	assert.NotEqual(t, first.id, second.id)
+	for i := 0; i < 3; i++ {
+		data, ok := second.Next(nil)
+		require.True(t, ok)
+		assert.Equal(t, fmt.Sprintf(`{"data":{"counter":{"count":%d}}}`, i), string(data))
+	}
+	client.Unsubscribe(second)
+}
+
+func TestWebsocketClient_GraphQLTransportWS(t *testing.T) {
+	server := FakeGraphQLTransportWSServer(t, `{"Authorization":"Bearer 123"}`)
+	defer server.Close()
+
+	host := server.Listener.Addr().String()
+
+	client := &WebsocketClient{
+		ConnectionInitPayload: []byte(`{"Authorization":"Bearer 123"}`),
+	}
+
+	err := client.Open("ws://"+host, nil)
+	defer client.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, ProtocolGraphQLTransportWS, client.Protocol)
+
+	subscription, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+	assert.True(t, ok)
+
+	for i := 0; i < 3; i++ {
+		data, ok := subscription.Next(nil)
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprintf(`{"data":{"counter":{"count":%d}}}`, i), string(data))
+	}
+
+	client.Unsubscribe(subscription)
+	assert.Equal(t, 0, activeSubscriptions(client))
+}
+
+func TestWebsocketClient_ProtocolNegotiation(t *testing.T) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{ProtocolGraphQLWS},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{ProtocolGraphQLTransportWS, ProtocolGraphQLWS}, websocket.Subprotocols(r))
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, message, err := c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"type":"connection_init"}`, string(message))
+		err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+		assert.NoError(t, err)
+		_, _, _ = c.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := &WebsocketClient{}
+	err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+	defer client.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, ProtocolGraphQLWS, client.Protocol)
+}
+
+func TestWebsocketClient_Termination(t *testing.T) {
+	// terminatingServer acks the connection and answers the first subscribe message with the given messages
+	terminatingServer := func(t *testing.T, closeConnection bool, messages ...string) *httptest.Server {
+		upgrader := websocket.Upgrader{
+			Subprotocols: []string{ProtocolGraphQLTransportWS},
+		}
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			_, _, err = c.ReadMessage()
+			assert.NoError(t, err)
+			err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+			assert.NoError(t, err)
+			_, message, err := c.ReadMessage()
+			assert.NoError(t, err)
+			id, err := jsonparser.GetString(message, "id")
+			assert.NoError(t, err)
+			for i := range messages {
+				err = c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(messages[i], id)))
+				assert.NoError(t, err)
+			}
+			if closeConnection {
+				return
+			}
+			for {
+				if _, _, err = c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+	}
+
+	subscribe := func(t *testing.T, server *httptest.Server) (*WebsocketClient, Subscription) {
+		client := &WebsocketClient{}
+		err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+		assert.NoError(t, err)
+		sub, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+		assert.True(t, ok)
+		return client, sub
+	}
+
+	t.Run("upstream errors", func(t *testing.T) {
+		server := terminatingServer(t, false, `{"type":"error","id":"%s","payload":[{"message":"unauthorized"}]}`)
+		defer server.Close()
+		client, sub := subscribe(t, server)
+		defer client.Close()
+
+		_, ok := sub.Next(nil)
+		assert.False(t, ok)
+		terminated, err := sub.Terminated()
+		assert.True(t, terminated)
+		assert.Equal(t, &subscription.UpstreamError{Errors: []byte(`[{"message":"unauthorized"}]`)}, err)
+		client.Unsubscribe(sub)
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		server := terminatingServer(t, false,
+			`{"type":"next","id":"%s","payload":{"data":{"counter":{"count":0}}}}`,
+			`{"type":"complete","id":"%s"}`,
+		)
+		defer server.Close()
+		client, sub := subscribe(t, server)
+		defer client.Close()
+
+		data, ok := sub.Next(nil)
+		assert.True(t, ok)
+		assert.Equal(t, `{"data":{"counter":{"count":0}}}`, string(data))
+		_, ok = sub.Next(nil)
+		assert.False(t, ok)
+		terminated, err := sub.Terminated()
+		assert.True(t, terminated)
+		assert.NoError(t, err)
+		client.Unsubscribe(sub)
+	})
+
+	t.Run("connection lost", func(t *testing.T) {
+		server := terminatingServer(t, true)
+		defer server.Close()
+		client, sub := subscribe(t, server)
+
+		_, ok := sub.Next(nil)
+		assert.False(t, ok)
+		terminated, err := sub.Terminated()
+		assert.True(t, terminated)
+		assert.Equal(t, subscription.ErrConnectionLost, err)
+		assert.Eventually(t, client.Closed, time.Second, time.Millisecond)
+		client.Unsubscribe(sub)
+		assert.True(t, client.CloseIfNoSubscriptions())
+	})
+}
+
+func TestWebsocketClient_Shutdown(t *testing.T) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{ProtocolGraphQLTransportWS},
+	}
+	received := make(chan string, 2)
+	closeCode := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _, err = c.ReadMessage()
+		assert.NoError(t, err)
+		err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+		assert.NoError(t, err)
+		for {
+			_, message, err := c.ReadMessage()
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				closeCode <- closeErr.Code
+				return
+			}
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	}))
+	defer server.Close()
+
+	client := &WebsocketClient{}
+	err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+	assert.NoError(t, err)
+	sub, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+	assert.True(t, ok)
+	assert.Equal(t, `{"type":"subscribe","id":"1","payload":{"query":"subscription{counter{count}}"}}`, <-received)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, client.Shutdown(ctx))
+
+	_, ok = sub.Next(nil)
+	assert.False(t, ok)
+	terminated, err := sub.Terminated()
+	assert.True(t, terminated)
+	assert.NoError(t, err)
+	client.Unsubscribe(sub)
+
+	assert.Equal(t, `{"type":"complete","id":"1"}`, <-received)
+	assert.Equal(t, websocket.CloseNormalClosure, <-closeCode)
+	assert.True(t, client.Closed())
+
+	_, ok = client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+	assert.False(t, ok)
+}
+
+func TestReconnectConfiguration_Backoff(t *testing.T) {
+	config := ReconnectConfiguration{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+	}
+	assert.Equal(t, 100*time.Millisecond, config.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, config.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, config.backoff(4))
+	assert.Equal(t, time.Second, config.backoff(5))
+
+	config.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := config.backoff(2)
+		assert.True(t, backoff >= 100*time.Millisecond && backoff <= 300*time.Millisecond, backoff)
+	}
+}
+
+func TestWebsocketClient_Reconnect(t *testing.T) {
+	// flappingServer serves a single message per connection and drops the connection afterwards
+	// Connections after the given number of healthy connections get refused.
+	flappingServer := func(t *testing.T, healthyConnections int64) *httptest.Server {
+		upgrader := websocket.Upgrader{
+			Subprotocols: []string{ProtocolGraphQLTransportWS},
+		}
+		connections := atomic.NewInt64(0)
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count := connections.Inc()
+			if count > healthyConnections {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			_, _, err = c.ReadMessage()
+			assert.NoError(t, err)
+			err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+			assert.NoError(t, err)
+			_, message, err := c.ReadMessage()
+			assert.NoError(t, err)
+			assert.Equal(t, `{"type":"subscribe","id":"1","payload":{"query":"subscription{counter{count}}"}}`, string(message))
+			err = c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"type":"next","id":"1","payload":{"data":{"counter":{"count":%d}}}}`, count)))
+			assert.NoError(t, err)
+		}))
+	}
+
+	t.Run("should resubscribe after reconnecting", func(t *testing.T) {
+		server := flappingServer(t, 2)
+		defer server.Close()
+
+		var events []ReconnectEvent
+		client := &WebsocketClient{
+			Reconnect: ReconnectConfiguration{
+				MaxAttempts:     3,
+				InitialInterval: time.Millisecond,
+			},
+			OnReconnect: func(event ReconnectEvent) {
+				events = append(events, event)
+			},
+		}
+		err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+		assert.NoError(t, err)
+		defer client.Close()
+
+		sub, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+		assert.True(t, ok)
+
+		for i := 1; i <= 2; i++ {
+			data, ok := sub.Next(nil)
+			assert.True(t, ok)
+			assert.Equal(t, fmt.Sprintf(`{"data":{"counter":{"count":%d}}}`, i), string(data))
+		}
+
+		// the third connection gets refused until reconnecting gives up
+		_, ok = sub.Next(nil)
+		assert.False(t, ok)
+		terminated, err := sub.Terminated()
+		assert.True(t, terminated)
+		assert.Equal(t, subscription.ErrConnectionLost, err)
+
+		assert.Equal(t, 4, len(events))
+		assert.True(t, events[0].Reconnected)
+		assert.Equal(t, 1, events[0].Attempt)
+		assert.NoError(t, events[0].Err)
+		assert.Error(t, events[0].Cause)
+		for i, attempt := range events[1:] {
+			assert.False(t, attempt.Reconnected)
+			assert.Equal(t, i+1, attempt.Attempt)
+			assert.Error(t, attempt.Err)
+			assert.Equal(t, i == 2, attempt.GaveUp)
+		}
+	})
+
+	t.Run("should detect half-open connections", func(t *testing.T) {
+		upgrader := websocket.Upgrader{
+			Subprotocols: []string{ProtocolGraphQLTransportWS},
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			_, _, err = c.ReadMessage()
+			assert.NoError(t, err)
+			err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+			assert.NoError(t, err)
+			// read pings and subscriptions without ever answering
+			for {
+				if _, _, err = c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+		defer server.Close()
+
+		client := &WebsocketClient{
+			KeepAliveTimeout: 50 * time.Millisecond,
+		}
+		err := client.Open("ws://"+server.Listener.Addr().String(), nil)
+		assert.NoError(t, err)
+		defer client.Close()
+
+		sub, ok := client.Subscribe([]byte(`{"query":"subscription{counter{count}}"}`))
+		assert.True(t, ok)
+
+		_, ok = sub.Next(nil)
+		assert.False(t, ok)
+		terminated, err := sub.Terminated()
+		assert.True(t, terminated)
+		assert.Equal(t, subscription.ErrConnectionLost, err)
+	})
+}
+
+// activeSubscriptions returns the number of subscriptions of the client, which are shared with its read loop
+func activeSubscriptions(client *WebsocketClient) int {
+	client.subscriptionsMux.RLock()
+	defer client.subscriptionsMux.RUnlock()
+	return len(client.subscriptions)
+}
+
+func FakeGraphQLTransportWSServer(t *testing.T, expectedConnectionInitPayload string) *httptest.Server {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{ProtocolGraphQLTransportWS},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Print("upgrade:", err)
+			return
+		}
+		defer c.Close()
+		_, message, err := c.ReadMessage()
+		assert.NoError(t, err)
+		messageType, _ := jsonparser.GetString(message, "type")
+		assert.Equal(t, "connection_init", messageType)
+		payload, _, _, _ := jsonparser.Get(message, "payload")
+		assert.Equal(t, expectedConnectionInitPayload, string(payload))
+
+		// the client has to answer pings even before the connection is acknowledged
+		err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+		assert.NoError(t, err)
+		_, message, err = c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"type":"pong"}`, string(message))
+
+		err = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_ack"}`))
+		assert.NoError(t, err)
+
+		streams := map[string]func(){}
+		writeMux := &sync.Mutex{}
+
+		startStream := func(id string, done <-chan struct{}) {
+			counter := 0
+			for {
+				time.Sleep(time.Millisecond)
+				select {
+				case <-done:
+					return
+				default:
+					writeMux.Lock()
+					if counter == 1 {
+						_ = c.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+					}
+					err := c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"type":"next","id":"%s","payload":{"data":{"counter":{"count":%d}}}}`, id, counter)))
+					writeMux.Unlock()
+					if err != nil {
+						return
+					}
+					counter++
+				}
+			}
+		}
+
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			messageType, err := jsonparser.GetString(message, "type")
+			assert.NoError(t, err)
+
+			switch messageType {
+			case "subscribe":
+				messageID, err := jsonparser.GetString(message, "id")
+				assert.NoError(t, err)
+				ctx, cancel := context.WithCancel(context.Background())
+				streams[messageID] = cancel
+				go startStream(messageID, ctx.Done())
+			case "complete":
+				messageID, err := jsonparser.GetString(message, "id")
+				assert.NoError(t, err)
+				streams[messageID]()
+				delete(streams, messageID)
+			case "pong":
+			default:
+				t.Errorf("unexpected message: %s", string(message))
+			}
+		}
+	}))
+}
+
 func FakeGraphQLSubscriptionServer(t *testing.T) *httptest.Server {
 	upgrader := websocket.Upgrader{}
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -89,13 +547,14 @@ func FakeGraphQLSubscriptionServer(t *testing.T) *httptest.Server {
 				case <-done:
 					message := fmt.Sprintf(`{"type":"complete","id":"%s","payload":null}`, id)
 					writeMux.Lock()
-					err = c.WriteMessage(websocket.TextMessage, []byte(message))
+					_ = c.WriteMessage(websocket.TextMessage, []byte(message))
 					writeMux.Unlock()
 					return
 				default:
 					message := fmt.Sprintf(`{"type":"data","id":"%s","payload":{"data":{"counter":{"count":%d}}}}`, id, counter)
 					writeMux.Lock()
-					err = c.WriteMessage(websocket.TextMessage, []byte(message))
+					// streams run concurrently, so they must not share the err of the handler
+					err := c.WriteMessage(websocket.TextMessage, []byte(message))
 					writeMux.Unlock()
 					if err != nil {
 						return
@@ -1,19 +1,99 @@
 package subscription
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+
 	"github.com/jensneuse/graphql-go-tools/pkg/pool"
 )
 
-func NewManager(stream Stream) *Manager {
-	return &Manager{
+// SlowConsumerPolicy defines what happens to results for subscribers whose buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerPolicyBlock waits until the subscriber has room, which stalls all subscribers of the same stream
+	SlowConsumerPolicyBlock SlowConsumerPolicy = "block"
+	// SlowConsumerPolicyDropOldest drops the oldest buffered result to make room for the new one
+	SlowConsumerPolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+	// SlowConsumerPolicyDropNewest drops the new result
+	SlowConsumerPolicyDropNewest SlowConsumerPolicy = "drop_newest"
+	// SlowConsumerPolicyCoalesce only keeps the latest result, the buffer size is always one
+	SlowConsumerPolicyCoalesce SlowConsumerPolicy = "coalesce"
+	// SlowConsumerPolicyDisconnect terminates the subscriber with ErrSlowConsumer
+	SlowConsumerPolicyDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+const (
+	// DefaultSubscriberBufferSize is used for all policies except SlowConsumerPolicyBlock if no buffer size is set
+	DefaultSubscriberBufferSize = 16
+)
+
+type Option func(m *Manager)
+
+// WithBufferSize sets the number of results buffered per subscriber
+func WithBufferSize(size int) Option {
+	return func(m *Manager) {
+		m.bufferSize = size
+	}
+}
+
+// WithSlowConsumerPolicy sets the policy for subscribers which can't keep up with the stream
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) Option {
+	return func(m *Manager) {
+		m.policy = policy
+	}
+}
+
+// NewManager creates a Manager which blocks on slow subscribers unless configured otherwise
+// The lossy policies are opt-in with WithSlowConsumerPolicy.
+func NewManager(stream Stream, options ...Option) *Manager {
+	m := &Manager{
 		stream:             stream,
+		policy:             SlowConsumerPolicyBlock,
+		counters:           &counters{},
 		subscribers:        map[uint64]int64{},
 		subscriptions:      map[uint64]*subscription{},
+		triggers:           map[Trigger]*subscription{},
 		addTrigger:         make(chan addTrigger),
 		removeTrigger:      make(chan Trigger),
 		countSubscribers:   make(chan chan int64),
 		countSubscriptions: make(chan chan int64),
+		metrics:            make(chan chan Metrics),
+		terminate:          make(chan terminateSubscription),
+		shutdown:           make(chan struct{}),
+		closed:             make(chan struct{}),
 	}
+	for i := range options {
+		options[i](m)
+	}
+	switch {
+	case m.policy == SlowConsumerPolicyCoalesce:
+		m.bufferSize = 1
+	case m.policy != SlowConsumerPolicyBlock && m.bufferSize <= 0:
+		m.bufferSize = DefaultSubscriberBufferSize
+	}
+	return m
+}
+
+// Metrics is a snapshot of the state of a Manager
+type Metrics struct {
+	Subscriptions int64
+	Subscribers   int64
+	// BufferedResults is the number of results waiting in the buffers of all subscribers
+	BufferedResults int64
+	// BufferCapacity is the total size of the buffers of all subscribers
+	BufferCapacity int64
+	// DroppedResults counts the results dropped or coalesced because of slow subscribers
+	DroppedResults int64
+	// DisconnectedSubscribers counts the subscribers terminated by SlowConsumerPolicyDisconnect
+	DisconnectedSubscribers int64
+}
+
+// counters are shared with the subscriptions of a Manager
+type counters struct {
+	droppedResults          int64
+	disconnectedSubscribers int64
 }
 
 type addTrigger struct {
@@ -21,38 +101,119 @@ type addTrigger struct {
 	input   []byte
 }
 
+type terminateSubscription struct {
+	subscriptionID uint64
+	subscription   *subscription
+	err            error
+}
+
 type Manager struct {
 	stream             Stream
+	bufferSize         int
+	policy             SlowConsumerPolicy
+	counters           *counters
 	subscriptions      map[uint64]*subscription
 	subscribers        map[uint64]int64
+	triggers           map[Trigger]*subscription
 	addTrigger         chan addTrigger
 	removeTrigger      chan Trigger
 	countSubscriptions chan chan int64
 	countSubscribers   chan chan int64
+	metrics            chan chan Metrics
+	terminate          chan terminateSubscription
+	shutdown           chan struct{}
+	// closed is closed as soon as run returned
+	closed chan struct{}
+	// shuttingDown rejects new triggers, it's only accessed by run
+	shuttingDown bool
+	// streams tracks the running streams so that Shutdown can wait for them
+	streams sync.WaitGroup
 }
 
 func (m *Manager) TotalSubscriptions() int64 {
 	out := make(chan int64)
-	m.countSubscriptions <- out
+	select {
+	case m.countSubscriptions <- out:
+	case <-m.closed:
+		return 0
+	}
 	return <-out
 }
 
 func (m *Manager) TotalSubscribers() int64 {
 	out := make(chan int64)
-	m.countSubscribers <- out
+	select {
+	case m.countSubscribers <- out:
+	case <-m.closed:
+		return 0
+	}
 	return <-out
 }
 
+// Metrics returns the current subscriptions, subscribers, buffer occupancy and drops of the Manager
+func (m *Manager) Metrics() Metrics {
+	out := make(chan Metrics)
+	select {
+	case m.metrics <- out:
+	case <-m.closed:
+		return Metrics{
+			DroppedResults:          atomic.LoadInt64(&m.counters.droppedResults),
+			DisconnectedSubscribers: atomic.LoadInt64(&m.counters.disconnectedSubscribers),
+		}
+	}
+	return <-out
+}
+
+// Run starts the Manager until done is closed
+// Closing done completes all subscriptions and stops their streams without waiting for them, use Shutdown to drain the Manager.
 func (m *Manager) Run(done <-chan struct{}) {
 	go m.run(done)
 }
 
+// Shutdown completes all subscriptions, stops their streams and waits until all streams returned or the context is done
+// Triggers started afterwards get terminated with ErrShuttingDown.
+// Once all streams returned, a Stream implementing ShutdownStream gets shut down as well, e.g. to close upstream connections.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	select {
+	case m.shutdown <- struct{}{}:
+	case <-m.closed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	streamsDone := make(chan struct{})
+	go func() {
+		m.streams.Wait()
+		close(streamsDone)
+	}()
+
+	select {
+	case <-streamsDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if stream, ok := m.stream.(ShutdownStream); ok {
+		return stream.Shutdown(ctx)
+	}
+	return nil
+}
+
 func (m *Manager) run(done <-chan struct{}) {
+	defer close(m.closed)
 	for {
 		select {
 		case <-done:
+			m.completeAll()
 			return
+		case <-m.shutdown:
+			m.shuttingDown = true
+			m.completeAll()
 		case addTrigger := <-m.addTrigger:
+			if m.shuttingDown {
+				addTrigger.trigger.terminate(ErrShuttingDown)
+				continue
+			}
 			sub, exists := m.subscriptions[addTrigger.trigger.subscriptionID]
 			if !exists {
 				sub = &subscription{
@@ -63,25 +224,33 @@ func (m *Manager) run(done <-chan struct{}) {
 					removeTrigger: make(chan Trigger),
 					stop:          make(chan struct{}),
 					results:       make(chan []byte),
+					terminate:     make(chan error),
+					terminated:    make(chan struct{}),
+					policy:        m.policy,
+					counters:      m.counters,
 				}
+				m.triggers[addTrigger.trigger] = sub
 				m.subscriptions[addTrigger.trigger.subscriptionID] = sub
 				m.subscribers[addTrigger.trigger.subscriptionID] = 1
-				go m.stream.Start(addTrigger.input, sub.results, sub.stop)
+				m.streams.Add(1)
+				go m.startStream(addTrigger.trigger.subscriptionID, sub, addTrigger.input)
 				go sub.run()
 				continue
 			}
 			sub.addTrigger <- addTrigger.trigger
+			m.triggers[addTrigger.trigger] = sub
 			m.subscribers[addTrigger.trigger.subscriptionID] += 1
 		case trigger := <-m.removeTrigger:
-			m.subscriptions[trigger.subscriptionID].removeTrigger <- trigger
-			subscribers := m.subscribers[trigger.subscriptionID] - 1
-			if subscribers == 0 {
-				close(m.subscriptions[trigger.subscriptionID].stop)
-				delete(m.subscriptions, trigger.subscriptionID)
-				delete(m.subscribers, trigger.subscriptionID)
+			m.stopTrigger(trigger)
+		case terminate := <-m.terminate:
+			if m.subscriptions[terminate.subscriptionID] != terminate.subscription {
+				// the subscription was stopped in the meantime
 				continue
 			}
-			m.subscribers[trigger.subscriptionID] = subscribers
+			terminate.subscription.terminate <- terminate.err
+			if m.awaitTerminated(terminate.subscriptionID, terminate.subscription) {
+				m.removeSubscription(terminate.subscriptionID, terminate.subscription)
+			}
 		case out := <-m.countSubscriptions:
 			out <- int64(len(m.subscriptions))
 		case out := <-m.countSubscribers:
@@ -90,8 +259,98 @@ func (m *Manager) run(done <-chan struct{}) {
 				subs += m.subscribers[i]
 			}
 			out <- subs
+		case out := <-m.metrics:
+			metrics := Metrics{
+				Subscriptions:           int64(len(m.subscriptions)),
+				Subscribers:             int64(len(m.triggers)),
+				DroppedResults:          atomic.LoadInt64(&m.counters.droppedResults),
+				DisconnectedSubscribers: atomic.LoadInt64(&m.counters.disconnectedSubscribers),
+			}
+			for trigger := range m.triggers {
+				metrics.BufferedResults += int64(len(trigger.results))
+				metrics.BufferCapacity += int64(cap(trigger.results))
+			}
+			out <- metrics
+		}
+	}
+}
+
+// completeAll completes the triggers of all subscriptions and stops their streams
+func (m *Manager) completeAll() {
+	for subscriptionID, sub := range m.subscriptions {
+		sub.terminate <- nil
+		if m.awaitTerminated(subscriptionID, sub) {
+			close(sub.stop)
+			m.removeSubscription(subscriptionID, sub)
+		}
+	}
+}
+
+func (m *Manager) stopTrigger(trigger Trigger) {
+	sub, exists := m.triggers[trigger]
+	if !exists {
+		// the subscription of the trigger was terminated by the stream and is already removed
+		return
+	}
+	delete(m.triggers, trigger)
+	select {
+	case sub.removeTrigger <- trigger:
+	case <-sub.terminated:
+	}
+	subscribers := m.subscribers[trigger.subscriptionID] - 1
+	if subscribers == 0 {
+		close(sub.stop)
+		delete(m.subscriptions, trigger.subscriptionID)
+		delete(m.subscribers, trigger.subscriptionID)
+		return
+	}
+	m.subscribers[trigger.subscriptionID] = subscribers
+}
+
+// awaitTerminated waits until the subscription terminated its triggers
+// Triggers keep getting removed meanwhile, because the result sent before the termination
+// might wait for a subscriber which stopped reading. It returns false if the subscription got stopped instead.
+func (m *Manager) awaitTerminated(subscriptionID uint64, sub *subscription) bool {
+	for {
+		select {
+		case <-sub.terminated:
+			return true
+		case trigger := <-m.removeTrigger:
+			m.stopTrigger(trigger)
+			if m.subscriptions[subscriptionID] != sub {
+				return false
+			}
+		}
+	}
+}
+
+func (m *Manager) removeSubscription(subscriptionID uint64, sub *subscription) {
+	for trigger, triggerSub := range m.triggers {
+		if triggerSub == sub {
+			delete(m.triggers, trigger)
 		}
 	}
+	delete(m.subscriptions, subscriptionID)
+	delete(m.subscribers, subscriptionID)
+}
+
+// startStream starts the stream of a subscription and terminates the subscription if the stream ends before it got stopped
+func (m *Manager) startStream(subscriptionID uint64, sub *subscription, input []byte) {
+	defer m.streams.Done()
+	var err error
+	if stream, ok := m.stream.(TerminatingStream); ok {
+		err = stream.StartWithError(input, sub.results, sub.stop)
+	} else {
+		m.stream.Start(input, sub.results, sub.stop)
+	}
+	select {
+	case <-sub.stop:
+	case m.terminate <- terminateSubscription{
+		subscriptionID: subscriptionID,
+		subscription:   sub,
+		err:            err,
+	}:
+	}
 }
 
 type subscription struct {
@@ -100,6 +359,13 @@ type subscription struct {
 	removeTrigger chan Trigger
 	stop          chan struct{}
 	results       chan []byte
+	terminate     chan error
+	terminated    chan struct{}
+	policy        SlowConsumerPolicy
+	counters      *counters
+	// terminating is set if the stream terminated while a result was being delivered
+	terminating  bool
+	terminateErr error
 }
 
 func (s *subscription) run() {
@@ -113,29 +379,124 @@ func (s *subscription) run() {
 			delete(s.triggers, trigger)
 		case result := <-s.results:
 			for trigger := range s.triggers {
-				trigger.results <- result
+				if done := s.deliver(trigger, result); done {
+					return
+				}
+			}
+			if s.terminating {
+				s.terminateAll(s.terminateErr)
+				return
 			}
+		case err := <-s.terminate:
+			s.terminateAll(err)
+			return
+		}
+	}
+}
+
+func (s *subscription) terminateAll(err error) {
+	for trigger := range s.triggers {
+		trigger.terminate(err)
+	}
+	close(s.terminated)
+}
+
+// deliver sends a result to a trigger according to the slow consumer policy
+// It returns true if the subscription got stopped or terminated while waiting for a blocking subscriber.
+func (s *subscription) deliver(trigger Trigger, result []byte) (done bool) {
+	if !trigger.matches(result) {
+		return false
+	}
+
+	if s.policy == SlowConsumerPolicyBlock {
+		return s.deliverBlocking(trigger, result)
+	}
+
+	for {
+		select {
+		case trigger.results <- result:
+			return false
+		default:
+		}
+
+		switch s.policy {
+		case SlowConsumerPolicyDropNewest:
+			atomic.AddInt64(&s.counters.droppedResults, 1)
+			return false
+		case SlowConsumerPolicyDisconnect:
+			atomic.AddInt64(&s.counters.disconnectedSubscribers, 1)
+			delete(s.triggers, trigger)
+			trigger.terminate(ErrSlowConsumer)
+			return false
+		default:
+			// drop the oldest result, the subscriber might have read it in the meantime
+			select {
+			case <-trigger.results:
+				atomic.AddInt64(&s.counters.droppedResults, 1)
+			default:
+			}
+		}
+	}
+}
+
+// deliverBlocking waits until the trigger has room for the result
+// Triggers keep getting added and removed meanwhile, so that a subscriber which stopped reading can always be removed.
+// A termination of the stream is deferred until the result got delivered to all triggers.
+func (s *subscription) deliverBlocking(trigger Trigger, result []byte) (done bool) {
+	terminate := s.terminate
+	if s.terminating {
+		terminate = nil
+	}
+	for {
+		select {
+		case trigger.results <- result:
+			return false
+		case added := <-s.addTrigger:
+			s.triggers[added] = struct{}{}
+		case removed := <-s.removeTrigger:
+			delete(s.triggers, removed)
+			if removed == trigger {
+				return false
+			}
+		case <-s.stop:
+			return true
+		case err := <-terminate:
+			s.terminating, s.terminateErr = true, err
+			terminate = nil
 		}
 	}
 }
 
 func (m *Manager) StartTrigger(input []byte) (trigger Trigger) {
+	return m.StartTriggerWithFilter(input, nil)
+}
+
+// StartTriggerWithFilter starts a trigger which only receives the results matching the filter
+// The filter doesn't affect deduplication, triggers with the same input share one stream regardless of their filters.
+func (m *Manager) StartTriggerWithFilter(input []byte, filter Filter) (trigger Trigger) {
 	subscriptionID := m.subscriptionID(input)
-	trigger = NewTrigger(subscriptionID)
-	m.addTrigger <- addTrigger{
+	trigger = newTrigger(subscriptionID, m.bufferSize, filter)
+	select {
+	case m.addTrigger <- addTrigger{
 		trigger: trigger,
 		input:   input,
+	}:
+	case <-m.closed:
+		trigger.terminate(ErrShuttingDown)
 	}
 	return
 }
 
 func (m *Manager) StopTrigger(trigger Trigger) {
-	m.removeTrigger <- trigger
+	select {
+	case m.removeTrigger <- trigger:
+	case <-m.closed:
+	}
 }
 
 func (m *Manager) subscriptionID(input []byte) uint64 {
 	hash64 := pool.Hash64.Get()
-	_, _ = hash64.Write(input)
+	_, _ = hash64.Write(withoutTraceparent(input))
 	subscriptionID := hash64.Sum64()
 	pool.Hash64.Put(hash64)
 	return subscriptionID
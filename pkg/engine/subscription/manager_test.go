@@ -54,7 +54,7 @@ func TestSubscriptionManager(t *testing.T) {
 
 	trigger1 = manager.StartTrigger(input)
 	time.Sleep(time.Millisecond)
-	assert.Equal(t, 3, len(manager.subscriptions[trigger1.SubscriptionID()].triggers))
+	assert.Equal(t, int64(3), manager.TotalSubscribers())
 
 	receiveOneAndStop := func(trigger Trigger, wg *sync.WaitGroup, triggerID int) {
 		data, ok := trigger.Next(context.Background())
@@ -102,7 +102,411 @@ func TestSubscriptionManager(t *testing.T) {
 
 	manager.StopTrigger(trigger4)
 	time.Sleep(time.Millisecond)
-	assert.Equal(t, 0, len(manager.subscriptions))
+	assert.Equal(t, int64(0), manager.TotalSubscriptions())
 	fakeStream.wg.Wait()
 	assert.Equal(t, true, fakeStream.done)
 }
+
+type TerminatingFakeStream struct {
+	start chan struct{}
+	err   error
+}
+
+func (f *TerminatingFakeStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	_ = f.StartWithError(input, next, stop)
+}
+
+func (f *TerminatingFakeStream) StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error {
+	<-f.start
+	select {
+	case next <- []byte("0"):
+	case <-stop:
+		return nil
+	}
+	return f.err
+}
+
+func (f *TerminatingFakeStream) UniqueIdentifier() []byte {
+	return []byte("terminating_fake_stream")
+}
+
+func TestSubscriptionManager_Terminate(t *testing.T) {
+	run := func(t *testing.T, terminalErr error) {
+		stream := &TerminatingFakeStream{
+			start: make(chan struct{}),
+			err:   terminalErr,
+		}
+		manager := NewManager(stream)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.Run(ctx.Done())
+
+		trigger1 := manager.StartTrigger([]byte("none"))
+		trigger2 := manager.StartTrigger([]byte("none"))
+		assert.Equal(t, int64(2), manager.TotalSubscribers())
+		close(stream.start)
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		for _, trigger := range []Trigger{trigger1, trigger2} {
+			go func(trigger Trigger) {
+				defer wg.Done()
+				data, ok := trigger.Next(context.Background())
+				assert.True(t, ok)
+				assert.Equal(t, "0", string(data))
+
+				_, ok = trigger.Next(context.Background())
+				assert.False(t, ok)
+				terminated, err := trigger.Terminated()
+				assert.True(t, terminated)
+				assert.Equal(t, terminalErr, err)
+			}(trigger)
+		}
+		wg.Wait()
+
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+		assert.Equal(t, int64(0), manager.TotalSubscribers())
+
+		// stopping terminated triggers is a no-op
+		manager.StopTrigger(trigger1)
+		manager.StopTrigger(trigger2)
+		assert.Equal(t, int64(0), manager.TotalSubscribers())
+	}
+
+	t.Run("upstream completes the subscription", func(t *testing.T) {
+		run(t, nil)
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		run(t, &UpstreamError{Errors: []byte(`[{"message":"unauthorized"}]`)})
+	})
+
+	t.Run("upstream completes while the subscriber stopped reading", func(t *testing.T) {
+		stream := &TerminatingFakeStream{
+			start: make(chan struct{}),
+		}
+		manager := NewManager(stream)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.Run(ctx.Done())
+
+		trigger := manager.StartTrigger([]byte("none"))
+		close(stream.start)
+		// give the subscription time to block on the last result and the stream to complete
+		time.Sleep(10 * time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			manager.StopTrigger(trigger)
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("stopping a blocked trigger of a completed stream deadlocked the manager")
+		}
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+	})
+}
+
+// SendAllStream sends all results without waiting for subscribers and signals when it's done
+type SendAllStream struct {
+	results [][]byte
+	sent    chan struct{}
+	// start delays sending the results if set
+	start chan struct{}
+}
+
+func (f *SendAllStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	if f.start != nil {
+		select {
+		case <-f.start:
+		case <-stop:
+			return
+		}
+	}
+	for i := range f.results {
+		select {
+		case next <- f.results[i]:
+		case <-stop:
+			return
+		}
+	}
+	close(f.sent)
+	<-stop
+}
+
+func (f *SendAllStream) UniqueIdentifier() []byte {
+	return []byte("send_all_stream")
+}
+
+func TestSubscriptionManager_SlowConsumerPolicy(t *testing.T) {
+	run := func(t *testing.T, options []Option, expectedResults []string, expectedErr error, expectedMetrics Metrics) {
+		stream := &SendAllStream{
+			results: [][]byte{[]byte("0"), []byte("1"), []byte("2"), []byte("3"), []byte("4")},
+			sent:    make(chan struct{}),
+		}
+		manager := NewManager(stream, options...)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.Run(ctx.Done())
+
+		trigger := manager.StartTrigger([]byte("none"))
+
+		// the stream must not get blocked by the subscriber which doesn't read
+		select {
+		case <-stream.sent:
+		case <-time.After(time.Second):
+			t.Fatal("stream blocked by slow subscriber")
+		}
+		assert.Eventually(t, func() bool {
+			return manager.Metrics() == expectedMetrics
+		}, time.Second, time.Millisecond, "%+v", manager.Metrics())
+
+		var results []string
+		for range expectedResults {
+			data, ok := trigger.Next(context.Background())
+			assert.True(t, ok)
+			results = append(results, string(data))
+		}
+		assert.Equal(t, expectedResults, results)
+
+		if expectedErr != nil {
+			_, ok := trigger.Next(context.Background())
+			assert.False(t, ok)
+			terminated, err := trigger.Terminated()
+			assert.True(t, terminated)
+			assert.Equal(t, expectedErr, err)
+		}
+
+		manager.StopTrigger(trigger)
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		run(t, []Option{WithSlowConsumerPolicy(SlowConsumerPolicyDropNewest), WithBufferSize(2)},
+			[]string{"0", "1"}, nil,
+			Metrics{Subscriptions: 1, Subscribers: 1, BufferedResults: 2, BufferCapacity: 2, DroppedResults: 3},
+		)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		run(t, []Option{WithSlowConsumerPolicy(SlowConsumerPolicyDropOldest), WithBufferSize(2)},
+			[]string{"3", "4"}, nil,
+			Metrics{Subscriptions: 1, Subscribers: 1, BufferedResults: 2, BufferCapacity: 2, DroppedResults: 3},
+		)
+	})
+
+	t.Run("coalesce", func(t *testing.T) {
+		run(t, []Option{WithSlowConsumerPolicy(SlowConsumerPolicyCoalesce), WithBufferSize(2)},
+			[]string{"4"}, nil,
+			Metrics{Subscriptions: 1, Subscribers: 1, BufferedResults: 1, BufferCapacity: 1, DroppedResults: 4},
+		)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		run(t, []Option{WithSlowConsumerPolicy(SlowConsumerPolicyDisconnect), WithBufferSize(2)},
+			[]string{"0", "1"}, ErrSlowConsumer,
+			Metrics{Subscriptions: 1, Subscribers: 1, BufferedResults: 2, BufferCapacity: 2, DisconnectedSubscribers: 1},
+		)
+	})
+}
+
+func TestSubscriptionManager_StopBlockedTrigger(t *testing.T) {
+	stream := &SendAllStream{
+		results: [][]byte{[]byte("0"), []byte("1"), []byte("2"), []byte("3"), []byte("4")},
+		sent:    make(chan struct{}),
+		start:   make(chan struct{}),
+	}
+	// managers block on slow subscribers by default
+	manager := NewManager(stream)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Run(ctx.Done())
+
+	stuck := manager.StartTrigger([]byte("none"))
+	reading := manager.StartTrigger([]byte("none"))
+
+	// the reading trigger might get added to the subscription after the first result, so it waits for the last one
+	results := make(chan string)
+	go func() {
+		for {
+			data, ok := reading.Next(context.Background())
+			if !ok || string(data) == "4" {
+				results <- string(data)
+				return
+			}
+		}
+	}()
+
+	close(stream.start)
+	// give the subscription time to block on the subscriber which doesn't read
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		manager.StopTrigger(stuck)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopping a blocked trigger deadlocked the manager")
+	}
+
+	select {
+	case last := <-results:
+		assert.Equal(t, "4", last)
+	case <-time.After(time.Second):
+		t.Fatal("the reading subscriber stalled")
+	}
+	assert.Equal(t, int64(1), manager.TotalSubscribers())
+	manager.StopTrigger(reading)
+	assert.Equal(t, int64(0), manager.TotalSubscriptions())
+}
+
+func TestSubscriptionManager_Filter(t *testing.T) {
+	stream := &SendAllStream{
+		results: [][]byte{
+			[]byte(`{"data":{"orderUpdated":{"id":1,"customerId":"1"}}}`),
+			[]byte(`{"data":{"orderUpdated":{"id":2,"customerId":"2"}}}`),
+			[]byte(`{"data":{"orderUpdated":{"id":3,"customerId":"1"}}}`),
+			[]byte(`{"data":{"orderUpdated":{"id":4}}}`),
+		},
+		sent:  make(chan struct{}),
+		start: make(chan struct{}),
+	}
+	manager := NewManager(stream, WithSlowConsumerPolicy(SlowConsumerPolicyDropNewest))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Run(ctx.Done())
+
+	customerIDPath := []string{"data", "orderUpdated", "customerId"}
+	customer1 := manager.StartTriggerWithFilter([]byte("orders"), FieldEquals(customerIDPath, []byte("1")))
+	customer2 := manager.StartTriggerWithFilter([]byte("orders"), All(
+		FieldEquals(customerIDPath, []byte("2")),
+		FieldEquals([]string{"data", "orderUpdated", "id"}, []byte("2")),
+	))
+	unfiltered := manager.StartTrigger([]byte("orders"))
+
+	assert.Equal(t, int64(1), manager.TotalSubscriptions())
+	assert.Equal(t, int64(3), manager.TotalSubscribers())
+
+	close(stream.start)
+	select {
+	case <-stream.sent:
+	case <-time.After(time.Second):
+		t.Fatal("stream blocked")
+	}
+
+	assert.Eventually(t, func() bool {
+		return manager.Metrics().BufferedResults == 7
+	}, time.Second, time.Millisecond, "%+v", manager.Metrics())
+
+	next := func(trigger Trigger) string {
+		data, ok := trigger.Next(context.Background())
+		assert.True(t, ok)
+		return string(data)
+	}
+
+	assert.Equal(t, string(stream.results[0]), next(customer1))
+	assert.Equal(t, string(stream.results[2]), next(customer1))
+	assert.Equal(t, string(stream.results[1]), next(customer2))
+	for i := range stream.results {
+		assert.Equal(t, string(stream.results[i]), next(unfiltered))
+	}
+	assert.Equal(t, int64(0), manager.Metrics().BufferedResults)
+
+	manager.StopTrigger(customer1)
+	manager.StopTrigger(customer2)
+	manager.StopTrigger(unfiltered)
+	assert.Equal(t, int64(0), manager.TotalSubscriptions())
+}
+
+// ShutdownFakeStream records if it got shut down
+type ShutdownFakeStream struct {
+	SendAllStream
+	shutdown bool
+}
+
+func (f *ShutdownFakeStream) Shutdown(ctx context.Context) error {
+	f.shutdown = true
+	return nil
+}
+
+func TestSubscriptionManager_Shutdown(t *testing.T) {
+	assertCompleted := func(t *testing.T, trigger Trigger, expectedErr error) {
+		_, ok := trigger.Next(context.Background())
+		assert.False(t, ok)
+		terminated, err := trigger.Terminated()
+		assert.True(t, terminated)
+		assert.Equal(t, expectedErr, err)
+	}
+
+	t.Run("shutdown", func(t *testing.T) {
+		stream := &ShutdownFakeStream{
+			SendAllStream: SendAllStream{
+				results: [][]byte{[]byte("0")},
+				sent:    make(chan struct{}),
+				start:   make(chan struct{}),
+			},
+		}
+		manager := NewManager(stream, WithSlowConsumerPolicy(SlowConsumerPolicyDropNewest))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.Run(ctx.Done())
+
+		trigger1 := manager.StartTrigger([]byte("1"))
+		trigger2 := manager.StartTrigger([]byte("1"))
+		assert.Equal(t, int64(2), manager.TotalSubscribers())
+		close(stream.start)
+		for _, trigger := range []Trigger{trigger1, trigger2} {
+			data, ok := trigger.Next(context.Background())
+			assert.True(t, ok)
+			assert.Equal(t, "0", string(data))
+		}
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+		defer cancelShutdown()
+		assert.NoError(t, manager.Shutdown(shutdownCtx))
+		assert.True(t, stream.shutdown)
+
+		// the subscribers get completed
+		assertCompleted(t, trigger1, nil)
+		assertCompleted(t, trigger2, nil)
+		manager.StopTrigger(trigger1)
+		manager.StopTrigger(trigger2)
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+
+		assertCompleted(t, manager.StartTrigger([]byte("2")), ErrShuttingDown)
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+	})
+
+	t.Run("done", func(t *testing.T) {
+		stream := &SendAllStream{
+			sent: make(chan struct{}),
+		}
+		manager := NewManager(stream)
+		ctx, cancel := context.WithCancel(context.Background())
+		manager.Run(ctx.Done())
+
+		trigger := manager.StartTrigger([]byte("1"))
+		cancel()
+
+		assertCompleted(t, trigger, nil)
+		assertCompleted(t, manager.StartTrigger([]byte("2")), ErrShuttingDown)
+		manager.StopTrigger(trigger)
+		assert.Equal(t, int64(0), manager.TotalSubscriptions())
+	})
+}
+
+func TestFieldEquals(t *testing.T) {
+	result := []byte(`{"data":{"id":1,"name":"foo","tags":["a","b"]}}`)
+
+	assert.True(t, FieldEquals([]string{"data", "id"}, []byte("1"))(result))
+	assert.True(t, FieldEquals([]string{"data", "name"}, []byte("foo"))(result))
+	assert.True(t, FieldEquals([]string{"data", "tags"}, []byte("b"))(result))
+	assert.False(t, FieldEquals([]string{"data", "id"}, []byte("2"))(result))
+	assert.False(t, FieldEquals([]string{"data", "tags"}, []byte("c"))(result))
+	assert.False(t, FieldEquals([]string{"data", "missing"}, []byte("1"))(result))
+}
@@ -0,0 +1,300 @@
+package sse_subscription
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buger/jsonparser"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+)
+
+var (
+	ServerSentEvents = []byte("sse_stream")
+)
+
+const (
+	// DefaultReconnectInterval is used until the upstream sends a retry hint
+	DefaultReconnectInterval = 3 * time.Second
+
+	eventStreamContentType = "text/event-stream"
+)
+
+var (
+	// DefaultHttpClient doesn't use a timeout as event streams are long lived
+	DefaultHttpClient = &http.Client{}
+)
+
+// SSEStream subscribes to upstreams publishing Server-Sent Events (text/event-stream)
+// The input is a request input of the httpclient package, e.g. {"method":"POST","url":"...","body":{"query":"subscription {...}"}}
+// The data of "message" and "next" events is sent to the subscribers,
+// a "complete" event completes and an "error" event terminates the subscription.
+// Lost connections are reconnected with the Last-Event-ID header set to the id of the last event.
+type SSEStream struct {
+	client               *http.Client
+	reconnectInterval    time.Duration
+	maxReconnectAttempts int
+}
+
+type Option func(s *SSEStream)
+
+// WithHttpClient sets the client used for the event stream requests
+func WithHttpClient(client *http.Client) Option {
+	return func(s *SSEStream) {
+		s.client = client
+	}
+}
+
+// WithReconnectInterval sets the time to wait before reconnecting until the upstream sends a retry hint
+func WithReconnectInterval(interval time.Duration) Option {
+	return func(s *SSEStream) {
+		s.reconnectInterval = interval
+	}
+}
+
+// WithMaxReconnectAttempts limits the number of consecutive reconnect attempts
+// Zero means unlimited, a negative number disables reconnecting.
+// The subscription is terminated with subscription.ErrConnectionLost if no attempt succeeded.
+func WithMaxReconnectAttempts(attempts int) Option {
+	return func(s *SSEStream) {
+		s.maxReconnectAttempts = attempts
+	}
+}
+
+func New(options ...Option) *SSEStream {
+	stream := &SSEStream{
+		client:            DefaultHttpClient,
+		reconnectInterval: DefaultReconnectInterval,
+	}
+	for i := range options {
+		options[i](stream)
+	}
+	return stream
+}
+
+func (s *SSEStream) Start(input []byte, next chan<- []byte, stop <-chan struct{}) {
+	_ = s.StartWithError(input, next, stop)
+}
+
+func (s *SSEStream) StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn := &connection{
+		stream:            s,
+		input:             input,
+		next:              next,
+		stop:              stop,
+		reconnectInterval: s.reconnectInterval,
+	}
+
+	return conn.run(ctx)
+}
+
+func (s *SSEStream) UniqueIdentifier() []byte {
+	return ServerSentEvents
+}
+
+// connection holds the state of one event stream across reconnects
+type connection struct {
+	stream            *SSEStream
+	input             []byte
+	next              chan<- []byte
+	stop              <-chan struct{}
+	reconnectInterval time.Duration
+	attempts          int
+	// lastEventID is sent as Last-Event-ID header when reconnecting
+	lastEventID string
+	// lastEventIDBuffer is the id of the current event, it becomes the lastEventID once the event is dispatched
+	lastEventIDBuffer string
+}
+
+// event is a parsed event of the stream
+type event struct {
+	name string
+	data []byte
+}
+
+func (c *connection) run(ctx context.Context) error {
+	for {
+		done, err := c.subscribe(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if done {
+			return err
+		}
+
+		c.attempts++
+		if c.stream.maxReconnectAttempts < 0 || (c.stream.maxReconnectAttempts > 0 && c.attempts > c.stream.maxReconnectAttempts) {
+			return subscription.ErrConnectionLost
+		}
+
+		select {
+		case <-time.After(c.reconnectInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// subscribe opens the event stream and reads it until it ends
+// done is false if the connection got lost and should be reconnected.
+func (c *connection) subscribe(ctx context.Context) (done bool, err error) {
+	request, err := httpclient.NewRequest(ctx, c.input)
+	if err != nil {
+		return true, err
+	}
+
+	request.Header.Set("Accept", eventStreamContentType)
+	request.Header.Set("Cache-Control", "no-cache")
+	if request.ContentLength != 0 && request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.lastEventID != "" {
+		request.Header.Set("Last-Event-ID", c.lastEventID)
+	}
+
+	response, err := c.stream.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		// the upstream tells us to stop reconnecting
+		return true, nil
+	default:
+		return true, fmt.Errorf("unexpected upstream status code: %d", response.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType != eventStreamContentType {
+		return true, fmt.Errorf("unexpected upstream content type: %s", response.Header.Get("Content-Type"))
+	}
+
+	c.attempts = 0
+	return c.readEvents(response.Body)
+}
+
+func (c *connection) readEvents(body io.Reader) (done bool, err error) {
+	reader := bufio.NewReader(body)
+	current := event{}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// an incomplete event at the end of the stream is discarded
+			return false, err
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+		if len(line) == 0 {
+			done, err = c.dispatch(current)
+			if done {
+				return done, err
+			}
+			current = event{}
+			continue
+		}
+
+		c.parseLine(line, &current)
+	}
+}
+
+func (c *connection) parseLine(line []byte, current *event) {
+	if line[0] == ':' {
+		// comment, e.g. used as keepalive
+		return
+	}
+
+	field, value := line, []byte(nil)
+	if i := bytes.IndexByte(line, ':'); i != -1 {
+		field, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+	}
+
+	switch string(field) {
+	case "event":
+		current.name = string(value)
+	case "data":
+		current.data = append(current.data, value...)
+		current.data = append(current.data, '\n')
+	case "id":
+		if bytes.IndexByte(value, 0) == -1 {
+			c.lastEventIDBuffer = string(value)
+		}
+	case "retry":
+		millis, err := strconv.ParseUint(string(value), 10, 64)
+		if err == nil {
+			c.reconnectInterval = time.Duration(millis) * time.Millisecond
+		}
+	}
+}
+
+func (c *connection) dispatch(current event) (done bool, err error) {
+	c.lastEventID = c.lastEventIDBuffer
+	data := bytes.TrimSuffix(current.data, []byte("\n"))
+
+	switch current.name {
+	case "complete":
+		return true, nil
+	case "error":
+		return true, &subscription.UpstreamError{Errors: upstreamErrors(data)}
+	case "", "message", "next":
+		if len(current.data) == 0 {
+			return false, nil
+		}
+		select {
+		case c.next <- data:
+			return false, nil
+		case <-c.stop:
+			return true, nil
+		}
+	default:
+		// unknown events are ignored
+		return false, nil
+	}
+}
+
+// upstreamErrors returns the data of an error event as JSON array of GraphQL errors
+func upstreamErrors(data []byte) []byte {
+	value, dataType, _, err := jsonparser.Get(data)
+	if err != nil {
+		dataType = jsonparser.String
+		value = data
+	}
+	switch dataType {
+	case jsonparser.Array:
+		return value
+	case jsonparser.Object:
+		if graphQLErrors, _, _, err := jsonparser.Get(value, "errors"); err == nil {
+			return graphQLErrors
+		}
+		return append(append([]byte("["), value...), ']')
+	default:
+		if len(value) == 0 {
+			return []byte(`[{"message":"upstream error"}]`)
+		}
+		errorMessage, _ := json.Marshal(string(value))
+		return []byte(`[{"message":` + string(errorMessage) + `}]`)
+	}
+}
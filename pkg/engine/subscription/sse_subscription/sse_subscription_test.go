@@ -0,0 +1,192 @@
+package sse_subscription
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+)
+
+func eventStream(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, connection int64)) *httptest.Server {
+	connections := &atomic.Int64{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		handler(w, r, connections.Inc())
+		w.(http.Flusher).Flush()
+	}))
+}
+
+type result struct {
+	data []string
+	err  error
+}
+
+func start(stream *SSEStream, input []byte, stop chan struct{}) <-chan result {
+	next := make(chan []byte)
+	done := make(chan result)
+	go func() {
+		var data []string
+		finished := make(chan error)
+		go func() {
+			finished <- stream.StartWithError(input, next, stop)
+		}()
+		for {
+			select {
+			case message := <-next:
+				data = append(data, string(message))
+			case err := <-finished:
+				done <- result{data: data, err: err}
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func await(t *testing.T, done <-chan result) result {
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatal("stream didn't end")
+		return result{}
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	t.Run("events", func(t *testing.T) {
+		server := eventStream(t, func(w http.ResponseWriter, r *http.Request, connection int64) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, `{"query":"subscription{counter}"}`, string(body))
+
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+			_, _ = fmt.Fprint(w, "event: next\ndata: {\"data\":{\"counter\":1}}\n\n")
+			_, _ = fmt.Fprint(w, "data: {\"data\":\r\ndata: {\"counter\":2}}\r\n\r\n")
+			_, _ = fmt.Fprint(w, "event: unknown\ndata: ignored\n\n")
+			_, _ = fmt.Fprint(w, "data:{\"data\":{\"counter\":3}}\n\n")
+			_, _ = fmt.Fprint(w, "event: complete\ndata:\n\n")
+			_, _ = fmt.Fprint(w, "data: {\"data\":{\"counter\":4}}\n\n")
+		})
+		defer server.Close()
+
+		input := httpclient.SetInputURL(nil, []byte(server.URL))
+		input = httpclient.SetInputMethod(input, []byte("POST"))
+		input = httpclient.SetInputBodyWithPath(input, []byte(`subscription{counter}`), "query")
+		input = httpclient.SetInputHeader(input, []byte(`{"X-Foo":["bar"]}`))
+
+		res := await(t, start(New(), input, make(chan struct{})))
+		assert.NoError(t, res.err)
+		assert.Equal(t, []string{
+			`{"data":{"counter":1}}`,
+			"{\"data\":\n{\"counter\":2}}",
+			`{"data":{"counter":3}}`,
+		}, res.data)
+	})
+
+	t.Run("reconnect with last event id and retry hint", func(t *testing.T) {
+		server := eventStream(t, func(w http.ResponseWriter, r *http.Request, connection int64) {
+			switch connection {
+			case 1:
+				assert.Equal(t, "", r.Header.Get("Last-Event-ID"))
+				_, _ = fmt.Fprint(w, "retry: 10\nid: 1\ndata: 1\n\n")
+				_, _ = fmt.Fprint(w, "id: 2\ndata: incomplete")
+			case 2:
+				assert.Equal(t, "1", r.Header.Get("Last-Event-ID"))
+				_, _ = fmt.Fprint(w, "id: 2\ndata: 2\n\n")
+				_, _ = fmt.Fprint(w, "event: complete\n\n")
+			}
+		})
+		defer server.Close()
+
+		input := httpclient.SetInputURL(nil, []byte(server.URL))
+
+		started := time.Now()
+		res := await(t, start(New(WithReconnectInterval(time.Minute)), input, make(chan struct{})))
+		assert.NoError(t, res.err)
+		assert.Equal(t, []string{"1", "2"}, res.data)
+		assert.True(t, time.Since(started) < time.Minute)
+	})
+
+	t.Run("error event", func(t *testing.T) {
+		server := eventStream(t, func(w http.ResponseWriter, r *http.Request, connection int64) {
+			_, _ = fmt.Fprint(w, "data: 1\n\n")
+			_, _ = fmt.Fprint(w, "event: error\ndata: [{\"message\":\"unauthorized\"}]\n\n")
+		})
+		defer server.Close()
+
+		res := await(t, start(New(), httpclient.SetInputURL(nil, []byte(server.URL)), make(chan struct{})))
+		assert.Equal(t, []string{"1"}, res.data)
+		assert.Equal(t, &subscription.UpstreamError{Errors: []byte(`[{"message":"unauthorized"}]`)}, res.err)
+	})
+
+	t.Run("no content stops reconnecting", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		res := await(t, start(New(), httpclient.SetInputURL(nil, []byte(server.URL)), make(chan struct{})))
+		assert.NoError(t, res.err)
+		assert.Nil(t, res.data)
+	})
+
+	t.Run("unexpected status code", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		res := await(t, start(New(), httpclient.SetInputURL(nil, []byte(server.URL)), make(chan struct{})))
+		require.Error(t, res.err)
+		assert.Equal(t, "unexpected upstream status code: 500", res.err.Error())
+	})
+
+	t.Run("give up reconnecting", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		stream := New(WithReconnectInterval(time.Millisecond), WithMaxReconnectAttempts(2))
+		res := await(t, start(stream, httpclient.SetInputURL(nil, []byte(server.URL)), make(chan struct{})))
+		assert.Equal(t, subscription.ErrConnectionLost, res.err)
+		assert.Nil(t, res.data)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		server := eventStream(t, func(w http.ResponseWriter, r *http.Request, connection int64) {
+			_, _ = fmt.Fprint(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		})
+		defer server.Close()
+
+		stop := make(chan struct{})
+		done := start(New(), httpclient.SetInputURL(nil, []byte(server.URL)), stop)
+		time.Sleep(time.Millisecond * 50)
+		close(stop)
+
+		res := await(t, done)
+		assert.NoError(t, res.err)
+		assert.Equal(t, []string{"1"}, res.data)
+	})
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	assert.Equal(t, `[{"message":"a"}]`, string(upstreamErrors([]byte(`[{"message":"a"}]`))))
+	assert.Equal(t, `[{"message":"a"}]`, string(upstreamErrors([]byte(`{"message":"a"}`))))
+	assert.Equal(t, `[{"message":"a"}]`, string(upstreamErrors([]byte(`{"errors":[{"message":"a"}]}`))))
+	assert.Equal(t, `[{"message":"forbidden"}]`, string(upstreamErrors([]byte(`forbidden`))))
+	assert.Equal(t, `[{"message":"upstream error"}]`, string(upstreamErrors(nil)))
+}
@@ -1,5 +1,9 @@
 package subscription
 
+import (
+	"context"
+)
+
 type Stream interface {
 	Start(input []byte, next chan<- []byte, stop <-chan struct{})
 	// UniqueIdentifier gives each stream a name, e.g. "kafka", "nats", "http-polling"
@@ -7,3 +11,21 @@ type Stream interface {
 	// This value should be static and the same for streams of the same kind
 	UniqueIdentifier() []byte
 }
+
+// TerminatingStream is a Stream which reports why it ended
+// The Manager prefers StartWithError over Start if a Stream implements it.
+type TerminatingStream interface {
+	Stream
+	// StartWithError behaves like Start but returns the terminal event of the stream
+	// Returning nil before stop was closed means the upstream completed the subscription,
+	// an error, e.g. an *UpstreamError or ErrConnectionLost, gets forwarded to all subscribers.
+	StartWithError(input []byte, next chan<- []byte, stop <-chan struct{}) error
+}
+
+// ShutdownStream is a Stream holding resources shared by its subscriptions, e.g. upstream connections
+// Manager.Shutdown calls Shutdown once all subscriptions of the stream got stopped.
+type ShutdownStream interface {
+	Stream
+	// Shutdown releases the resources of the stream gracefully until the context is done
+	Shutdown(ctx context.Context) error
+}
@@ -0,0 +1,35 @@
+package subscription
+
+import (
+	"github.com/buger/jsonparser"
+	"github.com/tidwall/sjson"
+)
+
+// TRACEPARENT is the key of the W3C traceparent of the subscriber starting a trigger in the trigger input
+// It isn't part of the identity of a trigger, subscribers with different traceparents share the same stream.
+const TRACEPARENT = "traceparent"
+
+// SetInputTraceparent adds the traceparent to the trigger input, streams may propagate it upstream
+func SetInputTraceparent(input []byte, traceparent string) []byte {
+	out, err := sjson.SetBytes(input, TRACEPARENT, traceparent)
+	if err != nil {
+		return input
+	}
+	return out
+}
+
+// InputTraceparent returns the traceparent of the trigger input, if any
+func InputTraceparent(input []byte) string {
+	traceparent, _ := jsonparser.GetString(input, TRACEPARENT)
+	return traceparent
+}
+
+// withoutTraceparent returns the trigger input without the traceparent, the input is left unchanged
+func withoutTraceparent(input []byte) []byte {
+	if _, _, _, err := jsonparser.Get(input, TRACEPARENT); err != nil {
+		return input
+	}
+	out := make([]byte, len(input))
+	copy(out, input)
+	return jsonparser.Delete(out, TRACEPARENT)
+}
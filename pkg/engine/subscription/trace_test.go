@@ -0,0 +1,23 @@
+package subscription
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputTraceparent(t *testing.T) {
+	input := []byte(`{"url":"wss://users.service","body":{"query":"subscription{updated}"}}`)
+	first := SetInputTraceparent(input, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	second := SetInputTraceparent(input, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b8-01")
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", InputTraceparent(first))
+	assert.Equal(t, "", InputTraceparent(input))
+
+	t.Run("triggers with different traceparents share the subscription", func(t *testing.T) {
+		manager := NewManager(&FakeStream{})
+		assert.Equal(t, manager.subscriptionID(input), manager.subscriptionID(first))
+		assert.Equal(t, manager.subscriptionID(first), manager.subscriptionID(second))
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", InputTraceparent(first), "the input must not be changed")
+	})
+}
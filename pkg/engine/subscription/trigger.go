@@ -5,21 +5,41 @@ import (
 )
 
 func NewTrigger(subscriptionID uint64) Trigger {
-	return Trigger{
+	return newTrigger(subscriptionID, 0, nil) // unbuffered channel
+}
+
+func newTrigger(subscriptionID uint64, bufferSize int, filter Filter) Trigger {
+	trigger := Trigger{
 		subscriptionID: subscriptionID,
-		results:        make(chan []byte), // unbuffered channel
+		results:        make(chan []byte, bufferSize),
+		terminal:       &terminal{},
+	}
+	if filter != nil {
+		trigger.filter = &filter
 	}
+	return trigger
 }
 
 type Trigger struct {
 	subscriptionID uint64
 	results        chan []byte
+	terminal       *terminal
+	// filter is a pointer to keep the Trigger comparable
+	filter *Filter
+}
+
+// terminal is set before the results channel of a trigger gets closed
+type terminal struct {
+	terminated bool
+	err        error
 }
 
 func (h *Trigger) SubscriptionID() uint64 {
 	return h.subscriptionID
 }
 
+// Next returns the next result of the subscription
+// ok is false if the context is done or the subscription was terminated by the stream.
 func (h *Trigger) Next(ctx context.Context) (data []byte, ok bool) {
 	done := ctx.Done()
 	select {
@@ -29,3 +49,24 @@ func (h *Trigger) Next(ctx context.Context) (data []byte, ok bool) {
 		return result, ok
 	}
 }
+
+// Terminated returns true if the stream terminated the subscription and the error it terminated with
+// A nil error means the upstream completed the subscription.
+// It must only be called after Next returned not ok while the context was not done.
+func (h *Trigger) Terminated() (terminated bool, err error) {
+	return h.terminal.terminated, h.terminal.err
+}
+
+// matches returns true if the result passes the filter of the trigger
+func (h *Trigger) matches(result []byte) bool {
+	if h.filter == nil {
+		return true
+	}
+	return (*h.filter)(result)
+}
+
+func (h *Trigger) terminate(err error) {
+	h.terminal.terminated = true
+	h.terminal.err = err
+	close(h.results)
+}
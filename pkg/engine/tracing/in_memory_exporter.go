@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// SpanData is an ended span recorded by the InMemoryExporter
+type SpanData struct {
+	Name        string
+	SpanContext SpanContext
+	// Parent is the span context of the parent span, it is invalid for root spans
+	Parent     SpanContext
+	Attributes map[string]interface{}
+	Errors     []error
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// Duration returns the time between the start and the end of the span
+func (s SpanData) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// InMemoryExporter is a Tracer which records all ended spans in memory, e.g. to assert on them in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns an InMemoryExporter without spans
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Start starts a span, it continues the trace of the parent span context or starts a new sampled trace
+func (e *InMemoryExporter) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	span := &inMemorySpan{
+		exporter: e,
+		data: SpanData{
+			Name:       name,
+			Parent:     parent,
+			Attributes: make(map[string]interface{}, len(attributes)),
+			StartTime:  time.Now(),
+		},
+	}
+	span.data.SpanContext.Sampled = true
+	if parent.IsValid() {
+		span.data.SpanContext.TraceID = parent.TraceID
+		span.data.SpanContext.Sampled = parent.Sampled
+	} else {
+		_, _ = rand.Read(span.data.SpanContext.TraceID[:])
+	}
+	_, _ = rand.Read(span.data.SpanContext.SpanID[:])
+	span.SetAttributes(attributes...)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Spans returns the ended spans in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make([]SpanData, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// SpansByName returns the ended spans with the name
+func (e *InMemoryExporter) SpansByName(name string) []SpanData {
+	var spans []SpanData
+	for _, span := range e.Spans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Reset drops all recorded spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+type inMemorySpan struct {
+	exporter *InMemoryExporter
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+func (s *inMemorySpan) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *inMemorySpan) SetAttributes(attributes ...Attribute) {
+	s.mu.Lock()
+	for _, attribute := range attributes {
+		s.data.Attributes[attribute.Key] = attribute.Value
+	}
+	s.mu.Unlock()
+}
+
+func (s *inMemorySpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Errors = append(s.data.Errors, err)
+	s.mu.Unlock()
+}
+
+func (s *inMemorySpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.exporter.mu.Lock()
+	s.exporter.spans = append(s.exporter.spans, data)
+	s.exporter.mu.Unlock()
+}
@@ -0,0 +1,183 @@
+// Package tracing defines the interfaces the engine traces the execution of operations with
+//
+// The interfaces mirror the OpenTelemetry tracing API so that an OpenTelemetry tracer can be plugged in with a thin adapter,
+// without adding OpenTelemetry as a dependency. Spans are propagated to upstreams with the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the name of the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// Tracer starts spans
+// Start must return a context carrying the new span, use ContextWithSpan to add it to the context.
+// The parent of the span is the span context returned by SpanContextFromContext.
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a single operation within a trace
+type Span interface {
+	// SpanContext returns the IDs of the span which get propagated to upstreams
+	SpanContext() SpanContext
+	// SetAttributes adds attributes to the span, attributes with the same key get replaced
+	SetAttributes(attributes ...Attribute)
+	// RecordError marks the span as failed
+	RecordError(err error)
+	// End completes the span, it must be called exactly once
+	End()
+}
+
+// Attribute is a key value pair describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// IsValid returns false for the all zero TraceID
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// IsValid returns false for the all zero SpanID
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext holds the IDs of a span
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true if both the TraceID and the SpanID are valid
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// Traceparent returns the value of the W3C traceparent header, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+// It returns an empty string for invalid span contexts.
+func (s SpanContext) Traceparent() string {
+	if !s.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses the value of a W3C traceparent header
+// It returns false if the value is malformed or contains invalid IDs.
+func ParseTraceparent(traceparent string) (SpanContext, bool) {
+	var spanContext SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext, false
+	}
+	// version 00 has exactly four fields, later versions may append fields
+	if parts[0] == "00" && len(parts) != 4 {
+		return spanContext, false
+	}
+	if _, err := hex.Decode(spanContext.TraceID[:], []byte(parts[1])); err != nil {
+		return spanContext, false
+	}
+	if _, err := hex.Decode(spanContext.SpanID[:], []byte(parts[2])); err != nil {
+		return spanContext, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return spanContext, false
+	}
+	spanContext.Sampled = flags[0]&1 == 1
+	return spanContext, spanContext.IsValid()
+}
+
+type spanKey struct{}
+
+type remoteSpanContextKey struct{}
+
+// ContextWithSpan returns a context carrying the span
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span of the context, if any
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context carrying the span context of a caller, e.g. extracted from its traceparent header
+// Spans started with the context become children of the remote span.
+func ContextWithRemoteSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, spanContext)
+}
+
+// SpanContextFromContext returns the span context of the span of the context or the remote span context
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	spanContext, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return spanContext
+}
+
+// Extract adds the span context of the traceparent header to the context
+// The context is returned unchanged if the header is missing or malformed.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	spanContext, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, spanContext)
+}
+
+// Traceparent returns the traceparent header value for the span context of the context
+// It returns an empty string if the context carries no valid span context.
+func Traceparent(ctx context.Context) string {
+	return SpanContextFromContext(ctx).Traceparent()
+}
+
+// Inject sets the traceparent header for the span context of the context
+func Inject(ctx context.Context, header http.Header) {
+	if traceparent := Traceparent(ctx); traceparent != "" {
+		header.Set(TraceparentHeader, traceparent)
+	}
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Run("should parse and print a valid traceparent", func(t *testing.T) {
+		spanContext, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		require.True(t, ok)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", spanContext.SpanID.String())
+		assert.True(t, spanContext.Sampled)
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", spanContext.Traceparent())
+	})
+
+	t.Run("should accept future versions with additional fields", func(t *testing.T) {
+		spanContext, ok := ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-the-future-will-be-like")
+		require.True(t, ok)
+		assert.False(t, spanContext.Sampled)
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", spanContext.Traceparent())
+	})
+
+	t.Run("should reject malformed traceparents", func(t *testing.T) {
+		for _, traceparent := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		} {
+			_, ok := ParseTraceparent(traceparent)
+			assert.False(t, ok, traceparent)
+		}
+	})
+
+	t.Run("should not print invalid span contexts", func(t *testing.T) {
+		assert.Equal(t, "", SpanContext{}.Traceparent())
+	})
+}
+
+func TestInMemoryExporter(t *testing.T) {
+	exporter := NewInMemoryExporter()
+
+	t.Run("should record spans with their parents", func(t *testing.T) {
+		exporter.Reset()
+		ctx, root := exporter.Start(context.Background(), "root", String("operation", "Hero"))
+		childCtx, child := exporter.Start(ctx, "child")
+		child.SetAttributes(Int("size", 42), Bool("shared", false))
+		child.RecordError(errors.New("failed"))
+		child.End()
+		child.End()
+		root.End()
+
+		assert.Equal(t, child, SpanFromContext(childCtx))
+		assert.Equal(t, child.SpanContext().Traceparent(), Traceparent(childCtx))
+
+		spans := exporter.Spans()
+		require.Len(t, spans, 2)
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, "root", spans[1].Name)
+		assert.Equal(t, root.SpanContext(), spans[0].Parent)
+		assert.Equal(t, root.SpanContext().TraceID, spans[0].SpanContext.TraceID)
+		assert.False(t, spans[1].Parent.IsValid())
+		assert.Equal(t, map[string]interface{}{"size": 42, "shared": false}, spans[0].Attributes)
+		assert.Equal(t, map[string]interface{}{"operation": "Hero"}, spans[1].Attributes)
+		assert.Equal(t, []error{errors.New("failed")}, spans[0].Errors)
+		assert.True(t, spans[0].Duration() >= 0)
+		assert.Len(t, exporter.SpansByName("root"), 1)
+	})
+
+	t.Run("should continue the trace of the caller", func(t *testing.T) {
+		exporter.Reset()
+		header := http.Header{}
+		header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		ctx, span := exporter.Start(Extract(context.Background(), header), "root")
+		span.End()
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", exporter.Spans()[0].Parent.SpanID.String())
+
+		upstream := http.Header{}
+		Inject(ctx, upstream)
+		assert.Equal(t, span.SpanContext().Traceparent(), upstream.Get(TraceparentHeader))
+	})
+
+	t.Run("should not propagate without span", func(t *testing.T) {
+		header := http.Header{}
+		Inject(Extract(context.Background(), http.Header{}), header)
+		assert.Empty(t, header)
+	})
+}
@@ -80,15 +80,67 @@ func (o OperationValidationErrors) ErrorByIndex(i int) error {
 }
 
 type OperationValidationError struct {
-	Message   string          `json:"message"`
-	Locations []ErrorLocation `json:"locations,omitempty"`
-	Path      ErrorPath       `json:"path,omitempty"`
+	Message    string           `json:"message"`
+	Locations  []ErrorLocation  `json:"locations,omitempty"`
+	Path       ErrorPath        `json:"path,omitempty"`
+	Extensions *ErrorExtensions `json:"extensions,omitempty"`
 }
 
 func (o OperationValidationError) Error() string {
 	return fmt.Sprintf("%s, locations: %+v, path: %s", o.Message, o.Locations, o.Path.String())
 }
 
+// ExecutionErrors are returned by ExecutionEngineV2.Execute with an error policy if planning or resolving the operation failed
+type ExecutionErrors []ExecutionError
+
+func (e ExecutionErrors) Error() string {
+	if len(e) > 0 {
+		return e[0].Error()
+	}
+
+	return "no error"
+}
+
+func (e ExecutionErrors) WriteResponse(writer io.Writer) (n int, err error) {
+	response := Response{
+		Errors: e,
+	}
+
+	responseBytes, err := response.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Write(responseBytes)
+}
+
+func (e ExecutionErrors) Count() int {
+	return len(e)
+}
+
+func (e ExecutionErrors) ErrorByIndex(i int) error {
+	if i >= e.Count() {
+		return nil
+	}
+
+	return e[i]
+}
+
+type ExecutionError struct {
+	Message    string           `json:"message"`
+	Extensions *ErrorExtensions `json:"extensions,omitempty"`
+}
+
+func (e ExecutionError) Error() string {
+	return e.Message
+}
+
+// ErrorExtensions hold the stable code and the correlation ID added to errors by the error policy
+type ErrorExtensions struct {
+	Code          string `json:"code,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
 type SchemaValidationErrors []SchemaValidationError
 
 func schemaValidationErrorsFromOperationReport(report operationreport.Report) (errors SchemaValidationErrors) {
@@ -30,12 +30,17 @@ type roundTripperTestCase struct {
 	expectedBody     string
 	sendStatusCode   int
 	sendResponseBody string
+	sendHeader       http.Header
+	expectedHeader   http.Header
 }
 
 func createTestRoundTripper(t *testing.T, testCase roundTripperTestCase) testRoundTripper {
 	return func(req *http.Request) *http.Response {
 		assert.Equal(t, testCase.expectedHost, req.URL.Host)
 		assert.Equal(t, testCase.expectedPath, req.URL.Path)
+		for name := range testCase.expectedHeader {
+			assert.Equal(t, testCase.expectedHeader[name], req.Header[name])
+		}
 
 		if len(testCase.expectedBody) > 0 {
 			var receivedBodyBytes []byte
@@ -48,7 +53,7 @@ func createTestRoundTripper(t *testing.T, testCase roundTripperTestCase) testRou
 		}
 
 		body := bytes.NewBuffer([]byte(testCase.sendResponseBody))
-		return &http.Response{StatusCode: testCase.sendStatusCode, Body: ioutil.NopCloser(body)}
+		return &http.Response{StatusCode: testCase.sendStatusCode, Header: testCase.sendHeader, Body: ioutil.NopCloser(body)}
 	}
 }
 
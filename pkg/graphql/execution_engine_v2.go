@@ -10,16 +10,21 @@ import (
 
 	"github.com/jensneuse/abstractlogger"
 
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/operationreport"
 	"github.com/jensneuse/graphql-go-tools/pkg/postprocess"
 )
 
 type EngineV2Configuration struct {
-	schema        *Schema
-	plannerConfig plan.Configuration
+	schema            *Schema
+	plannerConfig     plan.Configuration
+	headerPropagation []resolve.HeaderPropagationRule
+	errorPolicy       *resolve.ErrorPolicy
+	tracer            tracing.Tracer
 }
 
 func NewEngineV2Configuration(schema *Schema) EngineV2Configuration {
@@ -49,6 +54,27 @@ func (e *EngineV2Configuration) SetFieldConfigurations(fieldConfigs plan.FieldCo
 	e.plannerConfig.Fields = fieldConfigs
 }
 
+// SetHeaderForwardingRules forwards client request headers to the upstreams of all data sources without own rules
+func (e *EngineV2Configuration) SetHeaderForwardingRules(rules resolve.HeaderForwardingRules) {
+	e.plannerConfig.DefaultHeaderForwarding = &rules
+}
+
+// SetClientCache shares the http clients built from the transports of data sources with other engines
+func (e *EngineV2Configuration) SetClientCache(clientCache *httpclient.ClientCache) {
+	e.plannerConfig.ClientCache = clientCache
+}
+
+// SetHeaderPropagationRules selects the upstream response headers which get merged into the header passed with WithResponseHeader
+func (e *EngineV2Configuration) SetHeaderPropagationRules(rules []resolve.HeaderPropagationRule) {
+	e.headerPropagation = rules
+}
+
+// SetErrorPolicy classifies, masks and reports the errors of all operations
+// Without OnError hook the errors get logged by the logger of the engine.
+func (e *EngineV2Configuration) SetErrorPolicy(policy resolve.ErrorPolicy) {
+	e.errorPolicy = &policy
+}
+
 type EngineResultWriter struct {
 	buf           *bytes.Buffer
 	flushCallback func(data []byte)
@@ -113,6 +139,8 @@ func (e *EngineResultWriter) AsHTTPResponse(status int, headers http.Header) *ht
 type internalExecutionContext struct {
 	resolveContext *resolve.Context
 	postProcessor  *postprocess.Processor
+	responseHeader http.Header
+	liveQueries    bool
 }
 
 func newInternalExecutionContext() *internalExecutionContext {
@@ -142,6 +170,8 @@ func (e *internalExecutionContext) setVariables(variables []byte) {
 
 func (e *internalExecutionContext) reset() {
 	e.resolveContext.Free()
+	e.responseHeader = nil
+	e.liveQueries = false
 }
 
 type ExecutionEngineV2 struct {
@@ -152,6 +182,9 @@ type ExecutionEngineV2 struct {
 	internalExecutionContextPool sync.Pool
 }
 
+// ErrLiveQueryNotSupported rejects live queries of callers which can't stream results
+var ErrLiveQueryNotSupported = errors.New("live queries are only supported over websockets and server-sent events")
+
 type ExecutionOptionsV2 func(ctx *internalExecutionContext)
 
 func WithBeforeFetchHook(hook resolve.BeforeFetchHook) ExecutionOptionsV2 {
@@ -166,6 +199,22 @@ func WithAfterFetchHook(hook resolve.AfterFetchHook) ExecutionOptionsV2 {
 	}
 }
 
+// WithResponseHeader merges the upstream response headers selected by the header propagation rules into header
+// The headers get merged before the response is written, so the header of a http.ResponseWriter can be passed as is.
+func WithResponseHeader(header http.Header) ExecutionOptionsV2 {
+	return func(ctx *internalExecutionContext) {
+		ctx.responseHeader = header
+	}
+}
+
+// WithLiveQueries allows live queries, for transports which stream the results until the context is done
+// Without it live queries are rejected with ErrLiveQueryNotSupported, as they never return a single result.
+func WithLiveQueries() ExecutionOptionsV2 {
+	return func(ctx *internalExecutionContext) {
+		ctx.liveQueries = true
+	}
+}
+
 func NewExecutionEngineV2WithTriggerManagers(logger abstractlogger.Logger, engineConfig EngineV2Configuration, closer <- chan struct{}, triggerManagers ...*subscription.Manager) (*ExecutionEngineV2, error) {
 	executionEngine, err := NewExecutionEngineV2(logger, engineConfig, closer)
 	if err != nil {
@@ -180,6 +229,23 @@ func NewExecutionEngineV2WithTriggerManagers(logger abstractlogger.Logger, engin
 }
 
 func NewExecutionEngineV2(logger abstractlogger.Logger, engineConfig EngineV2Configuration, closer <- chan struct{}) (*ExecutionEngineV2, error) {
+	if engineConfig.plannerConfig.ClientCache == nil {
+		engineConfig.plannerConfig.ClientCache = httpclient.NewClientCache()
+	}
+	if engineConfig.errorPolicy != nil && engineConfig.errorPolicy.OnError == nil {
+		policy := *engineConfig.errorPolicy
+		policy.OnError = func(ctx context.Context, err resolve.ReportedError) {
+			logger.Error("ExecutionEngineV2.Execute",
+				abstractlogger.String("category", string(err.Category)),
+				abstractlogger.String("correlationId", err.CorrelationID),
+				abstractlogger.String("message", err.Message),
+				abstractlogger.ByteString("path", err.Path),
+				abstractlogger.String("dataSource", err.DataSource),
+				abstractlogger.Error(err.Err),
+			)
+		}
+		engineConfig.errorPolicy = &policy
+	}
 	return &ExecutionEngineV2{
 		logger: logger,
 		config: engineConfig,
@@ -201,50 +267,189 @@ func (e *ExecutionEngineV2) WithTriggerManager(subManager *subscription.Manager)
 	e.resolver.RegisterTriggerManager(subManager)
 }
 
-func (e *ExecutionEngineV2) Execute(ctx context.Context, operation *Request, writer resolve.FlushWriter, options ...ExecutionOptionsV2) error {
+// Shutdown completes all subscriptions of the registered trigger managers and closes their upstreams gracefully
+// It returns once all upstreams got closed or the context is done.
+func (e *ExecutionEngineV2) Shutdown(ctx context.Context) error {
+	defer e.config.plannerConfig.ClientCache.CloseIdleConnections()
+	return e.resolver.ShutdownTriggerManagers(ctx)
+}
+
+// InvalidateLiveQueries resolves all running live queries again which depend on one of the keys
+// Live queries depend on the coordinates of their root fields, e.g. "Query.orders".
+func (e *ExecutionEngineV2) InvalidateLiveQueries(keys ...string) {
+	e.resolver.InvalidateLiveQueries(keys...)
+}
+
+func (e *ExecutionEngineV2) Execute(ctx context.Context, operation *Request, writer resolve.FlushWriter, options ...ExecutionOptionsV2) (err error) {
+	if len(operation.uploads) != 0 {
+		ctx = httpclient.InjectFiles(ctx, operation.uploadFiles())
+	}
+	if operation.request.Header != nil {
+		ctx = httpclient.InjectClientRequestHeader(ctx, operation.request.Header)
+	}
+
+	if e.config.tracer != nil {
+		var span tracing.Span
+		ctx, span = e.startExecuteSpan(ctx, operation)
+		defer func() {
+			endSpan(span, err)
+		}()
+	}
+
+	execContext := e.getExecutionCtx()
+	defer e.putExecutionCtx(execContext)
+
+	execContext.setContext(ctx)
+	execContext.resolveContext.SetErrorPolicy(e.config.errorPolicy)
+	execContext.resolveContext.SetTracer(e.config.tracer)
+
 	if !operation.IsNormalized() {
-		result, err := operation.Normalize(e.config.schema)
+		err = e.stage(ctx, SpanNameParse, func(ctx context.Context) error {
+			result, err := normalizationResultFromReport(operation.parseQueryOnce())
+			return e.normalizationError(execContext, result, err)
+		})
 		if err != nil {
 			return err
 		}
 
-		if !result.Successful {
-			return result.Errors
+		err = e.stage(ctx, SpanNameNormalize, func(ctx context.Context) error {
+			result, err := operation.Normalize(e.config.schema)
+			return e.normalizationError(execContext, result, err)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	execContext := e.getExecutionCtx()
-	defer e.putExecutionCtx(execContext)
+	if !operation.isValid {
+		err = e.stage(ctx, SpanNameValidate, func(ctx context.Context) error {
+			result, err := operation.ValidateForSchema(e.config.schema)
+			if err != nil {
+				return e.executionError(execContext, err)
+			}
+			if !result.Valid {
+				return e.validationErrors(execContext, result.Errors)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
 	execContext.prepare(ctx, operation.Variables, operation.request)
 
 	for i := range options {
 		options[i](execContext)
 	}
+	execContext.resolveContext.SetHeaderPropagation(e.config.headerPropagation, execContext.responseHeader)
+
+	var planResult plan.Plan
+	err = e.stage(ctx, SpanNamePlan, func(ctx context.Context) error {
+		// Optimization: Hashing the operation and caching the postprocessed plan for
+		// this specific operation will improve perfomance significantly.
+		var report operationreport.Report
+		planner := e.plannerPool.Get().(*plan.Planner)
+		planResult = planner.Plan(&operation.document, &e.config.schema.document, operation.OperationName, &report)
+		e.plannerPool.Put(planner)
+		if report.HasErrors() {
+			return e.executionError(execContext, errors.New(report.Error()))
+		}
 
-	// Optimization: Hashing the operation and caching the postprocessed plan for
-	// this specific operation will improve perfomance significantly.
-	var report operationreport.Report
-	planner := e.plannerPool.Get().(*plan.Planner)
-	planResult := planner.Plan(&operation.document, &e.config.schema.document, operation.OperationName, &report)
-	e.plannerPool.Put(planner)
-	if report.HasErrors() {
-		return errors.New(report.Error())
+		planResult = execContext.postProcessor.Process(planResult)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	planResult = execContext.postProcessor.Process(planResult)
+	return e.stage(ctx, SpanNameResolve, func(ctx context.Context) error {
+		// fetches are traced as children of the resolve span
+		execContext.setContext(ctx)
+
+		var err error
+		switch p := planResult.(type) {
+		case *plan.SynchronousResponsePlan:
+			if p.LiveQuery != nil {
+				if !execContext.liveQueries {
+					return e.executionError(execContext, ErrLiveQueryNotSupported)
+				}
+				err = e.resolver.ResolveGraphQLLiveQuery(execContext.resolveContext, p.Response, p.LiveQuery, writer)
+				break
+			}
+			err = e.resolver.ResolveGraphQLResponse(execContext.resolveContext, p.Response, nil, writer)
+		case *plan.SubscriptionResponsePlan:
+			err = e.resolver.ResolveGraphQLSubscription(execContext.resolveContext, &p.Response, writer)
+		default:
+			return e.executionError(execContext, errors.New("execution of operation is not possible"))
+		}
+
+		if err != nil {
+			return e.executionError(execContext, err)
+		}
+		return nil
+	})
+}
+
+// ValidateOperation validates the operation against the schema of the engine
+// Unlike Request.ValidateForSchema it validates in a span if the engine has a tracer.
+// Execute validates operations itself, it skips the validation of operations which passed it already.
+func (e *ExecutionEngineV2) ValidateOperation(ctx context.Context, operation *Request) (result ValidationResult, err error) {
+	_ = e.stage(ctx, SpanNameValidate, func(ctx context.Context) error {
+		result, err = operation.ValidateForSchema(e.config.schema)
+		if err != nil {
+			return err
+		}
+		if !result.Valid {
+			return result.Errors
+		}
+		return nil
+	})
+	return result, err
+}
+
+// normalizationError returns the error of a failed parse or normalization
+func (e *ExecutionEngineV2) normalizationError(execContext *internalExecutionContext, result NormalizationResult, err error) error {
+	if err != nil {
+		return e.executionError(execContext, err)
+	}
+	if !result.Successful {
+		return e.validationErrors(execContext, result.Errors)
+	}
+	return nil
+}
 
-	var err error
-	switch p := planResult.(type) {
-	case *plan.SynchronousResponsePlan:
-		err = e.resolver.ResolveGraphQLResponse(execContext.resolveContext, p.Response, nil, writer)
-	case *plan.SubscriptionResponsePlan:
-		err = e.resolver.ResolveGraphQLSubscription(execContext.resolveContext, &p.Response, writer)
-	default:
-		return errors.New("execution of operation is not possible")
+// validationErrors adds the code and correlation ID of the error policy to the validation errors of the operation
+func (e *ExecutionEngineV2) validationErrors(execContext *internalExecutionContext, errs Errors) error {
+	validationErrors, ok := errs.(OperationValidationErrors)
+	if e.config.errorPolicy == nil || !ok {
+		return errs
+	}
+	for i := range validationErrors {
+		validationErrors[i].Message = execContext.resolveContext.ReportError(resolve.ErrorCategoryValidation, validationErrors[i].Message, nil)
+		validationErrors[i].Extensions = &ErrorExtensions{
+			Code:          resolve.ErrorCategoryValidation.Code(),
+			CorrelationID: execContext.resolveContext.CorrelationID(),
+		}
 	}
+	return validationErrors
+}
 
-	return err
+// executionError reports the error to the error policy and returns the error for the client
+func (e *ExecutionEngineV2) executionError(execContext *internalExecutionContext, err error) error {
+	if e.config.errorPolicy == nil {
+		return err
+	}
+	category := resolve.ClassifyError(execContext.resolveContext.Context, err)
+	return ExecutionErrors{
+		{
+			Message: execContext.resolveContext.ReportError(category, err.Error(), err),
+			Extensions: &ErrorExtensions{
+				Code:          category.Code(),
+				CorrelationID: execContext.resolveContext.CorrelationID(),
+			},
+		},
+	}
 }
 
 func (e *ExecutionEngineV2) getExecutionCtx() *internalExecutionContext {
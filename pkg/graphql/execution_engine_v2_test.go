@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -22,6 +23,7 @@ import (
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription/http_polling"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
 	"github.com/jensneuse/graphql-go-tools/pkg/starwars"
 )
 
@@ -957,12 +959,14 @@ func (b *beforeFetchHook) OnBeforeFetch(ctx resolve.HookContext, input []byte) {
 }
 
 type afterFetchHook struct {
-	data string
-	err  string
+	data     string
+	err      string
+	response *resolve.FetchResponse
 }
 
 func (a *afterFetchHook) OnData(ctx resolve.HookContext, output []byte, singleFlight bool) {
 	a.data += string(output)
+	a.response = ctx.Response
 }
 
 func (a *afterFetchHook) OnError(ctx resolve.HookContext, output []byte, singleFlight bool) {
@@ -989,6 +993,7 @@ func TestExecutionWithOptions(t *testing.T) {
 						expectedBody:     "",
 						sendResponseBody: `{"data":{"hero":{"name":"Luke Skywalker"}}}`,
 						sendStatusCode:   200,
+						sendHeader:       http.Header{"Cache-Control": {"max-age=60"}, "X-Internal": {"secret"}},
 					}),
 				},
 				Custom: graphql_datasource.ConfigJson(graphql_datasource.Configuration{
@@ -1006,6 +1011,9 @@ func TestExecutionWithOptions(t *testing.T) {
 	engineConf := NewEngineV2Configuration(testCase.schema)
 	engineConf.SetDataSources(testCase.dataSources)
 	engineConf.SetFieldConfigurations(testCase.fields)
+	engineConf.SetHeaderPropagationRules([]resolve.HeaderPropagationRule{
+		{Name: "Cache-Control", Algorithm: resolve.HeaderPropagationMostRestrictiveCacheControl},
+	})
 
 	engine, err := NewExecutionEngineV2(abstractlogger.Noop{}, engineConf, closer)
 	require.NoError(t, err)
@@ -1015,14 +1023,327 @@ func TestExecutionWithOptions(t *testing.T) {
 
 	operation := testCase.operation(t)
 	resultWriter := NewEngineResultWriter()
-	err = engine.Execute(context.Background(), &operation, &resultWriter, WithBeforeFetchHook(before), WithAfterFetchHook(after))
+	responseHeader := http.Header{}
+	err = engine.Execute(context.Background(), &operation, &resultWriter, WithBeforeFetchHook(before), WithAfterFetchHook(after), WithResponseHeader(responseHeader))
 
 	assert.Equal(t, `{"method":"GET","url":"https://example.com/","body":{"query":"{hero}"}}`, before.input)
 	assert.Equal(t, `{"hero":{"name":"Luke Skywalker"}}`, after.data)
 	assert.Equal(t, "", after.err)
+	require.NotNil(t, after.response)
+	assert.Equal(t, http.StatusOK, after.response.StatusCode)
+	assert.Equal(t, "secret", after.response.Header.Get("X-Internal"))
+	assert.Equal(t, http.Header{"Cache-Control": {"max-age=60"}}, responseHeader)
 	assert.NoError(t, err)
 }
 
+func TestExecutionEngineV2_HeaderForwarding(t *testing.T) {
+	closer := make(chan struct{})
+	defer close(closer)
+
+	engineConf := NewEngineV2Configuration(starwarsSchema(t))
+	engineConf.SetHeaderForwardingRules(resolve.HeaderForwardingRules{
+		Allow:  []string{"Authorization"},
+		Rename: map[string]string{"X-User": "X-Upstream-User"},
+		Inject: http.Header{"X-Gateway": {"graphql-go-tools"}},
+	})
+	engineConf.SetDataSources([]plan.DataSourceConfiguration{
+		{
+			RootNodes: []plan.TypeField{
+				{TypeName: "Query", FieldNames: []string{"hero"}},
+			},
+			Factory: &rest_datasource.Factory{
+				Client: testNetHttpClient(t, roundTripperTestCase{
+					expectedHost: "example.com",
+					expectedPath: "/",
+					expectedHeader: http.Header{
+						"Authorization":   {"Bearer token"},
+						"X-Upstream-User": {"jens"},
+						"X-Gateway":       {"graphql-go-tools"},
+						"Cookie":          nil,
+						"X-User":          nil,
+					},
+					sendResponseBody: `{"hero": {"name": "Luke Skywalker"}}`,
+					sendStatusCode:   200,
+				}),
+			},
+			Custom: rest_datasource.ConfigJSON(rest_datasource.Configuration{
+				Fetch: rest_datasource.FetchConfiguration{
+					URL:    "https://example.com/",
+					Method: "GET",
+				},
+			}),
+		},
+	})
+
+	engine, err := NewExecutionEngineV2(abstractlogger.Noop{}, engineConf, closer)
+	require.NoError(t, err)
+
+	operation := loadStarWarsQuery(starwars.FileSimpleHeroQuery, nil)(t)
+	operation.request.Header = http.Header{
+		"Authorization": {"Bearer token"},
+		"Cookie":        {"session=1"},
+		"X-User":        {"jens"},
+	}
+	resultWriter := NewEngineResultWriter()
+	err = engine.Execute(context.Background(), &operation, &resultWriter)
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":{"hero":{"name":"Luke Skywalker"}}}`, resultWriter.String())
+}
+
+func TestExecutionEngineV2_ErrorPolicy(t *testing.T) {
+	closer := make(chan struct{})
+	defer close(closer)
+
+	// the closed server makes the data source fail with a dial error
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	var reported []resolve.ReportedError
+	engineConf := NewEngineV2Configuration(starwarsSchema(t))
+	engineConf.SetErrorPolicy(resolve.ErrorPolicy{
+		MaskMessages: true,
+		CorrelationID: func(ctx context.Context) string {
+			return "abc"
+		},
+		OnError: func(ctx context.Context, err resolve.ReportedError) {
+			reported = append(reported, err)
+		},
+	})
+	engineConf.SetDataSources([]plan.DataSourceConfiguration{
+		{
+			RootNodes: []plan.TypeField{
+				{TypeName: "Query", FieldNames: []string{"hero"}},
+			},
+			Factory: &rest_datasource.Factory{
+				Client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient),
+			},
+			Custom: rest_datasource.ConfigJSON(rest_datasource.Configuration{
+				Fetch: rest_datasource.FetchConfiguration{
+					URL:    server.URL,
+					Method: "GET",
+				},
+			}),
+		},
+	})
+
+	engine, err := NewExecutionEngineV2(abstractlogger.Noop{}, engineConf, closer)
+	require.NoError(t, err)
+
+	t.Run("should mask errors of data sources", func(t *testing.T) {
+		reported = nil
+		operation := loadStarWarsQuery(starwars.FileSimpleHeroQuery, nil)(t)
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		require.NoError(t, err)
+		assert.Equal(t, `{"errors":[{"message":"internal server error","extensions":{"code":"INTERNAL_SERVER_ERROR","correlationId":"abc"}}],"data":{"hero":null}}`, resultWriter.String())
+		require.Len(t, reported, 1)
+		assert.Contains(t, reported[0].Message, server.Listener.Addr().String())
+	})
+
+	t.Run("should add code and correlation ID to validation errors", func(t *testing.T) {
+		reported = nil
+		operation := Request{Query: "{ hero {"}
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		require.Error(t, err)
+		validationErrors, ok := err.(OperationValidationErrors)
+		require.True(t, ok)
+		require.Len(t, validationErrors, 1)
+		assert.Equal(t, &ErrorExtensions{Code: "GRAPHQL_VALIDATION_FAILED", CorrelationID: "abc"}, validationErrors[0].Extensions)
+		require.Len(t, reported, 1)
+		assert.Equal(t, resolve.ErrorCategoryValidation, reported[0].Category)
+	})
+}
+
+func TestExecutionEngineV2_Tracing(t *testing.T) {
+	closer := make(chan struct{})
+	defer close(closer)
+
+	var upstreamTraceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte(`{"hero":{"name":"Luke Skywalker"}}`))
+	}))
+	defer server.Close()
+
+	exporter := tracing.NewInMemoryExporter()
+	engineConf := NewEngineV2Configuration(starwarsSchema(t))
+	engineConf.SetTracer(exporter)
+	engineConf.SetDataSources([]plan.DataSourceConfiguration{
+		{
+			ID: "heroes",
+			RootNodes: []plan.TypeField{
+				{TypeName: "Query", FieldNames: []string{"hero"}},
+			},
+			Factory: &rest_datasource.Factory{
+				Client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient),
+			},
+			Custom: rest_datasource.ConfigJSON(rest_datasource.Configuration{
+				Fetch: rest_datasource.FetchConfiguration{
+					URL:    server.URL,
+					Method: "GET",
+				},
+			}),
+		},
+	})
+
+	engine, err := NewExecutionEngineV2(abstractlogger.Noop{}, engineConf, closer)
+	require.NoError(t, err)
+
+	spanByName := func(t *testing.T, name string) tracing.SpanData {
+		spans := exporter.SpansByName(name)
+		require.Len(t, spans, 1, name)
+		return spans[0]
+	}
+
+	t.Run("should trace the stages and fetches of an operation", func(t *testing.T) {
+		exporter.Reset()
+		operation := loadStarWarsQuery(starwars.FileSimpleHeroQuery, nil)(t)
+		operation.SetHeader(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		require.NoError(t, err)
+		assert.Equal(t, `{"data":{"hero":{"name":"Luke Skywalker"}}}`, resultWriter.String())
+
+		execute := spanByName(t, SpanNameExecute)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", execute.SpanContext.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", execute.Parent.SpanID.String())
+		assert.Equal(t, map[string]interface{}{AttributeOperationName: operation.OperationName}, execute.Attributes)
+
+		for _, stage := range []string{SpanNameParse, SpanNameValidate, SpanNameNormalize, SpanNamePlan, SpanNameResolve} {
+			assert.Equal(t, execute.SpanContext, spanByName(t, stage).Parent, stage)
+		}
+
+		fetch := spanByName(t, "graphql.fetch")
+		assert.Equal(t, spanByName(t, SpanNameResolve).SpanContext, fetch.Parent)
+		assert.Equal(t, server.URL, fetch.Attributes[resolve.FetchAttributeURL])
+		assert.Equal(t, fetch.SpanContext.Traceparent(), upstreamTraceparent)
+	})
+
+	t.Run("should record errors of stages", func(t *testing.T) {
+		exporter.Reset()
+		operation := Request{Query: "{ hero {"}
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		require.Error(t, err)
+
+		assert.Equal(t, []error{err}, spanByName(t, SpanNameParse).Errors)
+		assert.Equal(t, []error{err}, spanByName(t, SpanNameExecute).Errors)
+		assert.Empty(t, exporter.SpansByName(SpanNamePlan))
+
+		exporter.Reset()
+		operation = Request{Query: "{ droid { name } }"}
+		err = engine.Execute(context.Background(), &operation, &resultWriter)
+		require.Error(t, err)
+
+		assert.Equal(t, []error{err}, spanByName(t, SpanNameValidate).Errors)
+		assert.Empty(t, exporter.SpansByName(SpanNamePlan))
+	})
+
+	t.Run("should not validate operations twice", func(t *testing.T) {
+		exporter.Reset()
+		operation := loadStarWarsQuery(starwars.FileSimpleHeroQuery, nil)(t)
+
+		validation, err := engine.ValidateOperation(context.Background(), &operation)
+		require.NoError(t, err)
+		require.True(t, validation.Valid)
+
+		resultWriter := NewEngineResultWriter()
+		err = engine.Execute(context.Background(), &operation, &resultWriter)
+		require.NoError(t, err)
+
+		assert.False(t, spanByName(t, SpanNameValidate).Parent.IsValid())
+	})
+
+	t.Run("should trace fetches with the ID of the data source", func(t *testing.T) {
+		exporter.Reset()
+		operation := loadStarWarsQuery(starwars.FileSimpleHeroQuery, nil)(t)
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		require.NoError(t, err)
+
+		assert.Equal(t, "heroes", spanByName(t, "graphql.fetch").Attributes[resolve.FetchAttributeDataSource])
+	})
+}
+
+func TestExecutionEngineV2_LiveQuery(t *testing.T) {
+	schema, err := NewSchemaFromString(`type Query { hello: String }`)
+	require.NoError(t, err)
+
+	engineConf := NewEngineV2Configuration(schema)
+	engineConf.SetDataSources([]plan.DataSourceConfiguration{
+		{
+			RootNodes: []plan.TypeField{
+				{TypeName: "Query", FieldNames: []string{"hello"}},
+			},
+			Factory: &staticdatasource.Factory{},
+			Custom: staticdatasource.ConfigJSON(staticdatasource.Configuration{
+				Data: "world",
+			}),
+		},
+	})
+	engineConf.SetFieldConfigurations([]plan.FieldConfiguration{
+		{
+			TypeName:              "Query",
+			FieldName:             "hello",
+			DisableDefaultMapping: true,
+		},
+	})
+
+	closer := make(chan struct{})
+	defer close(closer)
+	engine, err := NewExecutionEngineV2(abstractlogger.NoopLogger, engineConf, closer)
+	require.NoError(t, err)
+
+	flushed := make(chan string, 8)
+	resultWriter := NewEngineResultWriter()
+	resultWriter.SetFlushCallback(func(data []byte) {
+		flushed <- string(data)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		operation := Request{Query: `query Hello @live { hello }`, OperationName: "Hello"}
+		done <- engine.Execute(ctx, &operation, &resultWriter, WithLiveQueries())
+	}()
+
+	select {
+	case result := <-flushed:
+		assert.Equal(t, `{"data":{"hello":"world"}}`, result)
+	case <-time.After(time.Second):
+		t.Fatal("live query didn't flush its initial result")
+	}
+
+	// the result doesn't change, so invalidating it doesn't push anything
+	engine.InvalidateLiveQueries("Query.hello")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("live query didn't stop")
+	}
+	assert.Len(t, flushed, 0)
+
+	t.Run("live queries are valid", func(t *testing.T) {
+		operation := Request{Query: `query Hello @live(pollingIntervalMillis: 1000) { hello }`, OperationName: "Hello"}
+		result, err := operation.ValidateForSchema(schema)
+		require.NoError(t, err)
+		assert.True(t, result.Valid, "%v", result.Errors)
+	})
+
+	t.Run("live queries are rejected without streaming transport", func(t *testing.T) {
+		operation := Request{Query: `query Hello @live { hello }`, OperationName: "Hello"}
+		resultWriter := NewEngineResultWriter()
+		err := engine.Execute(context.Background(), &operation, &resultWriter)
+		assert.Equal(t, ErrLiveQueryNotSupported, err)
+		assert.Equal(t, 0, resultWriter.Len())
+	})
+}
+
 func BenchmarkExecutionEngineV2(b *testing.B) {
 
 	closer := make(chan struct{})
@@ -149,7 +149,8 @@ func (f *EngineConfigV2Factory) engineConfigDataSources() (planDataSources []pla
 		planDataSource.RootNodes, planDataSource.ChildNodes = extractor.GetAllNodes()
 
 		factory := &graphqlDataSource.Factory{}
-		if f.httpClient != nil {
+		// data sources with own transport get a client from the client cache of the engine
+		if f.httpClient != nil && dataSourceConfig.Transport == nil {
 			factory.Client = httpclient.NewNetHttpClient(f.httpClient)
 		}
 		planDataSource.Factory = factory
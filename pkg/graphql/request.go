@@ -41,7 +41,9 @@ type Request struct {
 	document     ast.Document
 	isParsed     bool
 	isNormalized bool
+	isValid      bool
 	request      resolve.Request
+	uploads      map[string]*Upload
 }
 
 func UnmarshalRequest(reader io.Reader, request *Request) error {
@@ -167,3 +169,35 @@ func (r *Request) OperationType() (OperationType, error) {
 
 	return OperationTypeUnknown, nil
 }
+
+// IsLiveQuery returns true if the operation is a query with the @live directive
+func (r *Request) IsLiveQuery() (bool, error) {
+	report := r.parseQueryOnce()
+	if report.HasErrors() {
+		return false, report
+	}
+
+	for _, rootNode := range r.document.RootNodes {
+		if rootNode.Kind != ast.NodeKindOperationDefinition {
+			continue
+		}
+
+		if r.document.OperationDefinitionNameString(rootNode.Ref) != r.OperationName {
+			continue
+		}
+
+		operationDefinition := r.document.OperationDefinitions[rootNode.Ref]
+		if operationDefinition.OperationType != ast.OperationTypeQuery || !operationDefinition.HasDirectives {
+			return false, nil
+		}
+
+		for _, directiveRef := range operationDefinition.Directives.Refs {
+			if r.document.DirectiveNameString(directiveRef) == "live" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	return false, nil
+}
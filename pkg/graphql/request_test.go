@@ -225,3 +225,39 @@ const namedIntrospectionQuery = `{"operationName":"IntrospectionQuery","variable
 const silentIntrospectionQuery = `{"operationName":null,"variables":{},"query":"{\n  __schema {\n    queryType {\n      name\n    }\n    mutationType {\n      name\n    }\n    subscriptionType {\n      name\n    }\n    types {\n      ...FullType\n    }\n    directives {\n      name\n      description\n      locations\n      args {\n        ...InputValue\n      }\n    }\n  }\n}\n\nfragment FullType on __Type {\n  kind\n  name\n  description\n  fields(includeDeprecated: true) {\n    name\n    description\n    args {\n      ...InputValue\n    }\n    type {\n      ...TypeRef\n    }\n    isDeprecated\n    deprecationReason\n  }\n  inputFields {\n    ...InputValue\n  }\n  interfaces {\n    ...TypeRef\n  }\n  enumValues(includeDeprecated: true) {\n    name\n    description\n    isDeprecated\n    deprecationReason\n  }\n  possibleTypes {\n    ...TypeRef\n  }\n}\n\nfragment InputValue on __InputValue {\n  name\n  description\n  type {\n    ...TypeRef\n  }\n  defaultValue\n}\n\nfragment TypeRef on __Type {\n  kind\n  name\n  ofType {\n    kind\n    name\n    ofType {\n      kind\n      name\n      ofType {\n        kind\n        name\n        ofType {\n          kind\n          name\n          ofType {\n            kind\n            name\n            ofType {\n              kind\n              name\n              ofType {\n                kind\n                name\n              }\n            }\n          }\n        }\n      }\n    }\n  }\n}\n"}`
 const nonIntrospectionQuery = `{"operationName":"Foo","query":"query Foo {bar}"}`
 const mutationQuery = `{"operationName":null,"query":"mutation Foo {bar}"}`
+
+func TestRequest_IsLiveQuery(t *testing.T) {
+	request := Request{
+		Query: "query LiveQuery @live { hello } query HelloQuery @foo { hello } subscription LiveSubscription @live { hello }",
+	}
+
+	t.Run("should return true for a query with the live directive", func(t *testing.T) {
+		request.OperationName = "LiveQuery"
+		isLiveQuery, err := request.IsLiveQuery()
+		assert.NoError(t, err)
+		assert.True(t, isLiveQuery)
+	})
+
+	t.Run("should return false for a query without the live directive", func(t *testing.T) {
+		request.OperationName = "HelloQuery"
+		isLiveQuery, err := request.IsLiveQuery()
+		assert.NoError(t, err)
+		assert.False(t, isLiveQuery)
+	})
+
+	t.Run("should return false for other operation types", func(t *testing.T) {
+		request.OperationName = "LiveSubscription"
+		isLiveQuery, err := request.IsLiveQuery()
+		assert.NoError(t, err)
+		assert.False(t, isLiveQuery)
+	})
+
+	t.Run("should return an error for broken queries", func(t *testing.T) {
+		brokenRequest := Request{
+			Query: "Broken Query",
+		}
+		isLiveQuery, err := brokenRequest.IsLiveQuery()
+		assert.Error(t, err)
+		assert.False(t, isLiveQuery)
+	})
+}
@@ -0,0 +1,53 @@
+package graphql
+
+import (
+	"context"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/tracing"
+)
+
+// Names of the spans of ExecutionEngineV2.Execute and ExecutionEngineV2.ValidateOperation
+// The stages of Execute are children of the execute span, the spans of fetches are children of the resolve span.
+const (
+	SpanNameExecute   = "graphql.execute"
+	SpanNameParse     = "graphql.parse"
+	SpanNameValidate  = "graphql.validate"
+	SpanNameNormalize = "graphql.normalize"
+	SpanNamePlan      = "graphql.plan"
+	SpanNameResolve   = "graphql.resolve"
+)
+
+// AttributeOperationName is the attribute holding the operation name on the execute span
+const AttributeOperationName = "graphql.operation.name"
+
+// SetTracer traces every execution with a span per stage and a span per fetch
+// The traceparent of the client request continues the trace of the caller and every upstream request continues the trace of the fetch.
+func (e *EngineV2Configuration) SetTracer(tracer tracing.Tracer) {
+	e.tracer = tracer
+}
+
+// startExecuteSpan starts the root span of an execution, it continues the trace of the client request
+func (e *ExecutionEngineV2) startExecuteSpan(ctx context.Context, operation *Request) (context.Context, tracing.Span) {
+	if tracing.SpanFromContext(ctx) == nil && operation.request.Header != nil {
+		ctx = tracing.Extract(ctx, operation.request.Header)
+	}
+	return e.config.tracer.Start(ctx, SpanNameExecute, tracing.String(AttributeOperationName, operation.OperationName))
+}
+
+// stage runs a stage of the execution, in a span if tracing is enabled
+func (e *ExecutionEngineV2) stage(ctx context.Context, name string, run func(ctx context.Context) error) error {
+	if e.config.tracer == nil {
+		return run(ctx)
+	}
+	ctx, span := e.config.tracer.Start(ctx, name)
+	err := run(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func endSpan(span tracing.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
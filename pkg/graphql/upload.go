@@ -0,0 +1,282 @@
+package graphql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tidwall/sjson"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+)
+
+const (
+	DefaultUploadMaxFileSizeBytes = 32 << 20
+	DefaultUploadMaxFiles         = 10
+	DefaultUploadMaxMemoryBytes   = 1 << 20
+)
+
+var (
+	ErrUploadFileTooLarge = errors.New("the uploaded file exceeds the maximum file size")
+	ErrTooManyUploads     = errors.New("the request exceeds the maximum number of uploaded files")
+)
+
+// UploadConfiguration limits the files of GraphQL multipart requests, zero values use the defaults
+// Files are kept in memory up to MaxMemoryBytes for all files of a request, the remaining ones are written to temporary files.
+type UploadConfiguration struct {
+	MaxFileSizeBytes int64
+	MaxFiles         int
+	// MaxMemoryBytes is negative to write all files to temporary files
+	MaxMemoryBytes int64
+	// TempDir defaults to the directory of os.TempDir
+	TempDir string
+}
+
+func (c UploadConfiguration) withDefaults() UploadConfiguration {
+	if c.MaxFileSizeBytes == 0 {
+		c.MaxFileSizeBytes = DefaultUploadMaxFileSizeBytes
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = DefaultUploadMaxFiles
+	}
+	if c.MaxMemoryBytes == 0 {
+		c.MaxMemoryBytes = DefaultUploadMaxMemoryBytes
+	}
+	return c
+}
+
+// Upload is a file of a GraphQL multipart request, it is the value of an Upload scalar variable
+type Upload struct {
+	fileName    string
+	contentType string
+	size        int64
+	content     []byte
+	tempFile    string
+}
+
+func (u *Upload) FileName() string {
+	return u.fileName
+}
+
+func (u *Upload) ContentType() string {
+	return u.contentType
+}
+
+func (u *Upload) Size() int64 {
+	return u.size
+}
+
+// Open returns the content of the file, it can be opened multiple times until the uploads of the request are removed
+func (u *Upload) Open() (io.ReadCloser, error) {
+	if u.tempFile != "" {
+		return os.Open(u.tempFile)
+	}
+	return ioutil.NopCloser(bytes.NewReader(u.content)), nil
+}
+
+func (u *Upload) remove() error {
+	if u.tempFile == "" {
+		return nil
+	}
+	return os.Remove(u.tempFile)
+}
+
+// IsMultipartHttpRequest returns true for multipart/form-data requests, e.g. GraphQL multipart requests with file uploads
+func IsMultipartHttpRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// UnmarshalMultipartHttpRequest parses a GraphQL multipart request with the operations, map and file parts in this order
+// The variables referenced by the map get a placeholder value which identifies the file when sent to the upstreams.
+// Uploads are opt-in: UnmarshalHttpRequest only accepts JSON, so servers call this function for requests passing IsMultipartHttpRequest.
+// multipart/form-data doesn't require a CORS preflight, servers accepting uploads must protect against cross-site requests themselves.
+// Call RemoveUploads once the request got executed to remove temporary files, it's safe to call if unmarshalling failed.
+func UnmarshalMultipartHttpRequest(r *http.Request, request *Request, config UploadConfiguration) (err error) {
+	config = config.withDefaults()
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	request.request.Header = r.Header
+	uploads := map[string]*Upload{}
+	defer func() {
+		if err != nil {
+			_ = removeUploads(uploads)
+		}
+	}()
+
+	operations, err := readMultipartField(reader, "operations")
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(operations), []byte("[")) {
+		return errors.New("batched multipart requests are not supported")
+	}
+	if err = json.Unmarshal(operations, request); err != nil {
+		return err
+	}
+
+	mapField, err := readMultipartField(reader, "map")
+	if err != nil {
+		return err
+	}
+	var fileMap map[string][]string
+	if err = json.Unmarshal(mapField, &fileMap); err != nil {
+		return fmt.Errorf("invalid multipart map: %s", err.Error())
+	}
+	if len(fileMap) > config.MaxFiles {
+		return ErrTooManyUploads
+	}
+
+	nonce, err := uploadNonce()
+	if err != nil {
+		return err
+	}
+	placeholders := make(map[string]string, len(fileMap))
+	for key, paths := range fileMap {
+		placeholder := "upload:" + nonce + ":" + key
+		placeholders[key] = placeholder
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "variables.") {
+				return fmt.Errorf("invalid multipart map path: %s", path)
+			}
+			request.Variables, err = sjson.SetBytes(request.Variables, strings.TrimPrefix(path, "variables."), placeholder)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	memory := config.MaxMemoryBytes
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		placeholder, ok := placeholders[part.FormName()]
+		if !ok {
+			return fmt.Errorf("unexpected file %s of multipart request", part.FormName())
+		}
+		if _, ok := uploads[placeholder]; ok {
+			return fmt.Errorf("duplicate file %s of multipart request", part.FormName())
+		}
+
+		upload := &Upload{
+			fileName:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+		}
+		uploads[placeholder] = upload
+		if err = config.readUpload(part, upload, &memory); err != nil {
+			return err
+		}
+	}
+
+	for key, placeholder := range placeholders {
+		if _, ok := uploads[placeholder]; !ok {
+			return fmt.Errorf("file %s of multipart map is missing", key)
+		}
+	}
+
+	request.uploads = uploads
+	return nil
+}
+
+// readUpload keeps the content in memory if it fits into the remaining memory and writes it to a temporary file otherwise
+func (c UploadConfiguration) readUpload(part io.Reader, upload *Upload, memory *int64) error {
+	limited := io.LimitReader(part, c.MaxFileSizeBytes+1)
+
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, limited, *memory+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n <= *memory {
+		*memory -= n
+		upload.content, upload.size = buf.Bytes(), n
+	} else {
+		file, err := ioutil.TempFile(c.TempDir, "graphql-upload-")
+		if err != nil {
+			return err
+		}
+		upload.tempFile = file.Name()
+		upload.size, err = io.Copy(file, io.MultiReader(buf, limited))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if upload.size > c.MaxFileSizeBytes {
+		return ErrUploadFileTooLarge
+	}
+	return nil
+}
+
+// readMultipartField reads the next part which must be the form field of the name
+func readMultipartField(reader *multipart.Reader, name string) ([]byte, error) {
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("multipart field %s is missing: %s", name, err.Error())
+	}
+	defer part.Close()
+	if part.FormName() != name {
+		return nil, fmt.Errorf("multipart field %s is missing, got %s", name, part.FormName())
+	}
+	return ioutil.ReadAll(part)
+}
+
+// RemoveUploads removes the temporary files of the uploads of a multipart request
+func (r *Request) RemoveUploads() error {
+	err := removeUploads(r.uploads)
+	r.uploads = nil
+	return err
+}
+
+// Uploads returns the files of a multipart request by the placeholder used as variable value
+func (r *Request) Uploads() map[string]*Upload {
+	return r.uploads
+}
+
+func (r *Request) uploadFiles() httpclient.Files {
+	files := make(httpclient.Files, len(r.uploads))
+	for placeholder, upload := range r.uploads {
+		files[placeholder] = upload
+	}
+	return files
+}
+
+func removeUploads(uploads map[string]*Upload) (err error) {
+	for _, upload := range uploads {
+		if removeErr := upload.remove(); removeErr != nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+func uploadNonce() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
@@ -0,0 +1,223 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/graphql_datasource"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/httpclient"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
+)
+
+type multipartTestFile struct {
+	name    string
+	content string
+}
+
+func newMultipartHttpRequest(t *testing.T, operations, fileMap string, files ...multipartTestFile) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("operations", operations))
+	require.NoError(t, writer.WriteField("map", fileMap))
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.name, file.name+".txt")
+		require.NoError(t, err)
+		_, err = part.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func readUpload(t *testing.T, upload *Upload) string {
+	content, err := upload.Open()
+	require.NoError(t, err)
+	defer content.Close()
+	data, err := ioutil.ReadAll(content)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestUnmarshalMultipartHttpRequest(t *testing.T) {
+	operations := `{"query":"mutation Upload($file: Upload!, $files: [Upload!]!) { upload(file: $file, files: $files) }","variables":{"file":null,"files":[null,null]}}`
+	fileMap := `{"0":["variables.file"],"1":["variables.files.0","variables.files.1"]}`
+
+	t.Run("should keep small files in memory and write the remaining ones to temporary files", func(t *testing.T) {
+		r := newMultipartHttpRequest(t, operations, fileMap,
+			multipartTestFile{name: "0", content: "first"},
+			multipartTestFile{name: "1", content: strings.Repeat("a", 16)},
+		)
+		tempDir, err := ioutil.TempDir("", "uploads")
+		require.NoError(t, err)
+		defer os.RemoveAll(tempDir)
+
+		var request Request
+		err = UnmarshalMultipartHttpRequest(r, &request, UploadConfiguration{MaxMemoryBytes: 10, TempDir: tempDir})
+		require.NoError(t, err)
+		require.Len(t, request.Uploads(), 2)
+
+		var placeholders []string
+		for placeholder, upload := range request.Uploads() {
+			placeholders = append(placeholders, placeholder)
+			switch upload.FileName() {
+			case "0.txt":
+				assert.Equal(t, "first", readUpload(t, upload))
+				assert.Equal(t, int64(5), upload.Size())
+				assert.Equal(t, "", upload.tempFile)
+			case "1.txt":
+				assert.Equal(t, strings.Repeat("a", 16), readUpload(t, upload))
+				assert.Equal(t, "application/octet-stream", upload.ContentType())
+				assert.NotEqual(t, "", upload.tempFile)
+			default:
+				t.Fatalf("unexpected upload %s", upload.FileName())
+			}
+		}
+		for _, placeholder := range placeholders {
+			assert.Contains(t, string(request.Variables), `"`+placeholder+`"`)
+		}
+
+		files, err := ioutil.ReadDir(tempDir)
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+
+		assert.NoError(t, request.RemoveUploads())
+		files, err = ioutil.ReadDir(tempDir)
+		require.NoError(t, err)
+		assert.Len(t, files, 0)
+		assert.Len(t, request.Uploads(), 0)
+	})
+
+	t.Run("should not be used by UnmarshalHttpRequest", func(t *testing.T) {
+		r := newMultipartHttpRequest(t, operations, fileMap,
+			multipartTestFile{name: "0", content: "first"},
+			multipartTestFile{name: "1", content: "second"},
+		)
+		var request Request
+		assert.Error(t, UnmarshalHttpRequest(r, &request))
+		assert.Len(t, request.Uploads(), 0)
+	})
+
+	t.Run("should enforce the limits and remove temporary files", func(t *testing.T) {
+		tempDir, err := ioutil.TempDir("", "uploads")
+		require.NoError(t, err)
+		defer os.RemoveAll(tempDir)
+
+		r := newMultipartHttpRequest(t, operations, fileMap,
+			multipartTestFile{name: "0", content: "first"},
+			multipartTestFile{name: "1", content: strings.Repeat("a", 16)},
+		)
+		var request Request
+		err = UnmarshalMultipartHttpRequest(r, &request, UploadConfiguration{MaxFileSizeBytes: 10, MaxMemoryBytes: -1, TempDir: tempDir})
+		assert.Equal(t, ErrUploadFileTooLarge, err)
+		files, err := ioutil.ReadDir(tempDir)
+		require.NoError(t, err)
+		assert.Len(t, files, 0)
+
+		r = newMultipartHttpRequest(t, operations, fileMap)
+		err = UnmarshalMultipartHttpRequest(r, &Request{}, UploadConfiguration{MaxFiles: 1})
+		assert.Equal(t, ErrTooManyUploads, err)
+	})
+
+	t.Run("should reject invalid multipart requests", func(t *testing.T) {
+		unmarshal := func(r *http.Request) error {
+			return UnmarshalMultipartHttpRequest(r, &Request{}, UploadConfiguration{})
+		}
+
+		err := unmarshal(newMultipartHttpRequest(t, operations, fileMap, multipartTestFile{name: "0", content: "first"}))
+		assert.EqualError(t, err, "file 1 of multipart map is missing")
+
+		err = unmarshal(newMultipartHttpRequest(t, operations, `{"0":["variables.file"]}`,
+			multipartTestFile{name: "0", content: "first"},
+			multipartTestFile{name: "1", content: "second"},
+		))
+		assert.EqualError(t, err, "unexpected file 1 of multipart request")
+
+		err = unmarshal(newMultipartHttpRequest(t, operations, `{"0":["query"]}`))
+		assert.EqualError(t, err, "invalid multipart map path: query")
+
+		err = unmarshal(newMultipartHttpRequest(t, `[`+operations+`]`, fileMap))
+		assert.EqualError(t, err, "batched multipart requests are not supported")
+	})
+}
+
+func TestExecutionEngineV2_Upload(t *testing.T) {
+	// the upstream responds with the operations and the content of the file parts of the multipart request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, `{"0":["variables.file"]}`, r.FormValue("map"))
+		file, header, err := r.FormFile("0")
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(file)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(`{"data":{"upload":"` + header.Filename + ` ` + string(content) + `"}}`))
+		assert.Equal(t, `{"query":"mutation($file: Upload!){upload(file: $file)}","variables":{"file":null}}`, r.FormValue("operations"))
+	}))
+	defer server.Close()
+
+	schema, err := NewSchemaFromString(`
+		scalar Upload
+		schema { query: Query mutation: Mutation }
+		type Query { hello: String }
+		type Mutation { upload(file: Upload!): String }`)
+	require.NoError(t, err)
+
+	engineConf := NewEngineV2Configuration(schema)
+	engineConf.SetDataSources([]plan.DataSourceConfiguration{
+		{
+			RootNodes: []plan.TypeField{
+				{TypeName: "Mutation", FieldNames: []string{"upload"}},
+			},
+			Factory: &graphql_datasource.Factory{
+				Client: httpclient.NewNetHttpClient(httpclient.DefaultNetHttpClient),
+			},
+			Custom: graphql_datasource.ConfigJson(graphql_datasource.Configuration{
+				Fetch: graphql_datasource.FetchConfiguration{
+					URL:    server.URL,
+					Method: http.MethodPost,
+				},
+			}),
+		},
+	})
+	engineConf.SetFieldConfigurations([]plan.FieldConfiguration{
+		{
+			TypeName:  "Mutation",
+			FieldName: "upload",
+			Arguments: []plan.ArgumentConfiguration{
+				{Name: "file", SourceType: plan.FieldArgumentSource},
+			},
+		},
+	})
+
+	closer := make(chan struct{})
+	defer close(closer)
+	engine, err := NewExecutionEngineV2(abstractlogger.NoopLogger, engineConf, closer)
+	require.NoError(t, err)
+
+	r := newMultipartHttpRequest(t,
+		`{"query":"mutation Upload($file: Upload!) { upload(file: $file) }","operationName":"Upload","variables":{"file":null}}`,
+		`{"0":["variables.file"]}`,
+		multipartTestFile{name: "0", content: "hello"},
+	)
+	var operation Request
+	require.NoError(t, UnmarshalMultipartHttpRequest(r, &operation, UploadConfiguration{}))
+	defer operation.RemoveUploads()
+
+	resultWriter := NewEngineResultWriter()
+	err = engine.Execute(context.Background(), &operation, &resultWriter)
+	require.NoError(t, err)
+	assert.Equal(t, `{"data":{"upload":"0.txt hello"}}`, resultWriter.String())
+}
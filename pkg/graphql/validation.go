@@ -22,6 +22,7 @@ func (r *Request) ValidateForSchema(schema *Schema) (result ValidationResult, er
 
 	validator := astvalidation.DefaultOperationValidator()
 	validator.Validate(&r.document, &schema.document, &report)
+	r.isValid = !report.HasErrors()
 	return operationValidationResultFromReport(report)
 }
 
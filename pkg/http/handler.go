@@ -9,6 +9,7 @@ import (
 	log "github.com/jensneuse/abstractlogger"
 
 	"github.com/jensneuse/graphql-go-tools/pkg/execution"
+	"github.com/jensneuse/graphql-go-tools/pkg/subscription"
 )
 
 const (
@@ -30,6 +31,10 @@ type GraphQLHTTPRequestHandler struct {
 }
 
 func (g *GraphQLHTTPRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.isServerSentEventsRequest(r) {
+		g.handleSSE(w, r)
+		return
+	}
 	isUpgrade := g.isWebsocketUpgrade(r)
 	if isUpgrade {
 		err := g.upgradeWithNewGoroutine(w, r)
@@ -45,11 +50,15 @@ func (g *GraphQLHTTPRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 }
 
 func (g *GraphQLHTTPRequestHandler) upgradeWithNewGoroutine(w http.ResponseWriter, r *http.Request) error {
-	conn, _, _, err := g.wsUpgrader.Upgrade(r, w)
+	upgrader := *g.wsUpgrader
+	if upgrader.Protocol == nil {
+		upgrader.Protocol = subscription.IsSupportedProtocol
+	}
+	conn, _, handshake, err := upgrader.Upgrade(r, w)
 	if err != nil {
 		return err
 	}
-	g.handleWebsocket(conn)
+	g.handleWebsocket(conn, handshake.Protocol)
 	return nil
 }
 
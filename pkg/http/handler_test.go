@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/gobwas/ws"
@@ -153,6 +155,95 @@ func TestGraphQLHTTPRequestHandler_ServeHTTP(t *testing.T) {
 		cancelFunc()
 	})
 
+	t.Run("server-sent events", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, httpAddr, bytes.NewBuffer(starwars.LoadQuery(t, starwars.FileRemainingJedisSubscription, nil)))
+		require.NoError(t, err)
+		req.Header.Set(httpHeaderAccept, httpContentTypeTextEventStream)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		client := http.Client{}
+		resp, err := client.Do(req.WithContext(ctx))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, httpContentTypeTextEventStream, resp.Header.Get(httpHeaderContentType))
+
+		reader := bufio.NewReader(resp.Body)
+		event, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "event: next\n", event)
+		data, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: {\"data\":null}\n", data)
+	})
+
+	t.Run("server-sent events over GET", func(t *testing.T) {
+		get := func(t *testing.T, ctx context.Context, query string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, httpAddr+"?query="+url.QueryEscape(query), nil)
+			require.NoError(t, err)
+			req.Header.Set(httpHeaderAccept, httpContentTypeTextEventStream)
+
+			client := http.Client{}
+			resp, err := client.Do(req.WithContext(ctx))
+			require.NoError(t, err)
+			return resp
+		}
+
+		t.Run("should stream subscriptions", func(t *testing.T) {
+			ctx, cancelFunc := context.WithCancel(context.Background())
+			defer cancelFunc()
+
+			resp := get(t, ctx, "subscription { remainingJedis }")
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			event, err := bufio.NewReader(resp.Body).ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "event: next\n", event)
+		})
+
+		t.Run("should reject mutations", func(t *testing.T) {
+			resp := get(t, context.Background(), `mutation { createReview(episode: JEDI, review: {stars: 5}) { id } }`)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+			assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+		})
+	})
+
+}
+
+func TestSSESubscriptionClient_WriteToClient(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	client, err := NewSSESubscriptionClient(abstractlogger.NoopLogger, recorder, req, []byte(`{"query":"subscription { remainingJedis }"}`))
+	require.NoError(t, err)
+
+	init, err := client.ReadFromClient()
+	require.NoError(t, err)
+	assert.Equal(t, subscription.MessageTypeConnectionInit, init.Type)
+	start, err := client.ReadFromClient()
+	require.NoError(t, err)
+	assert.Equal(t, subscription.MessageTypeStart, start.Type)
+	assert.Equal(t, `{"query":"subscription { remainingJedis }"}`, string(start.Payload))
+
+	require.NoError(t, client.WriteToClient(subscription.Message{Type: subscription.MessageTypeConnectionAck}))
+	require.NoError(t, client.WriteToClient(subscription.Message{Type: subscription.MessageTypeConnectionKeepAlive}))
+	require.NoError(t, client.WriteToClient(subscription.Message{Id: "1", Type: subscription.MessageTypeData, Payload: []byte(`{"data":{"remainingJedis":1}}`)}))
+	require.NoError(t, client.WriteToClient(subscription.Message{Id: "1", Type: subscription.MessageTypeComplete}))
+	assert.False(t, client.IsConnected())
+	require.NoError(t, client.WriteToClient(subscription.Message{Id: "1", Type: subscription.MessageTypeData, Payload: []byte(`{"data":{"remainingJedis":2}}`)}))
+
+	terminate, err := client.ReadFromClient()
+	require.NoError(t, err)
+	assert.Equal(t, subscription.MessageTypeConnectionTerminate, terminate.Type)
+
+	expected := ": keepalive\n\n" +
+		"event: next\ndata: {\"data\":{\"remainingJedis\":1}}\n\n" +
+		"event: complete\ndata: \n\n"
+	assert.Equal(t, expected, recorder.Body.String())
 }
 
 func TestGraphQLHTTPRequestHandler_IsWebsocketUpgrade(t *testing.T) {
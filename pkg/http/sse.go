@@ -0,0 +1,265 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jensneuse/abstractlogger"
+
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
+	"github.com/jensneuse/graphql-go-tools/pkg/subscription"
+)
+
+const (
+	httpHeaderAccept string = "Accept"
+
+	httpContentTypeTextEventStream string = "text/event-stream"
+
+	sseSubscriptionID = "1"
+)
+
+// SSESubscriptionClient is an implementation of the subscription client interface for Server-Sent Events.
+// It executes exactly one operation per request and ends the stream when the operation completes.
+type SSESubscriptionClient struct {
+	logger abstractlogger.Logger
+	// writer is the response writer of the event stream.
+	writer http.ResponseWriter
+	// flusher flushes every event to the client.
+	flusher http.Flusher
+	// ctx is the context of the request, it is done when the client disconnects.
+	ctx context.Context
+	// messages holds the messages read by the subscription handler.
+	messages []*subscription.Message
+	// done is closed as soon as the event stream ends.
+	done      chan struct{}
+	closeOnce sync.Once
+	mux       sync.Mutex
+}
+
+// NewSSESubscriptionClient will create a new Server-Sent Events subscription client executing the given request payload.
+func NewSSESubscriptionClient(logger abstractlogger.Logger, w http.ResponseWriter, r *http.Request, payload []byte) (*SSESubscriptionClient, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer does not support flushing")
+	}
+
+	return &SSESubscriptionClient{
+		logger:  logger,
+		writer:  w,
+		flusher: flusher,
+		ctx:     r.Context(),
+		messages: []*subscription.Message{
+			{Type: subscription.MessageTypeConnectionInit},
+			{Id: sseSubscriptionID, Type: subscription.MessageTypeStart, Payload: payload},
+		},
+		done: make(chan struct{}),
+	}, nil
+}
+
+// ReadFromClient will return the init and start message for the request and blocks until the event stream ends.
+func (s *SSESubscriptionClient) ReadFromClient() (*subscription.Message, error) {
+	if len(s.messages) != 0 {
+		message := s.messages[0]
+		s.messages = s.messages[1:]
+		return message, nil
+	}
+
+	select {
+	case <-s.done:
+	case <-s.ctx.Done():
+	}
+
+	return &subscription.Message{Type: subscription.MessageTypeConnectionTerminate}, nil
+}
+
+// WriteToClient will write a subscription message as event to the client.
+func (s *SSESubscriptionClient) WriteToClient(message subscription.Message) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.IsConnected() {
+		return nil
+	}
+
+	var (
+		event string
+		end   bool
+	)
+
+	switch message.Type {
+	case subscription.MessageTypeConnectionAck:
+		return nil
+	case subscription.MessageTypeConnectionKeepAlive, subscription.MessageTypePing:
+		event = ": keepalive\n\n"
+	case subscription.MessageTypeData, subscription.MessageTypeNext:
+		event = fmt.Sprintf("event: next\ndata: %s\n\n", message.Payload)
+	case subscription.MessageTypeError, subscription.MessageTypeConnectionError:
+		event = fmt.Sprintf("event: error\ndata: %s\n\n", message.Payload)
+		end = true
+	case subscription.MessageTypeComplete:
+		event = "event: complete\ndata: \n\n"
+		end = true
+	default:
+		return nil
+	}
+
+	_, err := s.writer.Write([]byte(event))
+	if err != nil {
+		s.logger.Error("http.SSESubscriptionClient.WriteToClient()",
+			abstractlogger.Error(err),
+			abstractlogger.Any("message", message),
+		)
+		s.close()
+		return err
+	}
+
+	s.flusher.Flush()
+
+	if end {
+		s.close()
+	}
+
+	return nil
+}
+
+// IsConnected will indicate if the event stream is still open.
+func (s *SSESubscriptionClient) IsConnected() bool {
+	select {
+	case <-s.done:
+		return false
+	case <-s.ctx.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// Disconnect will end the event stream, no events are written afterwards.
+func (s *SSESubscriptionClient) Disconnect() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.close()
+	return nil
+}
+
+func (s *SSESubscriptionClient) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// HandleSSE handles a GraphQL request as Server-Sent Events stream.
+// The operation is read from the request body (POST) or from the query, variables and operationName query parameters (GET).
+// GET requests are restricted to subscriptions and queries, mutations are rejected with 405 Method Not Allowed.
+func HandleSSE(w http.ResponseWriter, r *http.Request, executorPool subscription.ExecutorPool, logger abstractlogger.Logger) {
+	payload, err := sseRequestPayload(r)
+	if err != nil {
+		logger.Error("http.HandleSSE()",
+			abstractlogger.Error(err),
+		)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		allowed, err := sseGetOperationAllowed(executorPool, payload)
+		if err != nil {
+			logger.Error("http.HandleSSE()",
+				abstractlogger.Error(err),
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !allowed {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	sseClient, err := NewSSESubscriptionClient(logger, w, r, payload)
+	if err != nil {
+		logger.Error("http.HandleSSE()",
+			abstractlogger.Error(err),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	subscriptionHandler, err := subscription.NewHandler(logger, sseClient, executorPool)
+	if err != nil {
+		logger.Error("http.HandleSSE()",
+			abstractlogger.String("message", "could not create subscriptionHandler"),
+			abstractlogger.Error(err),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(httpHeaderContentType, httpContentTypeTextEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	sseClient.flusher.Flush()
+
+	subscriptionHandler.Handle(r.Context()) // Blocking
+	_ = sseClient.Disconnect()
+}
+
+func sseRequestPayload(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodPost {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	query := r.URL.Query()
+	request := struct {
+		Query         string          `json:"query"`
+		OperationName string          `json:"operationName,omitempty"`
+		Variables     json.RawMessage `json:"variables,omitempty"`
+	}{
+		Query:         query.Get("query"),
+		OperationName: query.Get("operationName"),
+	}
+
+	if variables := query.Get("variables"); variables != "" {
+		request.Variables = json.RawMessage(variables)
+	}
+
+	return json.Marshal(request)
+}
+
+// sseGetOperationAllowed returns true for subscriptions and queries.
+// Cross-site EventSource requests are GET requests carrying the cookies of the user, so mutations must be sent with POST.
+func sseGetOperationAllowed(executorPool subscription.ExecutorPool, payload []byte) (bool, error) {
+	executor, err := executorPool.Get(payload)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = executorPool.Put(executor)
+	}()
+
+	operationType := executor.OperationType()
+	return operationType == ast.OperationTypeSubscription || operationType == ast.OperationTypeQuery, nil
+}
+
+// handleSSE will handle a Server-Sent Events request.
+func (g *GraphQLHTTPRequestHandler) handleSSE(w http.ResponseWriter, r *http.Request) {
+	executorPool := subscription.NewExecutorV1Pool(g.executionHandler)
+	HandleSSE(w, r, executorPool, g.log)
+}
+
+func (g *GraphQLHTTPRequestHandler) isServerSentEventsRequest(r *http.Request) bool {
+	for _, header := range r.Header[httpHeaderAccept] {
+		if strings.Contains(header, httpContentTypeTextEventStream) {
+			return true
+		}
+	}
+	return false
+}
@@ -108,6 +108,21 @@ func (w *WebsocketSubscriptionClient) Disconnect() error {
 	return w.clientConn.Close()
 }
 
+// DisconnectWithCode will send a close frame with the given code and reason before closing the websocket connection.
+func (w *WebsocketSubscriptionClient) DisconnectWithCode(code int, reason string) error {
+	if !w.isClosedConnection {
+		closeFrameBody := ws.NewCloseFrameBody(ws.StatusCode(code), reason)
+		err := wsutil.WriteServerMessage(w.clientConn, ws.OpClose, closeFrameBody)
+		if err != nil {
+			w.logger.Error("http.WebsocketSubscriptionClient.DisconnectWithCode()",
+				abstractlogger.Error(err),
+			)
+		}
+	}
+
+	return w.Disconnect()
+}
+
 // isClosedConnectionError will indicate if the given error is a conenction closed error.
 func (w *WebsocketSubscriptionClient) isClosedConnectionError(err error) bool {
 	if _, ok := err.(wsutil.ClosedError); ok {
@@ -118,6 +133,11 @@ func (w *WebsocketSubscriptionClient) isClosedConnectionError(err error) bool {
 }
 
 func HandleWebsocket(done chan bool, errChan chan error, conn net.Conn, executorPool subscription.ExecutorPool, logger abstractlogger.Logger) {
+	HandleWebsocketWithProtocol(done, errChan, conn, executorPool, logger, subscription.ProtocolGraphQLWS)
+}
+
+// HandleWebsocketWithProtocol handles a websocket connection speaking the negotiated subprotocol.
+func HandleWebsocketWithProtocol(done chan bool, errChan chan error, conn net.Conn, executorPool subscription.ExecutorPool, logger abstractlogger.Logger, protocol string) {
 	defer func() {
 		if err := conn.Close(); err != nil {
 			logger.Error("http.HandleWebsocket()",
@@ -128,7 +148,11 @@ func HandleWebsocket(done chan bool, errChan chan error, conn net.Conn, executor
 	}()
 
 	websocketClient := NewWebsocketSubscriptionClient(logger, conn)
-	subscriptionHandler, err := subscription.NewHandler(logger, websocketClient, executorPool)
+	if protocol == "" {
+		protocol = subscription.ProtocolGraphQLWS
+	}
+
+	subscriptionHandler, err := subscription.NewHandlerWithProtocol(logger, websocketClient, executorPool, protocol)
 	if err != nil {
 		logger.Error("http.HandleWebsocket()",
 			abstractlogger.String("message", "could not create subscriptionHandler"),
@@ -144,12 +168,12 @@ func HandleWebsocket(done chan bool, errChan chan error, conn net.Conn, executor
 }
 
 // handleWebsocket will handle the websocket connection.
-func (g *GraphQLHTTPRequestHandler) handleWebsocket(conn net.Conn) {
+func (g *GraphQLHTTPRequestHandler) handleWebsocket(conn net.Conn, protocol string) {
 	done := make(chan bool)
 	errChan := make(chan error)
 
 	executorPool := subscription.NewExecutorV1Pool(g.executionHandler)
-	go HandleWebsocket(done, errChan, conn, executorPool, g.log)
+	go HandleWebsocketWithProtocol(done, errChan, conn, executorPool, g.log, protocol)
 	select {
 	case err := <-errChan:
 		g.log.Error("http.GraphQLHTTPRequestHandler.handleWebsocket()",
@@ -100,6 +100,7 @@ var (
 	REPLACE                       = []byte("replace")
 	INITIAL_BATCH_SIZE            = []byte("initialBatchSize")
 	MILLISECONDS                  = []byte("milliSeconds")
+	POLLING_INTERVAL_MILLIS       = []byte("pollingIntervalMillis")
 	PATH                          = []byte("path")
 	VALUE                         = []byte("value")
 	HTTP_METHOD_GET               = []byte("GET")
@@ -57,6 +57,11 @@ func (d *ProcessDataSource) traverseTrigger(trigger *resolve.GraphQLSubscription
 	d.resolveInputTemplate(trigger.Variables, trigger.Input, &trigger.InputTemplate)
 	trigger.Input = ""
 	trigger.Variables = nil
+	for i := range trigger.Filters {
+		d.resolveInputTemplate(trigger.Filters[i].Variables, trigger.Filters[i].Value, &trigger.Filters[i].ValueTemplate)
+		trigger.Filters[i].Value = ""
+		trigger.Filters[i].Variables = nil
+	}
 }
 
 func (d *ProcessDataSource) traverseSingleFetch(fetch *resolve.SingleFetch) {
@@ -296,3 +296,71 @@ func TestDataSourceInput_Subscription_Process(t *testing.T) {
 
 	assert.Equal(t, expected, actual)
 }
+
+func TestDataSourceInput_Subscription_Filter_Process(t *testing.T) {
+
+	pre := &plan.SubscriptionResponsePlan{
+		Response: resolve.GraphQLSubscription{
+			Trigger: resolve.GraphQLSubscriptionTrigger{
+				ManagerID: []byte("fake"),
+				Input:     `{"topic":"orders"}`,
+				Filters: []resolve.SubscriptionFilter{
+					{
+						FieldPath: []string{"data", "orderUpdated", "customerId"},
+						Value:     `$$0$$`,
+						Variables: []resolve.Variable{
+							&resolve.ContextVariable{
+								Path: []string{"customerId"},
+							},
+						},
+					},
+				},
+			},
+			Response: &resolve.GraphQLResponse{},
+		},
+	}
+
+	expected := &plan.SubscriptionResponsePlan{
+		Response: resolve.GraphQLSubscription{
+			Trigger: resolve.GraphQLSubscriptionTrigger{
+				ManagerID: []byte("fake"),
+				InputTemplate: resolve.InputTemplate{
+					Segments: []resolve.TemplateSegment{
+						{
+							Data:        []byte(`{"topic":"orders"}`),
+							SegmentType: resolve.StaticSegmentType,
+						},
+					},
+				},
+				Filters: []resolve.SubscriptionFilter{
+					{
+						FieldPath: []string{"data", "orderUpdated", "customerId"},
+						ValueTemplate: resolve.InputTemplate{
+							Segments: []resolve.TemplateSegment{
+								{
+									Data:        []byte(``),
+									SegmentType: resolve.StaticSegmentType,
+								},
+								{
+									SegmentType:        resolve.VariableSegmentType,
+									VariableSource:     resolve.VariableSourceContext,
+									VariableSourcePath: []string{"customerId"},
+								},
+								{
+									Data:        []byte(``),
+									SegmentType: resolve.StaticSegmentType,
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: &resolve.GraphQLResponse{},
+		},
+	}
+
+	processor := &ProcessDataSource{}
+	actual := processor.Process(pre)
+
+	assert.Equal(t, expected, actual)
+}
@@ -2,12 +2,18 @@ package subscription
 
 import (
 	"context"
+	"net/http"
 )
 
 type subscriptionCancellations map[string]context.CancelFunc
 
 func (sc subscriptionCancellations) Add(id string) context.Context {
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	return sc.AddWithParent(context.Background(), id)
+}
+
+// AddWithParent adds a cancellation func for a context derived from parent, e.g. the context of the connection.
+func (sc subscriptionCancellations) AddWithParent(parent context.Context, id string) context.Context {
+	ctx, cancelFunc := context.WithCancel(parent)
 	sc[id] = cancelFunc
 	return ctx
 }
@@ -28,3 +34,18 @@ func (sc subscriptionCancellations) CancelAll() {
 		cancelFunc()
 	}
 }
+
+type headerContextKey struct{}
+
+// ContextWithHeader returns a context carrying header, e.g. credentials of the connection_init payload returned by an InitFunc.
+// ExecutorV2 sets the header as request header of every operation executed with the context,
+// so data sources can use it in templates like {{ .request.headers.Authorization }}.
+func ContextWithHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerContextKey{}, header)
+}
+
+// HeaderFromContext returns the header attached by ContextWithHeader or nil.
+func HeaderFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerContextKey{}).(http.Header)
+	return header
+}
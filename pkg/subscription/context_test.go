@@ -2,6 +2,7 @@ package subscription
 
 import (
 	"context"
+	"net/http"
 	"testing"
 	"time"
 
@@ -34,3 +35,11 @@ func TestSubscriptionCancellations(t *testing.T) {
 		assert.Equal(t, 0, len(cancellations))
 	})
 }
+
+func TestContextWithHeader(t *testing.T) {
+	assert.Nil(t, HeaderFromContext(context.Background()))
+
+	header := http.Header{"Authorization": []string{"Bearer token"}}
+	ctx := ContextWithHeader(context.Background(), header)
+	assert.Equal(t, header, HeaderFromContext(ctx))
+}
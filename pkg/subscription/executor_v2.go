@@ -53,7 +53,18 @@ type ExecutorV2 struct {
 }
 
 func (e *ExecutorV2) Execute(writer resolve.FlushWriter) error {
-	return e.engine.Execute(e.context, e.operation, writer)
+	err := e.engine.Execute(e.context, e.operation, writer, graphql.WithLiveQueries())
+	if err == nil && e.context.Err() == nil && (e.OperationType() == ast.OperationTypeSubscription || e.IsLiveQuery()) {
+		// subscriptions and live queries block until they get stopped, returning earlier means they got terminated
+		return ErrSubscriptionCompleted
+	}
+	return err
+}
+
+// IsLiveQuery returns true for queries with the @live directive, the handler executes them like subscriptions
+func (e *ExecutorV2) IsLiveQuery() bool {
+	isLiveQuery, err := e.operation.IsLiveQuery()
+	return err == nil && isLiveQuery
 }
 
 func (e *ExecutorV2) OperationType() ast.OperationType {
@@ -65,8 +76,13 @@ func (e *ExecutorV2) OperationType() ast.OperationType {
 	return ast.OperationType(opType)
 }
 
+// SetContext sets the context the operation gets executed with
+// A header attached with ContextWithHeader becomes the request header of the operation.
 func (e *ExecutorV2) SetContext(context context.Context) {
 	e.context = context
+	if header := HeaderFromContext(context); header != nil {
+		e.operation.SetHeader(header)
+	}
 }
 
 func (e *ExecutorV2) Reset() {
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jensneuse/abstractlogger"
@@ -27,10 +30,43 @@ const (
 	MessageTypeError               = "error"
 	MessageTypeComplete            = "complete"
 
+	// message types of the graphql-transport-ws protocol which are not part of the graphql-ws protocol
+	MessageTypeSubscribe = "subscribe"
+	MessageTypeNext      = "next"
+	MessageTypePing      = "ping"
+	MessageTypePong      = "pong"
+
 	DefaultKeepAliveInterval          = "15s"
 	DefaultSubscriptionUpdateInterval = "1s"
+	DefaultConnectionInitWaitTimeout  = "3s"
+)
+
+const (
+	// ProtocolGraphQLWS is the legacy subscriptions-transport-ws protocol.
+	ProtocolGraphQLWS = "graphql-ws"
+	// ProtocolGraphQLTransportWS is the protocol of the graphql-ws library.
+	ProtocolGraphQLTransportWS = "graphql-transport-ws"
 )
 
+// close codes of the graphql-transport-ws protocol
+const (
+	CloseCodeInternalServerError             = 4500
+	CloseCodeBadRequest                      = 4400
+	CloseCodeUnauthorized                    = 4401
+	CloseCodeForbidden                       = 4403
+	CloseCodeConnectionInitialisationTimeout = 4408
+	CloseCodeSubscriberAlreadyExists         = 4409
+	CloseCodeTooManyInitialisationRequests   = 4429
+)
+
+// CloseCodeGoingAway is the websocket close code used when the server shuts down.
+const CloseCodeGoingAway = 1001
+
+// IsSupportedProtocol can be used to negotiate the websocket subprotocol.
+func IsSupportedProtocol(protocol string) bool {
+	return protocol == ProtocolGraphQLWS || protocol == ProtocolGraphQLTransportWS
+}
+
 // Message defines the actual subscription message wich will be passed from client to server and vice versa.
 type Message struct {
 	Id      string          `json:"id"`
@@ -50,12 +86,23 @@ type Client interface {
 	Disconnect() error
 }
 
+// ClientWithCloseCode can be implemented by clients which are able to tell the reason of a disconnect, e.g. websocket close codes.
+type ClientWithCloseCode interface {
+	Client
+	// DisconnectWithCode will close the connection between server and client with the given code and reason.
+	DisconnectWithCode(code int, reason string) error
+}
+
 // ExecutorPool is an abstraction for creating executors
 type ExecutorPool interface {
 	Get(payload []byte) (Executor, error)
 	Put(executor Executor) error
 }
 
+// ErrSubscriptionCompleted is returned by Executor.Execute if the upstream completed a subscription.
+// The handler sends a complete message to the client instead of executing the subscription again.
+var ErrSubscriptionCompleted = errors.New("subscription completed")
+
 // Executor is an abstraction for executing a GraphQL engine
 type Executor interface {
 	Execute(writer resolve.FlushWriter) error
@@ -64,25 +111,74 @@ type Executor interface {
 	Reset()
 }
 
+// LiveQueryExecutor is implemented by executors supporting live queries
+// The handler executes live queries like subscriptions until the client stops them.
+type LiveQueryExecutor interface {
+	Executor
+	IsLiveQuery() bool
+}
+
+func isLiveQuery(executor Executor) bool {
+	liveQueryExecutor, ok := executor.(LiveQueryExecutor)
+	return ok && liveQueryExecutor.IsLiveQuery()
+}
+
+// InitFunc is called with the payload of the connection_init message.
+// The returned context is the context every operation of the connection gets executed with, e.g. carrying the authenticated user.
+// Use ContextWithHeader to expose credentials to data sources as request headers.
+// Returning an error rejects the connection.
+type InitFunc func(ctx context.Context, payload []byte) (context.Context, error)
+
+// AuthorizeFunc is called with the context returned by the InitFunc and the payload of every start/subscribe message.
+// Returning an error rejects the operation, the error message is sent to the client.
+type AuthorizeFunc func(ctx context.Context, id string, payload []byte) error
+
 // Handler is the actual subscription handler which will keep track on how to handle messages coming from the client.
 type Handler struct {
 	logger abstractlogger.Logger
 	// client will hold the subscription client implementation.
 	client Client
+	// protocol is the protocol spoken with the client.
+	protocol string
+	// connectionInitWaitTimeout is the time a graphql-transport-ws client has to send the connection_init message.
+	connectionInitWaitTimeout time.Duration
+	// initialised is set to 1 as soon as the connection is initialised.
+	initialised int32
 	// keepAliveInterval is the actual interval on which the server send keep alive messages to the client.
 	keepAliveInterval time.Duration
 	// subscriptionUpdateInterval is the actual interval on which the server sends subscription updates to the client.
 	subscriptionUpdateInterval time.Duration
 	// subCancellations is map containing the cancellation functions to every active subscription.
 	subCancellations subscriptionCancellations
+	// subCancellationsMux guards subCancellations, subscriptions completed by the upstream remove themselves.
+	subCancellationsMux sync.Mutex
 	// executorPool is responsible to create and hold executors.
 	executorPool ExecutorPool
 	// bufferPool will hold buffers.
 	bufferPool *sync.Pool
+	// initFunc accepts or rejects the connection_init payload.
+	initFunc InitFunc
+	// authorizeFunc accepts or rejects every operation.
+	authorizeFunc AuthorizeFunc
+	// connectionContext is the context returned by initFunc, every operation gets executed with it.
+	connectionContext context.Context
+	// shuttingDown rejects new operations, it's guarded by subCancellationsMux.
+	shuttingDown bool
+	// operations tracks the running operations so that Shutdown can wait for them.
+	operations sync.WaitGroup
 }
 
-// NewHandler creates a new subscription handler.
+// NewHandler creates a new subscription handler speaking the graphql-ws protocol.
 func NewHandler(logger abstractlogger.Logger, client Client, executorPool ExecutorPool) (*Handler, error) {
+	return NewHandlerWithProtocol(logger, client, executorPool, ProtocolGraphQLWS)
+}
+
+// NewHandlerWithProtocol creates a new subscription handler speaking the given protocol.
+func NewHandlerWithProtocol(logger abstractlogger.Logger, client Client, executorPool ExecutorPool, protocol string) (*Handler, error) {
+	if !IsSupportedProtocol(protocol) {
+		return nil, fmt.Errorf("unsupported subscription protocol: %s", protocol)
+	}
+
 	keepAliveInterval, err := time.ParseDuration(DefaultKeepAliveInterval)
 	if err != nil {
 		return nil, err
@@ -93,13 +189,21 @@ func NewHandler(logger abstractlogger.Logger, client Client, executorPool Execut
 		return nil, err
 	}
 
+	connectionInitWaitTimeout, err := time.ParseDuration(DefaultConnectionInitWaitTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Handler{
 		logger:                     logger,
 		client:                     client,
+		protocol:                   protocol,
+		connectionInitWaitTimeout:  connectionInitWaitTimeout,
 		keepAliveInterval:          keepAliveInterval,
 		subscriptionUpdateInterval: subscriptionUpdateInterval,
 		subCancellations:           subscriptionCancellations{},
 		executorPool:               executorPool,
+		connectionContext:          context.Background(),
 		bufferPool: &sync.Pool{
 			New: func() interface{} {
 				writer := graphql.NewEngineResultWriterFromBuffer(bytes.NewBuffer(make([]byte, 0, 1024)))
@@ -112,9 +216,15 @@ func NewHandler(logger abstractlogger.Logger, client Client, executorPool Execut
 // Handle will handle the subscritpion connection.
 func (h *Handler) Handle(ctx context.Context) {
 	defer func() {
+		h.subCancellationsMux.Lock()
 		h.subCancellations.CancelAll()
+		h.subCancellationsMux.Unlock()
 	}()
 
+	if h.protocol == ProtocolGraphQLTransportWS {
+		go h.handleConnectionInitTimeout(ctx)
+	}
+
 	for {
 		if !h.client.IsConnected() {
 			h.logger.Debug("subscription.Handler.Handle()",
@@ -125,19 +235,39 @@ func (h *Handler) Handle(ctx context.Context) {
 		}
 
 		message, err := h.client.ReadFromClient()
-		if err != nil {
+		if err != nil && h.isShuttingDown() {
+			return
+		} else if err != nil {
 			h.logger.Error("subscription.Handler.Handle()",
 				abstractlogger.Error(err),
 				abstractlogger.Any("message", message),
 			)
 
+			if h.protocol == ProtocolGraphQLTransportWS {
+				h.closeWithCode(CloseCodeBadRequest, "Invalid message received")
+				return
+			}
+
 			h.handleConnectionError("could not read message from client")
+		} else if message != nil && h.protocol == ProtocolGraphQLTransportWS {
+			if terminate := h.handleGraphQLTransportWSMessage(ctx, message); terminate {
+				return
+			}
 		} else if message != nil {
 			switch message.Type {
 			case MessageTypeConnectionInit:
-				h.handleInit()
+				if err := h.handleInit(ctx, message.Payload); err != nil {
+					h.handleConnectionError(graphQLError{Message: err.Error()})
+					h.handleConnectionTerminate()
+					return
+				}
+				atomic.StoreInt32(&h.initialised, 1)
 				go h.handleKeepAlive(ctx)
 			case MessageTypeStart:
+				if h.initFunc != nil && atomic.LoadInt32(&h.initialised) == 0 {
+					h.handleError(message.Id, "connection not initialised")
+					break
+				}
 				h.handleStart(message.Id, message.Payload)
 			case MessageTypeStop:
 				h.handleStop(message.Id)
@@ -156,18 +286,152 @@ func (h *Handler) Handle(ctx context.Context) {
 	}
 }
 
+// Shutdown stops accepting operations, stops all subscriptions and waits for the running operations until the context is done.
+// Afterwards the stopped subscriptions get completed and the connection gets closed with CloseCodeGoingAway.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.subCancellationsMux.Lock()
+	h.shuttingDown = true
+	stoppedSubscriptions := make([]string, 0, len(h.subCancellations))
+	for id := range h.subCancellations {
+		stoppedSubscriptions = append(stoppedSubscriptions, id)
+	}
+	h.subCancellations.CancelAll()
+	h.subCancellations = subscriptionCancellations{}
+	h.subCancellationsMux.Unlock()
+
+	operationsDone := make(chan struct{})
+	go func() {
+		h.operations.Wait()
+		close(operationsDone)
+	}()
+
+	var err error
+	select {
+	case <-operationsDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	for _, id := range stoppedSubscriptions {
+		h.sendComplete(id)
+	}
+	h.closeWithCode(CloseCodeGoingAway, "Server is shutting down")
+	return err
+}
+
+// isShuttingDown will indicate if Shutdown got called.
+func (h *Handler) isShuttingDown() bool {
+	h.subCancellationsMux.Lock()
+	defer h.subCancellationsMux.Unlock()
+	return h.shuttingDown
+}
+
 // ChangeKeepAliveInterval can be used to change the keep alive interval.
 func (h *Handler) ChangeKeepAliveInterval(d time.Duration) {
 	h.keepAliveInterval = d
 }
 
+// SetInitFunc can be used to authenticate the connection with the connection_init payload.
+func (h *Handler) SetInitFunc(initFunc InitFunc) {
+	h.initFunc = initFunc
+}
+
+// SetAuthorizeFunc can be used to authorize every operation before it gets executed.
+func (h *Handler) SetAuthorizeFunc(authorizeFunc AuthorizeFunc) {
+	h.authorizeFunc = authorizeFunc
+}
+
+// handleGraphQLTransportWSMessage will handle a message of the graphql-transport-ws protocol.
+func (h *Handler) handleGraphQLTransportWSMessage(ctx context.Context, message *Message) (terminate bool) {
+	switch message.Type {
+	case MessageTypeConnectionInit:
+		if !atomic.CompareAndSwapInt32(&h.initialised, 0, 1) {
+			h.closeWithCode(CloseCodeTooManyInitialisationRequests, "Too many initialisation requests")
+			return true
+		}
+		if err := h.handleInit(ctx, message.Payload); err != nil {
+			h.closeWithCode(CloseCodeForbidden, "Forbidden")
+			return true
+		}
+		go h.handleKeepAlive(ctx)
+	case MessageTypeSubscribe:
+		if atomic.LoadInt32(&h.initialised) == 0 {
+			h.closeWithCode(CloseCodeUnauthorized, "Unauthorized")
+			return true
+		}
+		if h.hasSubscription(message.Id) {
+			h.closeWithCode(CloseCodeSubscriberAlreadyExists, fmt.Sprintf("Subscriber for %s already exists", message.Id))
+			return true
+		}
+		h.handleStart(message.Id, message.Payload)
+	case MessageTypeComplete:
+		h.cancelSubscription(message.Id)
+	case MessageTypePing:
+		h.sendPong(message.Payload)
+	case MessageTypePong:
+	default:
+		h.closeWithCode(CloseCodeBadRequest, fmt.Sprintf("Invalid message received: %s", message.Type))
+		return true
+	}
+	return false
+}
+
+// handleConnectionInitTimeout will close the connection if the client doesn't initialise the connection in time.
+func (h *Handler) handleConnectionInitTimeout(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(h.connectionInitWaitTimeout):
+	}
+
+	if atomic.LoadInt32(&h.initialised) == 0 {
+		h.closeWithCode(CloseCodeConnectionInitialisationTimeout, "Connection initialisation timeout")
+	}
+}
+
+// closeWithCode will disconnect the client and tells it the reason if the client supports it.
+func (h *Handler) closeWithCode(code int, reason string) {
+	var err error
+	if client, ok := h.client.(ClientWithCloseCode); ok {
+		err = client.DisconnectWithCode(code, reason)
+	} else {
+		err = h.client.Disconnect()
+	}
+
+	if err != nil {
+		h.logger.Error("subscription.Handler.closeWithCode()",
+			abstractlogger.Error(err),
+			abstractlogger.Int("code", code),
+			abstractlogger.String("reason", reason),
+		)
+	}
+}
+
+// ChangeConnectionInitWaitTimeout can be used to change the time a graphql-transport-ws client has to initialise the connection.
+func (h *Handler) ChangeConnectionInitWaitTimeout(d time.Duration) {
+	h.connectionInitWaitTimeout = d
+}
+
 // ChangeSubscriptionUpdateInterval can be used to change the update interval.
 func (h *Handler) ChangeSubscriptionUpdateInterval(d time.Duration) {
 	h.subscriptionUpdateInterval = d
 }
 
 // handleInit will handle an init message.
-func (h *Handler) handleInit() {
+// It returns the error of the initFunc if the connection got rejected.
+func (h *Handler) handleInit(ctx context.Context, payload []byte) error {
+	if h.initFunc != nil {
+		connectionContext, err := h.initFunc(ctx, payload)
+		if err != nil {
+			h.logger.Debug("subscription.Handler.handleInit()",
+				abstractlogger.String("message", "connection rejected"),
+				abstractlogger.Error(err),
+			)
+			return err
+		}
+		h.connectionContext = connectionContext
+	}
+
 	ackMessage := Message{
 		Type: MessageTypeConnectionAck,
 	}
@@ -178,10 +442,24 @@ func (h *Handler) handleInit() {
 			abstractlogger.Error(err),
 		)
 	}
+	return nil
 }
 
 // handleStart will handle s start message.
 func (h *Handler) handleStart(id string, payload []byte) {
+	if h.authorizeFunc != nil {
+		err := h.authorizeFunc(h.connectionContext, id, payload)
+		if err != nil {
+			h.logger.Debug("subscription.Handler.handleStart()",
+				abstractlogger.String("message", "operation rejected"),
+				abstractlogger.Error(err),
+			)
+
+			h.handleError(id, err.Error())
+			return
+		}
+	}
+
 	executor, err := h.executorPool.Get(payload)
 	if err != nil {
 		h.logger.Error("subscription.Handler.handleStart()",
@@ -192,17 +470,34 @@ func (h *Handler) handleStart(id string, payload []byte) {
 		return
 	}
 
-	if executor.OperationType() == ast.OperationTypeSubscription {
-		ctx := h.subCancellations.Add(id)
+	isSubscription := executor.OperationType() == ast.OperationTypeSubscription || isLiveQuery(executor)
+
+	h.subCancellationsMux.Lock()
+	if h.shuttingDown {
+		h.subCancellationsMux.Unlock()
+		_ = h.executorPool.Put(executor)
+		h.handleError(id, "server is shutting down")
+		return
+	}
+	h.operations.Add(1)
+	var ctx context.Context
+	if isSubscription {
+		ctx = h.subCancellations.AddWithParent(h.connectionContext, id)
+	}
+	h.subCancellationsMux.Unlock()
+
+	if isSubscription {
 		go h.startSubscription(ctx, id, executor)
 		return
 	}
 
+	executor.SetContext(h.connectionContext)
 	go h.handleNonSubscriptionOperation(id, executor)
 }
 
 // handleNonSubscriptionOperation will handle a non-subscription operation like a query or a mutation.
 func (h *Handler) handleNonSubscriptionOperation(id string, executor Executor) {
+	defer h.operations.Done()
 	defer func() {
 		err := h.executorPool.Put(executor)
 		if err != nil {
@@ -238,6 +533,7 @@ func (h *Handler) handleNonSubscriptionOperation(id string, executor Executor) {
 
 // startSubscription will invoke the actual subscription.
 func (h *Handler) startSubscription(ctx context.Context, id string, executor Executor) {
+	defer h.operations.Done()
 	defer func() {
 		err := h.executorPool.Put(executor)
 		if err != nil {
@@ -253,7 +549,9 @@ func (h *Handler) startSubscription(ctx context.Context, id string, executor Exe
 
 	defer h.bufferPool.Put(buf)
 
-	h.executeSubscription(buf, id, executor)
+	if ended := h.runSubscription(buf, id, executor); ended {
+		return
+	}
 
 	for {
 		buf.Reset()
@@ -261,14 +559,39 @@ func (h *Handler) startSubscription(ctx context.Context, id string, executor Exe
 		case <-ctx.Done():
 			return
 		case <-time.After(h.subscriptionUpdateInterval):
-			h.executeSubscription(buf, id, executor)
+			if ended := h.runSubscription(buf, id, executor); ended {
+				return
+			}
 		}
 	}
 
 }
 
+// runSubscription executes the subscription once and removes it if it ended.
+// graphql-transport-ws ends an operation with its error message, so failed subscriptions don't get executed again.
+func (h *Handler) runSubscription(buf *graphql.EngineResultWriter, id string, executor Executor) (ended bool) {
+	completed, failed := h.executeSubscription(buf, id, executor)
+	switch {
+	case completed:
+		h.completeSubscription(id)
+		return true
+	case failed && h.protocol == ProtocolGraphQLTransportWS:
+		h.cancelSubscription(id)
+		return true
+	}
+	return false
+}
+
+// completeSubscription will remove a subscription completed by the upstream and notify the client.
+func (h *Handler) completeSubscription(id string) {
+	if h.cancelSubscription(id) {
+		h.sendComplete(id)
+	}
+}
+
 // executeSubscription will keep execution the subscription until it ends.
-func (h *Handler) executeSubscription(buf *graphql.EngineResultWriter, id string, executor Executor) {
+// completed is true if the upstream ended the subscription, failed is true if the execution failed and the error got sent.
+func (h *Handler) executeSubscription(buf *graphql.EngineResultWriter, id string, executor Executor) (completed, failed bool) {
 	buf.SetFlushCallback(func(data []byte) {
 		h.logger.Debug("subscription.Handle.executeSubscription()",
 			abstractlogger.ByteString("execution_result", data),
@@ -278,13 +601,15 @@ func (h *Handler) executeSubscription(buf *graphql.EngineResultWriter, id string
 	defer buf.SetFlushCallback(nil)
 
 	err := executor.Execute(buf)
-	if err != nil {
+	if err == ErrSubscriptionCompleted {
+		completed = true
+	} else if err != nil {
 		h.logger.Error("subscription.Handle.executeSubscription()",
 			abstractlogger.Error(err),
 		)
 
 		h.handleError(id, err)
-		return
+		return false, true
 	}
 
 	if buf.Len() > 0 {
@@ -294,14 +619,30 @@ func (h *Handler) executeSubscription(buf *graphql.EngineResultWriter, id string
 		)
 		h.sendData(id, data)
 	}
+	return
 }
 
 // handleStop will handle a stop message,
 func (h *Handler) handleStop(id string) {
-	h.subCancellations.Cancel(id)
+	h.cancelSubscription(id)
 	h.sendComplete(id)
 }
 
+// hasSubscription will indicate if a subscription with the given id is active.
+func (h *Handler) hasSubscription(id string) bool {
+	h.subCancellationsMux.Lock()
+	defer h.subCancellationsMux.Unlock()
+	_, exists := h.subCancellations[id]
+	return exists
+}
+
+// cancelSubscription will cancel and remove an active subscription.
+func (h *Handler) cancelSubscription(id string) (ok bool) {
+	h.subCancellationsMux.Lock()
+	defer h.subCancellationsMux.Unlock()
+	return h.subCancellations.Cancel(id)
+}
+
 // sendData will send a data message to the client.
 func (h *Handler) sendData(id string, responseData []byte) {
 	dataMessage := Message{
@@ -310,6 +651,10 @@ func (h *Handler) sendData(id string, responseData []byte) {
 		Payload: responseData,
 	}
 
+	if h.protocol == ProtocolGraphQLTransportWS {
+		dataMessage.Type = MessageTypeNext
+	}
+
 	err := h.client.WriteToClient(dataMessage)
 	if err != nil {
 		h.logger.Error("subscription.Handler.sendData()",
@@ -363,6 +708,10 @@ func (h *Handler) sendKeepAlive() {
 		Type: MessageTypeConnectionKeepAlive,
 	}
 
+	if h.protocol == ProtocolGraphQLTransportWS {
+		keepAliveMessage.Type = MessageTypePing
+	}
+
 	err := h.client.WriteToClient(keepAliveMessage)
 	if err != nil {
 		h.logger.Error("subscription.Handler.sendKeepAlive()",
@@ -371,6 +720,21 @@ func (h *Handler) sendKeepAlive() {
 	}
 }
 
+// sendPong will answer a ping message of the client.
+func (h *Handler) sendPong(payload json.RawMessage) {
+	pongMessage := Message{
+		Type:    MessageTypePong,
+		Payload: payload,
+	}
+
+	err := h.client.WriteToClient(pongMessage)
+	if err != nil {
+		h.logger.Error("subscription.Handler.sendPong()",
+			abstractlogger.Error(err),
+		)
+	}
+}
+
 // handleConnectionError will handle a connection error message.
 func (h *Handler) handleConnectionError(errorPayload interface{}) {
 	payloadBytes, err := json.Marshal(errorPayload)
@@ -403,6 +767,11 @@ func (h *Handler) handleConnectionError(errorPayload interface{}) {
 
 // handleError will handle an error message.
 func (h *Handler) handleError(id string, errorPayload interface{}) {
+	if h.protocol == ProtocolGraphQLTransportWS {
+		// graphql-transport-ws expects a list of GraphQL errors
+		errorPayload = []graphQLError{{Message: errorMessage(errorPayload)}}
+	}
+
 	payloadBytes, err := json.Marshal(errorPayload)
 	if err != nil {
 		h.logger.Error("subscription.Handler.handleError()",
@@ -427,9 +796,26 @@ func (h *Handler) handleError(id string, errorPayload interface{}) {
 
 // ActiveSubscriptions will return the actual number of active subscriptions for that client.
 func (h *Handler) ActiveSubscriptions() int {
+	h.subCancellationsMux.Lock()
+	defer h.subCancellationsMux.Unlock()
 	return len(h.subCancellations)
 }
 
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+func errorMessage(errorPayload interface{}) string {
+	switch payload := errorPayload.(type) {
+	case error:
+		return payload.Error()
+	case string:
+		return payload
+	default:
+		return fmt.Sprintf("%v", payload)
+	}
+}
+
 func cleanErrorMessage(err error) string {
 	errMsg := strings.TrimPrefix(err.Error(), "external: ")
 	return errMsg
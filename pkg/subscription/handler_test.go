@@ -1,17 +1,23 @@
 package subscription
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/jensneuse/abstractlogger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 
+	"github.com/jensneuse/graphql-go-tools/pkg/ast"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/datasource/graphql_datasource"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/plan"
+	"github.com/jensneuse/graphql-go-tools/pkg/engine/resolve"
 	"github.com/jensneuse/graphql-go-tools/pkg/engine/subscription"
 	"github.com/jensneuse/graphql-go-tools/pkg/graphql"
 	"github.com/jensneuse/graphql-go-tools/pkg/starwars"
@@ -249,14 +255,14 @@ func TestHandler_Handle(t *testing.T) {
 
 			t.Run("should successfully disconnect from client", func(t *testing.T) {
 				client.prepareConnectionTerminateMessage().withoutError().and().send()
-				require.True(t, client.connected)
+				require.True(t, client.IsConnected())
 
 				ctx, cancelFunc := context.WithCancel(context.Background())
 
 				cancelFunc()
 				require.Eventually(t, handlerRoutine(ctx), 1*time.Second, 5*time.Millisecond)
 
-				assert.False(t, client.connected)
+				assert.False(t, client.IsConnected())
 			})
 		})
 
@@ -266,7 +272,7 @@ func TestHandler_Handle(t *testing.T) {
 			t.Run("server should not read from client and stop handler", func(t *testing.T) {
 				err := client.Disconnect()
 				require.NoError(t, err)
-				require.False(t, client.connected)
+				require.False(t, client.IsConnected())
 
 				client.prepareConnectionInitMessage().withoutError()
 				ctx, cancelFunc := context.WithCancel(context.Background())
@@ -274,7 +280,7 @@ func TestHandler_Handle(t *testing.T) {
 				cancelFunc()
 				require.Eventually(t, handlerRoutine(ctx), 1*time.Second, 5*time.Millisecond)
 
-				assert.False(t, client.serverHasRead)
+				assert.False(t, client.hasServerRead())
 			})
 		})
 	})
@@ -401,7 +407,7 @@ func TestHandler_Handle(t *testing.T) {
 				}
 				require.Eventually(t, waitForClientHavingAMessage, 5*time.Second, 5*time.Millisecond)
 
-				jsonErrorMsg, err := json.Marshal("external: cannot find an operation with name: Broken, locations: [], path: []")
+				jsonErrorMsg, err := json.Marshal("document doesn't contain any executable operation, locations: [], path: []")
 				require.NoError(t, err)
 
 				expectedMessage := Message{
@@ -540,14 +546,14 @@ func TestHandler_Handle(t *testing.T) {
 
 			t.Run("should successfully disconnect from client", func(t *testing.T) {
 				client.prepareConnectionTerminateMessage().withoutError().and().send()
-				require.True(t, client.connected)
+				require.True(t, client.IsConnected())
 
 				ctx, cancelFunc := context.WithCancel(context.Background())
 
 				cancelFunc()
 				require.Eventually(t, handlerRoutine(ctx), 1*time.Second, 5*time.Millisecond)
 
-				assert.False(t, client.connected)
+				assert.False(t, client.IsConnected())
 			})
 		})
 
@@ -557,7 +563,7 @@ func TestHandler_Handle(t *testing.T) {
 			t.Run("server should not read from client and stop handler", func(t *testing.T) {
 				err := client.Disconnect()
 				require.NoError(t, err)
-				require.False(t, client.connected)
+				require.False(t, client.IsConnected())
 
 				client.prepareConnectionInitMessage().withoutError()
 				ctx, cancelFunc := context.WithCancel(context.Background())
@@ -565,13 +571,553 @@ func TestHandler_Handle(t *testing.T) {
 				cancelFunc()
 				require.Eventually(t, handlerRoutine(ctx), 1*time.Second, 5*time.Millisecond)
 
-				assert.False(t, client.serverHasRead)
+				assert.False(t, client.hasServerRead())
 			})
 		})
 	})
 
 }
 
+func TestHandler_HandleGraphQLTransportWS(t *testing.T) {
+	starwars.SetRelativePathToStarWarsPackage("../starwars")
+	executorPool := NewExecutorV1Pool(starwars.NewExecutionHandler(t))
+
+	t.Run("should close connection when connection_init is not sent in time", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+		subscriptionHandler.ChangeConnectionInitWaitTimeout(5 * time.Millisecond)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+		code, reason := client.closedWith()
+		assert.Equal(t, CloseCodeConnectionInitialisationTimeout, code)
+		assert.Equal(t, "Connection initialisation timeout", reason)
+	})
+
+	t.Run("should ack connection_init and answer ping with pong", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withoutError().and().send()
+		client.preparePingMessage([]byte(`{"foo":"bar"}`)).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(1)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		messagesFromServer := client.readFromServer()
+		assert.Equal(t, Message{Type: MessageTypeConnectionAck}, messagesFromServer[0])
+		assert.Equal(t, Message{Type: MessageTypePong, Payload: []byte(`{"foo":"bar"}`)}, messagesFromServer[1])
+		assert.True(t, client.IsConnected())
+	})
+
+	t.Run("should close connection on second connection_init", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withoutError().and().send()
+		client.prepareConnectionInitMessage().withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+		assert.Equal(t, CloseCodeTooManyInitialisationRequests, client.closedWithCode())
+	})
+
+	t.Run("should close connection when subscribing before connection_init", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		payload := starwars.LoadQuery(t, starwars.FileSimpleHeroQuery, nil)
+		client.prepareSubscribeMessage("1", payload).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+		assert.Equal(t, CloseCodeUnauthorized, client.closedWithCode())
+	})
+
+	t.Run("should send next and complete for a query", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withoutError().and().send()
+		payload := starwars.LoadQuery(t, starwars.FileSimpleHeroQuery, nil)
+		client.prepareSubscribeMessage("1", payload).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(2)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		messagesFromServer := client.readFromServer()
+		assert.Equal(t, MessageTypeNext, messagesFromServer[1].Type)
+		assert.Equal(t, "1", messagesFromServer[1].Id)
+		assert.Equal(t, Message{Id: "1", Type: MessageTypeComplete}, messagesFromServer[2])
+	})
+
+	t.Run("should send errors as list of GraphQL errors", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withoutError().and().send()
+		payload := starwars.LoadQuery(t, starwars.FileInvalidQuery, nil)
+		client.prepareSubscribeMessage("1", payload).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(1)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		expectedMessage := Message{
+			Id:      "1",
+			Type:    MessageTypeError,
+			Payload: []byte(`[{"message":"field: invalid not defined on type: Character, locations: [], path: [query,hero,invalid]"}]`),
+		}
+		assert.Contains(t, client.readFromServer(), expectedMessage)
+	})
+
+	t.Run("should close connection on unknown message type", func(t *testing.T) {
+		_, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareStartMessage("1", nil).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+		assert.Equal(t, CloseCodeBadRequest, client.closedWithCode())
+	})
+}
+
+func TestHandler_SubscriptionCompletedByUpstream(t *testing.T) {
+	executorPool := &completingExecutorPool{
+		data: []byte(`{"data":{"remainingJedis":1}}`),
+	}
+	subscriptionHandler, client, handlerRoutine := setupSubscriptionHandlerTest(t, executorPool)
+
+	client.prepareStartMessage("1", []byte(`{"query":"subscription { remainingJedis }"}`)).withoutError().and().send()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go handlerRoutine(ctx)()
+
+	assert.Eventually(t, func() bool {
+		return client.hasMoreMessagesThan(1)
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, subscriptionHandler.ActiveSubscriptions())
+
+	expectedMessages := []Message{
+		{
+			Id:      "1",
+			Type:    MessageTypeData,
+			Payload: []byte(`{"data":{"remainingJedis":1}}`),
+		},
+		{
+			Id:   "1",
+			Type: MessageTypeComplete,
+		},
+	}
+	assert.Equal(t, expectedMessages, client.readFromServer())
+}
+
+func TestHandler_SubscriptionFailed(t *testing.T) {
+	executorPool := &failingExecutorPool{}
+	subscriptionHandler, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+	subscriptionHandler.ChangeSubscriptionUpdateInterval(time.Millisecond)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go handlerRoutine(ctx)()
+
+	client.prepareConnectionInitMessage().withoutError().and().send()
+	client.prepareSubscribeMessage("1", []byte(`{"query":"subscription { remainingJedis }"}`)).withoutError().and().send()
+
+	require.Eventually(t, func() bool {
+		return client.hasMoreMessagesThan(1)
+	}, time.Second, 5*time.Millisecond)
+	// the subscription would get executed again after every update interval
+	time.Sleep(50 * time.Millisecond)
+
+	expectedMessages := []Message{
+		{
+			Type: MessageTypeConnectionAck,
+		},
+		{
+			Id:      "1",
+			Type:    MessageTypeError,
+			Payload: []byte(`[{"message":"upstream failed"}]`),
+		},
+	}
+	assert.Equal(t, expectedMessages, client.readFromServer())
+	assert.Equal(t, int64(1), executorPool.executions.Load())
+	assert.Equal(t, 0, subscriptionHandler.ActiveSubscriptions())
+}
+
+func TestHandler_Authentication(t *testing.T) {
+	type userContextKey struct{}
+
+	initFunc := func(ctx context.Context, payload []byte) (context.Context, error) {
+		var initPayload struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(payload, &initPayload); err != nil || initPayload.Token != "secret" {
+			return nil, errors.New("invalid token")
+		}
+		return context.WithValue(ctx, userContextKey{}, "jens"), nil
+	}
+
+	authorizeFunc := func(ctx context.Context, id string, payload []byte) error {
+		if bytes.Contains(payload, []byte("mutation")) {
+			return fmt.Errorf("%s is not allowed to execute mutations", ctx.Value(userContextKey{}))
+		}
+		return nil
+	}
+
+	executorPool := &contextExecutorPool{
+		contextKey: userContextKey{},
+	}
+
+	t.Run("graphql-ws should send connection error and disconnect on rejected connection_init", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupSubscriptionHandlerTest(t, executorPool)
+		subscriptionHandler.SetInitFunc(initFunc)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withPayload([]byte(`{"token":"wrong"}`)).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+
+		expectedMessage := Message{
+			Type:    MessageTypeConnectionError,
+			Payload: []byte(`{"message":"invalid token"}`),
+		}
+		assert.Equal(t, []Message{expectedMessage}, client.readFromServer())
+	})
+
+	t.Run("graphql-ws should reject start before connection_init", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupSubscriptionHandlerTest(t, executorPool)
+		subscriptionHandler.SetInitFunc(initFunc)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareStartMessage("1", []byte(`{"query":"{ user }"}`)).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(0)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		expectedMessage := Message{
+			Id:      "1",
+			Type:    MessageTypeError,
+			Payload: []byte(`"connection not initialised"`),
+		}
+		assert.Equal(t, []Message{expectedMessage}, client.readFromServer())
+	})
+
+	t.Run("graphql-ws should execute operations with the context of the connection", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupSubscriptionHandlerTest(t, executorPool)
+		subscriptionHandler.SetInitFunc(initFunc)
+		subscriptionHandler.SetAuthorizeFunc(authorizeFunc)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withPayload([]byte(`{"token":"secret"}`)).withoutError().and().send()
+		client.prepareStartMessage("1", []byte(`{"query":"mutation { deleteUser }"}`)).withoutError().and().send()
+		client.prepareStartMessage("2", []byte(`{"query":"{ user }"}`)).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(3)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		expectedMessages := []Message{
+			{Type: MessageTypeConnectionAck},
+			{Id: "1", Type: MessageTypeError, Payload: []byte(`"jens is not allowed to execute mutations"`)},
+			{Id: "2", Type: MessageTypeData, Payload: []byte(`{"data":{"user":"jens"}}`)},
+			{Id: "2", Type: MessageTypeComplete},
+		}
+		assert.Equal(t, expectedMessages, client.readFromServer())
+	})
+
+	t.Run("graphql-transport-ws should close connection on rejected connection_init", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+		subscriptionHandler.SetInitFunc(initFunc)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 1*time.Second, 5*time.Millisecond)
+		assert.Equal(t, CloseCodeForbidden, client.closedWithCode())
+		assert.Equal(t, 0, len(client.readFromServer()))
+	})
+
+	t.Run("graphql-transport-ws should send errors of rejected operations", func(t *testing.T) {
+		subscriptionHandler, client, handlerRoutine := setupGraphQLTransportWSHandlerTest(t, executorPool)
+		subscriptionHandler.SetInitFunc(initFunc)
+		subscriptionHandler.SetAuthorizeFunc(authorizeFunc)
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go handlerRoutine(ctx)()
+
+		client.prepareConnectionInitMessage().withPayload([]byte(`{"token":"secret"}`)).withoutError().and().send()
+		client.prepareSubscribeMessage("1", []byte(`{"query":"mutation { deleteUser }"}`)).withoutError().and().send()
+
+		require.Eventually(t, func() bool {
+			return client.hasMoreMessagesThan(1)
+		}, 1*time.Second, 5*time.Millisecond)
+
+		expectedMessage := Message{
+			Id:      "1",
+			Type:    MessageTypeError,
+			Payload: []byte(`[{"message":"jens is not allowed to execute mutations"}]`),
+		}
+		assert.Equal(t, expectedMessage, client.readFromServer()[1])
+		assert.True(t, client.IsConnected())
+	})
+}
+
+func TestHandler_Shutdown(t *testing.T) {
+	executorPool := &blockingExecutorPool{
+		release: make(chan struct{}),
+	}
+	subscriptionHandler, client, handlerRoutine := setupSubscriptionHandlerTest(t, executorPool)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go handlerRoutine(ctx)()
+
+	client.prepareStartMessage("1", []byte(`{"query":"subscription { remainingJedis }"}`)).withoutError().and().send()
+	client.prepareStartMessage("2", []byte(`{"query":"{ hero }"}`)).withoutError().and().send()
+	require.Eventually(t, func() bool {
+		return executorPool.started.Load() == 2
+	}, 1*time.Second, 5*time.Millisecond)
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+		defer cancelShutdown()
+		shutdownErr <- subscriptionHandler.Shutdown(shutdownCtx)
+	}()
+
+	// the running query gets drained
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, client.IsConnected())
+	close(executorPool.release)
+	assert.NoError(t, <-shutdownErr)
+
+	client.prepareStartMessage("3", []byte(`{"query":"{ hero }"}`)).withoutError().and().send()
+	require.Eventually(t, func() bool {
+		return client.hasMoreMessagesThan(3)
+	}, 1*time.Second, 5*time.Millisecond)
+
+	expectedMessages := []Message{
+		{Id: "2", Type: MessageTypeData, Payload: []byte(`{"data":{"hero":"luke"}}`)},
+		{Id: "2", Type: MessageTypeComplete},
+		{Id: "1", Type: MessageTypeComplete},
+		{Id: "3", Type: MessageTypeError, Payload: []byte(`"server is shutting down"`)},
+	}
+	assert.Equal(t, expectedMessages, client.readFromServer())
+	assert.False(t, client.IsConnected())
+	assert.Equal(t, CloseCodeGoingAway, client.closedWithCode())
+	assert.Equal(t, 0, subscriptionHandler.ActiveSubscriptions())
+}
+
+// blockingExecutorPool creates executors of subscriptions running until they get stopped and of queries waiting for release
+type blockingExecutorPool struct {
+	release chan struct{}
+	started atomic.Int64
+}
+
+func (b *blockingExecutorPool) Get(payload []byte) (Executor, error) {
+	return &blockingExecutor{
+		pool:           b,
+		isSubscription: bytes.Contains(payload, []byte("subscription")),
+		context:        context.Background(),
+	}, nil
+}
+
+func (b *blockingExecutorPool) Put(executor Executor) error {
+	return nil
+}
+
+type blockingExecutor struct {
+	pool           *blockingExecutorPool
+	isSubscription bool
+	context        context.Context
+}
+
+func (b *blockingExecutor) Execute(writer resolve.FlushWriter) error {
+	b.pool.started.Inc()
+	if b.isSubscription {
+		<-b.context.Done()
+		return nil
+	}
+	<-b.pool.release
+	_, err := writer.Write([]byte(`{"data":{"hero":"luke"}}`))
+	return err
+}
+
+func (b *blockingExecutor) OperationType() ast.OperationType {
+	if b.isSubscription {
+		return ast.OperationTypeSubscription
+	}
+	return ast.OperationTypeQuery
+}
+
+func (b *blockingExecutor) SetContext(context context.Context) {
+	b.context = context
+}
+
+func (b *blockingExecutor) Reset() {}
+
+// contextExecutorPool creates executors of queries which respond with the value of contextKey of their context
+type contextExecutorPool struct {
+	contextKey interface{}
+}
+
+func (c *contextExecutorPool) Get(payload []byte) (Executor, error) {
+	return &contextExecutor{contextKey: c.contextKey, context: context.Background()}, nil
+}
+
+func (c *contextExecutorPool) Put(executor Executor) error {
+	return nil
+}
+
+type contextExecutor struct {
+	contextKey interface{}
+	context    context.Context
+}
+
+func (c *contextExecutor) Execute(writer resolve.FlushWriter) error {
+	_, err := fmt.Fprintf(writer, `{"data":{"user":"%s"}}`, c.context.Value(c.contextKey))
+	return err
+}
+
+func (c *contextExecutor) OperationType() ast.OperationType {
+	return ast.OperationTypeQuery
+}
+
+func (c *contextExecutor) SetContext(context context.Context) {
+	c.context = context
+}
+
+func (c *contextExecutor) Reset() {}
+
+// failingExecutorPool creates executors of subscriptions which fail on every execution
+type failingExecutorPool struct {
+	executions atomic.Int64
+}
+
+func (f *failingExecutorPool) Get(payload []byte) (Executor, error) {
+	return &failingExecutor{pool: f}, nil
+}
+
+func (f *failingExecutorPool) Put(executor Executor) error {
+	return nil
+}
+
+type failingExecutor struct {
+	pool *failingExecutorPool
+}
+
+func (f *failingExecutor) Execute(writer resolve.FlushWriter) error {
+	f.pool.executions.Inc()
+	return errors.New("upstream failed")
+}
+
+func (f *failingExecutor) OperationType() ast.OperationType {
+	return ast.OperationTypeSubscription
+}
+
+func (f *failingExecutor) SetContext(context context.Context) {}
+
+func (f *failingExecutor) Reset() {}
+
+// completingExecutorPool creates executors of subscriptions which get completed by the upstream after the first result
+type completingExecutorPool struct {
+	data []byte
+}
+
+func (c *completingExecutorPool) Get(payload []byte) (Executor, error) {
+	return &completingExecutor{data: c.data}, nil
+}
+
+func (c *completingExecutorPool) Put(executor Executor) error {
+	return nil
+}
+
+type completingExecutor struct {
+	data []byte
+}
+
+func (c *completingExecutor) Execute(writer resolve.FlushWriter) error {
+	_, err := writer.Write(c.data)
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return ErrSubscriptionCompleted
+}
+
+func (c *completingExecutor) OperationType() ast.OperationType {
+	return ast.OperationTypeSubscription
+}
+
+func (c *completingExecutor) SetContext(context context.Context) {}
+
+func (c *completingExecutor) Reset() {}
+
+func setupGraphQLTransportWSHandlerTest(t *testing.T, executorPool ExecutorPool) (subscriptionHandler *Handler, client *mockClient, routine handlerRoutine) {
+	client = newMockClient()
+
+	var err error
+	subscriptionHandler, err = NewHandlerWithProtocol(abstractlogger.NoopLogger, client, executorPool, ProtocolGraphQLTransportWS)
+	require.NoError(t, err)
+
+	routine = func(ctx context.Context) func() bool {
+		return func() bool {
+			subscriptionHandler.Handle(ctx)
+			return true
+		}
+	}
+
+	return subscriptionHandler, client, routine
+}
+
 func setupSubscriptionHandlerTest(t *testing.T, executorPool ExecutorPool) (subscriptionHandler *Handler, client *mockClient, routine handlerRoutine) {
 	client = newMockClient()
 
@@ -2,15 +2,20 @@ package subscription
 
 import (
 	"errors"
+	"sync"
 )
 
+// mockClient is used by the handler and the test concurrently, mu guards all fields but messagePipe and messageToServer
 type mockClient struct {
+	mu                 sync.Mutex
 	messagesFromServer []Message
 	messageToServer    *Message
 	err                error
 	messagePipe        chan *Message
 	connected          bool
 	serverHasRead      bool
+	closeCode          int
+	closeReason        string
 }
 
 func newMockClient() *mockClient {
@@ -21,37 +26,79 @@ func newMockClient() *mockClient {
 }
 
 func (c *mockClient) ReadFromClient() (*Message, error) {
+	c.mu.Lock()
 	returnErr := c.err
+	c.mu.Unlock()
 	returnMessage := <-c.messagePipe
 	if returnErr != nil {
 		return nil, returnErr
 	}
 
+	c.mu.Lock()
 	c.serverHasRead = true
 	c.err = nil
+	c.mu.Unlock()
 	return returnMessage, returnErr
 }
 
 func (c *mockClient) WriteToClient(message Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.messagesFromServer = append(c.messagesFromServer, message)
 	return c.err
 }
 
 func (c *mockClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.connected
 }
 
 func (c *mockClient) Disconnect() error {
+	c.mu.Lock()
 	c.connected = false
+	c.mu.Unlock()
 	return nil
 }
 
+func (c *mockClient) DisconnectWithCode(code int, reason string) error {
+	c.mu.Lock()
+	c.closeCode = code
+	c.closeReason = reason
+	c.mu.Unlock()
+	return c.Disconnect()
+}
+
 func (c *mockClient) hasMoreMessagesThan(num int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return len(c.messagesFromServer) > num
 }
 
+// readFromServer returns a copy of the messages sent by the handler so far
 func (c *mockClient) readFromServer() []Message {
-	return c.messagesFromServer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	messages := make([]Message, len(c.messagesFromServer))
+	copy(messages, c.messagesFromServer)
+	return messages
+}
+
+func (c *mockClient) hasServerRead() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serverHasRead
+}
+
+func (c *mockClient) closedWith() (code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeCode, c.closeReason
+}
+
+func (c *mockClient) closedWithCode() int {
+	code, _ := c.closedWith()
+	return code
 }
 
 func (c *mockClient) prepareConnectionInitMessage() *mockClient {
@@ -82,6 +129,34 @@ func (c *mockClient) prepareStopMessage(id string) *mockClient {
 	return c
 }
 
+func (c *mockClient) prepareSubscribeMessage(id string, payload []byte) *mockClient {
+	c.messageToServer = &Message{
+		Id:      id,
+		Type:    MessageTypeSubscribe,
+		Payload: payload,
+	}
+
+	return c
+}
+
+func (c *mockClient) prepareCompleteMessage(id string) *mockClient {
+	c.messageToServer = &Message{
+		Id:   id,
+		Type: MessageTypeComplete,
+	}
+
+	return c
+}
+
+func (c *mockClient) preparePingMessage(payload []byte) *mockClient {
+	c.messageToServer = &Message{
+		Type:    MessageTypePing,
+		Payload: payload,
+	}
+
+	return c
+}
+
 func (c *mockClient) prepareConnectionTerminateMessage() *mockClient {
 	c.messageToServer = &Message{
 		Type: MessageTypeConnectionTerminate,
@@ -90,6 +165,11 @@ func (c *mockClient) prepareConnectionTerminateMessage() *mockClient {
 	return c
 }
 
+func (c *mockClient) withPayload(payload []byte) *mockClient {
+	c.messageToServer.Payload = payload
+	return c
+}
+
 func (c *mockClient) send() bool {
 	c.messagePipe <- c.messageToServer
 	c.messageToServer = nil
@@ -97,12 +177,16 @@ func (c *mockClient) send() bool {
 }
 
 func (c *mockClient) withoutError() *mockClient {
+	c.mu.Lock()
 	c.err = nil
+	c.mu.Unlock()
 	return c
 }
 
 func (c *mockClient) withError() *mockClient {
+	c.mu.Lock()
 	c.err = errors.New("error")
+	c.mu.Unlock()
 	return c
 }
 
@@ -111,12 +195,16 @@ func (c *mockClient) and() *mockClient {
 }
 
 func (c *mockClient) reset() *mockClient {
+	c.mu.Lock()
 	c.messagesFromServer = []Message{}
+	c.mu.Unlock()
 	return c
 }
 
 func (c *mockClient) reconnect() *mockClient {
 	c.reset()
+	c.mu.Lock()
 	c.connected = true
+	c.mu.Unlock()
 	return c
 }